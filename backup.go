@@ -0,0 +1,25 @@
+package litefs
+
+import (
+	"context"
+	"io"
+
+	"github.com/superfly/ltx"
+)
+
+// Backup represents an off-site destination for LTX files. It is used for
+// disaster recovery when a node starts without any local databases.
+type Backup interface {
+	// WriteLTX writes the contents of an LTX file for the named database.
+	// The header is provided for convenience and is also included in r.
+	WriteLTX(ctx context.Context, dbName string, hdr ltx.Header, r io.Reader) error
+
+	// DBs returns the names of all databases in the backup.
+	DBs(ctx context.Context) ([]string, error)
+
+	// LTXFiles returns all LTX files for a database in ascending TXID order.
+	LTXFiles(ctx context.Context, dbName string) ([]LTXFileInfo, error)
+
+	// OpenLTX returns a reader for the contents of an LTX file.
+	OpenLTX(ctx context.Context, dbName string, minTXID, maxTXID uint64) (io.ReadCloser, error)
+}
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/superfly/litefs"
 	"github.com/superfly/litefs/http"
 	"github.com/superfly/litefs/internal"
 )
@@ -23,6 +24,9 @@ type ExportCommand struct {
 
 	// Path to export the database to.
 	Path string
+
+	// Encoding of the exported file, such as gzip.
+	Codec litefs.ExportCodec
 }
 
 // NewExportCommand returns a new instance of ExportCommand.
@@ -37,11 +41,15 @@ func (c *ExportCommand) ParseFlags(ctx context.Context, args []string) (err erro
 	fs := flag.NewFlagSet("litefs-export", flag.ContinueOnError)
 	fs.StringVar(&c.URL, "url", "http://localhost:20202", "LiteFS API URL")
 	fs.StringVar(&c.Name, "name", "", "database name")
+	codec := fs.String("codec", "none", "encoding of the exported file (none, gzip)")
 	fs.Usage = func() {
 		fmt.Println(`
 The export command will download a SQLite database from a LiteFS cluster. If the
 database doesn't exist then an error will be returned.
 
+If --codec is "gzip" then PATH is written as a gzip-compressed SQLite database
+file that can be read by standard tools.
+
 Usage:
 
 	litefs export [arguments] PATH
@@ -63,6 +71,10 @@ Arguments:
 	// Copy first arg as database path.
 	c.Path = fs.Arg(0)
 
+	if c.Codec, err = litefs.ParseExportCodec(*codec); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -88,7 +100,7 @@ func (c *ExportCommand) Run(ctx context.Context) (err error) {
 
 	// Fetch snapshot from the server.
 	client := http.NewClient()
-	r, err := client.Export(ctx, c.URL, c.Name)
+	r, err := client.Export(ctx, c.URL, c.Name, c.Codec)
 	if err != nil {
 		return err
 	}
@@ -33,8 +33,19 @@ const DefaultURL = "http://localhost:20202"
 func main() {
 	log.SetFlags(0)
 
+	var exitErr *exec.ExitError
 	if err := run(context.Background(), os.Args[1:]); err == flag.ErrHelp {
 		os.Exit(2)
+	} else if errors.As(err, &exitErr) {
+		// Exit with the same code as the subcommand. Follow the shell
+		// convention of 128+N if it was killed by a signal.
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			os.Exit(128 + int(status.Signal()))
+		}
+		os.Exit(exitErr.ExitCode())
+	} else if errors.Is(err, ErrHaltLockTimeout) {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(HaltLockTimeoutExitCode)
 	} else if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
 		os.Exit(1)
@@ -66,6 +77,20 @@ func run(ctx context.Context, args []string) error {
 	case "mount":
 		return runMount(ctx, args)
 
+	case "verify":
+		c := NewVerifyCommand()
+		if err := c.ParseFlags(ctx, args); err != nil {
+			return err
+		}
+		return c.Run(ctx)
+
+	case "pos":
+		c := NewPosCommand()
+		if err := c.ParseFlags(ctx, args); err != nil {
+			return err
+		}
+		return c.Run(ctx)
+
 	case "run":
 		c := NewRunCommand()
 		if err := c.ParseFlags(ctx, args); err != nil {
@@ -189,11 +214,15 @@ type Config struct {
 	SkipSync     bool   `yaml:"skip-sync"`
 	StrictVerify bool   `yaml:"strict-verify"`
 
+	DrainTimeout time.Duration `yaml:"drain-timeout"`
+
 	Data    DataConfig    `yaml:"data"`
 	FUSE    FUSEConfig    `yaml:"fuse"`
 	HTTP    HTTPConfig    `yaml:"http"`
 	Proxy   ProxyConfig   `yaml:"proxy"`
 	Lease   LeaseConfig   `yaml:"lease"`
+	Backup  BackupConfig  `yaml:"backup"`
+	Log     LogConfig     `yaml:"log"`
 	Tracing TracingConfig `yaml:"tracing"`
 }
 
@@ -205,12 +234,25 @@ func NewConfig() Config {
 	config.Data.Compress = true
 	config.Data.Retention = litefs.DefaultRetention
 	config.Data.RetentionMonitorInterval = litefs.DefaultRetentionMonitorInterval
+	config.Data.CompactionMinFiles = litefs.DefaultCompactionMinFiles
+	config.Data.CompactionMaxFileSize = litefs.DefaultCompactionMaxFileSize
+	config.Data.FileMode = litefs.DefaultFileMode
+	config.Data.DirMode = litefs.DefaultDirMode
+	config.Data.SyncBatchInterval = litefs.DefaultSyncBatchInterval
+	config.Data.OpenConcurrency = litefs.DefaultOpenConcurrency
 
 	config.HTTP.Addr = http.DefaultAddr
 
 	config.Lease.Candidate = true
+	config.Lease.CandidateWeight = litefs.DefaultCandidateWeight
 	config.Lease.ReconnectDelay = litefs.DefaultReconnectDelay
+	config.Lease.MaxReconnectDelay = litefs.DefaultMaxReconnectDelay
+	config.Lease.ReconnectJitter = litefs.DefaultReconnectJitter
+	config.Lease.HeartbeatInterval = litefs.DefaultHeartbeatInterval
 	config.Lease.DemoteDelay = litefs.DefaultDemoteDelay
+	config.Lease.SyncTimeout = litefs.DefaultSyncTimeout
+
+	config.Log.Format = LogFormatText
 
 	config.Tracing.MaxSize = DefaultTracingMaxSize
 	config.Tracing.MaxCount = DefaultTracingMaxCount
@@ -225,8 +267,52 @@ type DataConfig struct {
 	Dir      string `yaml:"dir"`
 	Compress bool   `yaml:"compress"`
 
-	Retention                time.Duration `yaml:"retention"`
-	RetentionMonitorInterval time.Duration `yaml:"retention-monitor-interval"`
+	FileMode os.FileMode `yaml:"file-mode"`
+	DirMode  os.FileMode `yaml:"dir-mode"`
+
+	Retention                time.Duration            `yaml:"retention"`
+	RetentionMonitorInterval time.Duration            `yaml:"retention-monitor-interval"`
+	RetentionOverrides       map[string]time.Duration `yaml:"retention-overrides"`
+	RetentionMinCount        int                      `yaml:"retention-min-count"`
+	RetentionMaxFiles        int                      `yaml:"retention-max-files"`
+	RetentionSafetyCheck     bool                     `yaml:"retention-safety-check"`
+
+	CompactionInterval    time.Duration `yaml:"compaction-interval"`
+	CompactionMinFiles    int           `yaml:"compaction-min-files"`
+	CompactionMaxFileSize int64         `yaml:"compaction-max-file-size"`
+
+	// If true, LTX files older than the latest file are compressed on disk.
+	CompressAtRest bool `yaml:"compress-at-rest"`
+
+	// Interval between background checksum verifications of a single database.
+	ScrubInterval time.Duration `yaml:"scrub-interval"`
+
+	// If true, database files that do not match their LTX position on
+	// startup are rebuilt from their LTX files.
+	RepairOnOpen bool `yaml:"repair-on-open"`
+
+	// Databases that reject application writes on every node.
+	ReadOnlyDBs []string `yaml:"read-only-dbs"`
+
+	// Databases that are never streamed to replicas.
+	NoReplicateDBs []string `yaml:"no-replicate-dbs"`
+
+	// Maximum number of databases. Zero disables the limit.
+	MaxDatabases int `yaml:"max-databases"`
+
+	// Database name prefixes to replicate to this node. Empty replicates all.
+	ReplicationPrefixes []string `yaml:"replication-prefixes"`
+
+	// Maximum number of databases opened or recovered in parallel.
+	OpenConcurrency int `yaml:"open-concurrency"`
+
+	// Determines when replicated LTX files are fsynced.
+	SyncMode          string        `yaml:"sync-mode"`
+	SyncBatchInterval time.Duration `yaml:"sync-batch-interval"`
+
+	// Determines how a replica handles a change that does not match its
+	// position, including when it is ahead of the primary.
+	OnDivergence string `yaml:"on-divergence"`
 }
 
 // FUSEConfig represents the configuration for the FUSE file system.
@@ -238,7 +324,28 @@ type FUSEConfig struct {
 
 // HTTPConfig represents the configuration for the HTTP server.
 type HTTPConfig struct {
-	Addr string `yaml:"addr"`
+	Addr             string    `yaml:"addr"`
+	TLS              TLSConfig `yaml:"tls"`
+	ReplicationToken string    `yaml:"replication-token"`
+
+	// Maximum bytes per second sent to each replica stream. Zero is unlimited.
+	MaxReplicationBytesPerSec int64 `yaml:"max-replication-bytes-per-sec"`
+
+	// If set, requests to other nodes are sent over this unix domain socket,
+	// such as to a local sidecar, instead of connecting to them directly.
+	ClientSocket string `yaml:"client-socket"`
+}
+
+// TLSConfig represents the TLS configuration for node-to-node communication.
+type TLSConfig struct {
+	CertFile string `yaml:"cert-file"`
+	KeyFile  string `yaml:"key-file"`
+	CAFile   string `yaml:"ca-file"`
+}
+
+// Enabled returns true if a certificate has been configured.
+func (c *TLSConfig) Enabled() bool {
+	return c.CertFile != ""
 }
 
 // ProxyConfig represents the configuration for the HTTP proxy server.
@@ -252,7 +359,7 @@ type ProxyConfig struct {
 
 // LeaseConfig represents a generic configuration for all lease types.
 type LeaseConfig struct {
-	// Specifies the type of leasing to use: "consul" or "static"
+	// Specifies the type of leasing to use: "consul", "quorum" or "static"
 	Type string `yaml:"type"`
 
 	// The hostname of this node. Used by the application to forward requests.
@@ -267,14 +374,51 @@ type LeaseConfig struct {
 	// Replicas in a state lease should set this to false.
 	Candidate bool `yaml:"candidate"`
 
+	// If true, this node never becomes primary & rejects all writes.
+	ReadOnly bool `yaml:"read-only"`
+
+	// Preference of this node to become primary, from 0 to 100. Higher
+	// weight candidates win elections & preempt lower weight primaries.
+	// A weight of zero never becomes primary. Defaults to 100.
+	CandidateWeight int `yaml:"candidate-weight"`
+
 	// After disconnect, time before node tries to reconnect to primary or
 	// becomes primary itself.
 	ReconnectDelay time.Duration `yaml:"reconnect-delay"`
 
+	// Maximum delay between reconnect attempts. The delay doubles from
+	// ReconnectDelay after each failed attempt. Zero disables backoff.
+	MaxReconnectDelay time.Duration `yaml:"max-reconnect-delay"`
+
+	// Fraction of the reconnect delay that is randomized, from 0 to 1.
+	ReconnectJitter float64 `yaml:"reconnect-jitter"`
+
+	// Interval between heartbeats sent by the primary on idle streams and
+	// the time a replica waits for a frame before reconnecting.
+	HeartbeatInterval time.Duration `yaml:"heartbeat-interval"`
+	StreamTimeout     time.Duration `yaml:"stream-timeout"`
+
 	// Amount of time to wait after a forced demotion before attempting to
 	// become primary again.
 	DemoteDelay time.Duration `yaml:"demote-delay"`
 
+	// If set, the primary demotes itself when it has neither renewed its
+	// lease nor heard from a replica within this duration.
+	PrimaryIsolationTimeout time.Duration `yaml:"primary-isolation-timeout"`
+
+	// Number of replicas that must acknowledge a transaction before a write
+	// on the primary completes. Must be set on all nodes. Zero disables.
+	SyncReplicas int `yaml:"sync-replicas"`
+
+	// Maximum time a write waits for replica acknowledgements.
+	SyncTimeout time.Duration `yaml:"sync-timeout"`
+
+	// If true, this node relays changes to replicas that stream from it.
+	Relay bool `yaml:"relay"`
+
+	// URL of a relay node to stream from instead of the primary.
+	UpstreamURL string `yaml:"upstream-url"`
+
 	// Consul lease settings.
 	Consul struct {
 		URL       string        `yaml:"url"`
@@ -282,6 +426,45 @@ type LeaseConfig struct {
 		TTL       time.Duration `yaml:"ttl"`
 		LockDelay time.Duration `yaml:"lock-delay"`
 	} `yaml:"consul"`
+
+	// Quorum lease settings.
+	Quorum struct {
+		Peers []string      `yaml:"peers"`
+		TTL   time.Duration `yaml:"ttl"`
+	} `yaml:"quorum"`
+}
+
+// BackupConfig represents the configuration for off-site backup of LTX files.
+type BackupConfig struct {
+	// Specifies the type of backup to use: "s3" or blank to disable.
+	Type string `yaml:"type"`
+
+	// If true, LTX files are not removed by retention enforcement until
+	// they have been written to the backup.
+	RequireForRetention bool `yaml:"require-for-retention"`
+
+	// S3-compatible object storage settings.
+	S3 struct {
+		Endpoint        string `yaml:"endpoint"`
+		Bucket          string `yaml:"bucket"`
+		Path            string `yaml:"path"`
+		Region          string `yaml:"region"`
+		AccessKeyID     string `yaml:"access-key-id"`
+		SecretAccessKey string `yaml:"secret-access-key"`
+	} `yaml:"s3"`
+}
+
+// Log formats.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogConfig represents the configuration for the process log.
+type LogConfig struct {
+	// Format of lease & replication state changes. The "json" format writes
+	// structured records to STDERR and also applies to the trace log.
+	Format string `yaml:"format"`
 }
 
 // Tracing configuration defaults.
@@ -387,7 +570,9 @@ The commands are:
 	export       export a database from a LiteFS cluster to disk
 	import       import a SQLite database into a LiteFS cluster
 	mount        mount the LiteFS FUSE file system
+	pos          print the current position of each database as JSON
 	run          executes a subcommand for remote writes
+	verify       verify a database against its LTX files
 	version      prints the version
 `[1:])
 }
@@ -3,11 +3,13 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"expvar"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"os/user"
@@ -21,13 +23,16 @@ import (
 	"github.com/superfly/litefs/consul"
 	"github.com/superfly/litefs/fuse"
 	"github.com/superfly/litefs/http"
+	"github.com/superfly/litefs/internal/s3"
+	"github.com/superfly/litefs/quorum"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 // MountCommand represents a command to mount the file system.
 type MountCommand struct {
-	cmd    *exec.Cmd  // subcommand
-	execCh chan error // subcommand error channel
+	cmd       *exec.Cmd   // subcommand
+	execCh    chan error  // subcommand error channel
+	tlsConfig *tls.Config // node-to-node TLS config, if enabled
 
 	Config Config
 
@@ -39,6 +44,9 @@ type MountCommand struct {
 
 	// Used for generating the advertise URL for testing.
 	AdvertiseURLFn func() string
+
+	// If true, a new node ID is generated before the store is opened.
+	ResetID bool
 }
 
 // NewMountCommand returns a new instance of MountCommand.
@@ -63,6 +71,7 @@ func (c *MountCommand) ParseFlags(ctx context.Context, args []string) (err error
 	noExpandEnv := fs.Bool("no-expand-env", false, "do not expand env vars in config")
 	fuseDebug := fs.Bool("fuse.debug", false, "enable FUSE debug logging")
 	tracing := fs.Bool("tracing", false, "enable trace logging to stdout")
+	fs.BoolVar(&c.ResetID, "reset-id", false, "generate a new node id, use when the data directory was cloned")
 	fs.Usage = func() {
 		fmt.Println(`
 The mount command will mount a LiteFS directory via FUSE and begin communicating
@@ -121,7 +130,9 @@ Arguments:
 			tw = io.MultiWriter(os.Stdout, tw)
 		}
 	}
-	if tw != nil {
+	if tw != nil && c.Config.Log.Format == LogFormatJSON {
+		litefs.TraceLog = slog.NewLogLogger(slog.NewJSONHandler(tw, &slog.HandlerOptions{Level: slog.LevelDebug}), slog.LevelDebug)
+	} else if tw != nil {
 		litefs.TraceLog.SetOutput(tw)
 	}
 
@@ -176,9 +187,24 @@ func (c *MountCommand) Validate(ctx context.Context) (err error) {
 		return fmt.Errorf("fuse directory and data directory cannot be the same path")
 	}
 
+	// Require both a certificate & key for TLS.
+	if tlsConfig := c.Config.HTTP.TLS; (tlsConfig.CertFile == "") != (tlsConfig.KeyFile == "") {
+		return fmt.Errorf("http tls requires both cert-file and key-file")
+	} else if tlsConfig.CAFile != "" && !tlsConfig.Enabled() {
+		return fmt.Errorf("http tls ca-file requires cert-file and key-file")
+	} else if _, ok := http.UnixSocketPath(c.Config.HTTP.Addr); ok && tlsConfig.Enabled() {
+		return fmt.Errorf("http tls is not supported on a unix socket address")
+	}
+
 	// Enforce a valid lease mode.
 	if !IsValidLeaseType(c.Config.Lease.Type) {
-		return fmt.Errorf("invalid lease type, must be either 'consul' or 'static', got: '%v'", c.Config.Lease.Type)
+		return fmt.Errorf("invalid lease type, must be 'consul', 'quorum' or 'static', got: '%v'", c.Config.Lease.Type)
+	}
+
+	switch c.Config.Log.Format {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("invalid log format, must be 'text' or 'json', got: '%v'", c.Config.Log.Format)
 	}
 
 	return nil
@@ -186,13 +212,14 @@ func (c *MountCommand) Validate(ctx context.Context) (err error) {
 
 const (
 	LeaseTypeConsul = "consul"
+	LeaseTypeQuorum = "quorum"
 	LeaseTypeStatic = "static"
 )
 
 // IsValidLeaseType returns true if s is a valid lease type.
 func IsValidLeaseType(s string) bool {
 	switch s {
-	case LeaseTypeConsul, LeaseTypeStatic:
+	case LeaseTypeConsul, LeaseTypeQuorum, LeaseTypeStatic:
 		return true
 	default:
 		return false
@@ -218,6 +245,15 @@ func (c *MountCommand) Close() (err error) {
 		}
 	}
 
+	// Allow in-flight writes to finish & replicate before shutting down.
+	if c.Store != nil && c.Config.DrainTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), c.Config.DrainTimeout)
+		if e := c.Store.Drain(ctx); e != nil {
+			log.Printf("cannot drain store: %s", e)
+		}
+		cancel()
+	}
+
 	if c.HTTPServer != nil {
 		if e := c.HTTPServer.Close(); err == nil {
 			err = e
@@ -243,7 +279,9 @@ func (c *MountCommand) Run(ctx context.Context) (err error) {
 	fmt.Println(VersionString())
 
 	// Start listening on HTTP server first so we can determine the URL.
-	if err := c.initStore(ctx); err != nil {
+	if err := c.initTLS(ctx); err != nil {
+		return fmt.Errorf("cannot init tls: %w", err)
+	} else if err := c.initStore(ctx); err != nil {
 		return fmt.Errorf("cannot init store: %w", err)
 	} else if err := c.initHTTPServer(ctx); err != nil {
 		return fmt.Errorf("cannot init http server: %w", err)
@@ -258,6 +296,11 @@ func (c *MountCommand) Run(ctx context.Context) (err error) {
 		if err := c.initConsul(ctx); err != nil {
 			return fmt.Errorf("cannot init consul: %w", err)
 		}
+	case LeaseTypeQuorum:
+		log.Println("Using quorum of peers to determine primary")
+		if err := c.initQuorum(ctx); err != nil {
+			return fmt.Errorf("cannot init quorum: %w", err)
+		}
 	case LeaseTypeStatic:
 		log.Printf("Using static primary: primary=%v hostname=%s advertise-url=%s",
 			c.Config.Lease.Candidate, c.Config.Lease.Hostname, c.Config.Lease.AdvertiseURL)
@@ -306,23 +349,9 @@ func (c *MountCommand) Run(ctx context.Context) (err error) {
 
 func (c *MountCommand) initConsul(ctx context.Context) (err error) {
 	// TEMP: Allow non-localhost addresses.
-
-	// Use hostname from OS, if not specified.
-	hostname := c.Config.Lease.Hostname
-	if hostname == "" {
-		if hostname, err = os.Hostname(); err != nil {
-			return err
-		}
-	}
-
-	// Determine the advertise URL for the LiteFS API.
-	// Default to use the hostname and HTTP port. Also allow injection for tests.
-	advertiseURL := c.Config.Lease.AdvertiseURL
-	if c.AdvertiseURLFn != nil {
-		advertiseURL = c.AdvertiseURLFn()
-	}
-	if advertiseURL == "" && hostname != "" {
-		advertiseURL = fmt.Sprintf("http://%s:%d", hostname, c.HTTPServer.Port())
+	hostname, advertiseURL, err := c.leaseHostnameAndAdvertiseURL()
+	if err != nil {
+		return err
 	}
 
 	leaser := consul.NewLeaser(c.Config.Lease.Consul.URL, c.Config.Lease.Consul.Key, hostname, advertiseURL)
@@ -332,6 +361,7 @@ func (c *MountCommand) initConsul(ctx context.Context) (err error) {
 	if v := c.Config.Lease.Consul.LockDelay; v > 0 {
 		leaser.LockDelay = v
 	}
+	leaser.Weight = c.Config.Lease.CandidateWeight
 	if err := leaser.Open(); err != nil {
 		return fmt.Errorf("cannot connect to consul: %w", err)
 	}
@@ -342,20 +372,174 @@ func (c *MountCommand) initConsul(ctx context.Context) (err error) {
 	return nil
 }
 
-func (c *MountCommand) initStore(ctx context.Context) error {
+func (c *MountCommand) initQuorum(ctx context.Context) (err error) {
+	hostname, advertiseURL, err := c.leaseHostnameAndAdvertiseURL()
+	if err != nil {
+		return err
+	}
+
+	leaser := quorum.NewLeaser(c.Config.Lease.Quorum.Peers, hostname, advertiseURL)
+	leaser.Token = c.Config.HTTP.ReplicationToken
+	leaser.Weight = c.Config.Lease.CandidateWeight
+	if v := c.Config.Lease.Quorum.TTL; v > 0 {
+		leaser.TTL = v
+	}
+	if client, ok := c.Store.Client.(*http.Client); ok {
+		leaser.HTTPClient = client.HTTPClient
+	}
+	if err := leaser.Open(); err != nil {
+		return err
+	}
+	log.Printf("initializing quorum: peers=%s majority=%d hostname=%s advertise-url=%s",
+		strings.Join(leaser.Peers(), ","), leaser.Majority(), hostname, advertiseURL)
+
+	// Peers request votes through this node's HTTP API.
+	c.HTTPServer.LeaseHandler = leaser
+
+	c.Leaser = leaser
+	return nil
+}
+
+// leaseHostnameAndAdvertiseURL returns the hostname & advertise URL that
+// other nodes use to reach this node. Defaults are based on the OS hostname.
+func (c *MountCommand) leaseHostnameAndAdvertiseURL() (hostname, advertiseURL string, err error) {
+	// Use hostname from OS, if not specified.
+	hostname = c.Config.Lease.Hostname
+	if hostname == "" {
+		if hostname, err = os.Hostname(); err != nil {
+			return "", "", err
+		}
+	}
+
+	// Determine the advertise URL for the LiteFS API.
+	// Default to use the hostname and HTTP port. Also allow injection for tests.
+	advertiseURL = c.Config.Lease.AdvertiseURL
+	if c.AdvertiseURLFn != nil {
+		advertiseURL = c.AdvertiseURLFn()
+	}
+	if _, ok := http.UnixSocketPath(c.Config.HTTP.Addr); ok && advertiseURL == "" {
+		advertiseURL = c.HTTPServer.URL()
+	} else if advertiseURL == "" && hostname != "" {
+		scheme := "http"
+		if c.tlsConfig != nil {
+			scheme = "https"
+		}
+		advertiseURL = fmt.Sprintf("%s://%s:%d", scheme, hostname, c.HTTPServer.Port())
+	}
+	return hostname, advertiseURL, nil
+}
+
+func (c *MountCommand) initStore(ctx context.Context) (err error) {
 	c.Store = litefs.NewStore(c.Config.Data.Dir, c.Config.Lease.Candidate)
 	c.Store.StrictVerify = c.Config.StrictVerify
+	if c.Config.Log.Format == LogFormatJSON {
+		c.Store.Logger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	}
 	c.Store.Compress = c.Config.Data.Compress
+	c.Store.FileMode = c.Config.Data.FileMode
+	c.Store.DirMode = c.Config.Data.DirMode
 	c.Store.Retention = c.Config.Data.Retention
 	c.Store.RetentionMonitorInterval = c.Config.Data.RetentionMonitorInterval
+	c.Store.RetentionOverrides = c.Config.Data.RetentionOverrides
+	c.Store.RetentionMinCount = c.Config.Data.RetentionMinCount
+	c.Store.RetentionMaxFiles = c.Config.Data.RetentionMaxFiles
+	c.Store.RetentionSafetyCheck = c.Config.Data.RetentionSafetyCheck
+	c.Store.CompactionInterval = c.Config.Data.CompactionInterval
+	c.Store.CompactionMinFiles = c.Config.Data.CompactionMinFiles
+	c.Store.CompactionMaxFileSize = c.Config.Data.CompactionMaxFileSize
+	c.Store.CompressAtRest = c.Config.Data.CompressAtRest
+	c.Store.ScrubInterval = c.Config.Data.ScrubInterval
+	c.Store.RepairOnOpen = c.Config.Data.RepairOnOpen
+	c.Store.OpenConcurrency = c.Config.Data.OpenConcurrency
+	c.Store.ReadOnlyDBs = c.Config.Data.ReadOnlyDBs
+	c.Store.NoReplicateDBs = c.Config.Data.NoReplicateDBs
+	c.Store.MaxDatabases = c.Config.Data.MaxDatabases
+	c.Store.ReplicationPrefixes = c.Config.Data.ReplicationPrefixes
+	if c.Store.OnDivergence, err = litefs.ParseDivergencePolicy(c.Config.Data.OnDivergence); err != nil {
+		return err
+	}
+	if c.Store.SyncMode, err = litefs.ParseSyncMode(c.Config.Data.SyncMode); err != nil {
+		return err
+	}
+	c.Store.SyncBatchInterval = c.Config.Data.SyncBatchInterval
 	c.Store.ReconnectDelay = c.Config.Lease.ReconnectDelay
+	c.Store.MaxReconnectDelay = c.Config.Lease.MaxReconnectDelay
+	c.Store.ReconnectJitter = c.Config.Lease.ReconnectJitter
+	c.Store.HeartbeatInterval = c.Config.Lease.HeartbeatInterval
+	c.Store.StreamTimeout = c.Config.Lease.StreamTimeout
 	c.Store.DemoteDelay = c.Config.Lease.DemoteDelay
-	c.Store.Client = http.NewClient()
+	c.Store.CandidateWeight = c.Config.Lease.CandidateWeight
+	c.Store.ReadOnly = c.Config.Lease.ReadOnly
+	c.Store.PrimaryIsolationTimeout = c.Config.Lease.PrimaryIsolationTimeout
+	c.Store.SyncReplicas = c.Config.Lease.SyncReplicas
+	c.Store.SyncTimeout = c.Config.Lease.SyncTimeout
+	c.Store.Relay = c.Config.Lease.Relay
+	c.Store.UpstreamURL = c.Config.Lease.UpstreamURL
+	c.Store.ReplicationToken = c.Config.HTTP.ReplicationToken
+	c.Store.MaxReplicationBytesPerSec = c.Config.HTTP.MaxReplicationBytesPerSec
+	if err := c.initBackup(); err != nil {
+		return err
+	}
+	if path := c.Config.HTTP.ClientSocket; path != "" {
+		client := http.NewUnixClient(path)
+		client.TLSConfig = c.tlsConfig
+		c.Store.Client = client
+		return nil
+	}
+
+	client := http.NewClient()
+	client.TLSConfig = c.tlsConfig
+	c.Store.Client = client
+	return nil
+}
+
+// initBackup attaches the configured off-site backup to the store.
+func (c *MountCommand) initBackup() error {
+	switch typ := c.Config.Backup.Type; typ {
+	case "":
+		return nil
+	case "s3":
+		client := s3.NewClient()
+		client.Endpoint = c.Config.Backup.S3.Endpoint
+		client.Bucket = c.Config.Backup.S3.Bucket
+		client.Path = c.Config.Backup.S3.Path
+		client.AccessKeyID = c.Config.Backup.S3.AccessKeyID
+		client.SecretAccessKey = c.Config.Backup.S3.SecretAccessKey
+		if v := c.Config.Backup.S3.Region; v != "" {
+			client.Region = v
+		}
+		if client.Endpoint == "" || client.Bucket == "" {
+			return fmt.Errorf("backup endpoint & bucket required for s3 backup")
+		}
+		log.Printf("initializing s3 backup: endpoint=%s bucket=%s path=%s", client.Endpoint, client.Bucket, client.Path)
+
+		c.Store.Backup = client
+		c.Store.RetentionRequireBackup = c.Config.Backup.RequireForRetention
+		return nil
+	default:
+		return fmt.Errorf("invalid backup type: %q", typ)
+	}
+}
+
+func (c *MountCommand) initTLS(ctx context.Context) (err error) {
+	if !c.Config.HTTP.TLS.Enabled() {
+		return nil
+	}
+
+	if c.tlsConfig, err = http.NewTLSConfig(c.Config.HTTP.TLS.CertFile, c.Config.HTTP.TLS.KeyFile, c.Config.HTTP.TLS.CAFile); err != nil {
+		return err
+	}
+	log.Printf("tls enabled: cert=%s ca=%s", c.Config.HTTP.TLS.CertFile, c.Config.HTTP.TLS.CAFile)
 	return nil
 }
 
 func (c *MountCommand) openStore(ctx context.Context) error {
 	c.Store.Leaser = c.Leaser
+	if c.ResetID {
+		if err := c.Store.ResetID(); err != nil {
+			return fmt.Errorf("reset node id: %w", err)
+		}
+	}
 	if err := c.Store.Open(); err != nil {
 		return err
 	}
@@ -384,6 +568,7 @@ func (c *MountCommand) initFileSystem(ctx context.Context) error {
 
 func (c *MountCommand) initHTTPServer(ctx context.Context) error {
 	server := http.NewServer(c.Store, c.Config.HTTP.Addr)
+	server.TLSConfig = c.tlsConfig
 	if err := server.Listen(); err != nil {
 		return fmt.Errorf("cannot open http server: %w", err)
 	}
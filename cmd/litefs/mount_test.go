@@ -458,6 +458,61 @@ func TestMultiNode_RejoinWithSnapshot(t *testing.T) {
 	}
 }
 
+// Ensure a replica that falls behind the primary's max LTX file count can
+// still catch up by receiving a snapshot.
+func TestMultiNode_RejoinAfterRetentionMaxFiles(t *testing.T) {
+	dir0, dir1 := t.TempDir(), t.TempDir()
+	cmd0 := newMountCommand(t, dir0, nil)
+	cmd0.Config.Data.Retention = 1 * time.Hour
+	cmd0.Config.Data.RetentionMaxFiles = 2
+	runMountCommand(t, cmd0)
+	waitForPrimary(t, cmd0)
+	cmd1 := runMountCommand(t, newMountCommand(t, dir1, cmd0))
+	db0 := testingutil.OpenSQLDB(t, filepath.Join(cmd0.Config.FUSE.Dir, "db"))
+
+	// Create a simple table with a single value.
+	if _, err := db0.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	} else if _, err := db0.Exec(`INSERT INTO t VALUES (100)`); err != nil {
+		t.Fatal(err)
+	}
+	waitForSync(t, "db", cmd0, cmd1)
+
+	// Shutdown replica.
+	if err := cmd1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Issue more transactions than the max file count & enforce retention.
+	for i := 0; i < 5; i++ {
+		if _, err := db0.Exec(`INSERT INTO t VALUES (100)`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cmd0.Store.EnforceRetention(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure only the max number of files remain, even though they are within the retention period.
+	if ents, err := cmd0.Store.DB("db").ReadLTXDir(); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(ents), 2; got != want {
+		t.Fatalf("len(entries)=%d, want %d", got, want)
+	}
+
+	// Reopen replica. It should require a snapshot to catch up.
+	cmd1 = runMountCommand(t, newMountCommand(t, dir1, cmd0))
+	waitForSync(t, "db", cmd0, cmd1)
+
+	db1 := testingutil.OpenSQLDB(t, filepath.Join(cmd1.Config.FUSE.Dir, "db"))
+	var n int
+	if err := db1.QueryRow(`SELECT SUM(x) FROM t`).Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if got, want := n, 600; got != want {
+		t.Fatalf("sum=%d, want %d", got, want)
+	}
+}
+
 func TestMultiNode_NonStandardPageSize(t *testing.T) {
 	cmd0 := runMountCommand(t, newMountCommand(t, t.TempDir(), nil))
 	waitForPrimary(t, cmd0)
@@ -1589,6 +1644,63 @@ func TestUnmarshalConfig(t *testing.T) {
 		if got, want := config.Data.Dir, "/var/lib/litefs"; got != want {
 			t.Fatalf("FUSE.Dir=%s, want %s", got, want)
 		}
+		if got, want := config.Data.OpenConcurrency, 16; got != want {
+			t.Fatalf("Data.OpenConcurrency=%d, want %d", got, want)
+		}
+		if got, want := config.Data.OnDivergence, "resnapshot"; got != want {
+			t.Fatalf("Data.OnDivergence=%s, want %s", got, want)
+		}
+		if got, want := config.Data.SyncMode, "batch"; got != want {
+			t.Fatalf("Data.SyncMode=%s, want %s", got, want)
+		}
+		if got, want := config.Data.SyncBatchInterval, 100*time.Millisecond; got != want {
+			t.Fatalf("Data.SyncBatchInterval=%s, want %s", got, want)
+		}
+		if got, want := config.Data.FileMode, os.FileMode(0640); got != want {
+			t.Fatalf("Data.FileMode=%s, want %s", got, want)
+		}
+		if got, want := config.Data.DirMode, os.FileMode(0750); got != want {
+			t.Fatalf("Data.DirMode=%s, want %s", got, want)
+		}
+		if got, want := config.Data.RetentionOverrides["my.db"], 1*time.Hour; got != want {
+			t.Fatalf("Data.RetentionOverrides=%s, want %s", got, want)
+		}
+		if got, want := config.Data.RetentionMinCount, 1; got != want {
+			t.Fatalf("Data.RetentionMinCount=%d, want %d", got, want)
+		}
+		if got, want := config.Data.RetentionSafetyCheck, true; got != want {
+			t.Fatalf("Data.RetentionSafetyCheck=%v, want %v", got, want)
+		}
+		if got, want := config.Data.CompactionInterval, 5*time.Minute; got != want {
+			t.Fatalf("Data.CompactionInterval=%s, want %s", got, want)
+		}
+		if got, want := config.Data.CompactionMinFiles, 10; got != want {
+			t.Fatalf("Data.CompactionMinFiles=%d, want %d", got, want)
+		}
+		if got, want := config.Data.CompactionMaxFileSize, int64(1048576); got != want {
+			t.Fatalf("Data.CompactionMaxFileSize=%d, want %d", got, want)
+		}
+		if got, want := config.Data.CompressAtRest, true; got != want {
+			t.Fatalf("Data.CompressAtRest=%v, want %v", got, want)
+		}
+		if got, want := config.Data.ScrubInterval, 1*time.Minute; got != want {
+			t.Fatalf("Data.ScrubInterval=%s, want %s", got, want)
+		}
+		if got, want := config.Data.RepairOnOpen, true; got != want {
+			t.Fatalf("Data.RepairOnOpen=%v, want %v", got, want)
+		}
+		if got, want := strings.Join(config.Data.ReadOnlyDBs, ","), "reference.db"; got != want {
+			t.Fatalf("Data.ReadOnlyDBs=%s, want %s", got, want)
+		}
+		if got, want := strings.Join(config.Data.NoReplicateDBs, ","), "scratch.db"; got != want {
+			t.Fatalf("Data.NoReplicateDBs=%s, want %s", got, want)
+		}
+		if got, want := config.Data.MaxDatabases, 1000; got != want {
+			t.Fatalf("Data.MaxDatabases=%d, want %d", got, want)
+		}
+		if got, want := config.DrainTimeout, 10*time.Second; got != want {
+			t.Fatalf("DrainTimeout=%s, want %s", got, want)
+		}
 		if got, want := config.FUSE.Dir, "/litefs"; got != want {
 			t.Fatalf("FUSE.Dir=%s, want %s", got, want)
 		}
@@ -1598,6 +1710,24 @@ func TestUnmarshalConfig(t *testing.T) {
 		if got, want := config.HTTP.Addr, ":20202"; got != want {
 			t.Fatalf("HTTP.Addr=%s, want %s", got, want)
 		}
+		if got, want := config.HTTP.TLS.CertFile, "/etc/litefs/tls/node.crt"; got != want {
+			t.Fatalf("HTTP.TLS.CertFile=%s, want %s", got, want)
+		}
+		if got, want := config.HTTP.TLS.KeyFile, "/etc/litefs/tls/node.key"; got != want {
+			t.Fatalf("HTTP.TLS.KeyFile=%s, want %s", got, want)
+		}
+		if got, want := config.HTTP.TLS.CAFile, "/etc/litefs/tls/ca.crt"; got != want {
+			t.Fatalf("HTTP.TLS.CAFile=%s, want %s", got, want)
+		}
+		if got, want := config.HTTP.ReplicationToken, "${LITEFS_REPLICATION_TOKEN}"; got != want {
+			t.Fatalf("HTTP.ReplicationToken=%s, want %s", got, want)
+		}
+		if got, want := config.HTTP.MaxReplicationBytesPerSec, int64(10485760); got != want {
+			t.Fatalf("HTTP.MaxReplicationBytesPerSec=%d, want %d", got, want)
+		}
+		if got, want := config.HTTP.ClientSocket, "/var/run/litefs-sidecar.sock"; got != want {
+			t.Fatalf("HTTP.ClientSocket=%s, want %s", got, want)
+		}
 		if got, want := config.Lease.Type, "consul"; got != want {
 			t.Fatalf("Lease.Type=%s, want %s", got, want)
 		}
@@ -1616,12 +1746,60 @@ func TestUnmarshalConfig(t *testing.T) {
 		if got, want := config.Lease.Consul.TTL, 10*time.Second; got != want {
 			t.Fatalf("Lease.Consul.TTL=%s, want %s", got, want)
 		}
+		if got, want := config.Backup.Type, "s3"; got != want {
+			t.Fatalf("Backup.Type=%s, want %s", got, want)
+		}
+		if got, want := config.Backup.RequireForRetention, true; got != want {
+			t.Fatalf("Backup.RequireForRetention=%v, want %v", got, want)
+		}
+		if got, want := config.Backup.S3.Bucket, "my-bucket"; got != want {
+			t.Fatalf("Backup.S3.Bucket=%s, want %s", got, want)
+		}
+		if got, want := config.Log.Format, "text"; got != want {
+			t.Fatalf("Log.Format=%s, want %s", got, want)
+		}
 		if got, want := config.Lease.Consul.LockDelay, 1*time.Second; got != want {
 			t.Fatalf("Lease.Consul.LockDelay=%s, want %s", got, want)
 		}
+		if got, want := len(config.Lease.Quorum.Peers), 3; got != want {
+			t.Fatalf("len(Lease.Quorum.Peers)=%d, want %d", got, want)
+		}
+		if got, want := config.Lease.Quorum.TTL, 10*time.Second; got != want {
+			t.Fatalf("Lease.Quorum.TTL=%s, want %s", got, want)
+		}
 		if got, want := config.Lease.Candidate, true; got != want {
 			t.Fatalf("Lease.Candidate=%v, want %v", got, want)
 		}
+		if got, want := config.Lease.CandidateWeight, 100; got != want {
+			t.Fatalf("Lease.CandidateWeight=%d, want %d", got, want)
+		}
+		if got, want := config.Lease.MaxReconnectDelay, 30*time.Second; got != want {
+			t.Fatalf("Lease.MaxReconnectDelay=%s, want %s", got, want)
+		}
+		if got, want := config.Lease.ReconnectJitter, 0.5; got != want {
+			t.Fatalf("Lease.ReconnectJitter=%v, want %v", got, want)
+		}
+		if got, want := config.Lease.HeartbeatInterval, 1*time.Second; got != want {
+			t.Fatalf("Lease.HeartbeatInterval=%s, want %s", got, want)
+		}
+		if got, want := config.Lease.StreamTimeout, 5*time.Second; got != want {
+			t.Fatalf("Lease.StreamTimeout=%s, want %s", got, want)
+		}
+		if got, want := config.Lease.PrimaryIsolationTimeout, 5*time.Second; got != want {
+			t.Fatalf("Lease.PrimaryIsolationTimeout=%s, want %s", got, want)
+		}
+		if got, want := config.Lease.SyncReplicas, 1; got != want {
+			t.Fatalf("Lease.SyncReplicas=%d, want %d", got, want)
+		}
+		if got, want := config.Lease.SyncTimeout, 5*time.Second; got != want {
+			t.Fatalf("Lease.SyncTimeout=%s, want %s", got, want)
+		}
+		if got, want := config.Lease.Relay, true; got != want {
+			t.Fatalf("Lease.Relay=%v, want %v", got, want)
+		}
+		if got, want := config.Lease.UpstreamURL, "http://relay:20202"; got != want {
+			t.Fatalf("Lease.UpstreamURL=%s, want %s", got, want)
+		}
 	})
 
 	t.Run("ErrUnknownField", func(t *testing.T) {
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/superfly/litefs"
+	"github.com/superfly/litefs/http"
+)
+
+// PosCommand represents a command to print the current database positions.
+type PosCommand struct {
+	// Target LiteFS URL
+	URL string
+
+	// Name of a single database to print. Prints all databases if blank.
+	DB string
+
+	// Output of the command.
+	Stdout io.Writer
+}
+
+// NewPosCommand returns a new instance of PosCommand.
+func NewPosCommand() *PosCommand {
+	return &PosCommand{
+		URL:    DefaultURL,
+		Stdout: os.Stdout,
+	}
+}
+
+// ParseFlags parses the command line flags & config file.
+func (c *PosCommand) ParseFlags(ctx context.Context, args []string) (err error) {
+	fs := flag.NewFlagSet("litefs-pos", flag.ContinueOnError)
+	fs.StringVar(&c.URL, "url", "http://localhost:20202", "LiteFS API URL")
+	fs.StringVar(&c.DB, "db", "", "database name")
+	fs.Usage = func() {
+		fmt.Println(`
+The pos command prints the current TXID & checksum of each database on a LiteFS
+node as JSON. If --db is specified, only that database is printed and an error
+is returned if it does not exist.
+
+Usage:
+
+	litefs pos [arguments]
+
+Arguments:
+`[1:])
+		fs.PrintDefaults()
+		fmt.Println("")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() > 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	return nil
+}
+
+// Run executes the command.
+func (c *PosCommand) Run(ctx context.Context) (err error) {
+	m, err := http.NewClient().PosMap(ctx, c.URL)
+	if err != nil {
+		return err
+	}
+
+	var v any = m
+	if c.DB != "" {
+		pos, ok := m[c.DB]
+		if !ok {
+			return fmt.Errorf("%w: %q", litefs.ErrDatabaseNotFound, c.DB)
+		}
+		v = pos
+	}
+
+	enc := json.NewEncoder(c.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,65 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/superfly/litefs"
+	main "github.com/superfly/litefs/cmd/litefs"
+)
+
+// Ensure database positions can be printed from a LiteFS node.
+func TestPosCommand(t *testing.T) {
+	store := litefs.NewStore(t.TempDir(), true)
+	store.Leaser = litefs.NewStaticLeaser(true, "localhost", "")
+	url := newStoreServer(t, store)
+	<-store.ReadyCh()
+
+	if _, _, err := store.CreateDB("my.db"); err != nil {
+		t.Fatal(err)
+	}
+	want := store.PosMap()
+
+	t.Run("OK", func(t *testing.T) {
+		var buf bytes.Buffer
+		cmd := main.NewPosCommand()
+		cmd.URL, cmd.Stdout = url, &buf
+		if err := cmd.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		var m map[string]litefs.Pos
+		if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		} else if got, want := m["my.db"], want["my.db"]; got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("DB", func(t *testing.T) {
+		var buf bytes.Buffer
+		cmd := main.NewPosCommand()
+		cmd.URL, cmd.Stdout, cmd.DB = url, &buf, "my.db"
+		if err := cmd.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		var pos litefs.Pos
+		if err := json.Unmarshal(buf.Bytes(), &pos); err != nil {
+			t.Fatal(err)
+		} else if got, want := pos, want["my.db"]; got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("ErrDatabaseNotFound", func(t *testing.T) {
+		cmd := main.NewPosCommand()
+		cmd.URL, cmd.DB = url, "nosuchdatabase"
+		if err := cmd.Run(context.Background()); !errors.Is(err, litefs.ErrDatabaseNotFound) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
@@ -2,22 +2,82 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/mattn/go-isatty"
+	"github.com/superfly/litefs"
 	litefsgo "github.com/superfly/litefs-go"
+	"github.com/superfly/litefs/http"
 )
 
+// Default settings for acquiring the HALT lock.
+const (
+	DefaultHaltTimeout       = 1 * time.Minute
+	DefaultHaltRetryInterval = 1 * time.Second
+)
+
+// Environment variables set for the subcommand. References to these in the
+// subcommand's arguments, such as "$LITEFS_NODE_ID", are also expanded.
+const (
+	EnvNodeID          = "LITEFS_NODE_ID"          // ID of the local node
+	EnvIsPrimary       = "LITEFS_IS_PRIMARY"       // "true" if the local node is primary, otherwise "false"
+	EnvPrimaryHostname = "LITEFS_PRIMARY_HOSTNAME" // hostname of the primary, blank if the local node is primary
+)
+
+// Default settings for acquiring the primary lease.
+const (
+	DefaultLeaseTimeout  = 30 * time.Second
+	DefaultDemoteTimeout = 10 * time.Second
+)
+
+// DefaultForwardSignals is the default set of signals relayed to the subcommand.
+var DefaultForwardSignals = []syscall.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP}
+
+// ErrHaltLockTimeout is returned when the HALT lock could not be acquired
+// within the halt timeout.
+var ErrHaltLockTimeout = errors.New("timed out waiting for halt lock")
+
+// HaltLockTimeoutExitCode is the process exit code used when the HALT lock
+// could not be acquired in time. Matches the exit code used by timeout(1).
+const HaltLockTimeoutExitCode = 124
+
 // RunCommand represents a command to run a program with the HALT lock.
 type RunCommand struct {
-	// The database to acquire a halt lock on.
-	WithHaltLockOn string
+	// The databases to acquire a halt lock on. Locks are acquired in sorted
+	// order and released in reverse order.
+	WithHaltLockOn []string
+
+	// Maximum time to wait for the halt lock & interval between attempts.
+	// Each failed attempt is logged when verbose logging is enabled.
+	HaltTimeout       time.Duration
+	HaltRetryInterval time.Duration
+
+	// If true, the node is promoted to primary before the subcommand is run &
+	// demoted once it exits. The command fails if promotion does not complete
+	// within LeaseTimeout.
+	WithLease    bool
+	LeaseTimeout time.Duration
+
+	// LiteFS API URL, used for promotion & demotion.
+	URL string
+
+	// Signals received by the command that are relayed to the subcommand's
+	// process group. Receiving one of these before the subcommand has started
+	// aborts the command instead.
+	ForwardSignals []syscall.Signal
 
 	// Subcommand & args
 	Cmd  string
@@ -29,7 +89,13 @@ type RunCommand struct {
 
 // NewRunCommand returns a new instance of RunCommand.
 func NewRunCommand() *RunCommand {
-	return &RunCommand{}
+	return &RunCommand{
+		HaltTimeout:       DefaultHaltTimeout,
+		HaltRetryInterval: DefaultHaltRetryInterval,
+		LeaseTimeout:      DefaultLeaseTimeout,
+		URL:               DefaultURL,
+		ForwardSignals:    DefaultForwardSignals,
+	}
 }
 
 // ParseFlags parses the command line flags & config file.
@@ -38,7 +104,13 @@ func (c *RunCommand) ParseFlags(ctx context.Context, args []string) (err error)
 	args0, args1 := splitArgs(args)
 
 	fs := flag.NewFlagSet("litefs-run", flag.ContinueOnError)
-	fs.StringVar(&c.WithHaltLockOn, "with-halt-lock-on", "", "full database path to halt")
+	fs.Var((*stringSliceFlag)(&c.WithHaltLockOn), "with-halt-lock-on", "full database path to halt, may be repeated or comma-separated")
+	fs.DurationVar(&c.HaltTimeout, "halt-timeout", c.HaltTimeout, "max time to wait for the halt lock, zero waits forever")
+	fs.DurationVar(&c.HaltRetryInterval, "halt-retry-interval", c.HaltRetryInterval, "time between halt lock attempts")
+	fs.BoolVar(&c.WithLease, "with-lease", false, "promote the node to primary while the subcommand runs")
+	fs.DurationVar(&c.LeaseTimeout, "lease-timeout", c.LeaseTimeout, "max time to wait for promotion, zero waits forever")
+	fs.StringVar(&c.URL, "url", c.URL, "LiteFS API URL")
+	forwardSignals := fs.String("forward-signals", formatSignals(c.ForwardSignals), "comma-separated signals to relay to the subcommand")
 	fs.BoolVar(&c.Verbose, "v", false, "enable verbose logging")
 	fs.Usage = func() {
 		fmt.Println(`
@@ -46,6 +118,22 @@ The run command will execute a subcommand with certain guarantees provided by
 LiteFS. Typically, this is executed with --with-halt-lock-on to acquire a HALT lock
 so that write transactions can temporarily be executed on the local node.
 
+For long-running jobs, --with-lease can be used instead to promote the local
+node to primary for the duration of the subcommand.
+
+The state of the local node is passed to the subcommand through the following
+environment variables. References to these variables in the subcommand's
+arguments are expanded before it is executed.
+
+	LITEFS_NODE_ID           ID of the local node
+	LITEFS_IS_PRIMARY        "true" if the local node is primary, otherwise "false"
+	LITEFS_PRIMARY_HOSTNAME  hostname of the primary, blank if the local node is primary
+
+The subcommand runs in its own process group so that forwarded signals reach
+its child processes. If stdin is a terminal, the subcommand instead shares the
+process group of litefs so that it can read from the terminal. Forwarded
+signals are then only sent to the subcommand itself.
+
 Usage:
 
 	litefs run [arguments] -- CMD [ARG...]
@@ -66,6 +154,11 @@ Arguments:
 
 	if len(args1) == 0 {
 		return fmt.Errorf("no subcommand specified")
+	} else if c.HaltRetryInterval <= 0 {
+		return fmt.Errorf("halt retry interval must be greater than zero")
+	}
+	if c.ForwardSignals, err = parseSignals(*forwardSignals); err != nil {
+		return err
 	}
 	c.Cmd, c.Args = args1[0], args1[1:]
 
@@ -79,48 +172,96 @@ Arguments:
 
 // Run executes the command.
 func (c *RunCommand) Run(ctx context.Context) (err error) {
-	// Acquire the halt lock on the given database, if specified.
-	var f *os.File
-	if c.WithHaltLockOn != "" {
-		// Ensure database exists first.
-		if _, err := os.Stat(c.WithHaltLockOn); os.IsNotExist(err) {
-			return fmt.Errorf("database does not exist: %s", c.WithHaltLockOn)
-		} else if err != nil {
-			return err
-		}
+	signals := make([]os.Signal, len(c.ForwardSignals))
+	for i, sig := range c.ForwardSignals {
+		signals[i] = sig
+	}
 
-		// Attempt to lock the database.
-		if f, err = os.OpenFile(c.WithHaltLockOn+"-lock", os.O_RDWR, 0666); os.IsNotExist(err) {
-			return fmt.Errorf("lock file not available, are you sure %q is a LiteFS mount?", filepath.Dir(c.WithHaltLockOn))
-		} else if err != nil {
-			return err
-		}
-		defer func() { _ = f.Close() }()
+	// Abort promptly on a signal while waiting for the halt lock.
+	haltCtx, stop := signal.NotifyContext(ctx, signals...)
+	defer stop()
 
-		t := time.Now()
-		log.Printf("acquiring halt lock")
-		if err := litefsgo.Halt(f); err != nil {
+	// Promote the node to primary, if requested. The node is demoted on every
+	// exit path, including if promotion fails partway or the command panics.
+	if c.WithLease {
+		defer c.demote()
+		if err := c.promote(haltCtx); err != nil {
 			return err
 		}
-		log.Printf("halt lock acquired in %s", time.Since(t))
 	}
 
-	// Execute subcommand.
-	cmd := exec.CommandContext(ctx, c.Cmd, c.Args...)
+	// Acquire the halt locks on the given databases, if specified.
+	files, err := c.acquireHaltLocks(haltCtx)
+	if err != nil {
+		return err
+	}
+	defer func() { closeFilesReverse(files) }()
+
+	// Once the subcommand is running, relay signals to it instead. The signal
+	// channel is registered before the context stops listening so no signal
+	// falls through to its default behavior in between.
+	signalCh := make(chan os.Signal, 1)
+	if len(signals) > 0 {
+		signal.Notify(signalCh, signals...)
+		defer signal.Stop(signalCh)
+	}
+	stop()
+
+	// Read the node state after promotion so it reflects the lease.
+	env, err := c.nodeEnv(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Execute subcommand in its own process group so that signals reach
+	// the whole process tree. If stdin is a terminal, the subcommand stays
+	// in our process group instead as a background group would be stopped
+	// by SIGTTIN when reading from it. The terminal sends job control
+	// signals to the whole foreground group in that case.
+	setpgid := !isatty.IsTerminal(os.Stdin.Fd())
+	cmd := exec.CommandContext(ctx, c.Cmd, expandNodeEnv(c.Args, env)...)
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	if f != nil {
-		cmd.ExtraFiles = []*os.File{f} // pass along, otherwise the file is flushed
-	}
-	if err := cmd.Run(); err != nil {
+	cmd.ExtraFiles = files // pass along, otherwise the files are flushed
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: setpgid}
+	cmd.Cancel = func() error { return signalSubcommand(cmd.Process.Pid, setpgid, syscall.SIGKILL) }
+	if err := cmd.Start(); err != nil {
 		return err
 	}
 
-	// Unhalt, if database specified.
-	if f != nil {
+	waitCh := make(chan error, 1)
+	go func() { waitCh <- cmd.Wait() }()
+
+	// If the subcommand exits with a non-zero code or is killed by a signal,
+	// still release the halt locks normally & then return its error so the
+	// process exits with the same code.
+	var exitErr *exec.ExitError
+	for exited := false; !exited; {
+		select {
+		case sig := <-signalCh:
+			log.Printf("forwarding signal to subcommand: %s", sig)
+			if err := signalSubcommand(cmd.Process.Pid, setpgid, sig.(syscall.Signal)); err != nil && err != syscall.ESRCH {
+				log.Printf("cannot forward signal: %s", err)
+			}
+		case err := <-waitCh:
+			if err != nil && !errors.As(err, &exitErr) {
+				return err
+			}
+			exited = true
+		}
+	}
+
+	// Unhalt databases in the reverse order that they were halted.
+	for i := len(files) - 1; i >= 0; i-- {
+		f := files[i]
+
 		t := time.Now()
-		log.Printf("releasing halt lock")
+		log.Printf("releasing halt lock on %s", f.Name())
 		if err := litefsgo.Unhalt(f); err != nil {
 			return err
 		}
@@ -130,5 +271,274 @@ func (c *RunCommand) Run(ctx context.Context) (err error) {
 			return err
 		}
 	}
+
+	if exitErr != nil {
+		return exitErr
+	}
+	return nil
+}
+
+// promote asks the local LiteFS node to become primary & waits until it is.
+func (c *RunCommand) promote(ctx context.Context) error {
+	if c.LeaseTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.LeaseTimeout)
+		defer cancel()
+	}
+
+	t := time.Now()
+	log.Printf("promoting node to primary")
+	if err := http.NewClient().Promote(ctx, c.URL); errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("timed out waiting for promotion after %s", c.LeaseTimeout)
+	} else if err != nil {
+		return fmt.Errorf("cannot promote node: %w", err)
+	}
+	log.Printf("promoted to primary in %s", time.Since(t))
+	return nil
+}
+
+// demote asks the local LiteFS node to release its primary lease. This is
+// called during cleanup so it is not bound to the command's context.
+func (c *RunCommand) demote() {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDemoteTimeout)
+	defer cancel()
+
+	log.Printf("demoting node")
+	if err := http.NewClient().Demote(ctx, c.URL); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot demote node: %s\n", err)
+	}
+}
+
+// nodeEnv returns the environment variables describing the local node. The
+// node state is only required if the arguments reference one of the variables
+// so, otherwise, an unreachable API is logged & no variables are returned.
+func (c *RunCommand) nodeEnv(ctx context.Context) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, nodeInfoTimeout)
+	defer cancel()
+
+	info, err := http.NewClient().Info(ctx, c.URL)
+	if err != nil {
+		if referencesNodeEnv(c.Args) {
+			return nil, fmt.Errorf("cannot read node info: %w", err)
+		}
+		log.Printf("cannot read node info, skipping environment variables: %s", err)
+		return nil, nil
+	}
+
+	env := map[string]string{
+		EnvNodeID:          litefs.FormatNodeID(info.ID),
+		EnvIsPrimary:       strconv.FormatBool(info.IsPrimary),
+		EnvPrimaryHostname: "",
+	}
+	if info.Primary != nil {
+		env[EnvPrimaryHostname] = info.Primary.Hostname
+	}
+	return env, nil
+}
+
+// nodeInfoTimeout is the time to wait for the node info from the LiteFS API.
+const nodeInfoTimeout = 5 * time.Second
+
+// expandNodeEnv replaces references to node environment variables in args.
+// Args that do not reference one are left unchanged.
+func expandNodeEnv(args []string, env map[string]string) []string {
+	other := make([]string, len(args))
+	for i, arg := range args {
+		if !referencesNodeEnv([]string{arg}) {
+			other[i] = arg
+			continue
+		}
+
+		other[i] = os.Expand(arg, func(name string) string {
+			if v, ok := env[name]; ok {
+				return v
+			}
+			return "${" + name + "}"
+		})
+	}
+	return other
+}
+
+// referencesNodeEnv returns true if any arg references a node environment variable.
+func referencesNodeEnv(args []string) bool {
+	for _, arg := range args {
+		var found bool
+		os.Expand(arg, func(name string) string {
+			switch name {
+			case EnvNodeID, EnvIsPrimary, EnvPrimaryHostname:
+				found = true
+			}
+			return ""
+		})
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// acquireHaltLocks opens the lock file & acquires the HALT lock for each
+// database. Locks are acquired in sorted order so that concurrent commands
+// cannot deadlock. If any lock cannot be acquired then all locks acquired so
+// far are released, in reverse order, and an error is returned.
+func (c *RunCommand) acquireHaltLocks(ctx context.Context) (files []*os.File, err error) {
+	defer func() {
+		if err != nil {
+			closeFilesReverse(files)
+		}
+	}()
+
+	paths := make([]string, len(c.WithHaltLockOn))
+	copy(paths, c.WithHaltLockOn)
+	sort.Strings(paths)
+
+	for i, path := range paths {
+		if i > 0 && path == paths[i-1] {
+			continue // skip duplicates, the lock is already held
+		}
+
+		// Ensure database exists first.
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return files, fmt.Errorf("database does not exist: %s", path)
+		} else if err != nil {
+			return files, err
+		}
+
+		// Attempt to lock the database.
+		f, err := os.OpenFile(path+"-lock", os.O_RDWR, 0666)
+		if os.IsNotExist(err) {
+			return files, fmt.Errorf("lock file not available, are you sure %q is a LiteFS mount?", filepath.Dir(path))
+		} else if err != nil {
+			return files, err
+		}
+
+		t := time.Now()
+		log.Printf("acquiring halt lock on %s", path)
+		if err := c.acquireHaltLock(ctx, f); err != nil {
+			_ = f.Close()
+			return files, fmt.Errorf("%s: %w", path, err)
+		}
+		log.Printf("halt lock acquired in %s", time.Since(t))
+
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// closeFilesReverse closes files in reverse order. Closing a lock file
+// releases any HALT lock held on it.
+func closeFilesReverse(files []*os.File) {
+	for i := len(files) - 1; i >= 0; i-- {
+		_ = files[i].Close()
+	}
+}
+
+// acquireHaltLock acquires the HALT lock on f. Attempts that time out on the
+// LiteFS node are retried, and logged, every HaltRetryInterval until
+// HaltTimeout elapses or ctx is done. The Halt() call blocks in a system call so it is run in a
+// separate goroutine & the lock file is closed on cancellation to abort it.
+func (c *RunCommand) acquireHaltLock(ctx context.Context, f *os.File) error {
+	var timeoutCh <-chan time.Time
+	if c.HaltTimeout > 0 {
+		timer := time.NewTimer(c.HaltTimeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	// Stop retrying once we return.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		for attempt := 1; ; attempt++ {
+			err := litefsgo.Halt(f)
+			if !errors.Is(err, syscall.EAGAIN) {
+				errCh <- err
+				return
+			}
+			log.Printf("halt lock unavailable, retrying in %s (attempt %d)", c.HaltRetryInterval, attempt)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(c.HaltRetryInterval):
+			}
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-timeoutCh:
+		_ = f.Close()
+		return fmt.Errorf("%w after %s, another node may be holding it", ErrHaltLockTimeout, c.HaltTimeout)
+	case <-ctx.Done():
+		_ = f.Close()
+		return context.Cause(ctx)
+	}
+}
+
+// signalNames maps names accepted by -forward-signals to signals.
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// parseSignals parses a comma-separated list of signal names, such as
+// "TERM,HUP" or "SIGTERM,SIGHUP". An empty string disables forwarding.
+func parseSignals(s string) ([]syscall.Signal, error) {
+	var a []syscall.Signal
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+		if !ok {
+			return nil, fmt.Errorf("unsupported signal: %q", name)
+		}
+		a = append(a, sig)
+	}
+	return a, nil
+}
+
+// signalSubcommand sends sig to the subcommand's process group if it has its
+// own group. Otherwise only the subcommand process itself is signaled.
+func signalSubcommand(pid int, group bool, sig syscall.Signal) error {
+	if group {
+		pid = -pid
+	}
+	return syscall.Kill(pid, sig)
+}
+
+// formatSignals returns a comma-separated list of signal names.
+func formatSignals(a []syscall.Signal) string {
+	names := make([]string, 0, len(a))
+	for _, sig := range a {
+		for name, v := range signalNames {
+			if v == sig {
+				names = append(names, name)
+			}
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// stringSliceFlag is a flag that can be repeated or passed comma-separated values.
+type stringSliceFlag []string
+
+func (f *stringSliceFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringSliceFlag) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*f = append(*f, v)
+		}
+	}
 	return nil
 }
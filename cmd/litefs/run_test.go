@@ -0,0 +1,394 @@
+package main_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/superfly/litefs"
+	litefsgo "github.com/superfly/litefs-go"
+	main "github.com/superfly/litefs/cmd/litefs"
+	"github.com/superfly/litefs/http"
+	"github.com/superfly/litefs/internal/testingutil"
+	"golang.org/x/sys/unix"
+)
+
+func TestRunCommand_HaltLock(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		cmd := newRunCommand(t, newHaltLockDB(t))
+		if err := cmd.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// Ensure the command gives up if another process holds the lock.
+	t.Run("Timeout", func(t *testing.T) {
+		path := newHaltLockDB(t)
+		holdHaltLock(t, path)
+
+		cmd := newRunCommand(t, path)
+		cmd.HaltTimeout = 100 * time.Millisecond
+		cmd.HaltRetryInterval = 10 * time.Millisecond
+		if err := cmd.Run(context.Background()); !errors.Is(err, main.ErrHaltLockTimeout) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Ensure the command aborts while waiting if the context is canceled.
+	t.Run("ContextCanceled", func(t *testing.T) {
+		path := newHaltLockDB(t)
+		holdHaltLock(t, path)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+
+		cmd := newRunCommand(t, path)
+		cmd.HaltTimeout = 0
+		if err := cmd.Run(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure all databases are halted & that locks are released if any fail.
+func TestRunCommand_MultipleHaltLocks(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		path0, path1 := newHaltLockDB(t), newHaltLockDB(t)
+		cmd := newRunCommand(t, path1, path0)
+		if err := cmd.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if isHaltLocked(t, path0) || isHaltLocked(t, path1) {
+			t.Fatal("expected halt locks to be released")
+		}
+	})
+
+	t.Run("ReleaseOnFailure", func(t *testing.T) {
+		dir := t.TempDir()
+		path0, path1 := filepath.Join(dir, "a.db"), filepath.Join(dir, "b.db")
+		createHaltLockDB(t, path0)
+		createHaltLockDB(t, path1)
+		holdHaltLock(t, path1)
+
+		cmd := newRunCommand(t, path0, path1)
+		cmd.HaltTimeout = 100 * time.Millisecond
+		cmd.HaltRetryInterval = 10 * time.Millisecond
+		if err := cmd.Run(context.Background()); err == nil || !strings.Contains(err.Error(), path1) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if isHaltLocked(t, path0) {
+			t.Fatal("expected first halt lock to be released")
+		}
+	})
+}
+
+// Ensure the subcommand's exit code is returned after releasing the halt lock.
+func TestRunCommand_ExitCode(t *testing.T) {
+	path := newHaltLockDB(t)
+	cmd := newRunCommand(t, path)
+	cmd.Cmd, cmd.Args = "sh", []string{"-c", "exit 3"}
+
+	var exitErr *exec.ExitError
+	if err := cmd.Run(context.Background()); !errors.As(err, &exitErr) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if got, want := exitErr.ExitCode(), 3; got != want {
+		t.Fatalf("ExitCode=%d, want %d", got, want)
+	} else if isHaltLocked(t, path) {
+		t.Fatal("expected halt lock to be released")
+	}
+}
+
+// Ensure signals are relayed to the subcommand & the halt lock is released.
+func TestRunCommand_ForwardSignals(t *testing.T) {
+	path := newHaltLockDB(t)
+	readyPath := filepath.Join(t.TempDir(), "ready")
+
+	cmd := newRunCommand(t, path)
+	cmd.ForwardSignals = []syscall.Signal{syscall.SIGHUP}
+	cmd.Cmd, cmd.Args = "sh", []string{"-c", `trap "exit 7" HUP; touch "$0"; while :; do sleep 0.01; done`, readyPath}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- cmd.Run(context.Background()) }()
+
+	// Signal ourselves once the subcommand has installed its trap.
+	testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+		if _, err := os.Stat(readyPath); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	var exitErr *exec.ExitError
+	select {
+	case err := <-errCh:
+		if !errors.As(err, &exitErr) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if got, want := exitErr.ExitCode(), 7; got != want {
+			t.Fatalf("ExitCode=%d, want %d", got, want)
+		} else if isHaltLocked(t, path) {
+			t.Fatal("expected halt lock to be released")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for subcommand")
+	}
+}
+
+// Ensure the node is promoted while the subcommand runs & demoted afterward.
+func TestRunCommand_WithLease(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		store := litefs.NewStore(t.TempDir(), true)
+		store.DemoteDelay = time.Minute
+		store.Leaser = litefs.NewStaticLeaser(true, "localhost", "")
+		url := newStoreServer(t, store)
+		<-store.ReadyCh()
+
+		cmd := newRunCommand(t)
+		cmd.WithLease, cmd.URL = true, url
+		if err := cmd.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+			if store.IsPrimary() {
+				return fmt.Errorf("expected node to be demoted")
+			}
+			return nil
+		})
+	})
+
+	// Ensure the subcommand is not run if the node cannot be promoted.
+	t.Run("ErrNotCandidate", func(t *testing.T) {
+		store := litefs.NewStore(t.TempDir(), false)
+		store.Leaser = litefs.NewStaticLeaser(false, "localhost", "http://localhost:1")
+		url := newStoreServer(t, store)
+
+		path := filepath.Join(t.TempDir(), "ran")
+		cmd := newRunCommand(t)
+		cmd.WithLease, cmd.URL = true, url
+		cmd.Cmd, cmd.Args = "touch", []string{path}
+		if err := cmd.Run(context.Background()); !errors.Is(err, litefs.ErrNotCandidate) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatal("expected subcommand not to run")
+		}
+	})
+}
+
+// Ensure the node state is passed to the subcommand in its args & environment.
+func TestRunCommand_NodeEnv(t *testing.T) {
+	store := litefs.NewStore(t.TempDir(), true)
+	store.Leaser = litefs.NewStaticLeaser(true, "localhost", "")
+	url := newStoreServer(t, store)
+	<-store.ReadyCh()
+
+	path := filepath.Join(t.TempDir(), "out")
+	cmd := newRunCommand(t)
+	cmd.URL = url
+	cmd.Cmd, cmd.Args = "sh", []string{"-c", `echo "$0 $1 $LITEFS_NODE_ID $LITEFS_IS_PRIMARY" > "$2"`, "$LITEFS_NODE_ID", "${LITEFS_IS_PRIMARY}", path}
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	id := litefs.FormatNodeID(store.ID())
+	if buf, err := os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if got, want := strings.TrimSpace(string(buf)), id+" true "+id+" true"; got != want {
+		t.Fatalf("output=%q, want %q", got, want)
+	}
+
+	// Referencing node variables requires the node state.
+	t.Run("ErrUnavailable", func(t *testing.T) {
+		cmd := newRunCommand(t)
+		cmd.URL = "http://127.0.0.1:1"
+		cmd.Cmd, cmd.Args = "echo", []string{"$LITEFS_NODE_ID"}
+		if err := cmd.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "cannot read node info") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure the subcommand only shares our process group when stdin is a
+// terminal so that it is not stopped by SIGTTIN when reading from it.
+func TestRunCommand_ProcessGroup(t *testing.T) {
+	// runPGID runs a subcommand with stdin & returns its process group ID.
+	runPGID := func(t *testing.T, stdin *os.File) int {
+		prev := os.Stdin
+		os.Stdin = stdin
+		defer func() { os.Stdin = prev }()
+
+		path := filepath.Join(t.TempDir(), "pgid")
+		cmd := newRunCommand(t)
+		cmd.Cmd, cmd.Args = "sh", []string{"-c", `cut -d" " -f5 /proc/$$/stat > "$0"`, path}
+		if err := cmd.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pgid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pgid
+	}
+
+	t.Run("NonTerminal", func(t *testing.T) {
+		f, err := os.Open(os.DevNull)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+
+		if pgid := runPGID(t, f); pgid == syscall.Getpgrp() {
+			t.Fatalf("expected subcommand in its own process group, got %d", pgid)
+		}
+	})
+
+	t.Run("Terminal", func(t *testing.T) {
+		tty := openTTY(t)
+		if got, want := runPGID(t, tty), syscall.Getpgrp(); got != want {
+			t.Fatalf("pgid=%d, want %d", got, want)
+		}
+	})
+}
+
+func TestRunCommand_ParseFlags(t *testing.T) {
+	cmd := main.NewRunCommand()
+	if err := cmd.ParseFlags(context.Background(), []string{"-with-halt-lock-on", "/a.db,/b.db", "-with-halt-lock-on", "/c.db", "--", "true"}); err != nil {
+		t.Fatal(err)
+	} else if got, want := strings.Join(cmd.WithHaltLockOn, ","), "/a.db,/b.db,/c.db"; got != want {
+		t.Fatalf("WithHaltLockOn=%s, want %s", got, want)
+	} else if got, want := cmd.ForwardSignals, main.DefaultForwardSignals; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ForwardSignals=%v, want %v", got, want)
+	}
+
+	t.Run("ForwardSignals", func(t *testing.T) {
+		cmd := main.NewRunCommand()
+		if err := cmd.ParseFlags(context.Background(), []string{"-forward-signals", "sigterm,USR1", "--", "true"}); err != nil {
+			t.Fatal(err)
+		} else if got, want := cmd.ForwardSignals, []syscall.Signal{syscall.SIGTERM, syscall.SIGUSR1}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("ForwardSignals=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("ErrUnsupportedSignal", func(t *testing.T) {
+		cmd := main.NewRunCommand()
+		if err := cmd.ParseFlags(context.Background(), []string{"-forward-signals", "KILL", "--", "true"}); err == nil || err.Error() != `unsupported signal: "KILL"` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// newRunCommand returns a RunCommand that executes "true" with halt locks on paths.
+// newStoreServer opens store & serves its API. Returns the API URL.
+func newStoreServer(tb testing.TB, store *litefs.Store) string {
+	tb.Helper()
+	if err := store.Open(); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = store.Close() })
+
+	server := http.NewServer(store, "127.0.0.1:0")
+	if err := server.Listen(); err != nil {
+		tb.Fatal(err)
+	}
+	server.Serve()
+	tb.Cleanup(func() { _ = server.Close() })
+	return fmt.Sprintf("http://127.0.0.1:%d", server.Port())
+}
+
+func newRunCommand(tb testing.TB, paths ...string) *main.RunCommand {
+	tb.Helper()
+	cmd := main.NewRunCommand()
+	cmd.WithHaltLockOn = paths
+	cmd.Cmd = "true"
+	return cmd
+}
+
+// openTTY opens the terminal side of a new pseudoterminal without making it
+// the controlling terminal. Skips the test if pseudoterminals are unavailable.
+func openTTY(tb testing.TB) *os.File {
+	tb.Helper()
+
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		tb.Skipf("pseudoterminal unavailable: %s", err)
+	}
+	tb.Cleanup(func() { _ = ptmx.Close() })
+
+	if err := unix.IoctlSetPointerInt(int(ptmx.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		tb.Skipf("cannot unlock pseudoterminal: %s", err)
+	}
+	n, err := unix.IoctlGetInt(int(ptmx.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		tb.Skipf("cannot read pseudoterminal number: %s", err)
+	}
+
+	tty, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		tb.Skipf("pseudoterminal unavailable: %s", err)
+	}
+	tb.Cleanup(func() { _ = tty.Close() })
+	return tty
+}
+
+// newHaltLockDB returns the path to a database & lock file on a regular file
+// system. The OFD locks on the lock file behave like those on a LiteFS mount.
+func newHaltLockDB(tb testing.TB) string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "db")
+	createHaltLockDB(tb, path)
+	return path
+}
+
+// createHaltLockDB creates an empty database & lock file at path.
+func createHaltLockDB(tb testing.TB, path string) {
+	tb.Helper()
+	for _, name := range []string{path, path + "-lock"} {
+		if err := os.WriteFile(name, nil, 0666); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// isHaltLocked returns true if another file handle holds the halt lock on path.
+func isHaltLocked(tb testing.TB, path string) bool {
+	tb.Helper()
+	f, err := os.Open(path + "-lock")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	lk := syscall.Flock_t{Type: syscall.F_WRLCK, Start: litefsgo.HaltByte, Len: 1}
+	if err := syscall.FcntlFlock(f.Fd(), litefsgo.F_OFD_GETLK, &lk); err != nil {
+		tb.Fatal(err)
+	}
+	return lk.Type != syscall.F_UNLCK
+}
+
+// holdHaltLock acquires the halt lock on path until the test ends.
+func holdHaltLock(tb testing.TB, path string) {
+	tb.Helper()
+	f, err := os.OpenFile(path+"-lock", os.O_RDWR, 0666)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = f.Close() })
+
+	if err := litefsgo.Halt(f); err != nil {
+		tb.Fatal(err)
+	}
+}
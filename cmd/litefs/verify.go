@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/superfly/litefs/http"
+)
+
+// VerifyCommand represents a command to verify the integrity of a database.
+type VerifyCommand struct {
+	// Target LiteFS URL
+	URL string
+
+	// Name of database on LiteFS node.
+	Name string
+
+	// If true, only the database checksum is verified & LTX files are not read.
+	ChecksumOnly bool
+}
+
+// NewVerifyCommand returns a new instance of VerifyCommand.
+func NewVerifyCommand() *VerifyCommand {
+	return &VerifyCommand{
+		URL: DefaultURL,
+	}
+}
+
+// ParseFlags parses the command line flags & config file.
+func (c *VerifyCommand) ParseFlags(ctx context.Context, args []string) (err error) {
+	fs := flag.NewFlagSet("litefs-verify", flag.ContinueOnError)
+	fs.StringVar(&c.URL, "url", "http://localhost:20202", "LiteFS API URL")
+	fs.StringVar(&c.Name, "name", "", "database name")
+	fs.BoolVar(&c.ChecksumOnly, "checksum-only", false, "only verify the database checksum")
+	fs.Usage = func() {
+		fmt.Println(`
+The verify command checks a database on a LiteFS node against its LTX files.
+Every retained LTX file is read & its checksums must form a continuous chain
+that ends at the checksum of the current database file. Writes to the database
+are blocked while it is being verified.
+
+If --checksum-only is specified, LTX files are not read & only the checksum of
+the database file is compared against its current position. The first page
+that does not match is reported on failure.
+
+Usage:
+
+	litefs verify [arguments]
+
+Arguments:
+`[1:])
+		fs.PrintDefaults()
+		fmt.Println("")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() > 0 {
+		return fmt.Errorf("too many arguments")
+	} else if c.Name == "" {
+		return fmt.Errorf("database name required")
+	}
+	return nil
+}
+
+// Run executes the command.
+func (c *VerifyCommand) Run(ctx context.Context) (err error) {
+	t := time.Now()
+
+	client := http.NewClient()
+	if err := client.Verify(ctx, c.URL, c.Name, c.ChecksumOnly); err != nil {
+		return err
+	}
+
+	// Notify user of success and elapsed time.
+	fmt.Printf("Verified database %q in %s\n", c.Name, time.Since(t))
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package main_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/superfly/litefs"
+	main "github.com/superfly/litefs/cmd/litefs"
+	"github.com/superfly/litefs/internal/testingutil"
+)
+
+// Ensure a database can be verified on a LiteFS node.
+func TestVerifyCommand(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		m0 := runMountCommand(t, newMountCommand(t, t.TempDir(), nil))
+		waitForPrimary(t, m0)
+
+		db := testingutil.OpenSQLDB(t, filepath.Join(m0.Config.FUSE.Dir, "my.db"))
+		if _, err := db.Exec(`CREATE TABLE t (x)`); err != nil {
+			t.Fatal(err)
+		} else if _, err := db.Exec(`INSERT INTO t VALUES (100)`); err != nil {
+			t.Fatal(err)
+		} else if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := main.NewVerifyCommand()
+		cmd.URL = m0.HTTPServer.URL()
+		cmd.Name = "my.db"
+		if err := cmd.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrDatabaseNotFound", func(t *testing.T) {
+		m0 := runMountCommand(t, newMountCommand(t, t.TempDir(), nil))
+		waitForPrimary(t, m0)
+
+		cmd := main.NewVerifyCommand()
+		cmd.URL = m0.HTTPServer.URL()
+		cmd.Name = "nosuchdatabase"
+		if err := cmd.Run(context.Background()); err != litefs.ErrDatabaseNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
@@ -22,6 +22,8 @@ const (
 	DefaultLockDelay   = 1 * time.Second
 )
 
+var _ litefs.HandoffLeaser = (*Leaser)(nil)
+
 // Leaser represents an API for obtaining a distributed lock on a single key.
 type Leaser struct {
 	consulURL    string
@@ -43,6 +45,9 @@ type Leaser struct {
 
 	// LockDefault is the time after the lock expires that a new lock can be acquired.
 	LockDelay time.Duration
+
+	// Candidate weight advertised in the primary info while holding the lock.
+	Weight int
 }
 
 // NewLeaser returns a new instance of Leaser.
@@ -132,6 +137,7 @@ func (l *Leaser) kvValue() ([]byte, error) {
 	return json.Marshal(litefs.PrimaryInfo{
 		Hostname:     l.hostname,
 		AdvertiseURL: l.advertiseURL,
+		Weight:       l.Weight,
 	})
 }
 
@@ -187,6 +193,22 @@ func (l *Leaser) AcquireExisting(ctx context.Context, leaseID string) (litefs.Le
 	if err := lease.Renew(ctx); err != nil {
 		return nil, err
 	}
+
+	// Update the key so other nodes connect to this node as the primary.
+	kvValue, err := l.kvValue()
+	if err != nil {
+		return nil, fmt.Errorf("marshal lease info: %w", err)
+	}
+
+	if acquired, _, err := l.client.KV().Acquire(&api.KVPair{
+		Key:     l.kvKey(),
+		Value:   kvValue,
+		Session: leaseID,
+	}, nil); err != nil {
+		return nil, fmt.Errorf("put consul key/value: %w", err)
+	} else if !acquired {
+		return nil, litefs.ErrLeaseExpired
+	}
 	return lease, nil
 }
 
@@ -205,6 +227,8 @@ func (l *Leaser) PrimaryInfo(ctx context.Context) (info litefs.PrimaryInfo, err
 	return info, nil
 }
 
+var _ litefs.HandoffLease = (*Lease)(nil)
+
 // Lease represents a distributed lock obtained by the Leaser.
 type Lease struct {
 	leaser    *Leaser
@@ -220,6 +244,9 @@ func newLease(leaser *Leaser, sessionID string, renewedAt time.Time) *Lease {
 	}
 }
 
+// ID returns the consul session ID.
+func (l *Lease) ID() string { return l.sessionID }
+
 // TTL returns the time-to-live value the lease was initialized with.
 func (l *Lease) TTL() time.Duration { return l.leaser.TTL }
 
@@ -2,6 +2,7 @@ package litefs
 
 import (
 	"bytes"
+	"container/list"
 	"context"
 	"encoding/binary"
 	"errors"
@@ -9,6 +10,7 @@ import (
 	"io"
 	"io/fs"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
@@ -36,8 +38,23 @@ type DB struct {
 	pageN    uint32       // database size, in pages
 	pos      atomic.Value // current tx position (Pos)
 	mode     atomic.Value // database journaling mode (rollback, wal)
+
+	posMu sync.Mutex
+	posCh chan struct{} // closed & replaced when the position changes or db is removed
+
+	primaryTXID      atomic.Uint64 // latest TXID reported by the primary in the stream, if replica
+	primaryTimestamp atomic.Int64  // LTX timestamp of primaryTXID on the primary, in milliseconds
+
+	backedUpLTX sync.Map    // filenames of LTX files written to Store.Backup
+	compactMu   sync.Mutex  // serializes compaction
+	diverged    atomic.Bool // if true, replication halted after diverging from the primary
+	quarantined atomic.Bool // if true, changes are skipped until a snapshot is received
+	removed     atomic.Bool // if true, db was dropped or renamed & locks can no longer be acquired
 	// waiting  atomic.Bool  // if true, database is waiting to catch up for a remote tx
 
+	backupLoadMu sync.Mutex
+	backupLoaded bool // if true, backedUpLTX includes files already in Store.Backup
+
 	// Halt lock prevents writes or checkpoints on the primary so that
 	// replica nodes can perform writes and send them back to the primary.
 	//
@@ -61,6 +78,9 @@ type DB struct {
 	}
 	shmMu sync.Mutex // shm invalidation can trigger mmap write that we need to avoid
 
+	// Headers & trailers of recently read LTX files.
+	ltxHeaders *ltxHeaderCache
+
 	// Collection of outstanding guard sets, protected by a mutex.
 	guardSets struct {
 		mu sync.Mutex
@@ -83,6 +103,13 @@ type DB struct {
 	read4Lock   RWMutex
 	dmsLock     RWMutex
 
+	// Per-database settings. These may be changed while the database is in
+	// use so they are only accessed through their getter & setter methods.
+	retentionMaxFiles atomic.Int64 // overrides Store.RetentionMaxFiles, if greater than zero
+	readOnly          atomic.Bool  // if true, application writes are rejected
+	beginTimeout      atomic.Int64 // overrides Store.BeginTimeout, if greater than zero
+	noReplicate       atomic.Bool  // if true, the database is not streamed to replicas
+
 	// Returns the current time. Used for mocking time in tests.
 	Now func() time.Time
 }
@@ -95,9 +122,12 @@ func NewDB(store *Store, name string, path string) *DB {
 		path:  path,
 
 		dirtyPageSet: make(map[uint32]struct{}),
+		posCh:        make(chan struct{}),
 
 		Now: time.Now,
 	}
+	db.readOnly.Store(store.isReadOnlyDB(name))
+	db.noReplicate.Store(store.isNoReplicateDB(name))
 	db.pos.Store(Pos{})
 	db.mode.Store(DBModeRollback)
 	db.haltLockAndGuard.Store((*haltLockAndGuard)(nil))
@@ -106,6 +136,7 @@ func NewDB(store *Store, name string, path string) *DB {
 	db.wal.frameOffsets = make(map[uint32]int64)
 	db.wal.chksums = make(map[uint32][]uint64)
 	db.guardSets.m = make(map[uint64]*GuardSet)
+	db.ltxHeaders = newLTXHeaderCache(store.LTXHeaderCacheSize)
 
 	return db
 }
@@ -119,6 +150,45 @@ func (db *DB) Store() *Store { return db.store }
 // Path of the database's data directory.
 func (db *DB) Path() string { return db.path }
 
+// Diverged returns true if replication of the database has halted because its
+// position was ahead of the primary. See DivergenceHalt.
+func (db *DB) Diverged() bool { return db.diverged.Load() }
+
+// Quarantined returns true if the database received a change that did not
+// match its position & is waiting on a snapshot. See DivergencePolicyQuarantine.
+func (db *DB) Quarantined() bool { return db.quarantined.Load() }
+
+// RetentionMaxFiles returns the maximum number of LTX files to retain for the
+// database. Returns zero if the store's RetentionMaxFiles setting is used.
+func (db *DB) RetentionMaxFiles() int { return int(db.retentionMaxFiles.Load()) }
+
+// SetRetentionMaxFiles overrides the store's RetentionMaxFiles setting for
+// the database, if n is greater than zero.
+func (db *DB) SetRetentionMaxFiles(n int) { db.retentionMaxFiles.Store(int64(n)) }
+
+// ReadOnly returns true if application writes are rejected even on the primary.
+// Replicated & imported changes are still applied.
+func (db *DB) ReadOnly() bool { return db.readOnly.Load() }
+
+// SetReadOnly sets whether application writes to the database are rejected.
+func (db *DB) SetReadOnly(v bool) { db.readOnly.Store(v) }
+
+// BeginTimeout returns the maximum time to wait for the write lock in
+// AcquireWriteLock. Returns zero if the store's BeginTimeout setting is used.
+func (db *DB) BeginTimeout() time.Duration { return time.Duration(db.beginTimeout.Load()) }
+
+// SetBeginTimeout overrides the store's BeginTimeout setting for the
+// database, if d is greater than zero.
+func (db *DB) SetBeginTimeout(d time.Duration) { db.beginTimeout.Store(int64(d)) }
+
+// NoReplicate returns true if the database is not streamed to replicas. Local
+// writes are still allowed on the primary.
+func (db *DB) NoReplicate() bool { return db.noReplicate.Load() }
+
+// SetNoReplicate sets whether the database is streamed to replicas. If
+// replication is later enabled then replicas receive a full snapshot.
+func (db *DB) SetNoReplicate(v bool) { db.noReplicate.Store(v) }
+
 // LTXDir returns the path to the directory of LTX transaction files.
 func (db *DB) LTXDir() string { return filepath.Join(db.path, "ltx") }
 
@@ -129,7 +199,7 @@ func (db *DB) LTXPath(minTXID, maxTXID uint64) string {
 
 // ReadLTXDir returns DirEntry for every LTX file.
 func (db *DB) ReadLTXDir() ([]fs.DirEntry, error) {
-	ents, err := os.ReadDir(db.LTXDir())
+	ents, err := db.store.FS.ReadDir(db.LTXDir())
 	if os.IsNotExist(err) {
 		return nil, nil
 	} else if err != nil {
@@ -148,6 +218,125 @@ func (db *DB) ReadLTXDir() ([]fs.DirEntry, error) {
 	return ents, nil
 }
 
+// LTXFileInfo describes an LTX file stored for a database.
+type LTXFileInfo struct {
+	MinTXID  uint64
+	MaxTXID  uint64
+	Size     int64
+	Snapshot bool
+}
+
+// ForEachLTX calls fn for every LTX file of the database in ascending TXID
+// order. Temporary files are skipped as are files removed by retention
+// enforcement during iteration. Iteration stops if fn returns an error.
+func (db *DB) ForEachLTX(fn func(info LTXFileInfo) error) error {
+	ents, err := db.ReadLTXDir()
+	if err != nil {
+		return err
+	}
+
+	for _, ent := range ents {
+		minTXID, maxTXID, _ := ltx.ParseFilename(ent.Name())
+
+		fi, err := ent.Info()
+		if os.IsNotExist(err) {
+			continue // removed by retention enforcement
+		} else if err != nil {
+			return fmt.Errorf("info: %w", err)
+		}
+
+		if err := fn(LTXFileInfo{
+			MinTXID:  minTXID,
+			MaxTXID:  maxTXID,
+			Size:     fi.Size(),
+			Snapshot: minTXID == 1,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ltxHeaderCache is a bounded LRU cache of LTX file headers & trailers keyed
+// by filename. Entries must be removed whenever a file is written or removed.
+// A cache with a non-positive size is disabled.
+type ltxHeaderCache struct {
+	mu    sync.Mutex
+	size  int
+	list  *list.List // least recently used at back
+	elems map[string]*list.Element
+}
+
+type ltxHeaderCacheEntry struct {
+	filename string
+	hdr      ltx.Header
+	trailer  ltx.Trailer
+}
+
+func newLTXHeaderCache(size int) *ltxHeaderCache {
+	return &ltxHeaderCache{
+		size:  size,
+		list:  list.New(),
+		elems: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached header & trailer for filename, if any.
+func (c *ltxHeaderCache) Get(filename string) (ltx.Header, ltx.Trailer, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem := c.elems[filename]
+	if elem == nil {
+		return ltx.Header{}, ltx.Trailer{}, false
+	}
+	c.list.MoveToFront(elem)
+	entry := elem.Value.(*ltxHeaderCacheEntry)
+	return entry.hdr, entry.trailer, true
+}
+
+// Add caches the header & trailer for filename. Evicts the least recently
+// used entry if the cache is full.
+func (c *ltxHeaderCache) Add(filename string, hdr ltx.Header, trailer ltx.Trailer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size <= 0 {
+		return
+	} else if elem := c.elems[filename]; elem != nil {
+		elem.Value = &ltxHeaderCacheEntry{filename: filename, hdr: hdr, trailer: trailer}
+		c.list.MoveToFront(elem)
+		return
+	}
+
+	c.elems[filename] = c.list.PushFront(&ltxHeaderCacheEntry{filename: filename, hdr: hdr, trailer: trailer})
+	for c.list.Len() > c.size {
+		elem := c.list.Back()
+		c.list.Remove(elem)
+		delete(c.elems, elem.Value.(*ltxHeaderCacheEntry).filename)
+	}
+}
+
+// Remove removes the entry for filename, if any.
+func (c *ltxHeaderCache) Remove(filename string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem := c.elems[filename]; elem != nil {
+		c.list.Remove(elem)
+		delete(c.elems, filename)
+	}
+}
+
+// Purge removes all entries.
+func (c *ltxHeaderCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.list.Init()
+	c.elems = make(map[string]*list.Element)
+}
+
 // DatabasePath returns the path to the underlying database file.
 func (db *DB) DatabasePath() string { return filepath.Join(db.path, "database") }
 
@@ -168,6 +357,7 @@ func (db *DB) Pos() Pos {
 // setPos sets the current transaction position of the database.
 func (db *DB) setPos(pos Pos) error {
 	db.pos.Store(pos)
+	db.notifyPos()
 
 	// Invalidate page cache.
 	if invalidator := db.store.Invalidator; invalidator != nil {
@@ -178,20 +368,86 @@ func (db *DB) setPos(pos Pos) error {
 
 	// Update metrics.
 	dbTXIDMetricVec.WithLabelValues(db.name).Set(float64(pos.TXID))
+	db.updateLagMetrics()
 
 	return nil
 }
 
+// notifyPos wakes goroutines waiting in WaitForPos(). This is called when the
+// position changes & when the database is removed from the store.
+func (db *DB) notifyPos() {
+	db.posMu.Lock()
+	defer db.posMu.Unlock()
+	close(db.posCh)
+	db.posCh = make(chan struct{})
+}
+
+// posNotifyCh returns a channel that is closed on the next call to notifyPos().
+func (db *DB) posNotifyCh() <-chan struct{} {
+	db.posMu.Lock()
+	defer db.posMu.Unlock()
+	return db.posCh
+}
+
+// TXIDLag returns the number of transactions that the database is behind the
+// position reported by the primary in the replication stream. Always zero on
+// the primary or if the primary does not report its position.
+func (db *DB) TXIDLag() uint64 {
+	if primaryTXID, txID := db.primaryTXID.Load(), db.TXID(); primaryTXID > txID {
+		return primaryTXID - txID
+	}
+	return 0
+}
+
+// ReplicationLag returns the difference between the LTX timestamp of the
+// latest transaction reported by the primary and the LTX timestamp of the last
+// applied transaction. Always zero if the database is caught up, even if the
+// primary has been idle since its last transaction, or if either timestamp is
+// unknown.
+func (db *DB) ReplicationLag() time.Duration {
+	if db.TXIDLag() == 0 {
+		return 0
+	}
+
+	primaryTimestamp, t := db.primaryTimestamp.Load(), db.Pos().Timestamp
+	if primaryTimestamp == 0 || t.IsZero() {
+		return 0
+	}
+
+	lag := time.Duration(primaryTimestamp-t.UnixMilli()) * time.Millisecond
+	if lag < 0 {
+		return 0
+	}
+	return lag
+}
+
+// setPrimaryTXID sets the latest transaction ID reported by the primary along
+// with the LTX timestamp of that transaction, in milliseconds.
+func (db *DB) setPrimaryTXID(txID uint64, timestamp int64) {
+	db.primaryTXID.Store(txID)
+	db.primaryTimestamp.Store(timestamp)
+	db.updateLagMetrics()
+}
+
+// updateLagMetrics updates the replica lag metrics for the database.
+func (db *DB) updateLagMetrics() {
+	dbReplicaLagTXIDMetricVec.WithLabelValues(db.name).Set(float64(db.TXIDLag()))
+	dbReplicationLagSecondsMetricVec.WithLabelValues(db.name).Set(db.ReplicationLag().Seconds())
+}
+
 // Mode returns the journaling mode for the database (DBModeWAL or DBModeRollback).
 func (db *DB) Mode() DBMode {
 	return db.mode.Load().(DBMode)
 }
 
-// AcquireHaltLock acquires the halt lock locally.
-// This implicitly acquires locks required for locking & performs a checkpoint.
-func (db *DB) AcquireHaltLock(ctx context.Context, lockID int64) (_ *HaltLock, retErr error) {
+// AcquireHaltLock acquires the halt lock locally on behalf of the node with
+// the given ID. This implicitly acquires locks required for locking & performs
+// a checkpoint.
+func (db *DB) AcquireHaltLock(ctx context.Context, nodeID uint64, lockID int64) (_ *HaltLock, retErr error) {
 	if lockID == 0 {
 		return nil, fmt.Errorf("halt lock id required")
+	} else if db.store.IsDraining() {
+		return nil, ErrStoreDraining
 	}
 
 	var msg string
@@ -202,6 +458,7 @@ func (db *DB) AcquireHaltLock(ctx context.Context, lockID int64) (_ *HaltLock, r
 
 	acquireCtx, cancel := context.WithTimeout(ctx, db.store.HaltAcquireTimeout)
 	defer cancel()
+	t0 := time.Now()
 
 	// Acquire a write lock before setting the halt lock. This can cause a race
 	// by the replica when a FUSE call is interrupted and the call is retried.
@@ -219,6 +476,9 @@ func (db *DB) AcquireHaltLock(ctx context.Context, lockID int64) (_ *HaltLock, r
 	if err == errHaltLockAlreadyAcquired {
 		return &currHaltLock, nil
 	} else if err != nil {
+		if acquireCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			log.Printf("%s: halt lock acquisition on %q timed out after %s", db.store.LogPrefix(), db.name, time.Since(t0))
+		}
 		return nil, err
 	}
 	defer func() {
@@ -233,11 +493,14 @@ func (db *DB) AcquireHaltLock(ctx context.Context, lockID int64) (_ *HaltLock, r
 	}
 
 	// Generate a random identifier for the lock so it can be referenced by clients.
-	expires := time.Now().Add(db.store.HaltLockTTL)
+	acquiredAt := time.Now()
+	expires := acquiredAt.Add(db.store.HaltLockTTL)
 	haltLock := &HaltLock{
-		ID:      lockID,
-		Pos:     db.Pos(),
-		Expires: &expires,
+		ID:         lockID,
+		NodeID:     nodeID,
+		Pos:        db.Pos(),
+		AcquiredAt: &acquiredAt,
+		Expires:    &expires,
 	}
 
 	// There shouldn't be an existing halt lock but clear it just in case.
@@ -299,6 +562,8 @@ func (db *DB) EnforceHaltLockExpiration(ctx context.Context) {
 	// Clear lock & unlock its guards.
 	db.haltLockAndGuard.CompareAndSwap(curr, (*haltLockAndGuard)(nil))
 	curr.guardSet.Unlock()
+
+	dbHaltExpireCountMetricVec.WithLabelValues(db.name).Inc()
 }
 
 // AcquireRemoteHaltLock acquires the remote lock and syncs the database to its
@@ -312,6 +577,10 @@ func (db *DB) AcquireRemoteHaltLock(ctx context.Context, lockID int64) (_ *HaltL
 
 	if lockID == 0 {
 		return nil, fmt.Errorf("remote halt lock id required")
+	} else if db.store.ReadOnly {
+		return nil, ErrReadOnly
+	} else if db.store.IsDraining() {
+		return nil, ErrStoreDraining
 	}
 
 	isPrimary, info := db.store.PrimaryInfo()
@@ -322,6 +591,7 @@ func (db *DB) AcquireRemoteHaltLock(ctx context.Context, lockID int64) (_ *HaltL
 	}
 
 	// Request the remote lock from the primary node.
+	t0 := time.Now()
 	haltLock, err := db.store.Client.AcquireHaltLock(ctx, info.AdvertiseURL, db.store.ID(), db.name, lockID)
 	if err != nil {
 		return nil, fmt.Errorf("remote begin: %w", err)
@@ -345,6 +615,12 @@ func (db *DB) AcquireRemoteHaltLock(ctx context.Context, lockID int64) (_ *HaltL
 		return nil, fmt.Errorf("wait: %w", err)
 	}
 
+	elapsed := time.Since(t0)
+	dbHaltAcquireSecondsMetricVec.WithLabelValues(db.name).Observe(elapsed.Seconds())
+	if elapsed > db.store.HaltAcquireTimeout {
+		log.Printf("%s: slow remote halt lock acquisition on %q: %s", db.store.LogPrefix(), db.name, elapsed)
+	}
+
 	other := *haltLock
 	return &other, nil
 }
@@ -427,6 +703,128 @@ func (db *DB) WaitPosExact(ctx context.Context, target Pos) error {
 	}
 }
 
+// WaitForPos blocks until the database has reached or passed the TXID of pos
+// or until ctx is done. This can be used by replicas to read their own writes
+// after they have been committed on the primary.
+//
+// Returns ErrPosForked if the database has a different checksum at the TXID
+// of pos. The checksum is not verified if pos has no checksum. Returns
+// ErrDatabaseNotFound if the database is dropped or renamed while waiting.
+func (db *DB) WaitForPos(ctx context.Context, pos Pos) error {
+	for {
+		// Fetch the channel before checking the position so no change is missed.
+		ch := db.posNotifyCh()
+		if db.store.DB(db.name) != db {
+			return ErrDatabaseNotFound
+		} else if db.TXID() >= pos.TXID {
+			return db.verifyPos(pos)
+		}
+
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-ch:
+		}
+	}
+}
+
+// verifyPos returns ErrPosForked if the checksum of the database at the TXID
+// of pos does not match. Positions without a checksum or that are no longer
+// in the retained LTX files cannot be verified and are assumed to match.
+func (db *DB) verifyPos(pos Pos) error {
+	if pos.TXID == 0 || pos.PostApplyChecksum == 0 {
+		return nil
+	}
+
+	chksum := db.Pos().PostApplyChecksum
+	if curr := db.TXID(); curr != pos.TXID {
+		var ok bool
+		var err error
+		if chksum, ok, err = db.postApplyChecksumAt(pos.TXID); err != nil {
+			return err
+		} else if !ok {
+			return nil
+		}
+	}
+
+	if chksum != pos.PostApplyChecksum {
+		return fmt.Errorf("%w: txid=%s checksum=%016x, expected %016x", ErrPosForked, ltx.FormatTXID(pos.TXID), chksum, pos.PostApplyChecksum)
+	}
+	return nil
+}
+
+// postApplyChecksumAt returns the post-apply checksum from the LTX file that
+// ends at txID. Returns false if no such LTX file is retained.
+func (db *DB) postApplyChecksumAt(txID uint64) (uint64, bool, error) {
+	ents, err := db.ReadLTXDir()
+	if err != nil {
+		return 0, false, err
+	}
+
+	for _, ent := range ents {
+		minTXID, maxTXID, _ := ltx.ParseFilename(ent.Name())
+		if maxTXID != txID {
+			continue
+		}
+
+		_, trailer, err := db.readLTXHeader(minTXID, maxTXID)
+		if os.IsNotExist(err) {
+			return 0, false, nil // removed by retention enforcement
+		} else if err != nil {
+			return 0, false, err
+		}
+		return trailer.PostApplyChecksum, true, nil
+	}
+	return 0, false, nil
+}
+
+// readLTXHeader returns the header & trailer of an LTX file. The result is
+// cached until the file is rewritten or removed.
+func (db *DB) readLTXHeader(minTXID, maxTXID uint64) (ltx.Header, ltx.Trailer, error) {
+	filename := ltx.FormatFilename(minTXID, maxTXID)
+	if hdr, trailer, ok := db.ltxHeaders.Get(filename); ok {
+		return hdr, trailer, nil
+	}
+
+	f, err := db.store.FS.Open(db.LTXPath(minTXID, maxTXID))
+	if err != nil {
+		return ltx.Header{}, ltx.Trailer{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	hdr, _, err := ltx.DecodeHeader(f)
+	if err != nil {
+		return ltx.Header{}, ltx.Trailer{}, fmt.Errorf("decode ltx header: %w", err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return ltx.Header{}, ltx.Trailer{}, err
+	}
+
+	buf := make([]byte, ltx.TrailerSize)
+	var trailer ltx.Trailer
+	if _, err := f.ReadAt(buf, fi.Size()-ltx.TrailerSize); err != nil {
+		return ltx.Header{}, ltx.Trailer{}, fmt.Errorf("read ltx trailer: %w", err)
+	} else if err := trailer.UnmarshalBinary(buf); err != nil {
+		return ltx.Header{}, ltx.Trailer{}, fmt.Errorf("unmarshal ltx trailer: %w", err)
+	}
+
+	db.ltxHeaders.Add(filename, hdr, trailer)
+	return hdr, trailer, nil
+}
+
+// HaltLock returns a copy of the halt lock held on this node on behalf of
+// another node, if any. This is only set on the primary.
+func (db *DB) HaltLock() *HaltLock {
+	curr := db.haltLockAndGuard.Load().(*haltLockAndGuard)
+	if curr == nil {
+		return nil
+	}
+	other := *curr.haltLock
+	return &other
+}
+
 // RemoteHaltLock returns a copy of the current remote lock, if any.
 func (db *DB) RemoteHaltLock() *HaltLock {
 	value := db.remoteHaltLock.Load().(*HaltLock)
@@ -443,9 +841,30 @@ func (db *DB) HasRemoteHaltLock() bool {
 }
 
 // Writeable returns true if the node is the primary or if we've acquire the
-// HALT lock from the primary.
+// HALT lock from the primary. Read-only databases & stores are never writeable.
 func (db *DB) Writeable() bool {
-	return db.HasRemoteHaltLock() || db.store.IsPrimary()
+	return !db.ReadOnly() && !db.store.ReadOnly && (db.HasRemoteHaltLock() || db.store.IsPrimary())
+}
+
+// checkWriteable returns ErrReadOnly, ErrReadOnlyDatabase or ErrReadOnlyReplica
+// if application writes are not allowed.
+func (db *DB) checkWriteable() error {
+	if db.store.ReadOnly {
+		return ErrReadOnly
+	} else if db.ReadOnly() {
+		return ErrReadOnlyDatabase
+	} else if !db.Writeable() {
+		return ErrReadOnlyReplica
+	}
+	return nil
+}
+
+// isWriting returns true if a write transaction or HALT lock is in-flight.
+func (db *DB) isWriting() bool {
+	return db.reservedLock.State() != RWMutexStateUnlocked ||
+		db.writeLock.State() != RWMutexStateUnlocked ||
+		db.haltLockAndGuard.Load().(*haltLockAndGuard) != nil ||
+		db.HasRemoteHaltLock()
 }
 
 // TXID returns the current transaction ID.
@@ -460,12 +879,12 @@ func (db *DB) Open() error {
 	}
 
 	// Ensure "ltx" directory exists.
-	if err := os.MkdirAll(db.LTXDir(), 0777); err != nil {
+	if err := db.store.FS.MkdirAll(db.LTXDir(), db.store.DirMode); err != nil {
 		return err
 	}
 
 	// Remove all SHM files on start up.
-	if err := os.Remove(db.SHMPath()); err != nil && !os.IsNotExist(err) {
+	if err := db.store.FS.Remove(db.SHMPath()); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("remove shm: %w", err)
 	}
 
@@ -493,9 +912,16 @@ func (db *DB) Open() error {
 	}
 
 	// Apply the last LTX file so our checksums match if there was a failure in
-	// between the LTX commit and journal/WAL commit.
+	// between the LTX commit and journal/WAL commit. If the database file was
+	// torn by a crash, it can optionally be rebuilt from the LTX files.
 	if ltxFilename != "" {
-		if err := db.ApplyLTX(context.Background(), ltxFilename); err != nil {
+		if err := db.ApplyLTX(context.Background(), ltxFilename); err != nil && db.store.RepairOnOpen {
+			log.Printf("%s: cannot recover ltx on %q, replaying ltx files: %s", db.store.LogPrefix(), db.name, err)
+			_, maxTXID, _ := ltx.ParseFilename(filepath.Base(ltxFilename))
+			if err := db.repair(context.Background(), maxTXID); err != nil {
+				return fmt.Errorf("repair: %w", err)
+			}
+		} else if err != nil {
 			return fmt.Errorf("recover ltx: %w", err)
 		}
 	}
@@ -503,9 +929,46 @@ func (db *DB) Open() error {
 	return nil
 }
 
+// repair rebuilds the database file by replaying the retained LTX files from
+// the latest snapshot up to & including txID. Each file is verified against
+// its post-apply checksum as it is applied.
+func (db *DB) repair(ctx context.Context, txID uint64) error {
+	guard, err := db.acquireWriteLock(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer guard.Unlock()
+
+	var infos []LTXFileInfo
+	if err := db.ForEachLTX(func(info LTXFileInfo) error {
+		if info.MaxTXID <= txID {
+			infos = append(infos, info)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	chain, err := ltxRestoreChain(infos)
+	if err != nil {
+		return err
+	} else if maxTXID := chain[len(chain)-1].MaxTXID; maxTXID != txID {
+		return fmt.Errorf("retained files end at txid %s", ltx.FormatTXID(maxTXID))
+	}
+
+	for _, info := range chain {
+		if err := db.ApplyLTXNoLock(ctx, db.LTXPath(info.MinTXID, info.MaxTXID)); err != nil {
+			return fmt.Errorf("apply %s: %w", ltx.FormatFilename(info.MinTXID, info.MaxTXID), err)
+		}
+	}
+	dbRepairCountMetricVec.WithLabelValues(db.name).Inc()
+
+	log.Printf("%s: database repaired: db=%s pos=%s", db.store.LogPrefix(), db.name, db.Pos())
+	return nil
+}
+
 // initFromDatabaseHeader reads the page size & page count from the database file header.
 func (db *DB) initFromDatabaseHeader() error {
-	f, err := os.Open(db.DatabasePath())
+	f, err := db.store.FS.Open(db.DatabasePath())
 	if os.IsNotExist(err) {
 		return nil // no database file yet, skip
 	} else if err != nil {
@@ -527,7 +990,9 @@ func (db *DB) initFromDatabaseHeader() error {
 		return err
 	}
 	db.pageSize = hdr.PageSize
-	db.pageN = hdr.PageN
+	if db.pageN, err = sqliteDatabasePageN(f, hdr); err != nil {
+		return err
+	}
 
 	// Initialize database mode.
 	if hdr.WriteVersion == 2 && hdr.ReadVersion == 2 {
@@ -541,7 +1006,7 @@ func (db *DB) initFromDatabaseHeader() error {
 
 // Recover forces a rollback (journal) or checkpoint (wal).
 func (db *DB) Recover(ctx context.Context) error {
-	guard, err := db.AcquireWriteLock(ctx, nil)
+	guard, err := db.acquireWriteLock(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -577,7 +1042,7 @@ func (db *DB) recover(ctx context.Context) (err error) {
 // to the database file. This is called on startup so that we can be in a
 // consistent state in order to verify our checksums.
 func (db *DB) rollbackJournal(ctx context.Context) error {
-	journalFile, err := os.OpenFile(db.JournalPath(), os.O_RDWR, 0666)
+	journalFile, err := db.store.FS.OpenFile(db.JournalPath(), os.O_RDWR, db.store.FileMode)
 	if os.IsNotExist(err) {
 		return nil // no journal file, skip
 	} else if err != nil {
@@ -585,7 +1050,7 @@ func (db *DB) rollbackJournal(ctx context.Context) error {
 	}
 	defer func() { _ = journalFile.Close() }()
 
-	dbFile, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666)
+	dbFile, err := db.store.FS.OpenFile(db.DatabasePath(), os.O_RDWR, db.store.FileMode)
 	if err != nil {
 		return err
 	}
@@ -619,7 +1084,7 @@ func (db *DB) rollbackJournal(ctx context.Context) error {
 
 	if err := journalFile.Close(); err != nil {
 		return err
-	} else if err := os.Remove(db.JournalPath()); err != nil {
+	} else if err := db.store.FS.Remove(db.JournalPath()); err != nil {
 		return err
 	}
 
@@ -632,7 +1097,7 @@ func (db *DB) rollbackJournal(ctx context.Context) error {
 	return nil
 }
 
-func (db *DB) rollbackJournalSegment(ctx context.Context, r *JournalReader, dbFile *os.File) error {
+func (db *DB) rollbackJournalSegment(ctx context.Context, r *JournalReader, dbFile File) error {
 	for i := 0; ; i++ {
 		pgno, data, err := r.ReadFrame()
 		if err == io.EOF {
@@ -650,7 +1115,7 @@ func (db *DB) rollbackJournalSegment(ctx context.Context, r *JournalReader, dbFi
 
 // Checkpoint acquires locks and copies pages from the WAL into the database and truncates the WAL.
 func (db *DB) Checkpoint(ctx context.Context) (err error) {
-	guard, err := db.AcquireWriteLock(ctx, nil)
+	guard, err := db.acquireWriteLock(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -668,7 +1133,7 @@ func (db *DB) CheckpointNoLock(ctx context.Context) (err error) {
 	}()
 
 	// Open the database file we'll checkpoint into. Skip if this hasn't been created.
-	dbFile, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666)
+	dbFile, err := db.store.FS.OpenFile(db.DatabasePath(), os.O_RDWR, db.store.FileMode)
 	if os.IsNotExist(err) {
 		return nil // no database file yet, skip
 	} else if err != nil {
@@ -677,7 +1142,7 @@ func (db *DB) CheckpointNoLock(ctx context.Context) (err error) {
 	defer func() { _ = dbFile.Close() }()
 
 	// Open the WAL file that we'll copy from. Skip if it was cleanly closed and removed.
-	walFile, err := os.Open(db.WALPath())
+	walFile, err := db.store.FS.Open(db.WALPath())
 	if os.IsNotExist(err) {
 		return nil // no WAL file, skip
 	} else if err != nil {
@@ -731,7 +1196,7 @@ func (db *DB) CheckpointNoLock(ctx context.Context) (err error) {
 
 // readWALPageOffsets returns a map of the offsets of the last committed version
 // of each page in the WAL. Also returns the commit size of the last transaction.
-func (db *DB) readWALPageOffsets(f *os.File) (_ map[uint32]int64, lastCommit uint32, _ error) {
+func (db *DB) readWALPageOffsets(f File) (_ map[uint32]int64, lastCommit uint32, _ error) {
 	r := NewWALReader(f)
 	if err := r.ReadHeader(); err == io.EOF {
 		return nil, 0, nil
@@ -770,7 +1235,7 @@ func (db *DB) readWALPageOffsets(f *os.File) (_ map[uint32]int64, lastCommit uin
 
 // maxLTXFile returns the filename of the highest LTX file.
 func (db *DB) maxLTXFile(ctx context.Context) (string, error) {
-	ents, err := os.ReadDir(db.LTXDir())
+	ents, err := db.store.FS.ReadDir(db.LTXDir())
 	if err != nil {
 		return "", err
 	}
@@ -796,7 +1261,7 @@ func (db *DB) maxLTXFile(ctx context.Context) (string, error) {
 // where a WAL file was sync'd past the last LTX file.
 func (db *DB) syncWALToLTX(ctx context.Context, ltxFilename string) error {
 	// Open last LTX file.
-	ltxFile, err := os.Open(ltxFilename)
+	ltxFile, err := db.store.FS.Open(ltxFilename)
 	if err != nil {
 		return err
 	}
@@ -811,7 +1276,7 @@ func (db *DB) syncWALToLTX(ctx context.Context, ltxFilename string) error {
 	ltxWALSize := dec.Header().WALOffset + dec.Header().WALSize
 
 	// Open WAL file, ignore if it doesn't exist.
-	walFile, err := os.OpenFile(db.WALPath(), os.O_RDWR, 0666)
+	walFile, err := db.store.FS.OpenFile(db.WALPath(), os.O_RDWR, db.store.FileMode)
 	if os.IsNotExist(err) {
 		log.Printf("wal-sync: no wal file exists on %q, skipping sync with ltx", db.name)
 		return nil // no wal file, nothing to do
@@ -842,7 +1307,7 @@ func (db *DB) syncWALToLTX(ctx context.Context, ltxFilename string) error {
 	salt2 := binary.BigEndian.Uint32(hdr[20:])
 	if salt1 != dec.Header().WALSalt1 || salt2 != dec.Header().WALSalt2 {
 		log.Printf("wal-sync: wal salt mismatch on %q, removing wal", db.name)
-		if err := os.Rename(db.WALPath(), db.WALPath()+".removed"); err != nil {
+		if err := db.store.FS.Rename(db.WALPath(), db.WALPath()+".removed"); err != nil {
 			return fmt.Errorf("wal-sync: rename wal file with salt mismatch: %w", err)
 		}
 		return nil
@@ -871,7 +1336,7 @@ func (db *DB) syncWALToLTX(ctx context.Context, ltxFilename string) error {
 // The journal & WAL should not exist at this point. The journal should be
 // rolled back and the WAL should be checkpointed.
 func (db *DB) initDatabaseFile() error {
-	f, err := os.Open(db.DatabasePath())
+	f, err := db.store.FS.Open(db.DatabasePath())
 	if os.IsNotExist(err) {
 		log.Printf("database file does not exist on initialization: %s", db.DatabasePath())
 		return nil // no database file yet
@@ -888,7 +1353,9 @@ func (db *DB) initDatabaseFile() error {
 		return fmt.Errorf("cannot read database header: %w", err)
 	}
 	db.pageSize = hdr.PageSize
-	db.pageN = hdr.PageN
+	if db.pageN, err = sqliteDatabasePageN(f, hdr); err != nil {
+		return err
+	}
 
 	assert(db.pageSize > 0, "page size must be greater than zero")
 
@@ -915,79 +1382,245 @@ func (db *DB) initDatabaseFile() error {
 	return nil
 }
 
+// discardLTXAfter removes every LTX file that contains transactions after
+// txID. This is used to roll back a replica that has diverged from the
+// primary. Returns the number of files removed. Must hold the write lock.
+func (db *DB) discardLTXAfter(txID uint64) (n int, err error) {
+	ents, err := db.ReadLTXDir()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, ent := range ents {
+		if _, maxTXID, _ := ltx.ParseFilename(ent.Name()); maxTXID <= txID {
+			continue
+		}
+		if err := db.store.FS.Remove(filepath.Join(db.LTXDir(), ent.Name())); err != nil && !os.IsNotExist(err) {
+			return n, err
+		}
+		db.backedUpLTX.Delete(ent.Name())
+		db.ltxHeaders.Remove(ent.Name())
+		n++
+	}
+
+	if n > 0 {
+		if err := db.store.FS.SyncDir(db.LTXDir()); err != nil {
+			return n, fmt.Errorf("sync ltx dir: %w", err)
+		}
+	}
+	return n, nil
+}
+
 // clean deletes and recreates the database data directory.
 func (db *DB) clean() error {
-	if err := os.RemoveAll(db.path); err != nil && !os.IsNotExist(err) {
+	if err := db.store.FS.RemoveAll(db.path); err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	return os.Mkdir(db.path, 0777)
+	db.ltxHeaders.Purge()
+	return db.store.FS.Mkdir(db.path, db.store.DirMode)
 }
 
-// OpenLTXFile returns a file handle to an LTX file that contains the given TXID.
-func (db *DB) OpenLTXFile(txID uint64) (*os.File, error) {
-	return os.Open(db.LTXPath(txID, txID))
-}
+// OpenLTXFile returns a file handle to an LTX file that starts at the given
+// TXID. This may be a compacted file that covers later transactions as well.
+func (db *DB) OpenLTXFile(txID uint64) (File, error) {
+	f, err := db.store.FS.Open(db.LTXPath(txID, txID))
+	if !os.IsNotExist(err) {
+		return f, err
+	}
 
-// OpenDatabase returns a handle for the database file.
-func (db *DB) OpenDatabase(ctx context.Context) (*os.File, error) {
-	f, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666)
-	TraceLog.Printf("%s [OpenDatabase(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
-	return f, err
+	ents, err := db.ReadLTXDir()
+	if err != nil {
+		return nil, err
+	}
+	for _, ent := range ents {
+		if minTXID, maxTXID, _ := ltx.ParseFilename(ent.Name()); minTXID == txID {
+			return db.store.FS.Open(db.LTXPath(minTXID, maxTXID))
+		}
+	}
+	return nil, &os.PathError{Op: "open", Path: db.LTXPath(txID, txID), Err: os.ErrNotExist}
 }
 
-// CloseDatabase closes a handle associated with the database file.
-func (db *DB) CloseDatabase(ctx context.Context, f *os.File, owner uint64) error {
-	err := f.Close()
-	TraceLog.Printf("%s [CloseDatabase(%s)]: owner=%d %s", db.store.LogPrefix(), db.name, owner, errorKeyValue(err))
-	return err
-}
+// OpenLTX returns a seekable, uncompressed LTX stream for the file covering
+// minTXID through maxTXID. Compressed files are decoded into an unlinked
+// temporary file so callers can seek to resume a partial read regardless of
+// how the file is stored. Returns ErrLTXFileNotFound if no such file exists.
+func (db *DB) OpenLTX(minTXID, maxTXID uint64) (io.ReadSeekCloser, error) {
+	path := db.LTXPath(minTXID, maxTXID)
+	f, err := db.store.FS.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrLTXFileNotFound, filepath.Base(path))
+	} else if err != nil {
+		return nil, err
+	}
 
-// TruncateDatabase sets the size of the database file.
-func (db *DB) TruncateDatabase(ctx context.Context, size int64) (err error) {
-	// Require the page size because we need to check against the page count & checksums.
-	if db.pageSize == 0 {
-		return fmt.Errorf("page size required on database truncation")
-	} else if size%int64(db.pageSize) != 0 {
-		return fmt.Errorf("size must be page-aligned (%d bytes)", db.pageSize)
+	hdr, _, err := ltx.DecodeHeader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("decode ltx header: %w", err)
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("seek ltx file: %w", err)
 	}
 
-	// Verify new size matches the database size specified in the header.
-	pageN := uint32(size / int64(db.pageSize))
-	if pageN != db.pageN {
-		return fmt.Errorf("truncation size (%d pages) does not match database header size (%d pages)", pageN, db.pageN)
+	// Uncompressed files can be returned as-is.
+	if hdr.Flags&ltx.HeaderFlagCompressLZ4 == 0 {
+		return f, nil
 	}
+	defer func() { _ = f.Close() }()
 
-	// Process the actual file system truncation.
-	if f, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666); err != nil {
-		return err
-	} else if err := db.truncateDatabase(f, pageN); err != nil {
-		_ = f.Close()
-		return err
-	} else if err := f.Close(); err != nil {
-		return err
+	tmp, err := createTemp(db.store.FS, db.LTXDir(), filepath.Base(path)+".*.tmp", db.store.FileMode)
+	if err != nil {
+		return nil, fmt.Errorf("create temp ltx file: %w", err)
+	} else if err := db.store.FS.Remove(tmp.Name()); err != nil {
+		_ = tmp.Close()
+		return nil, fmt.Errorf("remove temp ltx file: %w", err)
 	}
 
-	return nil
+	if err := decompressLTX(tmp, f); err != nil {
+		_ = tmp.Close()
+		return nil, err
+	} else if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		_ = tmp.Close()
+		return nil, fmt.Errorf("seek temp ltx file: %w", err)
+	}
+	return tmp, nil
 }
 
-// truncateDatabase truncates the database to a given page count.
-func (db *DB) truncateDatabase(f *os.File, pageN uint32) (err error) {
-	prevPageN := db.pageN
+// decompressLTX re-encodes the LTX stream from r to w without compression.
+func decompressLTX(w io.Writer, r io.Reader) error {
+	dec := ltx.NewDecoder(r)
+	if err := dec.DecodeHeader(); err != nil {
+		return fmt.Errorf("decode header: %w", err)
+	}
 
-	defer func() {
-		TraceLog.Printf("%s [TruncateDatabase(%s)]: pageN=%d prevPageN=%d pageSize=%d %s", db.store.LogPrefix(), db.name, pageN, prevPageN, db.pageSize, errorKeyValue(err))
-	}()
+	hdr := dec.Header()
+	hdr.Flags &^= ltx.HeaderFlagCompressLZ4
 
-	if err := f.Truncate(int64(pageN) * int64(db.pageSize)); err != nil {
-		return err
-	} else if err := f.Sync(); err != nil {
-		return err
+	enc := ltx.NewEncoder(w)
+	if err := enc.EncodeHeader(hdr); err != nil {
+		return fmt.Errorf("encode header: %w", err)
 	}
 
-	// Remove checksums after end of database on success.
-	func() {
-		db.chksums.mu.Lock()
-		defer db.chksums.mu.Unlock()
+	var pageHeader ltx.PageHeader
+	data := make([]byte, hdr.PageSize)
+	for i := 0; ; i++ {
+		if err := dec.DecodePage(&pageHeader, data); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("decode page %d: %w", i, err)
+		} else if err := enc.EncodePage(pageHeader, data); err != nil {
+			return fmt.Errorf("encode page %d: %w", i, err)
+		}
+	}
+
+	if err := dec.Close(); err != nil {
+		return fmt.Errorf("close decoder: %w", err)
+	}
+	enc.SetPostApplyChecksum(dec.Trailer().PostApplyChecksum)
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("close encoder: %w", err)
+	}
+	return nil
+}
+
+// compressLTX reads an uncompressed LTX file from r and writes it to w using
+// LZ4 compression. The header & trailer are otherwise unchanged.
+func compressLTX(w io.Writer, r io.Reader) error {
+	dec := ltx.NewDecoder(r)
+	if err := dec.DecodeHeader(); err != nil {
+		return fmt.Errorf("decode header: %w", err)
+	}
+
+	hdr := dec.Header()
+	hdr.Flags |= ltx.HeaderFlagCompressLZ4
+
+	enc := ltx.NewEncoder(w)
+	if err := enc.EncodeHeader(hdr); err != nil {
+		return fmt.Errorf("encode header: %w", err)
+	}
+
+	var pageHeader ltx.PageHeader
+	data := make([]byte, hdr.PageSize)
+	for i := 0; ; i++ {
+		if err := dec.DecodePage(&pageHeader, data); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("decode page %d: %w", i, err)
+		} else if err := enc.EncodePage(pageHeader, data); err != nil {
+			return fmt.Errorf("encode page %d: %w", i, err)
+		}
+	}
+
+	if err := dec.Close(); err != nil {
+		return fmt.Errorf("close decoder: %w", err)
+	}
+	enc.SetPostApplyChecksum(dec.Trailer().PostApplyChecksum)
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("close encoder: %w", err)
+	}
+	return nil
+}
+
+// OpenDatabase returns a handle for the database file.
+func (db *DB) OpenDatabase(ctx context.Context) (File, error) {
+	f, err := db.store.FS.OpenFile(db.DatabasePath(), os.O_RDWR, db.store.FileMode)
+	TraceLog.Printf("%s [OpenDatabase(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
+	return f, err
+}
+
+// CloseDatabase closes a handle associated with the database file.
+func (db *DB) CloseDatabase(ctx context.Context, f File, owner uint64) error {
+	err := f.Close()
+	TraceLog.Printf("%s [CloseDatabase(%s)]: owner=%d %s", db.store.LogPrefix(), db.name, owner, errorKeyValue(err))
+	return err
+}
+
+// TruncateDatabase sets the size of the database file.
+func (db *DB) TruncateDatabase(ctx context.Context, size int64) (err error) {
+	// Require the page size because we need to check against the page count & checksums.
+	if db.pageSize == 0 {
+		return fmt.Errorf("page size required on database truncation")
+	} else if size%int64(db.pageSize) != 0 {
+		return fmt.Errorf("size must be page-aligned (%d bytes)", db.pageSize)
+	}
+
+	// Verify new size matches the database size specified in the header.
+	pageN := uint32(size / int64(db.pageSize))
+	if pageN != db.pageN {
+		return fmt.Errorf("truncation size (%d pages) does not match database header size (%d pages)", pageN, db.pageN)
+	}
+
+	// Process the actual file system truncation.
+	if f, err := db.store.FS.OpenFile(db.DatabasePath(), os.O_RDWR, db.store.FileMode); err != nil {
+		return err
+	} else if err := db.truncateDatabase(f, pageN); err != nil {
+		_ = f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// truncateDatabase truncates the database to a given page count.
+func (db *DB) truncateDatabase(f File, pageN uint32) (err error) {
+	prevPageN := db.pageN
+
+	defer func() {
+		TraceLog.Printf("%s [TruncateDatabase(%s)]: pageN=%d prevPageN=%d pageSize=%d %s", db.store.LogPrefix(), db.name, pageN, prevPageN, db.pageSize, errorKeyValue(err))
+	}()
+
+	if err := f.Truncate(int64(pageN) * int64(db.pageSize)); err != nil {
+		return err
+	} else if err := f.Sync(); err != nil {
+		return err
+	}
+
+	// Remove checksums after end of database on success.
+	func() {
+		db.chksums.mu.Lock()
+		defer db.chksums.mu.Unlock()
 
 		for pgno := pageN + 1; pgno <= prevPageN; pgno++ {
 			pageChksum := db.chksums.m[pgno]
@@ -1008,7 +1641,7 @@ func (db *DB) SyncDatabase(ctx context.Context) (err error) {
 		TraceLog.Printf("%s [SyncDatabase(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
 	}()
 
-	f, err := os.Open(db.DatabasePath())
+	f, err := db.store.FS.Open(db.DatabasePath())
 	if err != nil {
 		return err
 	} else if err := f.Sync(); err != nil {
@@ -1019,7 +1652,7 @@ func (db *DB) SyncDatabase(ctx context.Context) (err error) {
 }
 
 // ReadDatabaseAt reads from the database at the specified index.
-func (db *DB) ReadDatabaseAt(ctx context.Context, f *os.File, data []byte, offset int64, owner uint64) (int, error) {
+func (db *DB) ReadDatabaseAt(ctx context.Context, f File, data []byte, offset int64, owner uint64) (int, error) {
 	n, err := f.ReadAt(data, offset)
 
 	// Compute checksum if page aligned.
@@ -1035,10 +1668,10 @@ func (db *DB) ReadDatabaseAt(ctx context.Context, f *os.File, data []byte, offse
 }
 
 // WriteDatabaseAt writes data to the main database file at the given index.
-func (db *DB) WriteDatabaseAt(ctx context.Context, f *os.File, data []byte, offset int64, owner uint64) error {
+func (db *DB) WriteDatabaseAt(ctx context.Context, f File, data []byte, offset int64, owner uint64) error {
 	// Return an error if the current process is not the leader.
-	if !db.Writeable() {
-		return ErrReadOnlyReplica
+	if err := db.checkWriteable(); err != nil {
+		return err
 	} else if len(data) == 0 {
 		return nil
 	}
@@ -1080,7 +1713,7 @@ func (db *DB) WriteDatabaseAt(ctx context.Context, f *os.File, data []byte, offs
 }
 
 // writeDatabasePage writes a page to the database file.
-func (db *DB) writeDatabasePage(f *os.File, pgno uint32, data []byte, invalidate bool) (err error) {
+func (db *DB) writeDatabasePage(f File, pgno uint32, data []byte, invalidate bool) (err error) {
 	var prevChksum, newChksum uint64
 	defer func() {
 		TraceLog.Printf("%s [WriteDatabasePage(%s)]: pgno=%d chksum=%016x prev=%016x %s", db.store.LogPrefix(), db.name, pgno, newChksum, prevChksum, errorKeyValue(err))
@@ -1126,26 +1759,26 @@ func (db *DB) UnlockDatabase(ctx context.Context, owner uint64) {
 }
 
 // CreateJournal creates a new journal file on disk.
-func (db *DB) CreateJournal() (*os.File, error) {
-	if !db.Writeable() {
-		TraceLog.Printf("%s [CreateJournal(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(ErrReadOnlyReplica))
-		return nil, ErrReadOnlyReplica
+func (db *DB) CreateJournal() (File, error) {
+	if err := db.checkWriteable(); err != nil {
+		TraceLog.Printf("%s [CreateJournal(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
+		return nil, err
 	}
 
-	f, err := os.OpenFile(db.JournalPath(), os.O_RDWR|os.O_CREATE|os.O_EXCL|os.O_TRUNC, 0666)
+	f, err := db.store.FS.OpenFile(db.JournalPath(), os.O_RDWR|os.O_CREATE|os.O_EXCL|os.O_TRUNC, db.store.FileMode)
 	TraceLog.Printf("%s [CreateJournal(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
 	return f, err
 }
 
 // OpenJournal returns a handle for the journal file.
-func (db *DB) OpenJournal(ctx context.Context) (*os.File, error) {
-	f, err := os.OpenFile(db.JournalPath(), os.O_RDWR, 0666)
+func (db *DB) OpenJournal(ctx context.Context) (File, error) {
+	f, err := db.store.FS.OpenFile(db.JournalPath(), os.O_RDWR, db.store.FileMode)
 	TraceLog.Printf("%s [OpenJournal(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
 	return f, err
 }
 
 // CloseJournal closes a handle associated with the journal file.
-func (db *DB) CloseJournal(ctx context.Context, f *os.File, owner uint64) error {
+func (db *DB) CloseJournal(ctx context.Context, f File, owner uint64) error {
 	err := f.Close()
 	TraceLog.Printf("%s [CloseJournal(%s)]: owner=%d %s", db.store.LogPrefix(), db.name, owner, errorKeyValue(err))
 	return err
@@ -1164,7 +1797,7 @@ func (db *DB) SyncJournal(ctx context.Context) (err error) {
 		TraceLog.Printf("%s [SyncJournal(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
 	}()
 
-	f, err := os.Open(db.JournalPath())
+	f, err := db.store.FS.Open(db.JournalPath())
 	if err != nil {
 		return err
 	} else if err := f.Sync(); err != nil {
@@ -1182,14 +1815,14 @@ func (db *DB) RemoveJournal(ctx context.Context) error {
 }
 
 // ReadJournalAt reads from the journal at the specified offset.
-func (db *DB) ReadJournalAt(ctx context.Context, f *os.File, data []byte, offset int64, owner uint64) (int, error) {
+func (db *DB) ReadJournalAt(ctx context.Context, f File, data []byte, offset int64, owner uint64) (int, error) {
 	n, err := f.ReadAt(data, offset)
 	TraceLog.Printf("%s [ReadJournalAt(%s)]: offset=%d size=%d owner=%d %s", db.store.LogPrefix(), db.name, offset, len(data), owner, errorKeyValue(err))
 	return n, err
 }
 
 // WriteJournal writes data to the rollback journal file.
-func (db *DB) WriteJournalAt(ctx context.Context, f *os.File, data []byte, offset int64, owner uint64) (err error) {
+func (db *DB) WriteJournalAt(ctx context.Context, f File, data []byte, offset int64, owner uint64) (err error) {
 	defer func() {
 		var buf []byte
 		if len(data) == 4 { // pgno or chksum
@@ -1204,8 +1837,8 @@ func (db *DB) WriteJournalAt(ctx context.Context, f *os.File, data []byte, offse
 		TraceLog.Printf("%s [WriteJournalAt(%s)]: offset=%d size=%d data=%x owner=%d %s", db.store.LogPrefix(), db.name, offset, len(data), buf, owner, errorKeyValue(err))
 	}()
 
-	if !db.Writeable() {
-		return ErrReadOnlyReplica
+	if err := db.checkWriteable(); err != nil {
+		return err
 	}
 
 	// Set the page size on initial journal header write.
@@ -1228,21 +1861,21 @@ func (db *DB) WriteJournalAt(ctx context.Context, f *os.File, data []byte, offse
 }
 
 // CreateWAL creates a new WAL file on disk.
-func (db *DB) CreateWAL() (*os.File, error) {
-	f, err := os.OpenFile(db.WALPath(), os.O_RDWR|os.O_CREATE|os.O_EXCL|os.O_TRUNC, 0666)
+func (db *DB) CreateWAL() (File, error) {
+	f, err := db.store.FS.OpenFile(db.WALPath(), os.O_RDWR|os.O_CREATE|os.O_EXCL|os.O_TRUNC, db.store.FileMode)
 	TraceLog.Printf("%s [CreateWAL(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
 	return f, err
 }
 
 // OpenWAL returns a handle for the write-ahead log file.
-func (db *DB) OpenWAL(ctx context.Context) (*os.File, error) {
-	f, err := os.OpenFile(db.WALPath(), os.O_RDWR, 0666)
+func (db *DB) OpenWAL(ctx context.Context) (File, error) {
+	f, err := db.store.FS.OpenFile(db.WALPath(), os.O_RDWR, db.store.FileMode)
 	TraceLog.Printf("%s [OpenWAL(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
 	return f, err
 }
 
 // CloseWAL closes a handle associated with the WAL file.
-func (db *DB) CloseWAL(ctx context.Context, f *os.File, owner uint64) error {
+func (db *DB) CloseWAL(ctx context.Context, f File, owner uint64) error {
 	err := f.Close()
 	TraceLog.Printf("%s [CloseWAL(%s)]: owner=%d %s", db.store.LogPrefix(), db.name, owner, errorKeyValue(err))
 	return err
@@ -1257,7 +1890,7 @@ func (db *DB) TruncateWAL(ctx context.Context, size int64) (err error) {
 	if size != 0 {
 		return fmt.Errorf("wal can only be truncated to zero")
 	}
-	if err := os.Truncate(db.WALPath(), size); err != nil {
+	if err := truncateFile(db.store.FS, db.WALPath(), size); err != nil {
 		return err
 	}
 
@@ -1272,7 +1905,7 @@ func (db *DB) TruncateWAL(ctx context.Context, size int64) (err error) {
 func (db *DB) RemoveWAL(ctx context.Context) (err error) {
 	defer func() { TraceLog.Printf("%s [RemoveWAL(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err)) }()
 
-	if err := os.Remove(db.WALPath()); err != nil {
+	if err := db.store.FS.Remove(db.WALPath()); err != nil {
 		return err
 	}
 
@@ -1289,7 +1922,7 @@ func (db *DB) SyncWAL(ctx context.Context) (err error) {
 		TraceLog.Printf("%s [SyncWAL(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
 	}()
 
-	f, err := os.Open(db.WALPath())
+	f, err := db.store.FS.Open(db.WALPath())
 	if err != nil {
 		return err
 	} else if err := f.Sync(); err != nil {
@@ -1300,7 +1933,7 @@ func (db *DB) SyncWAL(ctx context.Context) (err error) {
 }
 
 // ReadWALAt reads from the WAL at the specified index.
-func (db *DB) ReadWALAt(ctx context.Context, f *os.File, data []byte, offset int64, owner uint64) (int, error) {
+func (db *DB) ReadWALAt(ctx context.Context, f File, data []byte, offset int64, owner uint64) (int, error) {
 	n, err := f.ReadAt(data, offset)
 	TraceLog.Printf("%s [ReadWALAt(%s)]: offset=%d size=%d owner=%d %s", db.store.LogPrefix(), db.name, offset, len(data), owner, errorKeyValue(err))
 	return n, err
@@ -1308,11 +1941,11 @@ func (db *DB) ReadWALAt(ctx context.Context, f *os.File, data []byte, offset int
 
 // WriteWALAt writes data to the WAL file. On final commit write, an LTX file is
 // generated for the transaction.
-func (db *DB) WriteWALAt(ctx context.Context, f *os.File, data []byte, offset int64, owner uint64) (err error) {
+func (db *DB) WriteWALAt(ctx context.Context, f File, data []byte, offset int64, owner uint64) (err error) {
 	// Return an error if the current process is not the leader.
-	if !db.Writeable() {
+	if err := db.checkWriteable(); err != nil {
 		TraceLog.Printf("%s [WriteWALAt(%s)]: offset=%d size=%d owner=%d %s", db.store.LogPrefix(), db.name, offset, len(data), owner, errorKeyValue(err))
-		return ErrReadOnlyReplica
+		return err
 	} else if len(data) == 0 {
 		TraceLog.Printf("%s [WriteWALAt(%s)]: offset=%d size=%d owner=%d %s", db.store.LogPrefix(), db.name, offset, len(data), owner, errorKeyValue(err))
 		return nil
@@ -1346,7 +1979,7 @@ func (db *DB) WriteWALAt(ctx context.Context, f *os.File, data []byte, offset in
 	return nil
 }
 
-func (db *DB) writeWALHeader(ctx context.Context, f *os.File, data []byte, offset int64, owner uint64) (err error) {
+func (db *DB) writeWALHeader(ctx context.Context, f File, data []byte, offset int64, owner uint64) (err error) {
 	defer func() {
 		TraceLog.Printf("%s [WriteWALHeader(%s)]: offset=%d size=%d salt1=%08x salt2=%08x chksum1=%08x chksum2=%08x owner=%d %s",
 			db.store.LogPrefix(), db.name, offset, len(data), db.wal.salt1, db.wal.salt2, db.wal.chksum1, db.wal.chksum2, owner, errorKeyValue(err))
@@ -1380,7 +2013,7 @@ func (db *DB) writeWALHeader(ctx context.Context, f *os.File, data []byte, offse
 	return err
 }
 
-func (db *DB) writeWALFrameHeader(ctx context.Context, f *os.File, data []byte, offset, frameOffset int64, owner uint64) (err error) {
+func (db *DB) writeWALFrameHeader(ctx context.Context, f File, data []byte, offset, frameOffset int64, owner uint64) (err error) {
 	var pgno, commit, salt1, salt2, chksum1, chksum2 uint32
 	var hexdata string
 	if frameOffset == 0 && len(data) == WALFrameHeaderSize {
@@ -1414,7 +2047,7 @@ func (db *DB) writeWALFrameHeader(ctx context.Context, f *os.File, data []byte,
 	return err
 }
 
-func (db *DB) writeWALFrameData(ctx context.Context, f *os.File, data []byte, offset int64, owner uint64) (err error) {
+func (db *DB) writeWALFrameData(ctx context.Context, f File, data []byte, offset int64, owner uint64) (err error) {
 	defer func() {
 		TraceLog.Printf("%s [WriteWALFrameData(%s)]: offset=%d size=%d owner=%d %s", db.store.LogPrefix(), db.name, offset, len(data), owner, errorKeyValue(err))
 	}()
@@ -1429,7 +2062,7 @@ func (db *DB) writeWALFrameData(ctx context.Context, f *os.File, data []byte, of
 	return err
 }
 
-func (db *DB) buildTxFrameOffsets(walFile *os.File) (_ map[uint32]int64, commit, chksum1, chksum2 uint32, endOffset int64, err error) {
+func (db *DB) buildTxFrameOffsets(walFile File) (_ map[uint32]int64, commit, chksum1, chksum2 uint32, endOffset int64, err error) {
 	m := make(map[uint32]int64)
 
 	offset := db.wal.offset
@@ -1485,7 +2118,23 @@ var errNoTransaction = errors.New("no transaction")
 
 // CommitWAL is called when the client releases the WAL_WRITE_LOCK(120).
 // The transaction data is copied from the WAL into an LTX file and committed.
-func (db *DB) CommitWAL(ctx context.Context) (err error) {
+// If synchronous replication is enabled, it then waits for replicas to
+// acknowledge the transaction.
+func (db *DB) CommitWAL(ctx context.Context) error {
+	prevTXID := db.Pos().TXID
+	if err := db.commitWAL(ctx); err != nil {
+		return err
+	} else if txID := db.Pos().TXID; txID > prevTXID {
+		if err := db.store.WaitForSyncReplicas(ctx, db.name, txID); err != nil {
+			return fmt.Errorf("sync replication (wal): %w", err)
+		}
+	}
+	return nil
+}
+
+// commitWAL copies the WAL transaction into an LTX file and commits it
+// without waiting for synchronous replication.
+func (db *DB) commitWAL(ctx context.Context) (err error) {
 	var msg string
 	var commit uint32
 	var txPageCount int
@@ -1501,7 +2150,7 @@ func (db *DB) CommitWAL(ctx context.Context) (err error) {
 	TraceLog.Printf("%s [CommitWALBegin(%s)]: prev=%s offset=%d salt1=%08x salt2=%08x chksum1=%08x chksum2=%08x remote=%v",
 		db.store.LogPrefix(), db.name, prevPos, db.wal.offset, db.wal.salt1, db.wal.salt2, db.wal.chksum1, db.wal.chksum2, db.HasRemoteHaltLock())
 
-	walFile, err := os.Open(db.WALPath())
+	walFile, err := db.store.FS.Open(db.WALPath())
 	if err != nil {
 		return fmt.Errorf("open wal file: %w", err)
 	}
@@ -1523,7 +2172,7 @@ func (db *DB) CommitWAL(ctx context.Context) (err error) {
 	}
 	txPageCount = len(txFrameOffsets)
 
-	dbFile, err := os.Open(db.DatabasePath())
+	dbFile, err := db.store.FS.Open(db.DatabasePath())
 	if err != nil {
 		return fmt.Errorf("cannot open database file: %w", err)
 	}
@@ -1535,9 +2184,9 @@ func (db *DB) CommitWAL(ctx context.Context) (err error) {
 	// Open file descriptors for the header & page blocks for new LTX file.
 	ltxPath := db.LTXPath(txID, txID)
 	tmpPath := ltxPath + ".tmp"
-	_ = os.Remove(tmpPath)
+	_ = db.store.FS.Remove(tmpPath)
 
-	ltxFile, err := os.Create(tmpPath)
+	ltxFile, err := db.store.FS.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, db.store.FileMode)
 	if err != nil {
 		return fmt.Errorf("cannot create LTX file: %w", err)
 	}
@@ -1659,11 +2308,15 @@ func (db *DB) CommitWAL(ctx context.Context) (err error) {
 	}
 
 	// Atomically rename the file
-	if err := os.Rename(tmpPath, ltxPath); err != nil {
+	if err := db.store.FS.Rename(tmpPath, ltxPath); err != nil {
 		return fmt.Errorf("rename ltx file: %w", err)
-	} else if err := internal.Sync(filepath.Dir(ltxPath)); err != nil {
+	}
+	db.ltxHeaders.Remove(filepath.Base(ltxPath))
+
+	if err := db.store.FS.SyncDir(filepath.Dir(ltxPath)); err != nil {
 		return fmt.Errorf("sync ltx dir: %w", err)
 	}
+	db.store.enqueueBackup(db, enc.Header())
 
 	// Copy page offsets on commit.
 	for pgno, off := range txFrameOffsets {
@@ -1682,10 +2335,7 @@ func (db *DB) CommitWAL(ctx context.Context) (err error) {
 	db.wal.chksum2 = chksum2
 
 	// Update transaction for database.
-	pos = Pos{
-		TXID:              enc.Header().MaxTXID,
-		PostApplyChecksum: enc.Trailer().PostApplyChecksum,
-	}
+	pos = NewPosFromLTX(enc.Header(), enc.Trailer())
 	if err := db.setPos(pos); err != nil {
 		return fmt.Errorf("set pos: %w", err)
 	}
@@ -1702,9 +2352,13 @@ func (db *DB) CommitWAL(ctx context.Context) (err error) {
 	dbLTXCountMetricVec.WithLabelValues(db.name).Inc()
 	dbLTXBytesMetricVec.WithLabelValues(db.name).Set(float64(enc.N()))
 	dbLatencySecondsMetricVec.WithLabelValues(db.name).Set(0.0)
+	dbWriteBytesTotalMetricVec.WithLabelValues(db.name).Add(float64(enc.N()))
+	dbTxTotalMetricVec.WithLabelValues(db.name).Inc()
 
 	// Notify store of database change.
 	db.store.MarkDirty(db.name)
+	db.store.publishEvent(newTxEvent(db.name, enc.Header(), enc.Trailer(), enc.N()))
+	db.store.notifyApply(db.name, NewPosFromLTX(enc.Header(), enc.Trailer()))
 
 	// Perform full checksum verification, if set. For testing only.
 	if db.store.StrictVerify {
@@ -1718,8 +2372,41 @@ func (db *DB) CommitWAL(ctx context.Context) (err error) {
 	return nil
 }
 
+// commitWALOnUnlock commits the WAL transaction for the owner of the
+// WAL_WRITE_LOCK & returns the committed TXID, if any. Errors are logged as
+// SQLite does not check the result of unlocking the WAL.
+func (db *DB) commitWALOnUnlock(ctx context.Context) (txID uint64) {
+	prevTXID := db.Pos().TXID
+	if err := db.commitWAL(ctx); err != nil {
+		log.Printf("%s: commit wal error: db=%s err=%s", db.store.LogPrefix(), db.name, err)
+		return 0
+	} else if txID = db.Pos().TXID; txID <= prevTXID {
+		return 0
+	}
+	return txID
+}
+
+// waitForSyncReplicasOnUnlock waits for replicas to acknowledge a WAL
+// transaction after its locks have been released so other writers are not
+// blocked during the wait. The transaction is already in the WAL so SQLite
+// has reported it as committed. On failure, the error is also returned by
+// the owner's next write lock request so the writer observes it.
+func (db *DB) waitForSyncReplicasOnUnlock(ctx context.Context, guardSet *GuardSet, txID uint64) error {
+	if txID == 0 {
+		return nil
+	}
+
+	if err := db.store.WaitForSyncReplicas(ctx, db.name, txID); err != nil {
+		err = fmt.Errorf("sync replication (wal): txid=%s: %w", ltx.FormatTXID(txID), err)
+		log.Printf("%s: %s: db=%s", db.store.LogPrefix(), err, db.name)
+		guardSet.setErr(err)
+		return err
+	}
+	return nil
+}
+
 // readPage reads the latest version of the page before the current transaction.
-func (db *DB) readPage(dbFile, walFile *os.File, pgno uint32, buf []byte) error {
+func (db *DB) readPage(dbFile, walFile File, pgno uint32, buf []byte) error {
 	// Read from previous position in WAL, if available.
 	if off, ok := db.wal.frameOffsets[pgno]; ok {
 		hdr := make([]byte, WALFrameHeaderSize)
@@ -1742,21 +2429,21 @@ func (db *DB) readPage(dbFile, walFile *os.File, pgno uint32, buf []byte) error
 }
 
 // CreateSHM creates a new shared memory file on disk.
-func (db *DB) CreateSHM() (*os.File, error) {
-	f, err := os.OpenFile(db.SHMPath(), os.O_RDWR|os.O_CREATE|os.O_EXCL|os.O_TRUNC, 0666)
+func (db *DB) CreateSHM() (File, error) {
+	f, err := db.store.FS.OpenFile(db.SHMPath(), os.O_RDWR|os.O_CREATE|os.O_EXCL|os.O_TRUNC, db.store.FileMode)
 	TraceLog.Printf("%s [CreateSHM(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
 	return f, err
 }
 
 // OpenSHM returns a handle for the shared memory file.
-func (db *DB) OpenSHM(ctx context.Context) (*os.File, error) {
-	f, err := os.OpenFile(db.SHMPath(), os.O_RDWR, 0666)
+func (db *DB) OpenSHM(ctx context.Context) (File, error) {
+	f, err := db.store.FS.OpenFile(db.SHMPath(), os.O_RDWR, db.store.FileMode)
 	TraceLog.Printf("%s [OpenSHM(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
 	return f, err
 }
 
 // CloseSHM closes a handle associated with the SHM file.
-func (db *DB) CloseSHM(ctx context.Context, f *os.File, owner uint64) error {
+func (db *DB) CloseSHM(ctx context.Context, f File, owner uint64) error {
 	err := f.Close()
 	TraceLog.Printf("%s [CloseSHM(%s)]: owner=%d %s", db.store.LogPrefix(), db.name, owner, errorKeyValue(err))
 	return err
@@ -1768,7 +2455,7 @@ func (db *DB) SyncSHM(ctx context.Context) (err error) {
 		TraceLog.Printf("%s [SyncSHM(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
 	}()
 
-	f, err := os.Open(db.SHMPath())
+	f, err := db.store.FS.Open(db.SHMPath())
 	if err != nil {
 		return err
 	} else if err := f.Sync(); err != nil {
@@ -1780,14 +2467,14 @@ func (db *DB) SyncSHM(ctx context.Context) (err error) {
 
 // TruncateSHM sets the size of the the SHM file.
 func (db *DB) TruncateSHM(ctx context.Context, size int64) error {
-	err := os.Truncate(db.SHMPath(), size)
+	err := truncateFile(db.store.FS, db.SHMPath(), size)
 	TraceLog.Printf("%s [TruncateSHM(%s)]: size=%d %s", db.store.LogPrefix(), db.name, size, errorKeyValue(err))
 	return err
 }
 
 // RemoveSHM removes the SHM file from disk.
 func (db *DB) RemoveSHM(ctx context.Context) error {
-	err := os.Remove(db.SHMPath())
+	err := db.store.FS.Remove(db.SHMPath())
 	TraceLog.Printf("%s [RemoveSHM(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
 	return err
 }
@@ -1800,25 +2487,26 @@ func (db *DB) UnlockSHM(ctx context.Context, owner uint64) {
 	}
 
 	// Process WAL if we have an exclusive lock on WAL_WRITE_LOCK.
+	var txID uint64
 	if guardSet.Write().State() == RWMutexStateExclusive {
-		if err := db.CommitWAL(ctx); err != nil {
-			log.Printf("commit wal error(1): %s", err)
-		}
+		txID = db.commitWALOnUnlock(ctx)
 	}
 
 	guardSet.UnlockSHM()
 	TraceLog.Printf("%s [UnlockSHM(%s)]: owner=%d", db.store.LogPrefix(), db.name, owner)
+
+	_ = db.waitForSyncReplicasOnUnlock(ctx, guardSet, txID)
 }
 
 // ReadSHMAt reads from the shared memory at the specified offset.
-func (db *DB) ReadSHMAt(ctx context.Context, f *os.File, data []byte, offset int64, owner uint64) (int, error) {
+func (db *DB) ReadSHMAt(ctx context.Context, f File, data []byte, offset int64, owner uint64) (int, error) {
 	n, err := f.ReadAt(data, offset)
 	TraceLog.Printf("%s [ReadSHMAt(%s)]: offset=%d size=%d owner=%d %s", db.store.LogPrefix(), db.name, offset, len(data), owner, errorKeyValue(err))
 	return n, err
 }
 
 // WriteSHMAt writes data to the SHM file.
-func (db *DB) WriteSHMAt(ctx context.Context, f *os.File, data []byte, offset int64, owner uint64) (int, error) {
+func (db *DB) WriteSHMAt(ctx context.Context, f File, data []byte, offset int64, owner uint64) (int, error) {
 	// Ignore writes that occur while the SHM is updating. This is a side effect
 	// of SQLite using mmap() which can cause re-access to update it.
 	if !db.shmMu.TryLock() {
@@ -1853,8 +2541,8 @@ func (db *DB) CommitJournal(ctx context.Context, mode JournalMode) (err error) {
 	}()
 
 	// Return an error if the current process is not the leader.
-	if !db.Writeable() {
-		return ErrReadOnlyReplica
+	if err := db.checkWriteable(); err != nil {
+		return err
 	}
 
 	// Read journal header to ensure it's valid.
@@ -1876,7 +2564,7 @@ func (db *DB) CommitJournal(ctx context.Context, mode JournalMode) (err error) {
 	// Determine transaction ID of the in-process transaction.
 	txID := prevPos.TXID + 1
 
-	dbFile, err := os.Open(db.DatabasePath())
+	dbFile, err := db.store.FS.Open(db.DatabasePath())
 	if err != nil {
 		return fmt.Errorf("cannot open database file: %w", err)
 	}
@@ -1901,9 +2589,9 @@ func (db *DB) CommitJournal(ctx context.Context, mode JournalMode) (err error) {
 	// Open file descriptors for the header & page blocks for new LTX file.
 	ltxPath := db.LTXPath(txID, txID)
 	tmpPath := ltxPath + ".tmp"
-	_ = os.Remove(tmpPath)
+	_ = db.store.FS.Remove(tmpPath)
 
-	ltxFile, err := os.Create(tmpPath)
+	ltxFile, err := db.store.FS.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, db.store.FileMode)
 	if err != nil {
 		return fmt.Errorf("cannot create LTX file: %w", err)
 	}
@@ -2023,11 +2711,15 @@ func (db *DB) CommitJournal(ctx context.Context, mode JournalMode) (err error) {
 	}
 
 	// Atomically rename the file
-	if err := os.Rename(tmpPath, ltxPath); err != nil {
+	if err := db.store.FS.Rename(tmpPath, ltxPath); err != nil {
 		return fmt.Errorf("rename ltx file: %w", err)
-	} else if err := internal.Sync(filepath.Dir(ltxPath)); err != nil {
+	}
+	db.ltxHeaders.Remove(filepath.Base(ltxPath))
+
+	if err := db.store.FS.SyncDir(filepath.Dir(ltxPath)); err != nil {
 		return fmt.Errorf("sync ltx dir: %w", err)
 	}
+	db.store.enqueueBackup(db, enc.Header())
 
 	// Ensure file is persisted to disk.
 	if err := dbFile.Sync(); err != nil {
@@ -2043,10 +2735,7 @@ func (db *DB) CommitJournal(ctx context.Context, mode JournalMode) (err error) {
 	db.mode.Store(dbMode)
 
 	// Update transaction for database.
-	pos = Pos{
-		TXID:              enc.Header().MaxTXID,
-		PostApplyChecksum: enc.Trailer().PostApplyChecksum,
-	}
+	pos = NewPosFromLTX(enc.Header(), enc.Trailer())
 	if err := db.setPos(pos); err != nil {
 		return fmt.Errorf("set pos: %w", err)
 	}
@@ -2056,9 +2745,13 @@ func (db *DB) CommitJournal(ctx context.Context, mode JournalMode) (err error) {
 	dbLTXCountMetricVec.WithLabelValues(db.name).Inc()
 	dbLTXBytesMetricVec.WithLabelValues(db.name).Set(float64(enc.N()))
 	dbLatencySecondsMetricVec.WithLabelValues(db.name).Set(0.0)
+	dbWriteBytesTotalMetricVec.WithLabelValues(db.name).Add(float64(enc.N()))
+	dbTxTotalMetricVec.WithLabelValues(db.name).Inc()
 
 	// Notify store of database change.
 	db.store.MarkDirty(db.name)
+	db.store.publishEvent(newTxEvent(db.name, enc.Header(), enc.Trailer(), enc.N()))
+	db.store.notifyApply(db.name, NewPosFromLTX(enc.Header(), enc.Trailer()))
 
 	// Calculate checksum for entire database.
 	if db.store.StrictVerify {
@@ -2069,11 +2762,16 @@ func (db *DB) CommitJournal(ctx context.Context, mode JournalMode) (err error) {
 		}
 	}
 
+	// Wait for replicas to apply the transaction. See CommitWAL().
+	if err := db.store.WaitForSyncReplicas(ctx, db.name, pos.TXID); err != nil {
+		return fmt.Errorf("sync replication (journal): %w", err)
+	}
+
 	return nil
 }
 
 // onDiskChecksum calculates the LTX checksum directly from the on-disk database & WAL.
-func (db *DB) onDiskChecksum(dbFile, walFile *os.File) (chksum uint64, err error) {
+func (db *DB) onDiskChecksum(dbFile, walFile File) (chksum uint64, err error) {
 	if db.pageSize == 0 {
 		return 0, fmt.Errorf("page size required for checksum")
 	} else if db.pageN == 0 {
@@ -2109,7 +2807,7 @@ func (db *DB) onDiskChecksum(dbFile, walFile *os.File) (chksum uint64, err error
 
 // isJournalHeaderValid returns true if the journal starts with the journal magic.
 func (db *DB) isJournalHeaderValid() (bool, error) {
-	f, err := os.Open(db.JournalPath())
+	f, err := db.store.FS.Open(db.JournalPath())
 	if err != nil {
 		return false, err
 	}
@@ -2126,19 +2824,19 @@ func (db *DB) isJournalHeaderValid() (bool, error) {
 func (db *DB) invalidateJournal(mode JournalMode) error {
 	switch mode {
 	case JournalModeDelete:
-		if err := os.Remove(db.JournalPath()); err != nil {
+		if err := db.store.FS.Remove(db.JournalPath()); err != nil {
 			return fmt.Errorf("remove journal file: %w", err)
 		}
 
 	case JournalModeTruncate:
-		if err := os.Truncate(db.JournalPath(), 0); err != nil {
+		if err := truncateFile(db.store.FS, db.JournalPath(), 0); err != nil {
 			return fmt.Errorf("truncate: %w", err)
-		} else if err := internal.Sync(db.JournalPath()); err != nil {
+		} else if err := db.store.FS.SyncDir(db.JournalPath()); err != nil {
 			return fmt.Errorf("sync journal: %w", err)
 		}
 
 	case JournalModePersist:
-		f, err := os.OpenFile(db.JournalPath(), os.O_RDWR, 0666)
+		f, err := db.store.FS.OpenFile(db.JournalPath(), os.O_RDWR, db.store.FileMode)
 		if err != nil && !os.IsNotExist(err) {
 			return fmt.Errorf("open journal: %w", err)
 		} else if err == nil {
@@ -2156,7 +2854,7 @@ func (db *DB) invalidateJournal(mode JournalMode) error {
 	}
 
 	// Sync the underlying directory.
-	if err := internal.Sync(db.path); err != nil {
+	if err := db.store.FS.SyncDir(db.path); err != nil {
 		return fmt.Errorf("sync database directory: %w", err)
 	}
 
@@ -2191,9 +2889,9 @@ func (db *DB) WriteLTXFileAt(ctx context.Context, r io.Reader) (string, error) {
 	// Write LTX file to a temporary file.
 	path := db.LTXPath(hdr.MinTXID, hdr.MaxTXID)
 	tmpPath := path + ".tmp"
-	defer func() { _ = os.Remove(tmpPath) }()
+	defer func() { _ = db.store.FS.Remove(tmpPath) }()
 
-	f, err := os.Create(tmpPath)
+	f, err := db.store.FS.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, db.store.FileMode)
 	if err != nil {
 		return "", fmt.Errorf("cannot create temp ltx file: %w", err)
 	}
@@ -2214,17 +2912,22 @@ func (db *DB) WriteLTXFileAt(ctx context.Context, r io.Reader) (string, error) {
 	}
 
 	// Atomically rename file.
-	if err := os.Rename(tmpPath, path); err != nil {
+	if err := db.store.FS.Rename(tmpPath, path); err != nil {
 		return "", fmt.Errorf("rename ltx file: %w", err)
-	} else if err := internal.Sync(filepath.Dir(path)); err != nil {
+	}
+	db.ltxHeaders.Remove(filepath.Base(path))
+
+	if err := db.store.FS.SyncDir(filepath.Dir(path)); err != nil {
 		return "", fmt.Errorf("sync ltx dir: %w", err)
 	}
+	db.store.enqueueBackup(db, hdr)
+
 	return path, nil
 }
 
 // ApplyLTX acquires a write lock and then applies an LTX file to the database.
 func (db *DB) ApplyLTX(ctx context.Context, path string) error {
-	guard, err := db.AcquireWriteLock(ctx, nil)
+	guard, err := db.acquireWriteLock(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -2235,6 +2938,7 @@ func (db *DB) ApplyLTX(ctx context.Context, path string) error {
 
 // ApplyLTXNoLock applies an LTX file to the database.
 func (db *DB) ApplyLTXNoLock(ctx context.Context, path string) error {
+	t0 := time.Now()
 	var hdr ltx.Header
 	var trailer ltx.Trailer
 	prevDBMode := db.Mode()
@@ -2245,14 +2949,14 @@ func (db *DB) ApplyLTXNoLock(ctx context.Context, path string) error {
 	}()
 
 	// Open database file for writing.
-	dbFile, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666)
+	dbFile, err := db.store.FS.OpenFile(db.DatabasePath(), os.O_RDWR, db.store.FileMode)
 	if err != nil {
 		return fmt.Errorf("open database file: %w", err)
 	}
 	defer func() { _ = dbFile.Close() }()
 
 	// Open LTX header reader.
-	hf, err := os.Open(path)
+	hf, err := db.store.FS.Open(path)
 	if err != nil {
 		return fmt.Errorf("open file: %w", err)
 	}
@@ -2311,10 +3015,7 @@ func (db *DB) ApplyLTXNoLock(ctx context.Context, path string) error {
 	}
 
 	// Update transaction for database.
-	if err := db.setPos(Pos{
-		TXID:              dec.Header().MaxTXID,
-		PostApplyChecksum: dec.Trailer().PostApplyChecksum,
-	}); err != nil {
+	if err := db.setPos(NewPosFromLTX(dec.Header(), dec.Trailer())); err != nil {
 		return fmt.Errorf("set pos: %w", err)
 	}
 
@@ -2325,10 +3026,24 @@ func (db *DB) ApplyLTXNoLock(ctx context.Context, path string) error {
 
 	// Notify store of database change.
 	db.store.MarkDirty(db.name)
+	db.store.publishEvent(newTxEvent(db.name, dec.Header(), dec.Trailer(), dec.N()))
+	db.store.notifyApply(db.name, NewPosFromLTX(dec.Header(), dec.Trailer()))
 
 	// Calculate latency since LTX file was written.
 	latency := float64(time.Now().UnixMilli()-dec.Header().Timestamp) / 1000
 	dbLatencySecondsMetricVec.WithLabelValues(db.name).Set(latency)
+	dbLTXApplyDurationSecondsMetricVec.WithLabelValues(db.name).Observe(time.Since(t0).Seconds())
+
+	// Snapshots are counted as a single transaction as they replace the
+	// database rather than replaying each transaction.
+	txN := hdr.MaxTXID - hdr.MinTXID + 1
+	if hdr.IsSnapshot() {
+		txN = 1
+	}
+	if fi, err := hf.Stat(); err == nil {
+		dbWriteBytesTotalMetricVec.WithLabelValues(db.name).Add(float64(fi.Size()))
+	}
+	dbTxTotalMetricVec.WithLabelValues(db.name).Add(float64(txN))
 
 	return nil
 }
@@ -2344,7 +3059,7 @@ func (db *DB) updateSHM(ctx context.Context) error {
 	TraceLog.Printf("%s [UpdateSHM(%s)]", db.store.LogPrefix(), db.name)
 	defer TraceLog.Printf("%s [UpdateSHMDone(%s)]", db.store.LogPrefix(), db.name)
 
-	f, err := os.OpenFile(db.SHMPath(), os.O_RDWR|os.O_CREATE, 0666)
+	f, err := db.store.FS.OpenFile(db.SHMPath(), os.O_RDWR|os.O_CREATE, db.store.FileMode)
 	if err != nil {
 		return err
 	}
@@ -2456,16 +3171,16 @@ func (db *DB) Export(ctx context.Context, dst io.Writer) (Pos, error) {
 	}
 
 	// Open database file.
-	dbFile, err := os.Open(db.DatabasePath())
+	dbFile, err := db.store.FS.Open(db.DatabasePath())
 	if err != nil {
 		return pos, fmt.Errorf("open database file: %w", err)
 	}
 	defer func() { _ = dbFile.Close() }()
 
 	// Open WAL file if we have overriding WAL frames.
-	var walFile *os.File
+	var walFile File
 	if len(walFrameOffsets) > 0 {
-		if walFile, err = os.Open(db.WALPath()); err != nil {
+		if walFile, err = db.store.FS.Open(db.WALPath()); err != nil {
 			return pos, fmt.Errorf("open wal file: %w", err)
 		}
 		defer func() { _ = walFile.Close() }()
@@ -2505,7 +3220,7 @@ func (db *DB) Import(ctx context.Context, r io.Reader) error {
 	}
 
 	// Acquire write lock.
-	guard, err := db.AcquireWriteLock(ctx, nil)
+	guard, err := db.acquireWriteLock(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -2517,7 +3232,7 @@ func (db *DB) Import(ctx context.Context, r io.Reader) error {
 	}
 
 	// Truncate WAL, if it exists.
-	if _, err := os.Stat(db.WALPath()); err == nil {
+	if _, err := db.store.FS.Stat(db.WALPath()); err == nil {
 		if err := db.TruncateWAL(ctx, 0); err != nil {
 			return fmt.Errorf("truncate wal: %w", err)
 		}
@@ -2531,107 +3246,500 @@ func (db *DB) Import(ctx context.Context, r io.Reader) error {
 	return db.ApplyLTXNoLock(ctx, db.LTXPath(pos.TXID, pos.TXID))
 }
 
-// importToLTX reads a SQLite database and writes it to the next LTX file.
-func (db *DB) importToLTX(ctx context.Context, r io.Reader) (Pos, error) {
-	// Read header to determine DB mode, page size, & commit.
-	hdr, data, err := readSQLiteDatabaseHeader(r)
+// RecoverToTXID rewinds the database to txID by replaying the retained LTX
+// files up to and including txID. Newer LTX files are removed and replaced
+// by a snapshot at the recovered position so that replicas converge on it.
+// Returns ErrTXIDNotRetained if txID cannot be rebuilt from retained files.
+func (db *DB) RecoverToTXID(ctx context.Context, txID uint64) error {
+	guard, err := db.acquireWriteLock(ctx, nil)
 	if err != nil {
-		return Pos{}, fmt.Errorf("read database header: %w", err)
+		return err
 	}
+	defer guard.Unlock()
 
-	// Prepend header back onto original reader.
-	r = io.MultiReader(bytes.NewReader(data), r)
+	if pos := db.Pos(); txID == 0 || txID > pos.TXID {
+		return fmt.Errorf("%w: txid %s, current position %s", ErrTXIDNotRetained, ltx.FormatTXID(txID), pos)
+	}
 
-	// Determine resulting position.
-	pos := db.Pos()
-	pos.TXID++
-	preApplyChecksum := pos.PostApplyChecksum
-	pos.PostApplyChecksum = 0
+	// Determine the retained files that rebuild the database up to txID.
+	var infos []LTXFileInfo
+	if err := db.ForEachLTX(func(info LTXFileInfo) error {
+		if info.MaxTXID <= txID {
+			infos = append(infos, info)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	chain, err := ltxRestoreChain(infos)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrTXIDNotRetained, err)
+	} else if maxTXID := chain[len(chain)-1].MaxTXID; maxTXID != txID {
+		return fmt.Errorf("%w: retained files end at txid %s", ErrTXIDNotRetained, ltx.FormatTXID(maxTXID))
+	}
 
-	// Open file descriptors for the header & page blocks for new LTX file.
-	ltxPath := db.LTXPath(pos.TXID, pos.TXID)
-	tmpPath := ltxPath + ".tmp"
-	_ = os.Remove(tmpPath)
+	// Invalidate journal & truncate WAL so the database file is authoritative.
+	if err := db.invalidateJournal(JournalModePersist); err != nil {
+		return fmt.Errorf("invalidate journal: %w", err)
+	}
+	if _, err := db.store.FS.Stat(db.WALPath()); err == nil {
+		if err := db.TruncateWAL(ctx, 0); err != nil {
+			return fmt.Errorf("truncate wal: %w", err)
+		}
+	}
 
-	f, err := os.Create(tmpPath)
+	for _, info := range chain {
+		if err := db.ApplyLTXNoLock(ctx, db.LTXPath(info.MinTXID, info.MaxTXID)); err != nil {
+			return fmt.Errorf("apply %s: %w", ltx.FormatFilename(info.MinTXID, info.MaxTXID), err)
+		}
+	}
+
+	// Replace all LTX files with a snapshot at the recovered position.
+	hdr, err := db.writeSnapshotLTXNoLock(ctx)
 	if err != nil {
-		return Pos{}, fmt.Errorf("cannot create LTX file: %w", err)
+		return fmt.Errorf("write snapshot: %w", err)
 	}
-	defer func() { _ = f.Close() }()
+	filename := ltx.FormatFilename(hdr.MinTXID, hdr.MaxTXID)
+	if err := removeFilesExcept(db.store.FS, db.LTXDir(), filename); err != nil {
+		return fmt.Errorf("remove ltx files: %w", err)
+	}
+	db.ltxHeaders.Purge()
+	db.backedUpLTX.Range(func(key, value any) bool {
+		db.backedUpLTX.Delete(key)
+		return true
+	})
+	db.store.enqueueBackup(db, hdr)
 
-	enc := ltx.NewEncoder(f)
-	if err := enc.EncodeHeader(ltx.Header{
-		Version:          1,
-		Flags:            db.ltxHeaderFlags(),
-		PageSize:         hdr.PageSize,
-		Commit:           hdr.PageN,
-		MinTXID:          pos.TXID,
-		MaxTXID:          pos.TXID,
-		Timestamp:        db.Now().UnixMilli(),
-		PreApplyChecksum: preApplyChecksum,
-		NodeID:           db.store.ID(),
-	}); err != nil {
-		return Pos{}, fmt.Errorf("cannot encode ltx header: %s", err)
+	// Notify subscribers so replicas receive the snapshot.
+	db.store.MarkDirty(db.name)
+
+	log.Printf("%s: database recovered: db=%s pos=%s", db.store.LogPrefix(), db.name, db.Pos())
+	return nil
+}
+
+// Verify checks the integrity of the database against its retained LTX files.
+// Each file is read in full to validate its file checksum & the pages of
+// snapshots are checksummed against their post-apply checksum. The files must
+// form a continuous chain where each pre-apply checksum matches the previous
+// post-apply checksum. Files covered by a later snapshot are only checked
+// individually. The chain must end at the current position and the checksum
+// of the database file on disk must match it.
+//
+// Files are streamed from disk one at a time. Writes are blocked until
+// verification completes. Returns an error wrapping ErrVerifyFailed if the
+// database or its LTX files do not match.
+func (db *DB) Verify(ctx context.Context) error {
+	guard, err := db.acquireWriteLock(ctx, nil)
+	if err != nil {
+		return err
 	}
+	defer guard.Unlock()
 
-	// Generate LTX file from reader.
-	buf := make([]byte, hdr.PageSize)
-	lockPgno := ltx.LockPgno(hdr.PageSize)
-	for pgno := uint32(1); pgno <= hdr.PageN; pgno++ {
-		if _, err := io.ReadFull(r, buf); err != nil {
-			return Pos{}, fmt.Errorf("read page %d: %w", pgno, err)
-		}
+	pos := db.Pos()
+	if pos.TXID == 0 {
+		return nil // no transactions to verify
+	}
 
-		// Skip the lock page.
-		if pgno == lockPgno {
-			continue
-		}
+	var infos []LTXFileInfo
+	if err := db.ForEachLTX(func(info LTXFileInfo) error {
+		infos = append(infos, info)
+		return nil
+	}); err != nil {
+		return err
+	}
 
-		// Reset file change counter & schema cookie to ensure existing connections reload.
-		if pgno == 1 {
-			binary.BigEndian.PutUint32(buf[24:], 0)
-			binary.BigEndian.PutUint32(buf[40:], 0)
+	var txID, chksum uint64
+	for _, info := range infos {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		if err := enc.EncodePage(ltx.PageHeader{Pgno: pgno}, buf); err != nil {
-			return Pos{}, fmt.Errorf("encode ltx page: pgno=%d err=%w", pgno, err)
+		filename := ltx.FormatFilename(info.MinTXID, info.MaxTXID)
+		hdr, trailer, err := verifyLTXFile(db.store.FS, db.LTXPath(info.MinTXID, info.MaxTXID))
+		if os.IsNotExist(err) {
+			continue // removed by retention enforcement
+		} else if err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrVerifyFailed, filename, err)
 		}
 
-		pageChksum := ltx.ChecksumPage(pgno, buf)
-		pos.PostApplyChecksum = ltx.ChecksumFlag | (pos.PostApplyChecksum ^ pageChksum)
+		switch {
+		case txID == 0:
+			// Retained files may not start at a snapshot so the first
+			// pre-apply checksum cannot be checked.
+		case info.MaxTXID <= txID:
+			continue // covered by a later snapshot
+		case info.Snapshot:
+			// Snapshots replace the chain so their pre-apply checksum is zero.
+		case info.MinTXID != txID+1:
+			return fmt.Errorf("%w: gap in ltx files: %s follows txid %s", ErrVerifyFailed, filename, ltx.FormatTXID(txID))
+		case hdr.PreApplyChecksum != chksum:
+			return fmt.Errorf("%w: %s: pre-apply checksum %016x, expected %016x", ErrVerifyFailed, filename, hdr.PreApplyChecksum, chksum)
+		}
+		txID, chksum = info.MaxTXID, trailer.PostApplyChecksum
 	}
 
-	// Finish page block to compute checksum and then finish header block.
-	enc.SetPostApplyChecksum(pos.PostApplyChecksum)
-	if err := enc.Close(); err != nil {
-		return Pos{}, fmt.Errorf("close ltx encoder: %s", err)
-	} else if err := f.Sync(); err != nil {
-		return Pos{}, fmt.Errorf("sync ltx file: %s", err)
-	} else if err := f.Close(); err != nil {
-		return Pos{}, fmt.Errorf("close ltx file: %s", err)
+	if txID != pos.TXID {
+		return fmt.Errorf("%w: ltx files end at txid %s, current position %s", ErrVerifyFailed, ltx.FormatTXID(txID), pos)
+	} else if chksum != pos.PostApplyChecksum {
+		return fmt.Errorf("%w: ltx post-apply checksum %016x, current position %s", ErrVerifyFailed, chksum, pos)
 	}
 
-	// Atomically rename the file
-	if err := os.Rename(tmpPath, ltxPath); err != nil {
-		return Pos{}, fmt.Errorf("rename ltx file: %w", err)
-	} else if err := internal.Sync(filepath.Dir(ltxPath)); err != nil {
-		return Pos{}, fmt.Errorf("sync ltx dir: %w", err)
+	// Compare against the database file & WAL as they exist on disk.
+	return db.verifyChecksum(ctx, pos)
+}
+
+// VerifyChecksum computes the checksum of every page of the database as it
+// exists on disk & compares it against the checksum of the current position.
+// Unlike Verify(), LTX files are not read. Writes to the database are blocked
+// while it is being verified. Returns a *ChecksumMismatchError if the
+// checksums do not match.
+func (db *DB) VerifyChecksum(ctx context.Context) error {
+	guard, err := db.acquireWriteLock(ctx, nil)
+	if err != nil {
+		return err
 	}
+	defer guard.Unlock()
 
-	return pos, nil
+	pos := db.Pos()
+	if pos.TXID == 0 {
+		return nil // no transactions to verify
+	}
+	return db.verifyChecksum(ctx, pos)
 }
 
-// AcquireWriteLock acquires the appropriate locks for a write depending on if
-// the database uses a rollback journal or WAL.
-func (db *DB) AcquireWriteLock(ctx context.Context, fn func() error) (_ *GuardSet, err error) {
-	TraceLog.Printf("%s [AcquireWriteLock(%s)]: ", db.store.LogPrefix(), db.name)
-	defer TraceLog.Printf("%s [AcquireWriteLock.DONE(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
+// verifyChecksum compares the on-disk checksum of the database against pos.
+// Each page is also compared against its tracked checksum so that the first
+// mismatched page can be reported. Must hold the write lock.
+func (db *DB) verifyChecksum(ctx context.Context, pos Pos) error {
+	if db.pageSize == 0 {
+		return fmt.Errorf("page size required for checksum")
+	}
 
-	const interval = 1 * time.Millisecond
-	const maxInterval = 500 * time.Millisecond
+	dbFile, err := db.store.FS.Open(db.DatabasePath())
+	if err != nil {
+		return fmt.Errorf("open database file: %w", err)
+	}
+	defer func() { _ = dbFile.Close() }()
 
-	ticker := time.NewTimer(interval)
-	defer ticker.Stop()
+	var walFile File
+	if len(db.wal.frameOffsets) > 0 {
+		if walFile, err = db.store.FS.Open(db.WALPath()); err != nil {
+			return fmt.Errorf("open wal file: %w", err)
+		}
+		defer func() { _ = walFile.Close() }()
+	}
+
+	db.chksums.mu.Lock()
+	defer db.chksums.mu.Unlock()
+
+	lockPgno := ltx.LockPgno(db.pageSize)
+	data := make([]byte, db.pageSize)
+	var chksum uint64
+	var mismatchPgno uint32
+	for pgno := uint32(1); pgno <= db.pageN; pgno++ {
+		if pgno == lockPgno {
+			continue
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Read from either the database file or the WAL depending if the page exists in the WAL.
+		if offset, ok := db.wal.frameOffsets[pgno]; !ok {
+			if _, err := internal.ReadFullAt(dbFile, data, int64(pgno-1)*int64(db.pageSize)); err != nil {
+				return fmt.Errorf("db read (pgno=%d): %w", pgno, err)
+			}
+		} else {
+			if _, err := internal.ReadFullAt(walFile, data, offset+WALFrameHeaderSize); err != nil {
+				return fmt.Errorf("wal read (pgno=%d): %w", pgno, err)
+			}
+		}
+
+		pageChksum := ltx.ChecksumPage(pgno, data)
+		if tracked, ok := db.pageChecksum(pgno, db.pageN, nil); mismatchPgno == 0 && (!ok || tracked != pageChksum) {
+			mismatchPgno = pgno
+		}
+		chksum = ltx.ChecksumFlag | (chksum ^ pageChksum)
+	}
+
+	if chksum != pos.PostApplyChecksum || mismatchPgno != 0 {
+		return &ChecksumMismatchError{Name: db.name, Pos: pos, Checksum: chksum, Pgno: mismatchPgno}
+	}
+	return nil
+}
+
+// verifyLTXFile reads the LTX file at path in full and validates its file
+// checksum. The pages of a snapshot must also match its post-apply checksum.
+func verifyLTXFile(fsys FileSystem, path string) (ltx.Header, ltx.Trailer, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return ltx.Header{}, ltx.Trailer{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	dec := ltx.NewDecoder(f)
+	if err := dec.DecodeHeader(); err != nil {
+		return ltx.Header{}, ltx.Trailer{}, fmt.Errorf("decode header: %w", err)
+	}
+	hdr := dec.Header()
+
+	var chksum uint64
+	var pageHeader ltx.PageHeader
+	data := make([]byte, hdr.PageSize)
+	for i := 0; ; i++ {
+		if err := dec.DecodePage(&pageHeader, data); err == io.EOF {
+			break
+		} else if err != nil {
+			return ltx.Header{}, ltx.Trailer{}, fmt.Errorf("decode page %d: %w", i, err)
+		}
+		chksum = ltx.ChecksumFlag | (chksum ^ ltx.ChecksumPage(pageHeader.Pgno, data))
+	}
+
+	if err := dec.Close(); err != nil {
+		return ltx.Header{}, ltx.Trailer{}, fmt.Errorf("close reader: %w", err)
+	}
+	trailer := dec.Trailer()
+
+	if hdr.IsSnapshot() && chksum != trailer.PostApplyChecksum {
+		return ltx.Header{}, ltx.Trailer{}, fmt.Errorf("snapshot checksum %016x, expected %016x", chksum, trailer.PostApplyChecksum)
+	}
+	return hdr, trailer, nil
+}
+
+// snapshotTimestamp returns the LTX timestamp for a snapshot at pos. Snapshots
+// carry the commit time of the transaction they end at so that positions match
+// across nodes. Returns zero if the commit time is unknown.
+func (db *DB) snapshotTimestamp(pos Pos) int64 {
+	if pos.Timestamp.IsZero() {
+		return 0
+	}
+	return pos.Timestamp.UnixMilli()
+}
+
+// writeSnapshotLTXNoLock writes the current contents of the database file to
+// a snapshot LTX file at the current position. The database must not have
+// any WAL frames. Must hold the write lock.
+func (db *DB) writeSnapshotLTXNoLock(ctx context.Context) (ltx.Header, error) {
+	pos := db.Pos()
+	hdr := ltx.Header{
+		Version:   1,
+		Flags:     db.ltxHeaderFlags(),
+		PageSize:  db.pageSize,
+		Commit:    db.pageN,
+		MinTXID:   1,
+		MaxTXID:   pos.TXID,
+		Timestamp: db.snapshotTimestamp(pos),
+		NodeID:    db.store.ID(),
+	}
+
+	dbFile, err := db.store.FS.Open(db.DatabasePath())
+	if err != nil {
+		return hdr, err
+	}
+	defer func() { _ = dbFile.Close() }()
+
+	ltxPath := db.LTXPath(hdr.MinTXID, hdr.MaxTXID)
+	tmpPath := ltxPath + ".tmp"
+	defer func() { _ = db.store.FS.Remove(tmpPath) }()
+
+	f, err := db.store.FS.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, db.store.FileMode)
+	if err != nil {
+		return hdr, fmt.Errorf("cannot create LTX file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	enc := ltx.NewEncoder(f)
+	if err := enc.EncodeHeader(hdr); err != nil {
+		return hdr, fmt.Errorf("cannot encode ltx header: %s", err)
+	}
+
+	var chksum uint64
+	buf := make([]byte, db.pageSize)
+	lockPgno := ltx.LockPgno(db.pageSize)
+	for pgno := uint32(1); pgno <= db.pageN; pgno++ {
+		if pgno == lockPgno {
+			continue
+		}
+
+		if _, err := internal.ReadFullAt(dbFile, buf, int64(pgno-1)*int64(db.pageSize)); err != nil {
+			return hdr, fmt.Errorf("read page %d: %w", pgno, err)
+		} else if err := enc.EncodePage(ltx.PageHeader{Pgno: pgno}, buf); err != nil {
+			return hdr, fmt.Errorf("encode ltx page: pgno=%d err=%w", pgno, err)
+		}
+		chksum = ltx.ChecksumFlag | (chksum ^ ltx.ChecksumPage(pgno, buf))
+	}
+
+	// Ensure the database file matches the position before writing the file.
+	if chksum != pos.PostApplyChecksum {
+		return hdr, fmt.Errorf("database checksum %016x does not match position %s", chksum, pos)
+	}
+
+	enc.SetPostApplyChecksum(chksum)
+	if err := enc.Close(); err != nil {
+		return hdr, fmt.Errorf("close ltx encoder: %s", err)
+	} else if err := f.Sync(); err != nil {
+		return hdr, fmt.Errorf("sync ltx file: %s", err)
+	} else if err := f.Close(); err != nil {
+		return hdr, fmt.Errorf("close ltx file: %s", err)
+	}
+
+	if err := db.store.FS.Rename(tmpPath, ltxPath); err != nil {
+		return hdr, fmt.Errorf("rename ltx file: %w", err)
+	}
+	db.ltxHeaders.Remove(filepath.Base(ltxPath))
+
+	if err := db.store.FS.SyncDir(filepath.Dir(ltxPath)); err != nil {
+		return hdr, fmt.Errorf("sync ltx dir: %w", err)
+	}
+	return hdr, nil
+}
+
+// importToLTX reads a SQLite database and writes it to the next LTX file.
+func (db *DB) importToLTX(ctx context.Context, r io.Reader) (Pos, error) {
+	// Read header to determine DB mode, page size, & commit.
+	hdr, data, err := readSQLiteDatabaseHeader(r)
+	if err != nil {
+		return Pos{}, fmt.Errorf("read database header: %w", err)
+	}
+
+	// The header reader is lenient so existing databases are not discarded on
+	// startup, however, imported pages are copied based on these fields so they
+	// must be valid. Legacy SQLite versions may leave the in-header page count
+	// unset until the database is vacuumed.
+	if !ltx.IsValidPageSize(hdr.PageSize) {
+		return Pos{}, fmt.Errorf("invalid sqlite page size: %d", hdr.PageSize)
+	} else if hdr.PageN == 0 {
+		return Pos{}, fmt.Errorf("sqlite database header has no page count, vacuum the database before importing")
+	}
+
+	// Prepend header back onto original reader.
+	r = io.MultiReader(bytes.NewReader(data), r)
+
+	// Determine resulting position.
+	pos := db.Pos()
+	pos.TXID++
+	preApplyChecksum := pos.PostApplyChecksum
+	pos.PostApplyChecksum = 0
+
+	// Open file descriptors for the header & page blocks for new LTX file.
+	ltxPath := db.LTXPath(pos.TXID, pos.TXID)
+	tmpPath := ltxPath + ".tmp"
+	_ = db.store.FS.Remove(tmpPath)
+
+	f, err := db.store.FS.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, db.store.FileMode)
+	if err != nil {
+		return Pos{}, fmt.Errorf("cannot create LTX file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	enc := ltx.NewEncoder(f)
+	if err := enc.EncodeHeader(ltx.Header{
+		Version:          1,
+		Flags:            db.ltxHeaderFlags(),
+		PageSize:         hdr.PageSize,
+		Commit:           hdr.PageN,
+		MinTXID:          pos.TXID,
+		MaxTXID:          pos.TXID,
+		Timestamp:        db.Now().UnixMilli(),
+		PreApplyChecksum: preApplyChecksum,
+		NodeID:           db.store.ID(),
+	}); err != nil {
+		return Pos{}, fmt.Errorf("cannot encode ltx header: %s", err)
+	}
+
+	// Generate LTX file from reader.
+	buf := make([]byte, hdr.PageSize)
+	lockPgno := ltx.LockPgno(hdr.PageSize)
+	for pgno := uint32(1); pgno <= hdr.PageN; pgno++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return Pos{}, fmt.Errorf("read page %d: %w", pgno, err)
+		}
+
+		// Skip the lock page.
+		if pgno == lockPgno {
+			continue
+		}
+
+		// Reset file change counter & schema cookie to ensure existing connections reload.
+		if pgno == 1 {
+			binary.BigEndian.PutUint32(buf[24:], 0)
+			binary.BigEndian.PutUint32(buf[40:], 0)
+		}
+
+		if err := enc.EncodePage(ltx.PageHeader{Pgno: pgno}, buf); err != nil {
+			return Pos{}, fmt.Errorf("encode ltx page: pgno=%d err=%w", pgno, err)
+		}
+
+		pageChksum := ltx.ChecksumPage(pgno, buf)
+		pos.PostApplyChecksum = ltx.ChecksumFlag | (pos.PostApplyChecksum ^ pageChksum)
+	}
+
+	// Finish page block to compute checksum and then finish header block.
+	enc.SetPostApplyChecksum(pos.PostApplyChecksum)
+	if err := enc.Close(); err != nil {
+		return Pos{}, fmt.Errorf("close ltx encoder: %s", err)
+	} else if err := f.Sync(); err != nil {
+		return Pos{}, fmt.Errorf("sync ltx file: %s", err)
+	} else if err := f.Close(); err != nil {
+		return Pos{}, fmt.Errorf("close ltx file: %s", err)
+	}
+
+	// Atomically rename the file
+	if err := db.store.FS.Rename(tmpPath, ltxPath); err != nil {
+		return Pos{}, fmt.Errorf("rename ltx file: %w", err)
+	}
+	db.ltxHeaders.Remove(filepath.Base(ltxPath))
+
+	if err := db.store.FS.SyncDir(filepath.Dir(ltxPath)); err != nil {
+		return Pos{}, fmt.Errorf("sync ltx dir: %w", err)
+	}
+	db.store.enqueueBackup(db, enc.Header())
+
+	return pos, nil
+}
+
+// AcquireWriteLock acquires the appropriate locks for a write depending on if
+// the database uses a rollback journal or WAL. Returns ErrReadOnly or
+// ErrReadOnlyDatabase immediately if the store or database is read-only or
+// ErrStoreDraining if the store is draining.
+//
+// Returns ErrWriteLockTimeout if the lock cannot be acquired within the
+// database's BeginTimeout, or the store's BeginTimeout if unset.
+func (db *DB) AcquireWriteLock(ctx context.Context, fn func() error) (*GuardSet, error) {
+	if db.store.ReadOnly {
+		return nil, ErrReadOnly
+	} else if db.ReadOnly() {
+		return nil, ErrReadOnlyDatabase
+	} else if db.store.IsDraining() {
+		return nil, ErrStoreDraining
+	}
+
+	if timeout := db.writeLockTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, ErrWriteLockTimeout)
+		defer cancel()
+	}
+	return db.acquireWriteLock(ctx, fn)
+}
+
+// writeLockTimeout returns the write lock timeout for the database.
+func (db *DB) writeLockTimeout() time.Duration {
+	if timeout := db.BeginTimeout(); timeout > 0 {
+		return timeout
+	}
+	return db.store.BeginTimeout
+}
+
+// acquireWriteLock acquires the write locks without checking if the database
+// is read-only. Used for replication & administrative changes.
+func (db *DB) acquireWriteLock(ctx context.Context, fn func() error) (_ *GuardSet, err error) {
+	TraceLog.Printf("%s [AcquireWriteLock(%s)]: ", db.store.LogPrefix(), db.name)
+	defer TraceLog.Printf("%s [AcquireWriteLock.DONE(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
+
+	const interval = 1 * time.Millisecond
+	const maxInterval = 500 * time.Millisecond
+
+	ticker := time.NewTimer(interval)
+	defer ticker.Stop()
 
 	for i := 0; ; i++ {
 		// Execute callback on each lock attempt to see if we should exit.
@@ -2643,6 +3751,11 @@ func (db *DB) AcquireWriteLock(ctx context.Context, fn func() error) (_ *GuardSe
 		}
 
 		if gs := db.TryAcquireWriteLock(); gs != nil {
+			// The database may have been dropped or renamed while waiting.
+			if db.removed.Load() {
+				gs.Unlock()
+				return nil, ErrDatabaseNotFound
+			}
 			return gs, nil
 		}
 
@@ -2789,10 +3902,29 @@ func (db *DB) newGuardSet(owner uint64) *GuardSet {
 // TryLocks attempts to lock one or more locks on the database for a given owner.
 // Returns an error if no locks are supplied.
 func (db *DB) TryLocks(ctx context.Context, owner uint64, lockTypes []LockType) (bool, error) {
+	if db.removed.Load() {
+		return false, ErrDatabaseNotFound
+	}
+
 	guardSet := db.CreateGuardSetIfNotExists(owner)
+
+	// Report a synchronous replication failure from the owner's previous WAL
+	// transaction. SQLite ignores errors when releasing the WAL write lock.
+	if err := guardSet.takeErr(); err != nil {
+		return false, err
+	}
 	for _, lockType := range lockTypes {
 		guard := guardSet.Guard(lockType)
 
+		// Reject new write transactions while the store is draining. Owners
+		// that already hold the lock can continue their transaction.
+		if (lockType == LockTypeReserved || lockType == LockTypeWrite) &&
+			db.store.IsDraining() &&
+			guard.State() != RWMutexStateExclusive {
+			TraceLog.Printf("%s [TryLock(%s)]: type=%s owner=%d status=DRAINING", db.store.LogPrefix(), db.name, lockType, owner)
+			return false, nil
+		}
+
 		// There is a race condition where a passive checkpoint can copy out data
 		// from the WAL to the database before an LTX file is written. To prevent
 		// that, we require that the owner has acquired the WRITE lock before
@@ -2831,6 +3963,16 @@ func (db *DB) TryLocks(ctx context.Context, owner uint64, lockTypes []LockType)
 		//	}
 		//}
 	}
+
+	// The database may have been dropped or renamed while the locks were
+	// held by the store. Release them so they are not used on a stale path.
+	if db.removed.Load() {
+		for _, lockType := range lockTypes {
+			guardSet.Guard(lockType).Unlock()
+		}
+		return false, ErrDatabaseNotFound
+	}
+
 	return true, nil
 }
 
@@ -2850,6 +3992,10 @@ func (db *DB) CanLock(ctx context.Context, owner uint64, lockTypes []LockType) (
 // TryRLocks attempts to read lock one or more locks on the database for a given owner.
 // Returns an error if no locks are supplied.
 func (db *DB) TryRLocks(ctx context.Context, owner uint64, lockTypes []LockType) bool {
+	if db.removed.Load() {
+		return false
+	}
+
 	guardSet := db.CreateGuardSetIfNotExists(owner)
 	for _, lockType := range lockTypes {
 		ok := guardSet.Guard(lockType).TryRLock()
@@ -2886,10 +4032,9 @@ func (db *DB) Unlock(ctx context.Context, owner uint64, lockTypes []LockType) er
 	}
 
 	// Process WAL if we have an exclusive lock on WAL_WRITE_LOCK.
+	var txID uint64
 	if ContainsLockType(lockTypes, LockTypeWrite) && guardSet.Write().State() == RWMutexStateExclusive {
-		if err := db.CommitWAL(ctx); err != nil {
-			log.Printf("commit wal error(2): %s", err)
-		}
+		txID = db.commitWALOnUnlock(ctx)
 	}
 
 	for _, lockType := range lockTypes {
@@ -2899,7 +4044,7 @@ func (db *DB) Unlock(ctx context.Context, owner uint64, lockTypes []LockType) er
 
 	// TODO: Release guard set if completely unlocked.
 
-	return nil
+	return db.waitForSyncReplicasOnUnlock(ctx, guardSet, txID)
 }
 
 // InWriteTx returns true if the RESERVED lock has an exclusive lock.
@@ -3021,16 +4166,16 @@ func (db *DB) WriteSnapshotTo(ctx context.Context, dst io.Writer) (header ltx.He
 	log.Printf("writing snapshot %q @ %s", db.name, ltx.FormatTXID(pos.TXID))
 
 	// Open database file.
-	dbFile, err := os.Open(db.DatabasePath())
+	dbFile, err := db.store.FS.Open(db.DatabasePath())
 	if err != nil {
 		return header, trailer, fmt.Errorf("open database file: %w", err)
 	}
 	defer func() { _ = dbFile.Close() }()
 
 	// Open WAL file if we have overriding WAL frames.
-	var walFile *os.File
+	var walFile File
 	if len(walFrameOffsets) > 0 {
-		if walFile, err = os.Open(db.WALPath()); err != nil {
+		if walFile, err = db.store.FS.Open(db.WALPath()); err != nil {
 			return header, trailer, fmt.Errorf("open wal file: %w", err)
 		}
 		defer func() { _ = walFile.Close() }()
@@ -3045,7 +4190,7 @@ func (db *DB) WriteSnapshotTo(ctx context.Context, dst io.Writer) (header ltx.He
 		Commit:    pageN,
 		MinTXID:   1,
 		MaxTXID:   pos.TXID,
-		Timestamp: db.Now().UnixMilli(),
+		Timestamp: db.snapshotTimestamp(pos),
 		NodeID:    db.store.ID(),
 	}); err != nil {
 		return header, trailer, fmt.Errorf("encode ltx header: %w", err)
@@ -3097,8 +4242,17 @@ func (db *DB) WriteSnapshotTo(ctx context.Context, dst io.Writer) (header ltx.He
 	return enc.Header(), enc.Trailer(), nil
 }
 
-// EnforceRetention removes all LTX files created before minTime.
+// EnforceRetention removes all LTX files created before minTime as well as the
+// oldest files beyond the maximum file count. The most recent files, as
+// specified by the store's RetentionMinCount, are never removed. Files that a
+// connected replica needs to advance from its position are also kept.
 func (db *DB) EnforceRetention(ctx context.Context, minTime time.Time) error {
+	// Read position first as new LTX files can only move it forward.
+	pos := db.Pos()
+
+	// Determine the lowest position still required by a connected replica.
+	replicaTXID := db.store.replicaMinTXID(db.name)
+
 	// Collect all LTX files.
 	ents, err := db.ReadLTXDir()
 	if err != nil {
@@ -3107,28 +4261,77 @@ func (db *DB) EnforceRetention(ctx context.Context, minTime time.Time) error {
 		return nil // no LTX files, exit
 	}
 
-	// Ensure the latest LTX file is not removed.
-	ents = ents[:len(ents)-1]
+	// Refuse to remove files if the chain is already broken.
+	if db.store.RetentionSafetyCheck {
+		if n := db.scrubLTXChain(ents, pos); n > 0 {
+			log.Printf("%s: ltx chain has %d gap(s), skipping retention: db=%s", db.store.LogPrefix(), n, db.name)
+			return nil
+		}
+	}
+
+	// Ensure the latest LTX files are not removed.
+	keepN := db.store.RetentionMinCount
+	if keepN < 1 {
+		keepN = 1
+	} else if keepN > len(ents) {
+		keepN = len(ents)
+	}
+	n, maxN := len(ents), db.MaxLTXFileCount()
+	ents, keepEnts := ents[:len(ents)-keepN], ents[len(ents)-keepN:]
 
-	// Delete all files that are before the minimum time.
+	// Delete all files that are before the minimum time or that exceed the
+	// maximum file count, whichever removes more.
 	var totalN int
 	var totalSize int64
-	for _, ent := range ents {
-		// Check if file qualifies for deletion.
+	for _, ent := range keepEnts {
+		if fi, err := ent.Info(); err == nil {
+			totalN++
+			totalSize += fi.Size()
+		}
+	}
+	requireBackup := db.store.Backup != nil && db.store.RetentionRequireBackup
+	var blocked bool
+	for i, ent := range ents {
+		// Check if file qualifies for deletion. Files are sorted from oldest
+		// to newest so the remaining files are always a contiguous set.
 		fi, err := ent.Info()
 		if err != nil {
 			return fmt.Errorf("info: %w", err)
-		} else if fi.ModTime().After(minTime) {
+		} else if overMax := maxN > 0 && n-i > maxN; blocked || (!overMax && fi.ModTime().After(minTime)) {
+			totalN++
+			totalSize += fi.Size()
+			continue // after minimum time or blocked by backup or replica, skip
+		}
+
+		// Keep files that a connected replica has not yet advanced past.
+		if _, maxTXID, _ := ltx.ParseFilename(ent.Name()); replicaTXID > 0 && maxTXID > replicaTXID {
+			TraceLog.Printf("%s [EnforceRetention(%s)]: keeping %s, needed by replica at %s", db.store.LogPrefix(), db.name, ent.Name(), ltx.FormatTXID(replicaTXID))
+			blocked = true
 			totalN++
 			totalSize += fi.Size()
-			continue // after minimum time, skip
+			continue
+		}
+
+		// Ensure the file has been backed up before removing it. Newer files
+		// are kept as well so that the remaining files stay contiguous.
+		if requireBackup {
+			minTXID, maxTXID, _ := ltx.ParseFilename(ent.Name())
+			if err := db.backupLTX(ctx, minTXID, maxTXID); err != nil {
+				log.Printf("%s: cannot back up ltx file, deferring retention: db=%s file=%s err=%s", db.store.LogPrefix(), db.name, ent.Name(), err)
+				blocked = true
+				totalN++
+				totalSize += fi.Size()
+				continue
+			}
 		}
 
 		// Remove file if it passes all the checks.
 		filename := filepath.Join(db.LTXDir(), ent.Name())
-		if err := os.Remove(filename); err != nil {
+		if err := db.store.FS.Remove(filename); err != nil {
 			return err
 		}
+		db.backedUpLTX.Delete(ent.Name())
+		db.ltxHeaders.Remove(ent.Name())
 
 		// Update metrics.
 		dbLTXReapCountMetricVec.WithLabelValues(db.name).Inc()
@@ -3141,6 +4344,495 @@ func (db *DB) EnforceRetention(ctx context.Context, minTime time.Time) error {
 	return nil
 }
 
+// scrubLTXChain verifies that the LTX files in ents are contiguous & that the
+// last file reaches pos. Each gap is logged and the number of gaps is returned.
+func (db *DB) scrubLTXChain(ents []fs.DirEntry, pos Pos) (n int) {
+	var prevMaxTXID uint64
+	for i, ent := range ents {
+		minTXID, maxTXID, err := ltx.ParseFilename(ent.Name())
+		if err != nil {
+			continue // already filtered by ReadLTXDir()
+		}
+
+		// Snapshots restart the chain so they cannot leave a gap.
+		if i > 0 && minTXID != 1 && minTXID != prevMaxTXID+1 {
+			log.Printf("%s: ltx chain gap: db=%s prev=%s next=%s", db.store.LogPrefix(), db.name, ltx.FormatTXID(prevMaxTXID), ent.Name())
+			n++
+		}
+		prevMaxTXID = maxTXID
+	}
+
+	if prevMaxTXID < pos.TXID {
+		log.Printf("%s: ltx chain does not reach database position: db=%s last=%s pos=%s", db.store.LogPrefix(), db.name, ltx.FormatTXID(prevMaxTXID), ltx.FormatTXID(pos.TXID))
+		n++
+	}
+
+	dbLTXChainGapCountMetricVec.WithLabelValues(db.name).Set(float64(n))
+	return n
+}
+
+// backupLTX writes an LTX file to the store's backup, if it has not already
+// been written. Files removed before they can be backed up are skipped.
+func (db *DB) backupLTX(ctx context.Context, minTXID, maxTXID uint64) (err error) {
+	defer func() {
+		if err != nil {
+			backupErrorCountMetric.Inc()
+		}
+	}()
+
+	if err := db.loadBackedUpLTX(ctx); err != nil {
+		return err
+	}
+
+	filename := ltx.FormatFilename(minTXID, maxTXID)
+	if _, ok := db.backedUpLTX.Load(filename); ok {
+		return nil
+	}
+
+	f, err := db.store.FS.Open(db.LTXPath(minTXID, maxTXID))
+	if os.IsNotExist(err) {
+		return nil // removed by snapshot or retention enforcement
+	} else if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	hdr, _, err := ltx.DecodeHeader(f)
+	if err != nil {
+		return fmt.Errorf("decode ltx header: %w", err)
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek ltx file: %w", err)
+	}
+
+	if err := db.store.Backup.WriteLTX(ctx, db.name, hdr, f); err != nil {
+		return fmt.Errorf("write ltx to backup: %w", err)
+	}
+	db.backedUpLTX.Store(filename, struct{}{})
+
+	return nil
+}
+
+// loadBackedUpLTX marks the LTX files that already exist in the backup so
+// that files retained across a restart are not written again. The backup is
+// only listed once per database after the listing succeeds.
+func (db *DB) loadBackedUpLTX(ctx context.Context) error {
+	db.backupLoadMu.Lock()
+	defer db.backupLoadMu.Unlock()
+
+	if db.backupLoaded {
+		return nil
+	}
+
+	infos, err := db.store.Backup.LTXFiles(ctx, db.name)
+	if err != nil {
+		return fmt.Errorf("list backup ltx files: %w", err)
+	}
+	for _, info := range infos {
+		db.backedUpLTX.Store(ltx.FormatFilename(info.MinTXID, info.MaxTXID), struct{}{})
+	}
+	db.backupLoaded = true
+
+	return nil
+}
+
+// MaxLTXFileCount returns the maximum number of LTX files retained for the
+// database. Returns zero if there is no limit.
+func (db *DB) MaxLTXFileCount() int {
+	if n := db.RetentionMaxFiles(); n > 0 {
+		return n
+	}
+	return db.store.RetentionMaxFiles
+}
+
+// Compact merges each contiguous run of at least Store.CompactionMinFiles
+// incremental LTX files into a single LTX file covering the same TXID range
+// and then removes the originals. Snapshots and the LTX file at the current
+// position are never compacted so this is safe to run while new transactions
+// are written. Files after the position of a connected replica are also kept
+// as the replica needs to stream them individually to advance.
+func (db *DB) Compact(ctx context.Context) error {
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+
+	// Skip while a HALT lock is held as the remote node expects the LTX files
+	// to remain unchanged until it releases the lock.
+	if db.haltLockAndGuard.Load().(*haltLockAndGuard) != nil || db.HasRemoteHaltLock() {
+		TraceLog.Printf("%s [Compact(%s)]: halt lock held, skipping", db.store.LogPrefix(), db.name)
+		return nil
+	}
+
+	// Read position first as new LTX files can only move it forward.
+	pos := db.Pos()
+
+	// Determine the lowest position still required by a connected replica.
+	replicaTXID := db.store.replicaMinTXID(db.name)
+
+	var infos []LTXFileInfo
+	if err := db.ForEachLTX(func(info LTXFileInfo) error {
+		infos = append(infos, info)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Remove originals left behind if a previous compaction was interrupted.
+	infos, err := db.removeCompactedLTX(infos)
+	if err != nil {
+		return err
+	}
+
+	minN := db.store.CompactionMinFiles
+	if minN < 2 {
+		minN = 2
+	}
+
+	maxSize := db.store.CompactionMaxFileSize
+
+	var run []LTXFileInfo
+	flush := func() error {
+		defer func() { run = run[:0] }()
+		if len(run) < minN {
+			return nil
+		}
+		return db.compactLTX(ctx, run)
+	}
+
+	for _, info := range infos {
+		if info.Snapshot || info.MaxTXID >= pos.TXID || (replicaTXID > 0 && info.MaxTXID > replicaTXID) || (maxSize > 0 && info.Size > maxSize) {
+			if err := flush(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(run) > 0 && run[len(run)-1].MaxTXID+1 != info.MinTXID {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		run = append(run, info)
+	}
+	return flush()
+}
+
+// removeCompactedLTX removes incremental LTX files that are covered by a
+// compacted LTX file and returns the remaining files.
+func (db *DB) removeCompactedLTX(infos []LTXFileInfo) ([]LTXFileInfo, error) {
+	var compacted []LTXFileInfo
+	for _, info := range infos {
+		if !info.Snapshot && info.MaxTXID > info.MinTXID {
+			compacted = append(compacted, info)
+		}
+	}
+
+	other := make([]LTXFileInfo, 0, len(infos))
+	for _, info := range infos {
+		var covered bool
+		for _, c := range compacted {
+			if info != c && !info.Snapshot && info.MinTXID >= c.MinTXID && info.MaxTXID <= c.MaxTXID {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			other = append(other, info)
+			continue
+		}
+
+		filename := ltx.FormatFilename(info.MinTXID, info.MaxTXID)
+		if err := db.store.FS.Remove(filepath.Join(db.LTXDir(), filename)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		db.backedUpLTX.Delete(filename)
+		db.ltxHeaders.Remove(filename)
+	}
+	return other, nil
+}
+
+// compactLTX merges a contiguous run of incremental LTX files into a single
+// LTX file and removes the originals. The pages of every file are held in
+// memory until the merged file is written.
+func (db *DB) compactLTX(ctx context.Context, infos []LTXFileInfo) error {
+	first, last := infos[0], infos[len(infos)-1]
+	path := db.LTXPath(first.MinTXID, last.MaxTXID)
+
+	// Apply each file's pages in order. Later versions of a page replace
+	// earlier ones and truncation drops pages beyond the new commit size.
+	var firstHdr, lastHdr ltx.Header
+	var trailer ltx.Trailer
+	var lastModTime time.Time
+	pages := make(map[uint32][]byte)
+	minTruncate := uint32(math.MaxUint32)
+	for i, info := range infos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		hdr, t, modTime, err := db.readLTXPages(info, pages)
+		if os.IsNotExist(err) {
+			return nil // removed by retention enforcement, retry next time
+		} else if err != nil {
+			return fmt.Errorf("read ltx file %s: %w", ltx.FormatFilename(info.MinTXID, info.MaxTXID), err)
+		}
+
+		if i == 0 {
+			firstHdr = hdr
+		} else if hdr.PageSize != firstHdr.PageSize {
+			return fmt.Errorf("page size changed within ltx run: %d <> %d", firstHdr.PageSize, hdr.PageSize)
+		} else if hdr.Commit < lastHdr.Commit && hdr.Commit < minTruncate {
+			minTruncate = hdr.Commit
+		}
+		lastHdr, trailer, lastModTime = hdr, t, modTime
+	}
+
+	// Pages that were truncated & not rewritten by a later file cannot be
+	// represented in a single LTX file so the run is left as-is.
+	lockPgno := ltx.LockPgno(lastHdr.PageSize)
+	for pgno := minTruncate + 1; minTruncate != math.MaxUint32 && pgno <= lastHdr.Commit; pgno++ {
+		if _, ok := pages[pgno]; !ok && pgno != lockPgno {
+			log.Printf("%s: cannot compact ltx files across database shrink, skipping: db=%s txid=%s-%s",
+				db.store.LogPrefix(), db.name, ltx.FormatTXID(first.MinTXID), ltx.FormatTXID(last.MaxTXID))
+			return nil
+		}
+	}
+
+	// Ensure originals are backed up before they are removed.
+	if db.store.Backup != nil && db.store.RetentionRequireBackup {
+		for _, info := range infos {
+			if err := db.backupLTX(ctx, info.MinTXID, info.MaxTXID); err != nil {
+				return fmt.Errorf("back up ltx file before compaction: %w", err)
+			}
+		}
+	}
+
+	hdr := lastHdr
+	hdr.Flags = db.ltxHeaderFlags()
+	hdr.MinTXID = firstHdr.MinTXID
+	hdr.PreApplyChecksum = firstHdr.PreApplyChecksum
+	if err := db.writeCompactedLTX(path, hdr, trailer.PostApplyChecksum, pages); err != nil {
+		return err
+	}
+
+	// Keep the modification time of the newest original so retention is
+	// enforced as if the files had not been merged.
+	if err := db.store.FS.Chtimes(path, time.Now(), lastModTime); err != nil {
+		return fmt.Errorf("set compacted ltx file time: %w", err)
+	}
+
+	for _, info := range infos {
+		filename := ltx.FormatFilename(info.MinTXID, info.MaxTXID)
+		if err := db.store.FS.Remove(filepath.Join(db.LTXDir(), filename)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		db.backedUpLTX.Delete(filename)
+		db.ltxHeaders.Remove(filename)
+	}
+	dbLTXCompactCountMetricVec.WithLabelValues(db.name).Add(float64(len(infos)))
+
+	log.Printf("%s: compacted %d ltx files: db=%s txid=%s-%s", db.store.LogPrefix(), len(infos), db.name,
+		ltx.FormatTXID(first.MinTXID), ltx.FormatTXID(last.MaxTXID))
+
+	return nil
+}
+
+// readLTXPages reads the pages of an LTX file into pages after removing any
+// pages beyond the file's commit size. Returns the file's header, trailer &
+// modification time.
+func (db *DB) readLTXPages(info LTXFileInfo, pages map[uint32][]byte) (ltx.Header, ltx.Trailer, time.Time, error) {
+	f, err := db.store.FS.Open(db.LTXPath(info.MinTXID, info.MaxTXID))
+	if err != nil {
+		return ltx.Header{}, ltx.Trailer{}, time.Time{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return ltx.Header{}, ltx.Trailer{}, time.Time{}, err
+	}
+
+	dec := ltx.NewDecoder(f)
+	if err := dec.DecodeHeader(); err != nil {
+		return ltx.Header{}, ltx.Trailer{}, time.Time{}, fmt.Errorf("decode header: %w", err)
+	}
+	hdr := dec.Header()
+
+	for pgno := range pages {
+		if pgno > hdr.Commit {
+			delete(pages, pgno)
+		}
+	}
+
+	for i := 0; ; i++ {
+		var pageHeader ltx.PageHeader
+		data := make([]byte, hdr.PageSize)
+		if err := dec.DecodePage(&pageHeader, data); err == io.EOF {
+			break
+		} else if err != nil {
+			return ltx.Header{}, ltx.Trailer{}, time.Time{}, fmt.Errorf("decode page %d: %w", i, err)
+		}
+		pages[pageHeader.Pgno] = data
+	}
+
+	if err := dec.Close(); err != nil {
+		return ltx.Header{}, ltx.Trailer{}, time.Time{}, fmt.Errorf("close decoder: %w", err)
+	}
+	return hdr, dec.Trailer(), fi.ModTime(), nil
+}
+
+// writeCompactedLTX atomically writes pages to an LTX file at path.
+func (db *DB) writeCompactedLTX(path string, hdr ltx.Header, postApplyChecksum uint64, pages map[uint32][]byte) error {
+	pgnos := make([]uint32, 0, len(pages))
+	for pgno := range pages {
+		pgnos = append(pgnos, pgno)
+	}
+	sort.Slice(pgnos, func(i, j int) bool { return pgnos[i] < pgnos[j] })
+
+	tmpPath := path + ".tmp"
+	defer func() { _ = db.store.FS.Remove(tmpPath) }()
+
+	f, err := db.store.FS.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, db.store.FileMode)
+	if err != nil {
+		return fmt.Errorf("cannot create temp ltx file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	enc := ltx.NewEncoder(f)
+	if err := enc.EncodeHeader(hdr); err != nil {
+		return fmt.Errorf("encode ltx header: %w", err)
+	}
+	for _, pgno := range pgnos {
+		if err := enc.EncodePage(ltx.PageHeader{Pgno: pgno}, pages[pgno]); err != nil {
+			return fmt.Errorf("encode ltx page %d: %w", pgno, err)
+		}
+	}
+	enc.SetPostApplyChecksum(postApplyChecksum)
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("close ltx encoder: %w", err)
+	} else if err := f.Sync(); err != nil {
+		return fmt.Errorf("fsync ltx file: %w", err)
+	} else if err := f.Close(); err != nil {
+		return fmt.Errorf("close ltx file: %w", err)
+	}
+
+	if err := db.store.FS.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename ltx file: %w", err)
+	}
+	db.ltxHeaders.Remove(filepath.Base(path))
+
+	if err := db.store.FS.SyncDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("sync ltx dir: %w", err)
+	}
+	return nil
+}
+
+// CompressLTX rewrites each uncompressed LTX file below the current position
+// using LZ4 compression. The LTX file at the current position is left as-is
+// so that it can be applied & streamed without decompression. Readers such
+// as OpenLTX() decompress files transparently.
+func (db *DB) CompressLTX(ctx context.Context) error {
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+
+	// Skip while a HALT lock is held as the remote node expects the LTX files
+	// to remain unchanged until it releases the lock.
+	if db.haltLockAndGuard.Load().(*haltLockAndGuard) != nil || db.HasRemoteHaltLock() {
+		TraceLog.Printf("%s [CompressLTX(%s)]: halt lock held, skipping", db.store.LogPrefix(), db.name)
+		return nil
+	}
+
+	// Read position first as new LTX files can only move it forward.
+	pos := db.Pos()
+
+	var infos []LTXFileInfo
+	if err := db.ForEachLTX(func(info LTXFileInfo) error {
+		infos = append(infos, info)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		if err := ctx.Err(); err != nil {
+			return err
+		} else if info.MaxTXID >= pos.TXID {
+			continue
+		}
+
+		if err := db.compressLTXFile(info); err != nil {
+			return fmt.Errorf("compress ltx file %s: %w", ltx.FormatFilename(info.MinTXID, info.MaxTXID), err)
+		}
+	}
+	return nil
+}
+
+// compressLTXFile atomically replaces an LTX file with an LZ4 compressed copy.
+// Files that are already compressed or that have been removed are skipped.
+func (db *DB) compressLTXFile(info LTXFileInfo) error {
+	path := db.LTXPath(info.MinTXID, info.MaxTXID)
+	f, err := db.store.FS.Open(path)
+	if os.IsNotExist(err) {
+		return nil // removed by retention enforcement
+	} else if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if hdr, _, err := ltx.DecodeHeader(f); err != nil {
+		return fmt.Errorf("decode ltx header: %w", err)
+	} else if hdr.Flags&ltx.HeaderFlagCompressLZ4 != 0 {
+		return nil // already compressed
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek ltx file: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	defer func() { _ = db.store.FS.Remove(tmpPath) }()
+
+	tmp, err := db.store.FS.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, db.store.FileMode)
+	if err != nil {
+		return fmt.Errorf("cannot create temp ltx file: %w", err)
+	}
+	defer func() { _ = tmp.Close() }()
+
+	if err := compressLTX(tmp, f); err != nil {
+		return err
+	} else if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("fsync ltx file: %w", err)
+	}
+
+	tmpInfo, err := tmp.Stat()
+	if err != nil {
+		return err
+	} else if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close ltx file: %w", err)
+	}
+
+	// Keep the modification time of the original so retention is enforced
+	// as if the file had not been rewritten.
+	if err := db.store.FS.Chtimes(tmpPath, time.Now(), fi.ModTime()); err != nil {
+		return fmt.Errorf("set compressed ltx file time: %w", err)
+	}
+
+	if err := db.store.FS.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename ltx file: %w", err)
+	}
+	db.ltxHeaders.Remove(filepath.Base(path))
+
+	if err := db.store.FS.SyncDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("sync ltx dir: %w", err)
+	}
+
+	dbLTXCompressCountMetricVec.WithLabelValues(db.name).Inc()
+	dbLTXCompressSavedBytesMetricVec.WithLabelValues(db.name).Add(float64(fi.Size() - tmpInfo.Size()))
+
+	return nil
+}
+
 // ltxHeaderFlags returns flags used for the LTX header.
 func (db *DB) ltxHeaderFlags() uint32 {
 	var flags uint32
@@ -3150,10 +4842,27 @@ func (db *DB) ltxHeaderFlags() uint32 {
 	return flags
 }
 
+// ltxCompression returns the name of the compression algorithm recorded in
+// the flags of an LTX header.
+func ltxCompression(flags uint32) string {
+	if flags&ltx.HeaderFlagCompressLZ4 != 0 {
+		return "lz4"
+	}
+	return "none"
+}
+
 type dbVarJSON struct {
-	Name     string `json:"name"`
-	TXID     string `json:"txid"`
-	Checksum string `json:"checksum"`
+	Name      string `json:"name"`
+	TXID      string `json:"txid"`
+	Checksum  string `json:"checksum"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Mode      string `json:"mode"`
+	ReadOnly  bool   `json:"readOnly"`
+	TXIDLag   uint64 `json:"txidLag"`
+	Lag       string `json:"lag"`
+
+	NoReplicate bool `json:"noReplicate,omitempty"`
+	Quarantined bool `json:"quarantined,omitempty"`
 
 	Locks struct {
 		Pending  string `json:"pending"`
@@ -3170,11 +4879,14 @@ type dbVarJSON struct {
 		Read4   string `json:"read4"`
 		DMS     string `json:"dms"`
 	} `json:"locks"`
+
+	HaltLock       *HaltLock `json:"haltLock,omitempty"`       // held on behalf of a replica
+	RemoteHaltLock *HaltLock `json:"remoteHaltLock,omitempty"` // held on the primary
 }
 
 // JouralReader represents a reader of the SQLite journal file format.
 type JournalReader struct {
-	f      *os.File
+	f      File
 	fi     os.FileInfo // cached file info
 	offset int64       // read offset
 	frame  []byte      // frame buffer
@@ -3188,7 +4900,7 @@ type JournalReader struct {
 }
 
 // JournalReader returns a new instance of JournalReader.
-func NewJournalReader(f *os.File, pageSize uint32) *JournalReader {
+func NewJournalReader(f File, pageSize uint32) *JournalReader {
 	return &JournalReader{
 		f:        f,
 		pageSize: pageSize,
@@ -3344,9 +5056,15 @@ type HaltLock struct {
 	// Unique identifier for the lock.
 	ID int64 `json:"id"`
 
+	// Node that holds the lock.
+	NodeID uint64 `json:"nodeID,omitempty"`
+
 	// Position of the primary when this lock was acquired.
 	Pos Pos `json:"pos"`
 
+	// Time that the halt lock was acquired at.
+	AcquiredAt *time.Time `json:"acquiredAt,omitempty"`
+
 	// Time that the halt lock expires at.
 	Expires *time.Time `json:"expires"`
 }
@@ -3404,8 +5122,90 @@ var (
 		Help: "Number of LTX files removed by retention.",
 	}, []string{"db"})
 
+	dbLTXCompactCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_db_ltx_compact_count",
+		Help: "Number of LTX files merged by compaction.",
+	}, []string{"db"})
+
+	dbLTXCompressCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_db_ltx_compress_count",
+		Help: "Number of LTX files compressed at rest.",
+	}, []string{"db"})
+
+	dbLTXCompressSavedBytesMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_db_ltx_compress_saved_bytes",
+		Help: "Number of bytes saved by compressing LTX files at rest.",
+	}, []string{"db"})
+
+	dbLTXChainGapCountMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_db_ltx_chain_gap_count",
+		Help: "Number of gaps found in the LTX file chain by the retention safety check.",
+	}, []string{"db"})
+
+	dbReplicaLagTXIDMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_db_replica_lag_txid",
+		Help: "Number of transactions received from the primary but not yet applied.",
+	}, []string{"db"})
+
+	dbReplicationLagSecondsMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "litefs_db_replication_lag_seconds",
+		Help: "Time between the latest transaction received from the primary and the last applied transaction.",
+	}, []string{"db"})
+
 	dbLatencySecondsMetricVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "litefs_db_latency_seconds",
 		Help: "Latency between generating an LTX file and consuming it.",
 	}, []string{"db"})
+
+	dbWriteBytesTotalMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_db_write_bytes_total",
+		Help: "Total number of LTX bytes committed or applied to the database.",
+	}, []string{"db"})
+
+	dbTxTotalMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_db_tx_total",
+		Help: "Total number of transactions committed or applied to the database.",
+	}, []string{"db"})
+
+	dbLTXApplyDurationSecondsMetricVec = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "litefs_ltx_apply_duration_seconds",
+		Help:    "Time to apply an LTX file to the database.",
+		Buckets: []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+	}, []string{"db"})
+
+	dbLTXStreamLagSecondsMetricVec = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "litefs_ltx_stream_lag_seconds",
+		Help:    "Time between an LTX file being created on the primary & applied on the replica.",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 60},
+	}, []string{"db"})
+
+	dbHaltAcquireSecondsMetricVec = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "litefs_halt_acquire_seconds",
+		Help:    "Time to acquire a remote HALT lock & catch up to the primary's position.",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10},
+	}, []string{"db"})
+
+	dbHaltExpireCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_halt_expire_count",
+		Help: "Number of HALT locks released after expiring.",
+	}, []string{"db"})
+
+	dbRepairCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_db_repair_count",
+		Help: "Number of database files rebuilt from LTX files on open.",
+	}, []string{"db"})
 )
+
+// deleteDBWriteMetrics removes the cumulative write, apply & halt metrics for a
+// database so they restart from zero if a database with the same name is created.
+func deleteDBWriteMetrics(name string) {
+	dbWriteBytesTotalMetricVec.DeleteLabelValues(name)
+	dbTxTotalMetricVec.DeleteLabelValues(name)
+	dbLTXApplyDurationSecondsMetricVec.DeleteLabelValues(name)
+	dbLTXStreamLagSecondsMetricVec.DeleteLabelValues(name)
+	dbHaltAcquireSecondsMetricVec.DeleteLabelValues(name)
+	dbHaltExpireCountMetricVec.DeleteLabelValues(name)
+	dbRepairCountMetricVec.DeleteLabelValues(name)
+	dbLTXCompressCountMetricVec.DeleteLabelValues(name)
+	dbLTXCompressSavedBytesMetricVec.DeleteLabelValues(name)
+}
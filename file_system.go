@@ -0,0 +1,176 @@
+package litefs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/superfly/litefs/internal"
+)
+
+// File represents an open file on a FileSystem. It is implemented by *os.File.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+var _ File = (*os.File)(nil)
+
+// FileSystem represents the storage backing the store's data directory. It
+// contains the subset of operations the store uses so alternate backends,
+// such as an in-memory file system, can be used in place of the OS.
+//
+// Errors for missing or existing files must be compatible with
+// os.IsNotExist() & os.IsExist(), such as by returning an *fs.PathError.
+type FileSystem interface {
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	RemoveAll(path string) error
+	Mkdir(name string, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+	ReadDir(name string) ([]os.DirEntry, error)
+	Stat(name string) (os.FileInfo, error)
+	Chtimes(name string, atime, mtime time.Time) error
+
+	// SyncDir flushes directory entries to durable storage. Backends that
+	// cannot sync directories should return nil.
+	SyncDir(name string) error
+}
+
+var _ FileSystem = OSFileSystem{}
+
+// OSFileSystem is a FileSystem that uses the host's file system.
+type OSFileSystem struct{}
+
+func (OSFileSystem) Open(name string) (File, error) { return openOSFile(os.Open(name)) }
+
+func (OSFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return openOSFile(os.OpenFile(name, flag, perm))
+}
+
+func (OSFileSystem) Rename(oldpath, newpath string) error         { return os.Rename(oldpath, newpath) }
+func (OSFileSystem) Remove(name string) error                     { return os.Remove(name) }
+func (OSFileSystem) RemoveAll(path string) error                  { return os.RemoveAll(path) }
+func (OSFileSystem) Mkdir(name string, perm os.FileMode) error    { return os.Mkdir(name, perm) }
+func (OSFileSystem) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+func (OSFileSystem) ReadDir(name string) ([]os.DirEntry, error)   { return os.ReadDir(name) }
+func (OSFileSystem) Stat(name string) (os.FileInfo, error)        { return os.Stat(name) }
+
+func (OSFileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (OSFileSystem) SyncDir(name string) error { return internal.Sync(name) }
+
+// openOSFile converts the result of opening an *os.File so that a failed
+// open returns a nil interface instead of a typed nil.
+func openOSFile(f *os.File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// readFile reads the entire contents of the named file.
+func readFile(fsys FileSystem, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	return io.ReadAll(f)
+}
+
+// writeFile writes data to the named file, creating it with perm if needed.
+func writeFile(fsys FileSystem, name string, data []byte, perm os.FileMode) error {
+	f, err := fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// truncateFile changes the size of the named file.
+func truncateFile(fsys FileSystem, name string, size int64) error {
+	f, err := fsys.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(size); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// createTemp creates a new, uniquely named file in dir. The last "*" in
+// pattern is replaced by a random string, similar to os.CreateTemp().
+func createTemp(fsys FileSystem, dir, pattern string, perm os.FileMode) (File, error) {
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+
+	for i := 0; ; i++ {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10)+suffix)
+		f, err := fsys.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) && i < 10000 {
+			continue
+		}
+		return f, err
+	}
+}
+
+// walkFiles calls fn for every regular file under root. Files removed during
+// the walk are skipped.
+func walkFiles(fsys FileSystem, root string, fn func(path string, fi os.FileInfo) error) error {
+	ents, err := fsys.ReadDir(root)
+	if err != nil {
+		return err
+	}
+
+	for _, ent := range ents {
+		path := filepath.Join(root, ent.Name())
+		if ent.IsDir() {
+			if err := walkFiles(fsys, path, fn); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			continue
+		} else if !ent.Type().IsRegular() {
+			continue
+		}
+
+		fi, err := ent.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := fn(path, fi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
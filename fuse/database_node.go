@@ -36,18 +36,14 @@ func newDatabaseNode(fsys *FileSystem, db *litefs.DB) *DatabaseNode {
 }
 
 func (n *DatabaseNode) Attr(ctx context.Context, attr *fuse.Attr) error {
-	fi, err := os.Stat(n.db.DatabasePath())
+	fi, err := n.db.Store().FS.Stat(n.db.DatabasePath())
 	if os.IsNotExist(err) {
 		return syscall.ENOENT
 	} else if err != nil {
 		return err
 	}
 
-	if n.db.Store().IsPrimary() {
-		attr.Mode = 0666
-	} else {
-		attr.Mode = 0444
-	}
+	attr.Mode = n.fsys.fileMode(n.db.Store().IsPrimary() && !n.db.ReadOnly())
 
 	attr.Size = uint64(fi.Size())
 	attr.Uid = uint32(n.fsys.Uid)
@@ -113,10 +109,10 @@ var _ fs.HandlePOSIXLocker = (*DatabaseHandle)(nil)
 // DatabaseHandle represents a file handle to a SQLite database file.
 type DatabaseHandle struct {
 	node *DatabaseNode
-	file *os.File
+	file litefs.File
 }
 
-func newDatabaseHandle(node *DatabaseNode, file *os.File) *DatabaseHandle {
+func newDatabaseHandle(node *DatabaseNode, file litefs.File) *DatabaseHandle {
 	return &DatabaseHandle{
 		node: node,
 		file: file,
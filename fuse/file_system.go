@@ -63,7 +63,7 @@ func (fsys *FileSystem) Mount() (err error) {
 	_ = fuse.Unmount(fsys.path)
 
 	// Ensure mount directory exists before trying to mount to it.
-	if err := os.MkdirAll(fsys.path, 0777); err != nil {
+	if err := os.MkdirAll(fsys.path, fsys.store.DirMode); err != nil {
 		return err
 	}
 
@@ -193,6 +193,26 @@ func (fsys *FileSystem) InvalidateEntry(name string) error {
 	return nil
 }
 
+// fileMode returns the permissions reported for files on the mount based on
+// the store's FileMode. Write permissions are removed if writable is false.
+func (fsys *FileSystem) fileMode(writable bool) os.FileMode {
+	mode := fsys.store.FileMode.Perm()
+	if !writable {
+		mode &^= 0222
+	}
+	return mode
+}
+
+// dirMode returns the permissions reported for the root directory based on
+// the store's DirMode. Write permissions are removed if writable is false.
+func (fsys *FileSystem) dirMode(writable bool) os.FileMode {
+	mode := fsys.store.DirMode.Perm()
+	if !writable {
+		mode &^= 0222
+	}
+	return os.ModeDir | mode
+}
+
 // debugFn is called by the underlying FUSE library when debug logging is enabled.
 func (fsys *FileSystem) debugFn(msg any) {
 	status := "r"
@@ -349,6 +349,34 @@ func TestFileSystem_ReadOnly(t *testing.T) {
 	}
 }
 
+// Ensure files & directories use the store's permissions on disk & on the mount.
+func TestFileSystem_FileMode(t *testing.T) {
+	fs := newOpenFileSystem(t, t.TempDir(), litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202"))
+	fs.Store().FileMode, fs.Store().DirMode = 0640, 0750
+	dsn := filepath.Join(fs.Path(), "db")
+
+	db := testingutil.OpenSQLDB(t, dsn)
+	if _, err := db.Exec(`CREATE TABLE t (x)`); err != nil {
+		t.Fatal(err)
+	}
+
+	if fi, err := os.Stat(dsn); err != nil {
+		t.Fatal(err)
+	} else if got, want := fi.Mode().Perm(), os.FileMode(0640); got != want {
+		t.Fatalf("mount file mode=%s, want %s", got, want)
+	}
+	if fi, err := os.Stat(fs.Path()); err != nil {
+		t.Fatal(err)
+	} else if got, want := fi.Mode().Perm(), os.FileMode(0750); got != want {
+		t.Fatalf("mount dir mode=%s, want %s", got, want)
+	}
+	if fi, err := os.Stat(fs.Store().DB("db").DatabasePath()); err != nil {
+		t.Fatal(err)
+	} else if got, want := fi.Mode().Perm(), os.FileMode(0640); got != want {
+		t.Fatalf("database file mode=%s, want %s", got, want)
+	}
+}
+
 func TestFileSystem_ReadDir(t *testing.T) {
 	fs := newOpenFileSystem(t, t.TempDir(), litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202"))
 	db0 := testingutil.OpenSQLDB(t, filepath.Join(fs.Path(), "db0"))
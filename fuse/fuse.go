@@ -50,8 +50,10 @@ func ParseFilename(name string) (dbName string, fileType litefs.FileType) {
 func ToError(err error) error {
 	if os.IsNotExist(err) {
 		return &Error{err: err, errno: fuse.ToErrno(syscall.ENOENT)}
-	} else if err == litefs.ErrReadOnlyReplica {
+	} else if err == litefs.ErrReadOnlyReplica || err == litefs.ErrReadOnlyDatabase || err == litefs.ErrReadOnly {
 		return &Error{err: err, errno: fuse.ToErrno(syscall.EACCES)}
+	} else if err == litefs.ErrTooManyDatabases {
+		return &Error{err: err, errno: fuse.ToErrno(syscall.EDQUOT)}
 	}
 	return err
 }
@@ -32,14 +32,14 @@ func newJournalNode(fsys *FileSystem, db *litefs.DB) *JournalNode {
 }
 
 func (n *JournalNode) Attr(ctx context.Context, attr *fuse.Attr) error {
-	fi, err := os.Stat(n.db.JournalPath())
+	fi, err := n.db.Store().FS.Stat(n.db.JournalPath())
 	if os.IsNotExist(err) {
 		return syscall.ENOENT
 	} else if err != nil {
 		return err
 	}
 
-	attr.Mode = 0666
+	attr.Mode = n.fsys.fileMode(true)
 	attr.Size = uint64(fi.Size())
 	attr.Uid = uint32(n.fsys.Uid)
 	attr.Gid = uint32(n.fsys.Gid)
@@ -111,10 +111,10 @@ var _ fs.HandleWriter = (*JournalHandle)(nil)
 // JournalHandle represents a file handle to a SQLite journal file.
 type JournalHandle struct {
 	node *JournalNode
-	file *os.File
+	file litefs.File
 }
 
-func newJournalHandle(node *JournalNode, file *os.File) *JournalHandle {
+func newJournalHandle(node *JournalNode, file litefs.File) *JournalHandle {
 	return &JournalHandle{node: node, file: file}
 }
 
@@ -34,7 +34,7 @@ func newPosNode(fsys *FileSystem, db *litefs.DB) *PosNode {
 }
 
 func (n *PosNode) Attr(ctx context.Context, attr *fuse.Attr) error {
-	attr.Mode = 0666
+	attr.Mode = n.fsys.fileMode(true)
 	attr.Size = uint64(PosFileSize)
 	attr.Uid = uint32(n.fsys.Uid)
 	attr.Gid = uint32(n.fsys.Gid)
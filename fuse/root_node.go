@@ -54,11 +54,7 @@ func (n *RootNode) Node(name string) fs.Node {
 func (n *RootNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 	attr.Inode = RootInode
 
-	if n.fsys.store.IsPrimary() {
-		attr.Mode = os.ModeDir | 0777
-	} else {
-		attr.Mode = os.ModeDir | 0555
-	}
+	attr.Mode = n.fsys.dirMode(n.fsys.store.IsPrimary())
 
 	attr.Uid = uint32(n.fsys.Uid)
 	attr.Gid = uint32(n.fsys.Gid)
@@ -113,7 +109,7 @@ func (n *RootNode) lookupDBNode(ctx context.Context, name string) (fs.Node, erro
 		return newDatabaseNode(n.fsys, db), nil
 
 	case litefs.FileTypeJournal:
-		if _, err := os.Stat(db.JournalPath()); os.IsNotExist(err) {
+		if _, err := n.fsys.store.FS.Stat(db.JournalPath()); os.IsNotExist(err) {
 			return nil, syscall.ENOENT
 		} else if err != nil {
 			return nil, err
@@ -121,7 +117,7 @@ func (n *RootNode) lookupDBNode(ctx context.Context, name string) (fs.Node, erro
 		return newJournalNode(n.fsys, db), nil
 
 	case litefs.FileTypeWAL:
-		if _, err := os.Stat(db.WALPath()); os.IsNotExist(err) {
+		if _, err := n.fsys.store.FS.Stat(db.WALPath()); os.IsNotExist(err) {
 			return nil, syscall.ENOENT
 		} else if err != nil {
 			return nil, err
@@ -129,7 +125,7 @@ func (n *RootNode) lookupDBNode(ctx context.Context, name string) (fs.Node, erro
 		return newWALNode(n.fsys, db), nil
 
 	case litefs.FileTypeSHM:
-		if _, err := os.Stat(db.SHMPath()); os.IsNotExist(err) {
+		if _, err := n.fsys.store.FS.Stat(db.SHMPath()); os.IsNotExist(err) {
 			return nil, syscall.ENOENT
 		} else if err != nil {
 			return nil, err
@@ -265,6 +261,8 @@ func (n *RootNode) Remove(ctx context.Context, req *fuse.RemoveRequest) (err err
 		// Only allow deletion from the primary itself.
 		if !n.fsys.store.IsPrimary() {
 			return ToError(litefs.ErrReadOnlyReplica)
+		} else if db := n.fsys.store.DB(dbName); db != nil && db.ReadOnly() {
+			return ToError(litefs.ErrReadOnlyDatabase)
 		}
 
 		if err := n.fsys.store.DropDB(ctx, dbName); err == litefs.ErrDatabaseNotFound {
@@ -386,19 +384,19 @@ func (h *RootHandle) ReadDirAll(ctx context.Context) (ents []fuse.Dirent, err er
 			Type: fuse.DT_File,
 		})
 
-		if _, err := os.Stat(db.JournalPath()); err == nil {
+		if _, err := h.node.fsys.store.FS.Stat(db.JournalPath()); err == nil {
 			ents = append(ents, fuse.Dirent{
 				Name: fmt.Sprintf("%s-journal", db.Name()),
 				Type: fuse.DT_File,
 			})
 		}
-		if _, err := os.Stat(db.SHMPath()); err == nil {
+		if _, err := h.node.fsys.store.FS.Stat(db.SHMPath()); err == nil {
 			ents = append(ents, fuse.Dirent{
 				Name: fmt.Sprintf("%s-shm", db.Name()),
 				Type: fuse.DT_File,
 			})
 		}
-		if _, err := os.Stat(db.WALPath()); err == nil {
+		if _, err := h.node.fsys.store.FS.Stat(db.WALPath()); err == nil {
 			ents = append(ents, fuse.Dirent{
 				Name: fmt.Sprintf("%s-wal", db.Name()),
 				Type: fuse.DT_File,
@@ -36,14 +36,14 @@ func newSHMNode(fsys *FileSystem, db *litefs.DB) *SHMNode {
 }
 
 func (n *SHMNode) Attr(ctx context.Context, attr *fuse.Attr) error {
-	fi, err := os.Stat(n.db.SHMPath())
+	fi, err := n.db.Store().FS.Stat(n.db.SHMPath())
 	if os.IsNotExist(err) {
 		return syscall.ENOENT
 	} else if err != nil {
 		return err
 	}
 
-	attr.Mode = 0666
+	attr.Mode = n.fsys.fileMode(true)
 	attr.Size = uint64(fi.Size())
 	attr.Uid = uint32(n.fsys.Uid)
 	attr.Gid = uint32(n.fsys.Gid)
@@ -108,10 +108,10 @@ var _ fs.HandlePOSIXLocker = (*SHMHandle)(nil)
 // SHMHandle represents a file handle to a SQLite database file.
 type SHMHandle struct {
 	node *SHMNode
-	file *os.File
+	file litefs.File
 }
 
-func newSHMHandle(node *SHMNode, file *os.File) *SHMHandle {
+func newSHMHandle(node *SHMNode, file litefs.File) *SHMHandle {
 	return &SHMHandle{node: node, file: file}
 }
 
@@ -36,14 +36,14 @@ func newWALNode(fsys *FileSystem, db *litefs.DB) *WALNode {
 }
 
 func (n *WALNode) Attr(ctx context.Context, attr *fuse.Attr) error {
-	fi, err := os.Stat(n.db.WALPath())
+	fi, err := n.db.Store().FS.Stat(n.db.WALPath())
 	if os.IsNotExist(err) {
 		return syscall.ENOENT
 	} else if err != nil {
 		return err
 	}
 
-	attr.Mode = 0666
+	attr.Mode = n.fsys.fileMode(true)
 	attr.Size = uint64(fi.Size())
 	attr.Uid = uint32(n.fsys.Uid)
 	attr.Gid = uint32(n.fsys.Gid)
@@ -107,10 +107,10 @@ var _ fs.HandleWriter = (*WALHandle)(nil)
 // WALHandle represents a file handle to a SQLite WAL file.
 type WALHandle struct {
 	node *WALNode
-	file *os.File
+	file litefs.File
 }
 
-func newWALHandle(node *WALNode, file *os.File) *WALHandle {
+func newWALHandle(node *WALNode, file litefs.File) *WALHandle {
 	return &WALHandle{node: node, file: file}
 }
 
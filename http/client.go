@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,6 +13,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/superfly/litefs"
 	"github.com/superfly/litefs/internal/chunk"
@@ -24,31 +26,170 @@ var _ litefs.Client = (*Client)(nil)
 type Client struct {
 	// Underlying HTTP client
 	HTTPClient *http.Client
+
+	// TLS configuration used when connecting to "https" URLs. This is read on
+	// every new connection so it can be set after the client is created.
+	TLSConfig *tls.Config
 }
 
 // NewClient returns an instance of Client.
 func NewClient() *Client {
-	return &Client{
-		HTTPClient: &http.Client{
-			Transport: &http2.Transport{
+	c := &Client{}
+	c.HTTPClient = &http.Client{
+		Transport: &transport{
+			h2c: &http2.Transport{
 				AllowHTTP: true,
 				DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
-					return net.Dial(network, addr) // h2c-only right now
+					return net.Dial(network, addr) // h2c for "http" URLs
 				},
 			},
+			h2: &http2.Transport{
+				DialTLSContext: c.dialTLS,
+			},
+			unix: &http2.Transport{
+				AllowHTTP:      true,
+				DialTLSContext: dialUnix,
+			},
+		},
+	}
+	return c
+}
+
+// dialTLS connects to addr using the client's TLS config, if set.
+func (c *Client) dialTLS(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+	dialer := &tls.Dialer{Config: c.tlsConfig(cfg)}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// tlsConfig returns the client's TLS config, if set, using the server name
+// & protocols from the transport's default config cfg. Otherwise returns cfg.
+func (c *Client) tlsConfig(cfg *tls.Config) *tls.Config {
+	if c.TLSConfig == nil {
+		return cfg
+	}
+
+	serverName := cfg.ServerName
+	cfg = c.TLSConfig.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = serverName
+	}
+	cfg.NextProtos = []string{http2.NextProtoTLS}
+	return cfg
+}
+
+// transport routes "https" requests over TLS, "unix" requests over h2c on a
+// unix domain socket, and all others over h2c.
+type transport struct {
+	h2c  *http2.Transport
+	h2   *http2.Transport
+	unix *http2.Transport
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.URL.Scheme {
+	case "https":
+		return t.h2.RoundTrip(req)
+	case "unix":
+		req = req.Clone(req.Context())
+		req.URL.Scheme = "http"
+		return t.unix.RoundTrip(req)
+	default:
+		return t.h2c.RoundTrip(req)
+	}
+}
+
+// dialUnix connects to the unix domain socket encoded in the host of addr.
+func dialUnix(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	path, err := hex.DecodeString(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unix socket host: %q", host)
+	}
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "unix", string(path))
+}
+
+// parseURL parses & validates the base URL of a LiteFS server. Only the scheme
+// & host should be used from the returned URL. For "unix" URLs, the socket
+// path is hex-encoded into the host so that connections are pooled by socket.
+func parseURL(rawurl string) (*url.URL, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("URL host required")
+		}
+		return u, nil
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("URL socket path required")
+		}
+		return &url.URL{Scheme: u.Scheme, Host: hex.EncodeToString([]byte(u.Path))}, nil
+	default:
+		return nil, fmt.Errorf("invalid URL scheme")
+	}
+}
+
+var _ litefs.Client = (*UnixClient)(nil)
+
+// UnixClient is a Client that sends every request over the unix domain socket
+// at Path instead of connecting to the host in the server URL. This allows a
+// local sidecar to forward replication traffic to other nodes. Requests to
+// "https" URLs are encrypted over the socket using the client's TLS config.
+type UnixClient struct {
+	*Client
+
+	// Path to the unix domain socket.
+	Path string
+}
+
+// NewUnixClient returns an instance of UnixClient that connects to path.
+func NewUnixClient(path string) *UnixClient {
+	c := &UnixClient{Client: NewClient(), Path: path}
+	c.HTTPClient = &http.Client{
+		Transport: &transport{
+			h2c:  &http2.Transport{AllowHTTP: true, DialTLSContext: c.dial},
+			h2:   &http2.Transport{DialTLSContext: c.dialTLS},
+			unix: &http2.Transport{AllowHTTP: true, DialTLSContext: c.dial},
 		},
 	}
+	return c
+}
+
+// dial connects to the client's socket, regardless of addr.
+func (c *UnixClient) dial(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "unix", c.Path)
+}
+
+// dialTLS performs a TLS handshake over a connection to the client's socket.
+func (c *UnixClient) dialTLS(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+	conn, err := c.dial(ctx, network, addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, c.tlsConfig(cfg))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
 }
 
 // Import creates or replaces a SQLite database on the remote LiteFS server.
 func (c *Client) Import(ctx context.Context, primaryURL, name string, r io.Reader) error {
-	u, err := url.Parse(primaryURL)
+	u, err := parseURL(primaryURL)
 	if err != nil {
-		return fmt.Errorf("invalid client URL: %w", err)
-	} else if u.Scheme != "http" && u.Scheme != "https" {
-		return fmt.Errorf("invalid URL scheme")
-	} else if u.Host == "" {
-		return fmt.Errorf("URL host required")
+		return err
 	}
 
 	// Strip off everything but the scheme/host & add name to query params.
@@ -79,26 +220,25 @@ func (c *Client) Import(ctx context.Context, primaryURL, name string, r io.Reade
 	return nil
 }
 
-// Export downloads a SQLite database from the remote LiteFS server.
+// Export downloads a SQLite database from the remote LiteFS server. The
+// database is encoded with codec, which is not decoded by the client.
 // Returned reader must be closed by caller.
-func (c *Client) Export(ctx context.Context, primaryURL, name string) (io.ReadCloser, error) {
-	u, err := url.Parse(primaryURL)
+func (c *Client) Export(ctx context.Context, primaryURL, name string, codec litefs.ExportCodec) (io.ReadCloser, error) {
+	u, err := parseURL(primaryURL)
 	if err != nil {
-		return nil, fmt.Errorf("invalid client URL: %w", err)
-	} else if u.Scheme != "http" && u.Scheme != "https" {
-		return nil, fmt.Errorf("invalid URL scheme")
-	} else if u.Host == "" {
-		return nil, fmt.Errorf("URL host required")
+		return nil, err
 	}
 
 	// Strip off everything but the scheme/host & add name to query params.
+	q := url.Values{"name": {name}}
+	if codec != litefs.ExportCodecNone {
+		q.Set("codec", codec.String())
+	}
 	*u = url.URL{
-		Scheme: u.Scheme,
-		Host:   u.Host,
-		Path:   "/export",
-		RawQuery: (url.Values{
-			"name": {name},
-		}).Encode(),
+		Scheme:   u.Scheme,
+		Host:     u.Host,
+		Path:     "/export",
+		RawQuery: q.Encode(),
 	}
 
 	req, err := http.NewRequest("GET", u.String(), nil)
@@ -124,14 +264,58 @@ func (c *Client) Export(ctx context.Context, primaryURL, name string) (io.ReadCl
 	}
 }
 
+// Verify checks the integrity of a database on the remote LiteFS server
+// against its LTX files. If checksumOnly is true, only the checksum of the
+// database is verified against its current position. Returns an error
+// wrapping ErrVerifyFailed if the database does not match.
+func (c *Client) Verify(ctx context.Context, rawurl, name string, checksumOnly bool) error {
+	u, err := parseURL(rawurl)
+	if err != nil {
+		return err
+	}
+
+	// Strip off everything but the scheme/host & add name to query params.
+	q := url.Values{"name": {name}}
+	if checksumOnly {
+		q.Set("checksum-only", "true")
+	}
+	*u = url.URL{
+		Scheme:   u.Scheme,
+		Host:     u.Host,
+		Path:     "/verify",
+		RawQuery: q.Encode(),
+	}
+
+	req, err := http.NewRequest("POST", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return litefs.ErrDatabaseNotFound
+	case http.StatusConflict:
+		body, _ := io.ReadAll(resp.Body)
+		msg := strings.TrimPrefix(strings.TrimSpace(string(body)), litefs.ErrVerifyFailed.Error()+": ")
+		return fmt.Errorf("%w: %s", litefs.ErrVerifyFailed, msg)
+	default:
+		return fmt.Errorf("invalid response: code=%d", resp.StatusCode)
+	}
+}
+
 func (c *Client) AcquireHaltLock(ctx context.Context, primaryURL string, nodeID uint64, name string, lockID int64) (_ *litefs.HaltLock, retErr error) {
-	u, err := url.Parse(primaryURL)
+	u, err := parseURL(primaryURL)
 	if err != nil {
-		return nil, fmt.Errorf("invalid primary URL: %w", err)
-	} else if u.Scheme != "http" && u.Scheme != "https" {
-		return nil, fmt.Errorf("invalid URL scheme")
-	} else if u.Host == "" {
-		return nil, fmt.Errorf("URL host required")
+		return nil, err
 	}
 
 	// Strip off everything but the scheme & host.
@@ -171,13 +355,9 @@ func (c *Client) AcquireHaltLock(ctx context.Context, primaryURL string, nodeID
 }
 
 func (c *Client) ReleaseHaltLock(ctx context.Context, primaryURL string, nodeID uint64, name string, lockID int64) error {
-	u, err := url.Parse(primaryURL)
+	u, err := parseURL(primaryURL)
 	if err != nil {
-		return fmt.Errorf("invalid primary URL: %w", err)
-	} else if u.Scheme != "http" && u.Scheme != "https" {
-		return fmt.Errorf("invalid URL scheme")
-	} else if u.Host == "" {
-		return fmt.Errorf("URL host required")
+		return err
 	}
 
 	// Strip off everything but the scheme & host.
@@ -212,13 +392,9 @@ func (c *Client) ReleaseHaltLock(ctx context.Context, primaryURL string, nodeID
 }
 
 func (c *Client) Commit(ctx context.Context, primaryURL string, nodeID uint64, name string, lockID int64, r io.Reader) error {
-	u, err := url.Parse(primaryURL)
+	u, err := parseURL(primaryURL)
 	if err != nil {
-		return fmt.Errorf("invalid primary URL: %w", err)
-	} else if u.Scheme != "http" && u.Scheme != "https" {
-		return fmt.Errorf("invalid URL scheme")
-	} else if u.Host == "" {
-		return fmt.Errorf("URL host required")
+		return err
 	}
 
 	// Strip off everything but the scheme & host.
@@ -253,14 +429,10 @@ func (c *Client) Commit(ctx context.Context, primaryURL string, nodeID uint64, n
 }
 
 // Stream returns a snapshot and continuous stream of WAL updates.
-func (c *Client) Stream(ctx context.Context, primaryURL string, nodeID uint64, posMap map[string]litefs.Pos) (io.ReadCloser, error) {
-	u, err := url.Parse(primaryURL)
+func (c *Client) Stream(ctx context.Context, primaryURL string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+	u, err := parseURL(primaryURL)
 	if err != nil {
-		return nil, fmt.Errorf("invalid client URL: %w", err)
-	} else if u.Scheme != "http" && u.Scheme != "https" {
-		return nil, fmt.Errorf("invalid URL scheme")
-	} else if u.Host == "" {
-		return nil, fmt.Errorf("URL host required")
+		return nil, err
 	}
 
 	// Strip off everything but the scheme & host.
@@ -270,11 +442,32 @@ func (c *Client) Stream(ctx context.Context, primaryURL string, nodeID uint64, p
 		Path:   "/stream",
 	}
 
+	// Database filter & capabilities are passed as query parameters. Older
+	// primaries ignore them.
+	q := make(url.Values)
+	for _, prefix := range opts.Prefixes {
+		q.Add("prefix", prefix)
+	}
+	if opts.Handoff {
+		q.Set("handoff", "true")
+	}
+	if opts.Heartbeat {
+		q.Set("heartbeat", "true")
+	}
+	u.RawQuery = q.Encode()
+
 	var buf bytes.Buffer
 	if err := WritePosMapTo(&buf, posMap); err != nil {
 		return nil, fmt.Errorf("cannot write pos map: %w", err)
 	}
 
+	// Snapshot requests follow the pos map. Older primaries ignore them.
+	for _, name := range opts.Snapshots {
+		if err := litefs.WriteStreamFrame(&buf, &litefs.SnapshotRequestStreamFrame{Name: name}); err != nil {
+			return nil, fmt.Errorf("cannot write snapshot request: %w", err)
+		}
+	}
+
 	req, err := http.NewRequest("POST", u.String(), &buf)
 	if err != nil {
 		return nil, err
@@ -282,10 +475,19 @@ func (c *Client) Stream(ctx context.Context, primaryURL string, nodeID uint64, p
 	req = req.WithContext(ctx)
 
 	req.Header.Set("Litefs-Id", litefs.FormatNodeID(nodeID))
+	if opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.Token)
+	}
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return nil, err
+	} else if resp.StatusCode == http.StatusUnauthorized {
+		_ = resp.Body.Close()
+		return nil, litefs.ErrReplicationAuth
+	} else if resp.StatusCode == http.StatusConflict {
+		_ = resp.Body.Close()
+		return nil, litefs.ErrDuplicateNodeID
 	} else if resp.StatusCode != http.StatusOK {
 		_ = resp.Body.Close()
 		return nil, fmt.Errorf("invalid response: code=%d", resp.StatusCode)
@@ -293,6 +495,292 @@ func (c *Client) Stream(ctx context.Context, primaryURL string, nodeID uint64, p
 	return resp.Body, nil
 }
 
+// Ack sends the replica's applied positions to the primary.
+func (c *Client) Ack(ctx context.Context, primaryURL string, nodeID uint64, posMap map[string]litefs.Pos, token string) error {
+	u, err := parseURL(primaryURL)
+	if err != nil {
+		return err
+	}
+
+	// Strip off everything but the scheme & host.
+	*u = url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   "/ack",
+	}
+
+	var buf bytes.Buffer
+	if err := WritePosMapTo(&buf, posMap); err != nil {
+		return fmt.Errorf("cannot write pos map: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", u.String(), &buf)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Litefs-Id", litefs.FormatNodeID(nodeID))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return litefs.ErrReplicationAuth
+	default:
+		return fmt.Errorf("invalid response: code=%d", resp.StatusCode)
+	}
+}
+
+// Preempt asks the primary to hand off its lease to a higher weight replica.
+func (c *Client) Preempt(ctx context.Context, primaryURL string, nodeID uint64, weight int, token string) error {
+	u, err := parseURL(primaryURL)
+	if err != nil {
+		return err
+	}
+
+	// Strip off everything but the scheme & host.
+	*u = url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   "/preempt",
+		RawQuery: (url.Values{
+			"weight": {strconv.Itoa(weight)},
+		}).Encode(),
+	}
+
+	req, err := http.NewRequest("POST", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Litefs-Id", litefs.FormatNodeID(nodeID))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return litefs.ErrReplicationAuth
+	case http.StatusConflict:
+		return litefs.ErrPreemptRejected
+	default:
+		return fmt.Errorf("invalid response: code=%d", resp.StatusCode)
+	}
+}
+
+// Info returns the current state of the LiteFS server.
+func (c *Client) Info(ctx context.Context, rawurl string) (*litefs.NodeInfo, error) {
+	u, err := parseURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	// Strip off everything but the scheme & host.
+	*u = url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   "/info",
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("invalid response: code=%d", resp.StatusCode)
+	}
+
+	var info litefs.NodeInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("decode node info: %w", err)
+	}
+	return &info, nil
+}
+
+// PosMap returns the current position of each database on the LiteFS server.
+func (c *Client) PosMap(ctx context.Context, rawurl string) (map[string]litefs.Pos, error) {
+	u, err := parseURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	// Strip off everything but the scheme & host.
+	*u = url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   "/pos",
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("invalid response: code=%d", resp.StatusCode)
+	}
+
+	var m map[string]litefs.Pos
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("decode pos map: %w", err)
+	}
+	return m, nil
+}
+
+// Handoff asks the primary to hand off its lease to the replica nodeID.
+func (c *Client) Handoff(ctx context.Context, primaryURL string, nodeID uint64, token string) error {
+	u, err := parseURL(primaryURL)
+	if err != nil {
+		return err
+	}
+
+	// Strip off everything but the scheme & host.
+	*u = url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   "/handoff",
+	}
+
+	req, err := http.NewRequest("POST", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Litefs-Id", litefs.FormatNodeID(nodeID))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return litefs.ErrReplicationAuth
+	case http.StatusServiceUnavailable:
+		return litefs.ErrNotPrimary
+	case http.StatusPreconditionFailed:
+		return litefs.ErrReplicaLagging
+	default:
+		return fmt.Errorf("invalid response: code=%d", resp.StatusCode)
+	}
+}
+
+// Promote asks the LiteFS server to become the primary. Blocks until the
+// server is primary or ctx is done. Returns ErrNotCandidate if the server
+// is not eligible to become primary or ErrReplicaLagging if it is too far
+// behind the current primary.
+func (c *Client) Promote(ctx context.Context, rawurl string) error {
+	u, err := parseURL(rawurl)
+	if err != nil {
+		return err
+	}
+
+	// Strip off everything but the scheme & host.
+	*u = url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   "/promote",
+	}
+
+	req, err := http.NewRequest("POST", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusConflict:
+		return litefs.ErrNotCandidate
+	case http.StatusPreconditionFailed:
+		return litefs.ErrReplicaLagging
+	default:
+		return fmt.Errorf("invalid response: code=%d", resp.StatusCode)
+	}
+}
+
+// Demote asks the LiteFS server to release its primary lease, if it has one.
+func (c *Client) Demote(ctx context.Context, rawurl string) error {
+	u, err := parseURL(rawurl)
+	if err != nil {
+		return err
+	}
+
+	// Strip off everything but the scheme & host.
+	*u = url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   "/demote",
+	}
+
+	req, err := http.NewRequest("POST", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	default:
+		return fmt.Errorf("invalid response: code=%d", resp.StatusCode)
+	}
+}
+
 // RemoteTx represents a remote transaction created by Client.Begin().
 type RemoteTx struct {
 	id               uint64
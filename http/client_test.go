@@ -0,0 +1,64 @@
+package http_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/superfly/litefs"
+	"github.com/superfly/litefs/http"
+)
+
+func TestClient_Stream_Unix(t *testing.T) {
+	store := litefs.NewStore(t.TempDir(), true)
+	store.Leaser = litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202")
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	<-store.ReadyCh()
+
+	path := filepath.Join(t.TempDir(), "litefs.sock")
+	server := http.NewServer(store, "unix://"+path)
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+	t.Cleanup(func() { _ = server.Close() })
+
+	if got, want := server.URL(), "unix://"+path; got != want {
+		t.Fatalf("URL=%s, want %s", got, want)
+	}
+
+	// readReady reads the initial frame from a stream from client.
+	readReady := func(t *testing.T, client litefs.Client, rawurl string) {
+		t.Helper()
+
+		st, err := client.Stream(context.Background(), rawurl, 1, nil, litefs.StreamOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = st.Close() }()
+
+		if frame, err := litefs.ReadStreamFrame(st); err != nil {
+			t.Fatal(err)
+		} else if _, ok := frame.(*litefs.ReadyStreamFrame); !ok {
+			t.Fatalf("unexpected frame: %#v", frame)
+		}
+	}
+
+	t.Run("URL", func(t *testing.T) {
+		readReady(t, http.NewClient(), server.URL())
+	})
+
+	// Ensure the unix client connects to its socket regardless of the host.
+	t.Run("UnixClient", func(t *testing.T) {
+		readReady(t, http.NewUnixClient(path), "http://no-such-host:20202")
+	})
+
+	t.Run("ErrSocketPathRequired", func(t *testing.T) {
+		if _, err := http.NewClient().Stream(context.Background(), "unix://", 1, nil, litefs.StreamOptions{}); err == nil || err.Error() != "URL socket path required" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
@@ -2,6 +2,7 @@ package http
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -19,8 +20,10 @@ import (
 const TXIDCookieName = "__txid"
 
 const (
+	DefaultPollTXIDTimeout = 5 * time.Second
+
+	// Deprecated: Replicas no longer poll for the TXID. This is unused.
 	DefaultPollTXIDInterval = 1 * time.Millisecond
-	DefaultPollTXIDTimeout  = 5 * time.Second
 
 	DefaultCookieExpiry = 5 * time.Minute
 )
@@ -55,9 +58,14 @@ type ProxyServer struct {
 	// If true, add verbose debug logging.
 	Debug bool
 
-	// Interval & timeout for ensuring read consistency.
+	// Timeout for ensuring read consistency.
+	PollTXIDTimeout time.Duration
+
+	// Interval for polling the TXID when ensuring read consistency.
+	//
+	// Deprecated: The proxy is notified when the position changes so this
+	// setting is ignored.
 	PollTXIDInterval time.Duration
-	PollTXIDTimeout  time.Duration
 
 	// Time before cookie expires on client.
 	CookieExpiry time.Duration
@@ -68,8 +76,8 @@ func NewProxyServer(store *litefs.Store) *ProxyServer {
 	s := &ProxyServer{
 		store: store,
 
-		PollTXIDInterval: DefaultPollTXIDInterval,
 		PollTXIDTimeout:  DefaultPollTXIDTimeout,
+		PollTXIDInterval: DefaultPollTXIDInterval,
 		CookieExpiry:     DefaultCookieExpiry,
 	}
 
@@ -181,29 +189,25 @@ func (s *ProxyServer) serveGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Wait for database to catch up to TXID.
-	ticker := time.NewTicker(s.PollTXIDInterval)
-	defer ticker.Stop()
-
-	ctx, cancel := context.WithTimeout(r.Context(), s.PollTXIDTimeout)
-	defer cancel()
-
-	var pos litefs.Pos
-LOOP:
-	for {
-		if pos = db.Pos(); pos.TXID >= txid {
-			s.logf("proxy: %s %s: database %q at txid %s, proxying to target", r.Method, r.URL.Path, s.DBName, ltx.FormatTXID(pos.TXID))
-			break LOOP
-		}
-
-		select {
-		case <-ctx.Done():
-			s.logf("proxy: %s %s: database %q at txid %s, requires txid %s, proxy timeout", r.Method, r.URL.Path, s.DBName, ltx.FormatTXID(pos.TXID), ltx.FormatTXID(txid))
-			http.Error(w, "Proxy timeout", http.StatusGatewayTimeout)
+	// Wait for database to catch up to TXID, if it is behind.
+	if db.TXID() < txid {
+		ctx, cancel := context.WithTimeout(r.Context(), s.PollTXIDTimeout)
+		defer cancel()
+
+		if err := db.WaitForPos(ctx, litefs.Pos{TXID: txid}); err != nil {
+			s.logf("proxy: %s %s: database %q at txid %s, requires txid %s: %s", r.Method, r.URL.Path, s.DBName, ltx.FormatTXID(db.TXID()), ltx.FormatTXID(txid), err)
+			switch {
+			case errors.Is(err, litefs.ErrDatabaseNotFound):
+				http.Error(w, "Proxy database not found", http.StatusNotFound)
+			case errors.Is(err, litefs.ErrPosForked):
+				http.Error(w, "Proxy txid conflict", http.StatusConflict)
+			default:
+				http.Error(w, "Proxy timeout", http.StatusGatewayTimeout)
+			}
 			return
-		case <-ticker.C:
 		}
 	}
+	s.logf("proxy: %s %s: database %q at txid %s, proxying to target", r.Method, r.URL.Path, s.DBName, ltx.FormatTXID(db.TXID()))
 
 	// Send request to the target once we've caught up to the last write seen.
 	s.proxyToTarget(w, r, false)
@@ -2,7 +2,10 @@ package http
 
 import (
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"expvar"
 	"fmt"
 	"io"
@@ -14,12 +17,14 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/superfly/litefs"
 	"github.com/superfly/litefs/internal/chunk"
+	"github.com/superfly/litefs/internal/ratelimit"
 	"github.com/superfly/ltx"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
@@ -44,6 +49,14 @@ type Server struct {
 	addr  string
 	store *litefs.Store
 
+	// If set, the server only accepts TLS connections using this config.
+	// Must be set before calling Serve().
+	TLSConfig *tls.Config
+
+	// If set, requests under "/lease/" are passed to this handler. Used by
+	// leasers that elect a primary among peers. Must be set before Serve().
+	LeaseHandler http.Handler
+
 	g      errgroup.Group
 	ctx    context.Context
 	cancel context.CancelCauseFunc
@@ -67,22 +80,56 @@ func NewServer(store *litefs.Store, addr string) *Server {
 	return s
 }
 
+// Listen opens the listener on the server's address. An address in the form
+// of "unix:///path/to/socket" listens on a unix domain socket. A stale socket
+// file left behind by a previous process is removed first.
 func (s *Server) Listen() (err error) {
+	if path, ok := UnixSocketPath(s.addr); ok {
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("remove stale socket: %w", err)
+			}
+		}
+		if s.ln, err = net.Listen("unix", path); err != nil {
+			return err
+		}
+		return nil
+	}
+
 	if s.ln, err = net.Listen("tcp", s.addr); err != nil {
 		return err
 	}
 	return nil
 }
 
+// UnixSocketPath returns the socket path for a "unix://" address or URL.
+// Returns false if addr does not use the "unix" scheme.
+func UnixSocketPath(addr string) (string, bool) {
+	path, ok := strings.CutPrefix(addr, "unix://")
+	return path, ok && path != ""
+}
+
 func (s *Server) Serve() {
 	s.g.Go(func() error {
-		if err := s.httpServer.Serve(s.ln); s.ctx.Err() != nil {
+		if err := s.serve(); s.ctx.Err() != nil {
 			return err
 		}
 		return nil
 	})
 }
 
+func (s *Server) serve() error {
+	if s.TLSConfig == nil {
+		return s.httpServer.Serve(s.ln)
+	}
+
+	s.httpServer.TLSConfig = s.TLSConfig.Clone()
+	if err := http2.ConfigureServer(s.httpServer, s.http2Server); err != nil {
+		return fmt.Errorf("configure http2 server: %w", err)
+	}
+	return s.httpServer.ServeTLS(s.ln, "", "")
+}
+
 func (s *Server) Close() (err error) {
 	if s.ln != nil {
 		if e := s.ln.Close(); err == nil {
@@ -101,16 +148,25 @@ func (s *Server) Close() (err error) {
 	return err
 }
 
-// Port returns the port the listener is running on.
+// Port returns the port the listener is running on. Returns zero if the
+// server is listening on a unix domain socket.
 func (s *Server) Port() int {
 	if s.ln == nil {
 		return 0
 	}
-	return s.ln.Addr().(*net.TCPAddr).Port
+	addr, ok := s.ln.Addr().(*net.TCPAddr)
+	if !ok {
+		return 0
+	}
+	return addr.Port
 }
 
 // URL returns the full base URL for the running server.
 func (s *Server) URL() string {
+	if path, ok := UnixSocketPath(s.addr); ok {
+		return "unix://" + path
+	}
+
 	host, _, _ := net.SplitHostPort(s.addr)
 	if host == "" {
 		host = "localhost"
@@ -144,6 +200,11 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.LeaseHandler != nil && strings.HasPrefix(r.URL.Path, "/lease/") {
+		s.LeaseHandler.ServeHTTP(w, r)
+		return
+	}
+
 	switch r.URL.Path {
 	case "/halt":
 		switch r.Method {
@@ -179,6 +240,14 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
 		}
 
+	case "/verify":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostVerify(w, r)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+
 	case "/stream":
 		switch r.Method {
 		case http.MethodPost:
@@ -186,6 +255,62 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 		default:
 			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
 		}
+
+	case "/ack":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostAck(w, r)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+
+	case "/preempt":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostPreempt(w, r)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+
+	case "/info":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetInfo(w, r)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+
+	case "/pos":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetPos(w, r)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+
+	case "/handoff":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostHandoff(w, r)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+
+	case "/promote":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostPromote(w, r)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
+
+	case "/demote":
+		switch r.Method {
+		case http.MethodPost:
+			s.handlePostDemote(w, r)
+		default:
+			Error(w, r, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		}
 	default:
 		http.NotFound(w, r)
 	}
@@ -230,16 +355,34 @@ func (s *Server) handleGetExport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	codec, err := litefs.ParseExportCodec(r.URL.Query().Get("codec"))
+	if err != nil {
+		Error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
 	db := s.store.DB(name)
 	if db == nil {
 		Error(w, r, litefs.ErrDatabaseNotFound, http.StatusNotFound)
 		return
 	}
 
-	pos, err := db.Export(r.Context(), w)
+	if codec == litefs.ExportCodecGzip {
+		w.Header().Set("Content-Type", "application/gzip")
+	}
+	cw, err := codec.NewWriter(w)
+	if err != nil {
+		Error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	pos, err := db.Export(r.Context(), cw)
 	if err != nil {
 		Error(w, r, fmt.Errorf("write snapshot: %w", err), http.StatusInternalServerError)
 		return
+	} else if err := cw.Close(); err != nil {
+		log.Printf("%s: cannot close export writer: %s", litefs.FormatNodeID(s.store.ID()), err)
+		return
 	}
 
 	log.Printf("%s: snapshot successfully exported @ %s", litefs.FormatNodeID(s.store.ID()), pos.String())
@@ -255,7 +398,8 @@ func (s *Server) handlePostHalt(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Cannot issue remote halt lock from this node.
-	if id, _ := litefs.ParseNodeID(r.Header.Get("Litefs-Id")); id == s.store.ID() {
+	nodeID, _ := litefs.ParseNodeID(r.Header.Get("Litefs-Id"))
+	if nodeID == s.store.ID() {
 		Error(w, r, fmt.Errorf("cannot remotely halt self"), http.StatusBadRequest)
 		return
 	}
@@ -268,7 +412,7 @@ func (s *Server) handlePostHalt(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Acquire write locks on behalf of remote node.
-	haltLock, err := db.AcquireHaltLock(r.Context(), lockID)
+	haltLock, err := db.AcquireHaltLock(r.Context(), nodeID, lockID)
 	if err != nil {
 		Error(w, r, fmt.Errorf("acquire halt lock: %w", err), http.StatusInternalServerError)
 		return
@@ -340,6 +484,162 @@ func (s *Server) handlePostTx(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *Server) handlePostAck(w http.ResponseWriter, r *http.Request) {
+	id, err := litefs.ParseNodeID(r.Header.Get("Litefs-Id"))
+	if err != nil {
+		Error(w, r, err, http.StatusBadRequest)
+		return
+	} else if id == s.store.ID() {
+		Error(w, r, fmt.Errorf("cannot ack self"), http.StatusBadRequest)
+		return
+	}
+
+	// Acks use the same token as the stream they acknowledge.
+	if !s.isValidReplicationToken(r) {
+		Error(w, r, fmt.Errorf("invalid replication token"), http.StatusUnauthorized)
+		return
+	}
+
+	posMap, err := ReadPosMapFrom(r.Body)
+	if err != nil {
+		Error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.Ack(id, posMap); err == litefs.ErrNotPrimary {
+		Error(w, r, err, http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) handlePostVerify(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		Error(w, r, fmt.Errorf("name required"), http.StatusBadRequest)
+		return
+	}
+
+	verify := s.store.Verify
+	if r.URL.Query().Get("checksum-only") == "true" {
+		verify = s.store.VerifyDB
+	}
+
+	if err := verify(r.Context(), name); err == litefs.ErrDatabaseNotFound {
+		Error(w, r, err, http.StatusNotFound)
+		return
+	} else if errors.Is(err, litefs.ErrVerifyFailed) {
+		Error(w, r, err, http.StatusConflict)
+		return
+	} else if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) handlePostPreempt(w http.ResponseWriter, r *http.Request) {
+	id, err := litefs.ParseNodeID(r.Header.Get("Litefs-Id"))
+	if err != nil {
+		Error(w, r, err, http.StatusBadRequest)
+		return
+	} else if id == s.store.ID() {
+		Error(w, r, fmt.Errorf("cannot preempt self"), http.StatusBadRequest)
+		return
+	}
+
+	// Only replicas allowed to stream may take over the lease.
+	if !s.isValidReplicationToken(r) {
+		Error(w, r, fmt.Errorf("invalid replication token"), http.StatusUnauthorized)
+		return
+	}
+
+	weight, err := strconv.Atoi(r.URL.Query().Get("weight"))
+	if err != nil {
+		Error(w, r, fmt.Errorf("invalid weight"), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.Preempt(r.Context(), id, weight); err == litefs.ErrNotPrimary {
+		Error(w, r, err, http.StatusServiceUnavailable)
+		return
+	} else if err == litefs.ErrPreemptRejected {
+		Error(w, r, err, http.StatusConflict)
+		return
+	} else if errors.Is(err, litefs.ErrReplicaNotFound) {
+		Error(w, r, err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) handleGetInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.store.Info()); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) handleGetPos(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.store.PosMap()); err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) handlePostHandoff(w http.ResponseWriter, r *http.Request) {
+	id, err := litefs.ParseNodeID(r.Header.Get("Litefs-Id"))
+	if err != nil {
+		Error(w, r, err, http.StatusBadRequest)
+		return
+	} else if id == s.store.ID() {
+		Error(w, r, fmt.Errorf("cannot hand off to self"), http.StatusBadRequest)
+		return
+	}
+
+	// Only replicas allowed to stream may take over the lease.
+	if !s.isValidReplicationToken(r) {
+		Error(w, r, fmt.Errorf("invalid replication token"), http.StatusUnauthorized)
+		return
+	}
+
+	if err := s.store.Handoff(r.Context(), id); err == litefs.ErrNotPrimary {
+		Error(w, r, err, http.StatusServiceUnavailable)
+		return
+	} else if errors.Is(err, litefs.ErrReplicaNotFound) {
+		Error(w, r, err, http.StatusNotFound)
+		return
+	} else if errors.Is(err, litefs.ErrReplicaLagging) {
+		Error(w, r, err, http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) handlePostPromote(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.Promote(r.Context()); err == litefs.ErrNotCandidate {
+		Error(w, r, err, http.StatusConflict)
+		return
+	} else if errors.Is(err, litefs.ErrReplicaLagging) {
+		Error(w, r, err, http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		Error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) handlePostDemote(w http.ResponseWriter, r *http.Request) {
+	s.store.Demote()
+}
+
 func (s *Server) handlePostStream(w http.ResponseWriter, r *http.Request) {
 	if r.ProtoMajor < 2 {
 		http.Error(w, "Upgrade to HTTP/2 required", http.StatusUpgradeRequired)
@@ -347,13 +647,23 @@ func (s *Server) handlePostStream(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Prevent nodes from connecting to themselves.
-	if id, _ := litefs.ParseNodeID(r.Header.Get("Litefs-Id")); id == s.store.ID() {
-		Error(w, r, fmt.Errorf("cannot connect to self"), http.StatusBadRequest)
+	id, _ := litefs.ParseNodeID(r.Header.Get("Litefs-Id"))
+	if id == s.store.ID() {
+		log.Printf("%s: WARNING: stream rejected, remote node %s advertises the same node id; run 'litefs mount -reset-id' on the cloned node",
+			litefs.FormatNodeID(s.store.ID()), r.RemoteAddr)
+		Error(w, r, litefs.ErrDuplicateNodeID, http.StatusConflict)
 		return
 	}
 
-	// Wrap context so that it cancels when the primary lease is lost.
-	r = r.WithContext(s.store.PrimaryCtx(r.Context()))
+	// Reject replicas that do not present the replication token, if required.
+	if !s.isValidReplicationToken(r) {
+		Error(w, r, fmt.Errorf("invalid replication token"), http.StatusUnauthorized)
+		return
+	}
+
+	// Wrap context so that it cancels when the primary lease is lost or, for
+	// a relay, when it disconnects from its upstream node.
+	r = r.WithContext(s.store.StreamCtx(r.Context()))
 	if err := r.Context().Err(); err != nil {
 		Error(w, r, err, http.StatusServiceUnavailable)
 		return
@@ -365,10 +675,25 @@ func (s *Server) handlePostStream(w http.ResponseWriter, r *http.Request) {
 	serverStreamCountMetric.Inc()
 	defer serverStreamCountMetric.Dec()
 
+	// Two replicas sharing an ID usually means a data directory was cloned.
+	if addr := s.store.SubscriberRemoteAddr(id); addr != "" && addr != r.RemoteAddr {
+		log.Printf("%s: WARNING: replica %s at %s advertises the same node id as connected replica at %s; run 'litefs mount -reset-id' on the cloned node",
+			litefs.FormatNodeID(s.store.ID()), litefs.FormatNodeID(id), r.RemoteAddr, addr)
+	}
+
 	// Subscribe to store changes
-	subscription := s.store.Subscribe()
+	subscription := s.store.SubscribeWithOptions(litefs.SubscribeOptions{
+		NodeID:        id,
+		Handoff:       r.URL.Query().Get("handoff") == "true",
+		DirtySetLimit: s.store.SubscriberDirtySetLimit,
+		Policy:        litefs.SubscriberPolicyCoalesce,
+		RemoteAddr:    r.RemoteAddr,
+	})
 	defer func() { _ = subscription.Close() }()
 
+	// Only stream databases matching the replica's prefixes, if specified.
+	prefixes := r.URL.Query()["prefix"]
+
 	// Read in pos map.
 	posMap, err := ReadPosMapFrom(r.Body)
 	if err != nil {
@@ -376,6 +701,37 @@ func (s *Server) handlePostStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Read snapshot requests following the pos map. Clearing the position
+	// causes a snapshot to be sent for the database.
+	for {
+		frame, err := litefs.ReadStreamFrame(r.Body)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			Error(w, r, fmt.Errorf("read stream request frame: %w", err), http.StatusBadRequest)
+			return
+		}
+
+		switch frame := frame.(type) {
+		case *litefs.SnapshotRequestStreamFrame:
+			if !s.store.AllowSnapshotRequest(id, frame.Name) {
+				log.Printf("ignoring repeated snapshot request by replica %s for %q, limited to one per %s",
+					litefs.FormatNodeID(id), frame.Name, s.store.SnapshotRequestInterval)
+				serverSnapshotRequestIgnoredCountMetric.Inc()
+				continue
+			}
+			log.Printf("snapshot requested by replica %s for %q", litefs.FormatNodeID(id), frame.Name)
+			posMap[frame.Name] = litefs.Pos{}
+		default:
+			Error(w, r, fmt.Errorf("invalid stream request frame type: %T", frame), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Record the replica's reported positions so retention keeps the LTX
+	// files it needs while it catches up.
+	subscription.SetPosMap(posMap)
+
 	dbs := s.store.DBs()
 	sort.Slice(dbs, func(i, j int) bool { return dbs[i].Name() < dbs[j].Name() })
 
@@ -387,11 +743,21 @@ func (s *Server) handlePostStream(w http.ResponseWriter, r *http.Request) {
 	for _, db := range dbs {
 		dirtySet[db.Name()] = struct{}{}
 	}
+	filterDirtySet(dirtySet, prefixes)
 
 	// Flush header so client can resume control.
 	w.WriteHeader(http.StatusOK)
 	w.(http.Flusher).Flush()
 
+	// Limit the bandwidth used by this stream, if set. Each stream has its
+	// own limiter so a replica that is catching up does not slow the others.
+	if n := s.store.MaxReplicationBytesPerSec; n > 0 {
+		w = &throttledResponseWriter{
+			ResponseWriter: w,
+			w:              ratelimit.NewWriter(r.Context(), w, ratelimit.NewLimiter(n)),
+		}
+	}
+
 	// Attempt to flush an "end" frame on disconnect so we can flush it.
 	// See: https://github.com/superfly/litefs/issues/182
 	defer func() {
@@ -399,9 +765,65 @@ func (s *Server) handlePostStream(w http.ResponseWriter, r *http.Request) {
 		w.(http.Flusher).Flush()
 	}()
 
+	// Send heartbeats while idle so replicas can detect a dead connection.
+	// Only replicas that advertise support receive them as older replicas
+	// reject unknown frame types.
+	var heartbeatCh <-chan time.Time
+	if interval := s.store.HeartbeatInterval; interval > 0 && r.URL.Query().Get("heartbeat") == "true" {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		heartbeatCh = ticker.C
+	}
+
 	// Continually iterate by writing dirty changes and then waiting for new changes.
 	var readySent bool
 	for {
+		// Send renames first so the node moves its existing copy instead of
+		// dropping it and receiving a snapshot under the new name.
+		for _, frame := range subscription.Renames() {
+			pos, ok := posMap[frame.OldName]
+			if !ok {
+				// Send the database if it has been renamed into the filter.
+				if litefs.MatchPrefixes(frame.NewName, prefixes) {
+					if err := s.streamDB(r.Context(), w, frame.NewName, posMap); err != nil {
+						Error(w, r, fmt.Errorf("stream error: db=%q err=%s", frame.NewName, err), http.StatusInternalServerError)
+						return
+					}
+				}
+				continue // node does not have the database
+			}
+
+			// Drop the database if it has been renamed out of the filter.
+			if !litefs.MatchPrefixes(frame.NewName, prefixes) {
+				if err := litefs.WriteStreamFrame(w, &litefs.DropDBStreamFrame{Name: frame.OldName}); err != nil {
+					Error(w, r, fmt.Errorf("stream error: write drop db frame: %s", err), http.StatusInternalServerError)
+					return
+				}
+				w.(http.Flusher).Flush()
+
+				delete(posMap, frame.OldName)
+				continue
+			}
+
+			if err := litefs.WriteStreamFrame(w, &frame); err != nil {
+				Error(w, r, fmt.Errorf("stream error: write rename db frame: %s", err), http.StatusInternalServerError)
+				return
+			}
+			w.(http.Flusher).Flush()
+
+			delete(posMap, frame.OldName)
+			posMap[frame.NewName] = pos
+		}
+
+		// Send the primary's positions ahead of the changes so replicas can
+		// report how far behind they are while applying them.
+		if heartbeatCh != nil && len(dirtySet) > 0 {
+			if err := s.writeHeartbeatStreamFrame(w, dirtySet); err != nil {
+				Error(w, r, fmt.Errorf("stream error: write heartbeat frame: %s", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
 		// Send pending transactions for each database.
 		for name := range dirtySet {
 			if err := s.streamDB(r.Context(), w, name, posMap); err != nil {
@@ -409,6 +831,7 @@ func (s *Server) handlePostStream(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
+		subscription.SetPosMap(posMap)
 
 		// Send "ready" frame after initial replication set
 		if !readySent {
@@ -427,17 +850,95 @@ func (s *Server) handlePostStream(w http.ResponseWriter, r *http.Request) {
 			return // server disconnect
 		case <-r.Context().Done():
 			return // client disconnect
-		case <-subscription.NotifyCh():
+		case err := <-subscription.NotifyCh():
+			if err != nil {
+				Error(w, r, fmt.Errorf("stream error: %w", err), http.StatusInternalServerError)
+				return
+			}
 			dirtySet = subscription.DirtySet()
+			filterDirtySet(dirtySet, prefixes)
+		case leaseID := <-subscription.HandoffCh():
+			dirtySet = nil
+
+			err := litefs.WriteStreamFrame(w, &litefs.HandoffStreamFrame{LeaseID: leaseID})
+			if err == nil {
+				w.(http.Flusher).Flush()
+			}
+			subscription.HandoffSent(err)
+			if err != nil {
+				Error(w, r, fmt.Errorf("stream error: write handoff frame: %s", err), http.StatusInternalServerError)
+				return
+			}
+		case <-heartbeatCh:
+			dirtySet = nil
+
+			if err := s.writeHeartbeatStreamFrame(w, nil); err != nil {
+				Error(w, r, fmt.Errorf("stream error: write heartbeat frame: %s", err), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// writeHeartbeatStreamFrame writes the current time & database positions to
+// the stream. Positions include the LTX timestamp of each database's latest
+// transaction so replicas can compute their lag. If names is non-nil then only
+// those databases are included.
+func (s *Server) writeHeartbeatStreamFrame(w http.ResponseWriter, names map[string]struct{}) error {
+	posMap := s.store.PosMap()
+	if names != nil {
+		for name := range posMap {
+			if _, ok := names[name]; !ok {
+				delete(posMap, name)
+			}
+		}
+	}
+
+	if err := litefs.WriteStreamFrame(w, &litefs.HeartbeatStreamFrame{
+		Timestamp: time.Now().UnixMilli(),
+		Interval:  s.store.HeartbeatInterval.Milliseconds(),
+		PosMap:    posMap,
+	}); err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
+
+// filterDirtySet removes databases from dirtySet that do not match prefixes.
+func filterDirtySet(dirtySet map[string]struct{}, prefixes []string) {
+	for name := range dirtySet {
+		if !litefs.MatchPrefixes(name, prefixes) {
+			delete(dirtySet, name)
 		}
 	}
 }
 
+// isValidReplicationToken returns true if the request's bearer token matches the
+// store's replication token. Always returns true if no token is required.
+func (s *Server) isValidReplicationToken(r *http.Request) bool {
+	token := s.store.ReplicationToken
+	if token == "" {
+		return true
+	}
+
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, prefix)), []byte(token)) == 1
+}
+
 func (s *Server) streamDB(ctx context.Context, w http.ResponseWriter, name string, posMap map[string]litefs.Pos) error {
 	db := s.store.DB(name)
 
 	// If the replica has a database that doesn't exist on the primary, skip it.
+	// There is nothing to drop if the replica never received the database.
 	if db == nil {
+		if _, ok := posMap[name]; !ok {
+			return nil
+		}
 		if err := litefs.WriteStreamFrame(w, &litefs.DropDBStreamFrame{Name: name}); err != nil {
 			return fmt.Errorf("write drop db frame: %w", err)
 		}
@@ -447,6 +948,11 @@ func (s *Server) streamDB(ctx context.Context, w http.ResponseWriter, name strin
 		return nil
 	}
 
+	// Skip databases that are only kept on this node.
+	if db.NoReplicate() {
+		return nil
+	}
+
 	for {
 		clientPos := posMap[name]
 		dbPos := db.Pos()
@@ -532,7 +1038,7 @@ func (s *Server) streamLTX(ctx context.Context, w http.ResponseWriter, db *litef
 
 	serverFrameSendCountMetricVec.WithLabelValues(db.Name(), "ltx")
 
-	return litefs.Pos{TXID: dec.Header().MaxTXID, PostApplyChecksum: dec.Trailer().PostApplyChecksum}, nil
+	return litefs.NewPosFromLTX(dec.Header(), dec.Trailer()), nil
 }
 
 func (s *Server) streamLTXSnapshot(ctx context.Context, w http.ResponseWriter, db *litefs.DB) (newPos litefs.Pos, err error) {
@@ -553,9 +1059,19 @@ func (s *Server) streamLTXSnapshot(ctx context.Context, w http.ResponseWriter, d
 
 	serverFrameSendCountMetricVec.WithLabelValues(db.Name(), "ltx:snapshot")
 
-	return litefs.Pos{TXID: header.MaxTXID, PostApplyChecksum: trailer.PostApplyChecksum}, nil
+	return litefs.NewPosFromLTX(header, trailer), nil
+}
+
+// throttledResponseWriter wraps a response writer to limit its write rate.
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	w *ratelimit.Writer
 }
 
+func (w *throttledResponseWriter) Write(p []byte) (int, error) { return w.w.Write(p) }
+
+func (w *throttledResponseWriter) Flush() { w.ResponseWriter.(http.Flusher).Flush() }
+
 func Error(w http.ResponseWriter, r *http.Request, err error, code int) {
 	log.Printf("http: %s %s: error: %s", r.Method, r.URL.Path, err)
 	http.Error(w, err.Error(), code)
@@ -572,4 +1088,9 @@ var (
 		Name: "litefs_http_frame_send_count",
 		Help: "Number of frames sent.",
 	}, []string{"db", "type"})
+
+	serverSnapshotRequestIgnoredCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_http_snapshot_request_ignored_count",
+		Help: "Number of replica snapshot requests ignored by the rate limit.",
+	})
 )
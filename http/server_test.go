@@ -0,0 +1,892 @@
+package http_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/superfly/litefs"
+	"github.com/superfly/litefs/http"
+	"github.com/superfly/litefs/internal/chunk"
+	"github.com/superfly/litefs/internal/testingutil"
+	"github.com/superfly/litefs/mock"
+	"github.com/superfly/ltx"
+)
+
+func TestServer_Handoff(t *testing.T) {
+	data, err := os.ReadFile("../testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Primary holds a lease that can be handed off and must not be destroyed.
+	var leaseClosed atomic.Bool
+	primaryLease := newMockLease("lease1")
+	primaryLease.CloseFunc = func() error { leaseClosed.Store(true); return nil }
+
+	primary := litefs.NewStore(t.TempDir(), true)
+	primary.DemoteDelay = time.Minute
+	primary.Client = http.NewClient()
+	primary.Leaser = &mock.Leaser{
+		CloseFunc:        func() error { return nil },
+		AdvertiseURLFunc: func() string { return "" },
+		AcquireFunc:      func(ctx context.Context) (litefs.Lease, error) { return primaryLease, nil },
+		PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
+			return litefs.PrimaryInfo{}, litefs.ErrNoPrimary
+		},
+	}
+	if err := primary.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = primary.Close() })
+	<-primary.ReadyCh()
+
+	server := http.NewServer(primary, "127.0.0.1:0")
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+	t.Cleanup(func() { _ = server.Close() })
+
+	if _, err := primary.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replica accepts the handed off lease with the same ID.
+	var acquiredLeaseID atomic.Value
+	replica := litefs.NewStore(t.TempDir(), true)
+	replica.Client = http.NewClient()
+	replica.Leaser = &mock.Leaser{
+		CloseFunc:        func() error { return nil },
+		AdvertiseURLFunc: func() string { return "" },
+		AcquireFunc: func(ctx context.Context) (litefs.Lease, error) {
+			return nil, litefs.ErrPrimaryExists
+		},
+		AcquireExistingFunc: func(ctx context.Context, leaseID string) (litefs.Lease, error) {
+			acquiredLeaseID.Store(leaseID)
+			return newMockLease(leaseID), nil
+		},
+		PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
+			return litefs.PrimaryInfo{AdvertiseURL: fmt.Sprintf("http://127.0.0.1:%d", server.Port())}, nil
+		},
+	}
+	if err := replica.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = replica.Close() })
+	<-replica.ReadyCh()
+
+	// Retry until the replica's stream has registered with the primary.
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		return primary.Handoff(context.Background(), replica.ID())
+	})
+
+	if primary.IsPrimary() {
+		t.Fatal("expected primary to step down")
+	} else if leaseClosed.Load() {
+		t.Fatal("expected lease to be kept after handoff")
+	}
+
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if !replica.IsPrimary() {
+			return fmt.Errorf("replica not primary yet")
+		}
+		return nil
+	})
+	if got, want := acquiredLeaseID.Load(), "lease1"; got != want {
+		t.Fatalf("lease=%v, want %v", got, want)
+	}
+
+	// Replica must have received all transactions before taking over.
+	if db := replica.DB("sqlite.db"); db == nil {
+		t.Fatal("expected database on new primary")
+	} else if got, want := db.Pos(), primary.DB("sqlite.db").Pos(); got != want {
+		t.Fatalf("Pos=%s, want %s", got, want)
+	}
+}
+
+func TestServer_Preempt(t *testing.T) {
+	data, err := os.ReadFile("../testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := litefs.NewStore(t.TempDir(), true)
+	primary.CandidateWeight = 50
+	primary.DemoteDelay = time.Minute
+	primary.Client = http.NewClient()
+	primary.Leaser = &mock.Leaser{
+		CloseFunc:        func() error { return nil },
+		AdvertiseURLFunc: func() string { return "" },
+		AcquireFunc: func(ctx context.Context) (litefs.Lease, error) {
+			return newMockLease("lease1"), nil
+		},
+		PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
+			return litefs.PrimaryInfo{}, litefs.ErrNoPrimary
+		},
+	}
+	if err := primary.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = primary.Close() })
+	<-primary.ReadyCh()
+
+	server := http.NewServer(primary, "127.0.0.1:0")
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+	t.Cleanup(func() { _ = server.Close() })
+
+	if _, err := primary.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	primaryURL := fmt.Sprintf("http://127.0.0.1:%d", server.Port())
+
+	// A replica that does not outrank the primary is rejected.
+	if err := http.NewClient().Preempt(context.Background(), primaryURL, 2, 50, ""); err != litefs.ErrPreemptRejected {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Replica with a higher weight takes over the lease once caught up.
+	replica := litefs.NewStore(t.TempDir(), true)
+	replica.CandidateWeight = 100
+	replica.Client = http.NewClient()
+	replica.Leaser = &mock.Leaser{
+		CloseFunc:        func() error { return nil },
+		AdvertiseURLFunc: func() string { return "" },
+		AcquireFunc: func(ctx context.Context) (litefs.Lease, error) {
+			return nil, litefs.ErrPrimaryExists
+		},
+		AcquireExistingFunc: func(ctx context.Context, leaseID string) (litefs.Lease, error) {
+			return newMockLease(leaseID), nil
+		},
+		PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
+			return litefs.PrimaryInfo{AdvertiseURL: primaryURL, Weight: 50}, nil
+		},
+	}
+	if err := replica.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = replica.Close() })
+
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if !replica.IsPrimary() {
+			return fmt.Errorf("replica not primary yet")
+		}
+		return nil
+	})
+	if primary.IsPrimary() {
+		t.Fatal("expected primary to step down")
+	}
+
+	if db := replica.DB("sqlite.db"); db == nil {
+		t.Fatal("expected database on new primary")
+	} else if got, want := db.Pos(), primary.DB("sqlite.db").Pos(); got != want {
+		t.Fatalf("Pos=%s, want %s", got, want)
+	}
+}
+
+func TestServer_Promote(t *testing.T) {
+	primary := litefs.NewStore(t.TempDir(), true)
+	primary.CandidateWeight = 100
+	primary.DemoteDelay = time.Minute
+	primary.Client = http.NewClient()
+	primary.Leaser = &mock.Leaser{
+		CloseFunc:        func() error { return nil },
+		AdvertiseURLFunc: func() string { return "" },
+		AcquireFunc: func(ctx context.Context) (litefs.Lease, error) {
+			return newMockLease("lease1"), nil
+		},
+		PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
+			return litefs.PrimaryInfo{}, litefs.ErrNoPrimary
+		},
+	}
+	if err := primary.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = primary.Close() })
+	<-primary.ReadyCh()
+
+	primaryServer := http.NewServer(primary, "127.0.0.1:0")
+	if err := primaryServer.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	primaryServer.Serve()
+	t.Cleanup(func() { _ = primaryServer.Close() })
+	primaryURL := fmt.Sprintf("http://127.0.0.1:%d", primaryServer.Port())
+
+	// Replica has a lower weight so it only becomes primary when promoted.
+	replica := litefs.NewStore(t.TempDir(), true)
+	replica.CandidateWeight = 10
+	replica.DemoteDelay = time.Minute
+	replica.Client = http.NewClient()
+	replica.Leaser = &mock.Leaser{
+		CloseFunc:        func() error { return nil },
+		AdvertiseURLFunc: func() string { return "" },
+		AcquireFunc: func(ctx context.Context) (litefs.Lease, error) {
+			return nil, litefs.ErrPrimaryExists
+		},
+		AcquireExistingFunc: func(ctx context.Context, leaseID string) (litefs.Lease, error) {
+			return newMockLease(leaseID), nil
+		},
+		PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
+			return litefs.PrimaryInfo{AdvertiseURL: primaryURL, Weight: 100}, nil
+		},
+	}
+	if err := replica.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = replica.Close() })
+	<-replica.ReadyCh()
+
+	replicaServer := http.NewServer(replica, "127.0.0.1:0")
+	if err := replicaServer.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	replicaServer.Serve()
+	t.Cleanup(func() { _ = replicaServer.Close() })
+	replicaURL := fmt.Sprintf("http://127.0.0.1:%d", replicaServer.Port())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := http.NewClient().Promote(ctx, replicaURL); err != nil {
+		t.Fatal(err)
+	} else if !replica.IsPrimary() {
+		t.Fatal("expected replica to be primary")
+	} else if primary.IsPrimary() {
+		t.Fatal("expected primary to step down")
+	}
+
+	// Demoting releases the lease.
+	if err := http.NewClient().Demote(ctx, replicaURL); err != nil {
+		t.Fatal(err)
+	}
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if replica.IsPrimary() {
+			return fmt.Errorf("replica still primary")
+		}
+		return nil
+	})
+}
+
+func TestServer_Stream_RenameDB(t *testing.T) {
+	data, err := os.ReadFile("../testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := litefs.NewStore(t.TempDir(), true)
+	primary.Leaser = litefs.NewStaticLeaser(true, "localhost", "")
+	if err := primary.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = primary.Close() })
+	<-primary.ReadyCh()
+
+	server := http.NewServer(primary, "127.0.0.1:0")
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+	t.Cleanup(func() { _ = server.Close() })
+
+	if _, err := primary.CreateDBFromReader(context.Background(), "app_v2", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	replica := litefs.NewStore(t.TempDir(), false)
+	replica.Client = http.NewClient()
+	replica.Leaser = litefs.NewStaticLeaser(false, "localhost", fmt.Sprintf("http://127.0.0.1:%d", server.Port()))
+	if err := replica.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = replica.Close() })
+	<-replica.ReadyCh()
+
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if replica.DB("app_v2") == nil {
+			return fmt.Errorf("database not replicated yet")
+		}
+		return nil
+	})
+
+	// Write a marker into the replica's database directory so we can verify
+	// the directory was moved rather than recreated from a snapshot.
+	marker := filepath.Join(replica.DBPath("app_v2"), "marker")
+	if err := os.WriteFile(marker, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := primary.RenameDB(context.Background(), "app_v2", "app_v3"); err != nil {
+		t.Fatal(err)
+	}
+
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if replica.DB("app_v2") != nil {
+			return fmt.Errorf("old database still exists")
+		} else if replica.DB("app_v3") == nil {
+			return fmt.Errorf("new database not replicated yet")
+		}
+		return nil
+	})
+
+	if _, err := os.Stat(filepath.Join(replica.DBPath("app_v3"), "marker")); err != nil {
+		t.Fatalf("expected database directory to be renamed: %v", err)
+	} else if got, want := replica.DB("app_v3").Pos(), primary.DB("app_v3").Pos(); got != want {
+		t.Fatalf("Pos=%s, want %s", got, want)
+	}
+}
+
+// Ensure a relay serves the changes it receives to downstream replicas.
+func TestServer_Stream_Relay(t *testing.T) {
+	data, err := os.ReadFile("../testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := litefs.NewStore(t.TempDir(), true)
+	primary.Leaser = litefs.NewStaticLeaser(true, "localhost", "")
+	if err := primary.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = primary.Close() })
+	<-primary.ReadyCh()
+
+	primaryServer := http.NewServer(primary, "127.0.0.1:0")
+	if err := primaryServer.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	primaryServer.Serve()
+	t.Cleanup(func() { _ = primaryServer.Close() })
+	primaryURL := fmt.Sprintf("http://127.0.0.1:%d", primaryServer.Port())
+
+	db, err := primary.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relay := litefs.NewStore(t.TempDir(), false)
+	relay.Client = http.NewClient()
+	relay.Leaser = litefs.NewStaticLeaser(false, "localhost", primaryURL)
+	relay.Relay = true
+	relayServer := http.NewServer(relay, "127.0.0.1:0")
+	if err := relayServer.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	relayServer.Serve()
+	t.Cleanup(func() { _ = relayServer.Close() })
+
+	if err := relay.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = relay.Close() })
+	<-relay.ReadyCh()
+
+	// Stream from the relay while the primary is unreachable to ensure the
+	// changes are not coming from the primary.
+	var primaryStreamN atomic.Int64
+	client := &mock.Client{
+		StreamFunc: func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+			if rawurl == primaryURL {
+				primaryStreamN.Add(1)
+				return nil, fmt.Errorf("primary unavailable")
+			}
+			return http.NewClient().Stream(ctx, rawurl, nodeID, posMap, opts)
+		},
+	}
+
+	downstream := litefs.NewStore(t.TempDir(), false)
+	downstream.Client = client
+	downstream.Leaser = litefs.NewStaticLeaser(false, "localhost", primaryURL)
+	downstream.UpstreamURL = fmt.Sprintf("http://127.0.0.1:%d", relayServer.Port())
+	if err := downstream.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = downstream.Close() })
+	<-downstream.ReadyCh()
+
+	// Write a new transaction on the primary & ensure it propagates through.
+	if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if db := downstream.DB("sqlite.db"); db == nil {
+			return fmt.Errorf("database not replicated yet")
+		} else if got, want := db.Pos(), primary.DB("sqlite.db").Pos(); got != want {
+			return fmt.Errorf("Pos=%s, want %s", got, want)
+		}
+		return nil
+	})
+
+	if got, want := relay.DB("sqlite.db").Pos(), primary.DB("sqlite.db").Pos(); got != want {
+		t.Fatalf("relay Pos=%s, want %s", got, want)
+	} else if n := primaryStreamN.Load(); n != 0 {
+		t.Fatalf("expected no streams to primary, got %d", n)
+	}
+
+	// Ensure a replica that is not a relay rejects streams.
+	downstreamServer := http.NewServer(downstream, "127.0.0.1:0")
+	if err := downstreamServer.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	downstreamServer.Serve()
+	t.Cleanup(func() { _ = downstreamServer.Close() })
+
+	if st, err := http.NewClient().Stream(context.Background(), fmt.Sprintf("http://127.0.0.1:%d", downstreamServer.Port()), 1, nil, litefs.StreamOptions{}); err == nil {
+		_ = st.Close()
+		t.Fatal("expected error")
+	}
+}
+
+func TestServer_Stream_SnapshotRequest(t *testing.T) {
+	data, err := os.ReadFile("../testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := litefs.NewStore(t.TempDir(), true)
+	store.Leaser = litefs.NewStaticLeaser(true, "localhost", "")
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	<-store.ReadyCh()
+
+	db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	} else if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	server := http.NewServer(store, "127.0.0.1:0")
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+	t.Cleanup(func() { _ = server.Close() })
+
+	// Send a position that is one transaction behind but request a snapshot.
+	posMap := map[string]litefs.Pos{"sqlite.db": {TXID: 1, PostApplyChecksum: db.Pos().PostApplyChecksum}}
+	st, err := http.NewClient().Stream(context.Background(), fmt.Sprintf("http://127.0.0.1:%d", server.Port()), 1, posMap, litefs.StreamOptions{Snapshots: []string{"sqlite.db"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = st.Close() }()
+
+	frame, err := litefs.ReadStreamFrame(st)
+	if err != nil {
+		t.Fatal(err)
+	} else if frame, ok := frame.(*litefs.LTXStreamFrame); !ok || frame.Name != "sqlite.db" {
+		t.Fatalf("unexpected frame: %#v", frame)
+	}
+
+	hdr, _, err := ltx.DecodeHeader(chunk.NewReader(st))
+	if err != nil {
+		t.Fatal(err)
+	} else if !hdr.IsSnapshot() {
+		t.Fatalf("expected snapshot, got txid=%s-%s", ltx.FormatTXID(hdr.MinTXID), ltx.FormatTXID(hdr.MaxTXID))
+	} else if got, want := hdr.MaxTXID, uint64(2); got != want {
+		t.Fatalf("MaxTXID=%d, want %d", got, want)
+	}
+}
+
+// Ensure heartbeats are only sent to replicas that advertise support for them.
+func TestServer_Stream_Heartbeat(t *testing.T) {
+	store := litefs.NewStore(t.TempDir(), true)
+	store.Leaser = litefs.NewStaticLeaser(true, "localhost", "")
+	store.HeartbeatInterval = 10 * time.Millisecond
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	<-store.ReadyCh()
+
+	server := http.NewServer(store, "127.0.0.1:0")
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+	t.Cleanup(func() { _ = server.Close() })
+
+	t.Run("Supported", func(t *testing.T) {
+		st, err := http.NewClient().Stream(context.Background(), fmt.Sprintf("http://127.0.0.1:%d", server.Port()), 1, nil, litefs.StreamOptions{Heartbeat: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = st.Close() }()
+
+		if frame, err := litefs.ReadStreamFrame(st); err != nil {
+			t.Fatal(err)
+		} else if _, ok := frame.(*litefs.ReadyStreamFrame); !ok {
+			t.Fatalf("unexpected frame: %#v", frame)
+		}
+
+		if frame, err := litefs.ReadStreamFrame(st); err != nil {
+			t.Fatal(err)
+		} else if frame, ok := frame.(*litefs.HeartbeatStreamFrame); !ok {
+			t.Fatalf("unexpected frame: %#v", frame)
+		} else if got, want := frame.Interval, int64(10); got != want {
+			t.Fatalf("Interval=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		st, err := http.NewClient().Stream(context.Background(), fmt.Sprintf("http://127.0.0.1:%d", server.Port()), 2, nil, litefs.StreamOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = st.Close() }()
+
+		if frame, err := litefs.ReadStreamFrame(st); err != nil {
+			t.Fatal(err)
+		} else if _, ok := frame.(*litefs.ReadyStreamFrame); !ok {
+			t.Fatalf("unexpected frame: %#v", frame)
+		}
+
+		frameCh := make(chan litefs.StreamFrame, 1)
+		go func() {
+			frame, _ := litefs.ReadStreamFrame(st)
+			frameCh <- frame
+		}()
+
+		select {
+		case frame := <-frameCh:
+			t.Fatalf("unexpected frame: %#v", frame)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+}
+
+func TestServer_Stream_NoReplicate(t *testing.T) {
+	data, err := os.ReadFile("../testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := litefs.NewStore(t.TempDir(), true)
+	store.Leaser = litefs.NewStaticLeaser(true, "localhost", "")
+	store.NoReplicateDBs = []string{"scratch.db"}
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	<-store.ReadyCh()
+
+	for _, name := range []string{"app.db", "scratch.db"} {
+		if _, err := store.CreateDBFromReader(context.Background(), name, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := http.NewServer(store, "127.0.0.1:0")
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+	t.Cleanup(func() { _ = server.Close() })
+
+	st, err := http.NewClient().Stream(context.Background(), fmt.Sprintf("http://127.0.0.1:%d", server.Port()), 1, nil, litefs.StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = st.Close() }()
+
+	// Only the replicated database should be sent before the ready frame.
+	frame, err := litefs.ReadStreamFrame(st)
+	if err != nil {
+		t.Fatal(err)
+	} else if frame, ok := frame.(*litefs.LTXStreamFrame); !ok || frame.Name != "app.db" {
+		t.Fatalf("unexpected frame: %#v", frame)
+	} else if _, err := io.Copy(io.Discard, chunk.NewReader(st)); err != nil {
+		t.Fatal(err)
+	}
+
+	if frame, err := litefs.ReadStreamFrame(st); err != nil {
+		t.Fatal(err)
+	} else if _, ok := frame.(*litefs.ReadyStreamFrame); !ok {
+		t.Fatalf("unexpected frame: %#v", frame)
+	}
+
+	// Dropping the unreplicated database should not send a drop frame.
+	if err := store.DropDB(context.Background(), "scratch.db"); err != nil {
+		t.Fatal(err)
+	} else if err := store.DropDB(context.Background(), "app.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	if frame, err := litefs.ReadStreamFrame(st); err != nil {
+		t.Fatal(err)
+	} else if frame, ok := frame.(*litefs.DropDBStreamFrame); !ok || frame.Name != "app.db" {
+		t.Fatalf("unexpected frame: %#v", frame)
+	}
+}
+
+func TestServer_Stream_Prefixes(t *testing.T) {
+	data, err := os.ReadFile("../testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := litefs.NewStore(t.TempDir(), true)
+	store.Leaser = litefs.NewStaticLeaser(true, "localhost", "")
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	<-store.ReadyCh()
+
+	for _, name := range []string{"app.db", "other.db"} {
+		if _, err := store.CreateDBFromReader(context.Background(), name, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := http.NewServer(store, "127.0.0.1:0")
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+	t.Cleanup(func() { _ = server.Close() })
+
+	st, err := http.NewClient().Stream(context.Background(), fmt.Sprintf("http://127.0.0.1:%d", server.Port()), 1, nil, litefs.StreamOptions{Prefixes: []string{"app"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = st.Close() }()
+
+	// Only the matching database should be sent before the ready frame.
+	frame, err := litefs.ReadStreamFrame(st)
+	if err != nil {
+		t.Fatal(err)
+	} else if frame, ok := frame.(*litefs.LTXStreamFrame); !ok || frame.Name != "app.db" {
+		t.Fatalf("unexpected frame: %#v", frame)
+	} else if _, err := io.Copy(io.Discard, chunk.NewReader(st)); err != nil {
+		t.Fatal(err)
+	}
+
+	if frame, err := litefs.ReadStreamFrame(st); err != nil {
+		t.Fatal(err)
+	} else if _, ok := frame.(*litefs.ReadyStreamFrame); !ok {
+		t.Fatalf("unexpected frame: %#v", frame)
+	}
+}
+
+func TestServer_Stream_MaxReplicationBytesPerSec(t *testing.T) {
+	data, err := os.ReadFile("../testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const rate = 16384
+	store := litefs.NewStore(t.TempDir(), true)
+	store.Leaser = litefs.NewStaticLeaser(true, "localhost", "")
+	store.MaxReplicationBytesPerSec = rate
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	<-store.ReadyCh()
+
+	if _, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	server := http.NewServer(store, "127.0.0.1:0")
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+	t.Cleanup(func() { _ = server.Close() })
+
+	t0 := time.Now()
+	st, err := http.NewClient().Stream(context.Background(), fmt.Sprintf("http://127.0.0.1:%d", server.Port()), 1, nil, litefs.StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = st.Close() }()
+
+	frame, err := litefs.ReadStreamFrame(st)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, ok := frame.(*litefs.LTXStreamFrame); !ok {
+		t.Fatalf("unexpected frame: %#v", frame)
+	}
+
+	n, err := io.Copy(io.Discard, chunk.NewReader(st))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first second of bytes is sent immediately, the rest is throttled.
+	if min := time.Duration(float64(n-rate) / rate * float64(time.Second) * 0.9); time.Since(t0) < min {
+		t.Fatalf("expected stream to be throttled: n=%d elapsed=%s", n, time.Since(t0))
+	}
+}
+
+func TestServer_Stream_ReplicationToken(t *testing.T) {
+	store := litefs.NewStore(t.TempDir(), true)
+	store.ReplicationToken = "secret"
+	store.Leaser = litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202")
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	<-store.ReadyCh()
+
+	server := http.NewServer(store, "127.0.0.1:0")
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+	t.Cleanup(func() { _ = server.Close() })
+	serverURL := fmt.Sprintf("http://127.0.0.1:%d", server.Port())
+
+	t.Run("OK", func(t *testing.T) {
+		st, err := http.NewClient().Stream(context.Background(), serverURL, 1, nil, litefs.StreamOptions{Token: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = st.Close() }()
+
+		if frame, err := litefs.ReadStreamFrame(st); err != nil {
+			t.Fatal(err)
+		} else if _, ok := frame.(*litefs.ReadyStreamFrame); !ok {
+			t.Fatalf("unexpected frame: %#v", frame)
+		}
+	})
+
+	for _, token := range []string{"", "wrong", "secret2"} {
+		t.Run(fmt.Sprintf("ErrReplicationAuth(%q)", token), func(t *testing.T) {
+			if st, err := http.NewClient().Stream(context.Background(), serverURL, 1, nil, litefs.StreamOptions{Token: token}); err == nil {
+				_ = st.Close()
+				t.Fatal("expected error")
+			} else if !errors.Is(err, litefs.ErrReplicationAuth) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestServer_Ack(t *testing.T) {
+	store := litefs.NewStore(t.TempDir(), true)
+	store.ReplicationToken = "secret"
+	store.SyncReplicas = 1
+	store.Leaser = litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202")
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	<-store.ReadyCh()
+
+	server := http.NewServer(store, "127.0.0.1:0")
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+	t.Cleanup(func() { _ = server.Close() })
+	serverURL := fmt.Sprintf("http://127.0.0.1:%d", server.Port())
+
+	t.Run("OK", func(t *testing.T) {
+		posMap := map[string]litefs.Pos{"sqlite.db": {TXID: 3, PostApplyChecksum: 0x1234}}
+		if err := http.NewClient().Ack(context.Background(), serverURL, 1, posMap, "secret"); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := store.WaitForSyncReplicas(ctx, "sqlite.db", 3); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrReplicationAuth", func(t *testing.T) {
+		if err := http.NewClient().Ack(context.Background(), serverURL, 1, nil, "wrong"); !errors.Is(err, litefs.ErrReplicationAuth) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestServer_Export(t *testing.T) {
+	data, err := os.ReadFile("../testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := litefs.NewStore(t.TempDir(), true)
+	store.Leaser = litefs.NewStaticLeaser(true, "localhost", "http://localhost:20202")
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	<-store.ReadyCh()
+
+	db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Compare against the database file as LiteFS may modify its header.
+	if data, err = os.ReadFile(db.DatabasePath()); err != nil {
+		t.Fatal(err)
+	}
+
+	server := http.NewServer(store, "127.0.0.1:0")
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+	t.Cleanup(func() { _ = server.Close() })
+	serverURL := fmt.Sprintf("http://127.0.0.1:%d", server.Port())
+
+	t.Run("None", func(t *testing.T) {
+		rc, err := http.NewClient().Export(context.Background(), serverURL, "sqlite.db", litefs.ExportCodecNone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = rc.Close() }()
+
+		if buf, err := io.ReadAll(rc); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf, data) {
+			t.Fatal("exported database mismatch")
+		}
+	})
+
+	t.Run("Gzip", func(t *testing.T) {
+		rc, err := http.NewClient().Export(context.Background(), serverURL, "sqlite.db", litefs.ExportCodecGzip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = rc.Close() }()
+
+		zr, err := gzip.NewReader(rc)
+		if err != nil {
+			t.Fatal(err)
+		} else if buf, err := io.ReadAll(zr); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf, data) {
+			t.Fatal("exported database mismatch")
+		}
+	})
+}
+
+func newMockLease(id string) *mock.Lease {
+	return &mock.Lease{
+		IDFunc:        func() string { return id },
+		RenewedAtFunc: func() time.Time { return time.Now() },
+		TTLFunc:       func() time.Duration { return 10 * time.Second },
+		RenewFunc:     func(ctx context.Context) error { return nil },
+		CloseFunc:     func() error { return nil },
+	}
+}
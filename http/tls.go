@@ -0,0 +1,120 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// NewTLSConfig returns a TLS configuration for both the client & server that
+// reloads the certificate & key files whenever they change on disk. If caFile
+// is specified, it is used to verify the server and the client certificates
+// are required & verified for mutual TLS.
+func NewTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		GetCertificate:       reloader.GetCertificate,
+		GetClientCertificate: reloader.GetClientCertificate,
+	}
+
+	if caFile != "" {
+		buf, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("no certificates found in ca file: %s", caFile)
+		}
+		config.RootCAs = pool
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// CertReloader holds a TLS certificate & key pair loaded from disk. The pair
+// is reloaded on the next handshake after either file's modification time
+// changes so that certificates can be rotated without restarting.
+type CertReloader struct {
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+
+	certFile string
+	keyFile  string
+}
+
+// NewCertReloader returns a new instance of CertReloader with the initial
+// certificate loaded. Returns an error if the initial load fails.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.Certificate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Certificate returns the current certificate, reloading it if the files have
+// changed. If a reload fails, the error is logged and the previously loaded
+// certificate is returned.
+func (r *CertReloader) Certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := r.latestModTime()
+	if err != nil && r.cert == nil {
+		return nil, err
+	} else if err != nil {
+		log.Printf("cannot stat tls certificate, using previous certificate: %s", err)
+		return r.cert, nil
+	} else if r.cert != nil && modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil && r.cert == nil {
+		return nil, fmt.Errorf("load tls certificate: %w", err)
+	} else if err != nil {
+		log.Printf("cannot reload tls certificate, using previous certificate: %s", err)
+		return r.cert, nil
+	}
+
+	r.cert, r.modTime = &cert, modTime
+	return r.cert, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate for servers.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate()
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate for clients.
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate()
+}
+
+// latestModTime returns the most recent modification time of the cert & key files.
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var t time.Time
+	for _, filename := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return time.Time{}, err
+		} else if fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t, nil
+}
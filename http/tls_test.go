@@ -0,0 +1,226 @@
+package http_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/superfly/litefs"
+	"github.com/superfly/litefs/http"
+)
+
+func TestCertReloader(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "node.crt"), filepath.Join(dir, "node.key")
+	ca.WriteLeaf(t, certFile, keyFile, "node0")
+
+	reloader, err := http.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert0, err := reloader.Certificate()
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := certCommonName(t, cert0), "node0"; got != want {
+		t.Fatalf("CommonName=%s, want %s", got, want)
+	}
+
+	// Rotate the certificate and ensure the new one is picked up.
+	ca.WriteLeaf(t, certFile, keyFile, "node1")
+	future := time.Now().Add(time.Minute)
+	for _, filename := range []string{certFile, keyFile} {
+		if err := os.Chtimes(filename, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cert1, err := reloader.Certificate()
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := certCommonName(t, cert1), "node1"; got != want {
+		t.Fatalf("CommonName=%s, want %s", got, want)
+	}
+
+	// Ensure the previous certificate is kept if the files become invalid.
+	if err := os.WriteFile(keyFile, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	} else if err := os.Chtimes(keyFile, future.Add(time.Minute), future.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if cert, err := reloader.Certificate(); err != nil {
+		t.Fatal(err)
+	} else if cert != cert1 {
+		t.Fatal("expected previous certificate")
+	}
+
+	t.Run("ErrNotExist", func(t *testing.T) {
+		if _, err := http.NewCertReloader(filepath.Join(dir, "no-such-file"), keyFile); !os.IsNotExist(err) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestClient_Stream_TLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	ca.WriteFile(t, filepath.Join(dir, "ca.crt"))
+	ca.WriteLeaf(t, filepath.Join(dir, "node.crt"), filepath.Join(dir, "node.key"), "localhost")
+
+	tlsConfig, err := http.NewTLSConfig(filepath.Join(dir, "node.crt"), filepath.Join(dir, "node.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := litefs.NewStore(t.TempDir(), true)
+	store.Leaser = litefs.NewStaticLeaser(true, "localhost", "https://localhost:20202")
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	<-store.ReadyCh()
+
+	server := http.NewServer(store, "127.0.0.1:0")
+	server.TLSConfig = tlsConfig
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve()
+	t.Cleanup(func() { _ = server.Close() })
+	serverURL := fmt.Sprintf("https://localhost:%d", server.Port())
+
+	t.Run("OK", func(t *testing.T) {
+		client := http.NewClient()
+		client.TLSConfig = tlsConfig
+
+		st, err := client.Stream(context.Background(), serverURL, 1, nil, litefs.StreamOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = st.Close() }()
+
+		if frame, err := litefs.ReadStreamFrame(st); err != nil {
+			t.Fatal(err)
+		} else if _, ok := frame.(*litefs.ReadyStreamFrame); !ok {
+			t.Fatalf("unexpected frame: %#v", frame)
+		}
+	})
+
+	t.Run("ErrNoClientCert", func(t *testing.T) {
+		client := http.NewClient()
+		client.TLSConfig = &tls.Config{RootCAs: tlsConfig.RootCAs}
+
+		if st, err := client.Stream(context.Background(), serverURL, 1, nil, litefs.StreamOptions{}); err == nil {
+			_ = st.Close()
+			t.Fatal("expected error")
+		}
+	})
+}
+
+// testCA is a certificate authority used for generating test certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(tb testing.TB) *testCA {
+	tb.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "litefs-test-ca"},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(1 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// WriteFile writes the CA certificate to filename in PEM format.
+func (ca *testCA) WriteFile(tb testing.TB, filename string) {
+	tb.Helper()
+	writePEM(tb, filename, "CERTIFICATE", ca.cert.Raw)
+}
+
+// WriteLeaf generates a certificate signed by the CA that is valid for both
+// client & server authentication and writes it to certFile & keyFile.
+func (ca *testCA) WriteLeaf(tb testing.TB, certFile, keyFile, commonName string) {
+	tb.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	writePEM(tb, certFile, "CERTIFICATE", der)
+	writePEM(tb, keyFile, "EC PRIVATE KEY", keyDER)
+}
+
+// certCommonName returns the subject common name of the leaf certificate.
+func certCommonName(tb testing.TB, cert *tls.Certificate) string {
+	tb.Helper()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func writePEM(tb testing.TB, filename, typ string, der []byte) {
+	tb.Helper()
+	if err := os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		tb.Fatal(err)
+	}
+}
@@ -0,0 +1,390 @@
+// Package memfs implements an in-memory litefs.FileSystem for testing.
+package memfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/superfly/litefs"
+)
+
+var _ litefs.FileSystem = (*FileSystem)(nil)
+
+// FileSystem is an in-memory file system. Paths are cleaned & treated as
+// absolute. The root directory always exists.
+type FileSystem struct {
+	mu    sync.Mutex
+	nodes map[string]*node
+}
+
+// New returns a new, empty in-memory file system.
+func New() *FileSystem {
+	return &FileSystem{
+		nodes: map[string]*node{
+			"/": {mode: fs.ModeDir | 0o777, modTime: time.Now()},
+		},
+	}
+}
+
+// node represents a file or directory.
+type node struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (n *node) isDir() bool { return n.mode.IsDir() }
+
+func clean(name string) string {
+	return filepath.Clean("/" + name)
+}
+
+func (fsys *FileSystem) Open(name string) (litefs.File, error) {
+	return fsys.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (fsys *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (litefs.File, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	name = clean(name)
+	n := fsys.nodes[name]
+	if n == nil {
+		if flag&os.O_CREATE == 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		} else if parent := fsys.nodes[filepath.Dir(name)]; parent == nil || !parent.isDir() {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		n = &node{mode: perm.Perm(), modTime: time.Now()}
+		fsys.nodes[name] = n
+	} else if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	} else if n.isDir() && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if flag&os.O_TRUNC != 0 && !n.isDir() {
+		n.data, n.modTime = nil, time.Now()
+	}
+	return &file{fsys: fsys, name: name, node: n, flag: flag}, nil
+}
+
+func (fsys *FileSystem) Rename(oldpath, newpath string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	oldpath, newpath = clean(oldpath), clean(newpath)
+	n := fsys.nodes[oldpath]
+	if n == nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	} else if parent := fsys.nodes[filepath.Dir(newpath)]; parent == nil || !parent.isDir() {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	} else if oldpath == newpath {
+		return nil
+	}
+
+	if dst := fsys.nodes[newpath]; dst != nil && dst.isDir() {
+		if !n.isDir() || fsys.hasChildren(newpath) {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrExist}
+		}
+	}
+
+	delete(fsys.nodes, oldpath)
+	fsys.nodes[newpath] = n
+
+	// Move the contents of a directory along with it.
+	if n.isDir() {
+		prefix := oldpath + "/"
+		for name, child := range fsys.nodes {
+			if strings.HasPrefix(name, prefix) {
+				delete(fsys.nodes, name)
+				fsys.nodes[newpath+"/"+strings.TrimPrefix(name, prefix)] = child
+			}
+		}
+	}
+	return nil
+}
+
+func (fsys *FileSystem) Remove(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	name = clean(name)
+	if n := fsys.nodes[name]; n == nil || name == "/" {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	} else if n.isDir() && fsys.hasChildren(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+	}
+	delete(fsys.nodes, name)
+	return nil
+}
+
+func (fsys *FileSystem) RemoveAll(path string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	path = clean(path)
+	for name := range fsys.nodes {
+		if name != "/" && (name == path || strings.HasPrefix(name, path+"/")) {
+			delete(fsys.nodes, name)
+		}
+	}
+	return nil
+}
+
+func (fsys *FileSystem) Mkdir(name string, perm os.FileMode) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	name = clean(name)
+	if fsys.nodes[name] != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	} else if parent := fsys.nodes[filepath.Dir(name)]; parent == nil || !parent.isDir() {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrNotExist}
+	}
+	fsys.nodes[name] = &node{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (fsys *FileSystem) MkdirAll(path string, perm os.FileMode) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	path = clean(path)
+	for dir, i := "", 1; i <= len(path); i++ {
+		if i < len(path) && path[i] != '/' {
+			continue
+		}
+		dir = path[:i]
+
+		if n := fsys.nodes[dir]; n == nil {
+			fsys.nodes[dir] = &node{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+		} else if !n.isDir() {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+		}
+	}
+	return nil
+}
+
+func (fsys *FileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	name = clean(name)
+	if n := fsys.nodes[name]; n == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	} else if !n.isDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	var a []os.DirEntry
+	for path, n := range fsys.nodes {
+		if path != "/" && filepath.Dir(path) == name {
+			a = append(a, fs.FileInfoToDirEntry(n.info(path)))
+		}
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].Name() < a[j].Name() })
+	return a, nil
+}
+
+func (fsys *FileSystem) Stat(name string) (os.FileInfo, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	name = clean(name)
+	n := fsys.nodes[name]
+	if n == nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return n.info(name), nil
+}
+
+func (fsys *FileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	name = clean(name)
+	n := fsys.nodes[name]
+	if n == nil {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: fs.ErrNotExist}
+	}
+	n.modTime = mtime
+	return nil
+}
+
+// SyncDir is a no-op as the file system is not durable.
+func (fsys *FileSystem) SyncDir(name string) error { return nil }
+
+// hasChildren returns true if the directory at name contains any entries.
+// Must be called while holding the lock.
+func (fsys *FileSystem) hasChildren(name string) bool {
+	for path := range fsys.nodes {
+		if path != "/" && filepath.Dir(path) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// info returns a snapshot of the node's metadata.
+func (n *node) info(path string) *fileInfo {
+	return &fileInfo{
+		name:    filepath.Base(path),
+		size:    int64(len(n.data)),
+		mode:    n.mode,
+		modTime: n.modTime,
+	}
+}
+
+// file is an open handle to a node. The handle continues to reference the
+// node's data after it is removed or renamed, similar to an OS file.
+type file struct {
+	fsys   *FileSystem
+	name   string
+	node   *node
+	flag   int
+	offset int64
+	closed bool
+}
+
+func (f *file) Name() string { return f.name }
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	} else if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	} else if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.flag&os.O_APPEND != 0 {
+		f.fsys.mu.Lock()
+		f.offset = int64(len(f.node.data))
+		f.fsys.mu.Unlock()
+	}
+
+	n, err := f.WriteAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.closed {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrClosed}
+	} else if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrPermission}
+	} else if off < 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if end := off + int64(len(p)); end > int64(len(f.node.data)) {
+		data := make([]byte, end)
+		copy(data, f.node.data)
+		f.node.data = data
+	}
+	copy(f.node.data[off:], p)
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	return f.node.info(f.name), nil
+}
+
+func (f *file) Sync() error { return nil }
+
+func (f *file) Truncate(size int64) error {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: fs.ErrInvalid}
+	} else if size <= int64(len(f.node.data)) {
+		f.node.data = f.node.data[:size:size]
+	} else {
+		data := make([]byte, size)
+		copy(data, f.node.data)
+		f.node.data = data
+	}
+	f.node.modTime = time.Now()
+	return nil
+}
+
+func (f *file) Close() error {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+// fileInfo implements os.FileInfo for a node.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() any           { return nil }
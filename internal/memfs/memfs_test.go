@@ -0,0 +1,76 @@
+package memfs_test
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/superfly/litefs/internal/memfs"
+)
+
+func TestFileSystem_OpenFile(t *testing.T) {
+	fsys := memfs.New()
+	if err := fsys.MkdirAll("/a/b", 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fsys.OpenFile("/a/b/c", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := f.WriteAt([]byte("world"), 6); err != nil {
+		t.Fatal(err)
+	} else if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fsys.OpenFile("/a/b/c", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666); !os.IsExist(err) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := fsys.Open("/a/b/x"); !os.IsNotExist(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f, err = fsys.Open("/a/b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if buf, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	} else if got, want := string(buf), "hello\x00world"; got != want {
+		t.Fatalf("data=%q, want %q", got, want)
+	}
+}
+
+func TestFileSystem_Rename(t *testing.T) {
+	fsys := memfs.New()
+	if err := fsys.MkdirAll("/a/b", 0o777); err != nil {
+		t.Fatal(err)
+	} else if f, err := fsys.OpenFile("/a/b/c", os.O_RDWR|os.O_CREATE, 0o666); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Directories are moved along with their contents.
+	if err := fsys.Rename("/a", "/x"); err != nil {
+		t.Fatal(err)
+	} else if _, err := fsys.Stat("/x/b/c"); err != nil {
+		t.Fatal(err)
+	} else if _, err := fsys.Stat("/a/b/c"); !os.IsNotExist(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Non-empty directories cannot be removed individually.
+	if err := fsys.Remove("/x/b"); err == nil {
+		t.Fatal("expected error")
+	} else if err := fsys.RemoveAll("/x"); err != nil {
+		t.Fatal(err)
+	} else if ents, err := fsys.ReadDir("/"); err != nil {
+		t.Fatal(err)
+	} else if len(ents) != 0 {
+		t.Fatalf("unexpected entries: %v", ents)
+	}
+}
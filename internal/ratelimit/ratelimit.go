@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket that limits throughput to a number of bytes per
+// second. The bucket holds up to one second of bytes so idle time allows a
+// short burst. Requests larger than the bucket are allowed to go into debt
+// and the caller waits until the debt has been repaid.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64   // bytes per second
+	tokens float64   // available bytes, negative if in debt
+	last   time.Time // last time tokens were added
+}
+
+// NewLimiter returns a new Limiter that allows bytesPerSec bytes per second.
+func NewLimiter(bytesPerSec int64) *Limiter {
+	return &Limiter{
+		rate:   float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// WaitN blocks until n bytes are allowed or until ctx is done.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	d := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-timer.C:
+		return nil
+	}
+}
+
+var _ io.Writer = (*Writer)(nil)
+
+// Writer wraps an io.Writer and limits the rate that bytes are written to it.
+type Writer struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *Limiter
+}
+
+// NewWriter returns a new Writer that writes to w at the rate of limiter.
+// Writes return an error once ctx is done.
+func NewWriter(ctx context.Context, w io.Writer, limiter *Limiter) *Writer {
+	return &Writer{ctx: ctx, w: w, limiter: limiter}
+}
+
+func (w *Writer) Write(p []byte) (n int, err error) {
+	if err := w.limiter.WaitN(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
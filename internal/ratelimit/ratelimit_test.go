@@ -0,0 +1,47 @@
+package ratelimit_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/superfly/litefs/internal/ratelimit"
+)
+
+func TestWriter(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := ratelimit.NewWriter(context.Background(), &buf, ratelimit.NewLimiter(10000))
+
+		// The first second of bytes is allowed immediately so writing two
+		// seconds worth should take about one second.
+		t0 := time.Now()
+		for i := 0; i < 20; i++ {
+			if _, err := w.Write(make([]byte, 1000)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got, want := buf.Len(), 20000; got != want {
+			t.Fatalf("len=%d, want %d", got, want)
+		} else if elapsed := time.Since(t0); elapsed < 900*time.Millisecond {
+			t.Fatalf("expected writes to be throttled, elapsed %s", elapsed)
+		}
+	})
+
+	t.Run("ContextCanceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		w := ratelimit.NewWriter(ctx, &bytes.Buffer{}, ratelimit.NewLimiter(1))
+
+		// Write more than the bucket holds so the writer has to wait.
+		if _, err := w.Write(make([]byte, 1)); err != nil {
+			t.Fatal(err)
+		}
+
+		cancel()
+		if _, err := w.Write(make([]byte, 1000)); err != context.Canceled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,315 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/superfly/litefs"
+	"github.com/superfly/ltx"
+)
+
+// DefaultRegion is the region used for signing if none is specified.
+const DefaultRegion = "us-east-1"
+
+var _ litefs.Backup = (*Client)(nil)
+
+// Client writes LTX files to an S3-compatible object store. Objects are
+// stored at "<bucket>/<path>/<db>/<ltx-filename>" using path-style URLs so
+// that it works with non-AWS providers.
+type Client struct {
+	// Base URL of the object store (e.g. "https://s3.us-east-1.amazonaws.com").
+	Endpoint string
+
+	// Bucket name & optional key prefix to write objects under.
+	Bucket string
+	Path   string
+
+	// Region used for request signing.
+	Region string
+
+	// Credentials used for request signing. Requests are sent unsigned if
+	// AccessKeyID is blank.
+	AccessKeyID     string
+	SecretAccessKey string
+
+	HTTPClient *http.Client
+
+	// Returns the current time. Used for request signing.
+	Now func() time.Time
+}
+
+// NewClient returns a new instance of Client.
+func NewClient() *Client {
+	return &Client{
+		Region:     DefaultRegion,
+		HTTPClient: http.DefaultClient,
+		Now:        time.Now,
+	}
+}
+
+// Key returns the object key for an LTX file.
+func (c *Client) Key(dbName string, hdr ltx.Header) string {
+	return c.prefix() + dbName + "/" + ltx.FormatFilename(hdr.MinTXID, hdr.MaxTXID)
+}
+
+// WriteLTX uploads the contents of an LTX file. Implements litefs.Backup.
+func (c *Client) WriteLTX(ctx context.Context, dbName string, hdr ltx.Header, r io.Reader) error {
+	// Read the file into memory so the payload can be hashed for signing.
+	// LTX files are typically small so this avoids spooling to disk.
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read ltx file: %w", err)
+	}
+
+	resp, err := c.do(ctx, "PUT", c.Key(dbName, hdr), nil, body)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// DBs returns the names of all databases in the backup. Implements litefs.Backup.
+func (c *Client) DBs(ctx context.Context) ([]string, error) {
+	prefix := c.prefix()
+
+	var names []string
+	if err := c.list(ctx, prefix, "/", func(result *listBucketResult) {
+		for _, p := range result.CommonPrefixes {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/"))
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// LTXFiles returns all LTX files for a database in ascending TXID order.
+// Objects that are not LTX files are ignored. Implements litefs.Backup.
+func (c *Client) LTXFiles(ctx context.Context, dbName string) ([]litefs.LTXFileInfo, error) {
+	prefix := c.prefix() + dbName + "/"
+
+	var infos []litefs.LTXFileInfo
+	if err := c.list(ctx, prefix, "/", func(result *listBucketResult) {
+		for _, obj := range result.Contents {
+			minTXID, maxTXID, err := ltx.ParseFilename(strings.TrimPrefix(obj.Key, prefix))
+			if err != nil {
+				continue
+			}
+			infos = append(infos, litefs.LTXFileInfo{
+				MinTXID:  minTXID,
+				MaxTXID:  maxTXID,
+				Size:     obj.Size,
+				Snapshot: minTXID == 1,
+			})
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].MinTXID != infos[j].MinTXID {
+			return infos[i].MinTXID < infos[j].MinTXID
+		}
+		return infos[i].MaxTXID < infos[j].MaxTXID
+	})
+	return infos, nil
+}
+
+// OpenLTX returns a reader for the contents of an LTX file. Implements litefs.Backup.
+func (c *Client) OpenLTX(ctx context.Context, dbName string, minTXID, maxTXID uint64) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, "GET", c.Key(dbName, ltx.Header{MinTXID: minTXID, MaxTXID: maxTXID}), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// prefix returns the key prefix for all databases, including a trailing slash.
+func (c *Client) prefix() string {
+	if p := strings.Trim(c.Path, "/"); p != "" {
+		return p + "/"
+	}
+	return ""
+}
+
+// list calls fn for each page of a bucket listing under prefix.
+func (c *Client) list(ctx context.Context, prefix, delimiter string, fn func(*listBucketResult)) error {
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {delimiter}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := c.do(ctx, "GET", "", query, nil)
+		if err != nil {
+			return err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decode list response: %w", err)
+		}
+		fn(&result)
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// do sends a signed request for an object key in the bucket. A blank key
+// refers to the bucket itself. Returns an error for non-2xx responses.
+func (c *Client) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+	u.Path = "/" + c.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	c.sign(req, body)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer func() { _ = resp.Body.Close() }()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("s3: %s %s: status=%d %s", strings.ToLower(method), u.Path, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 authorization header to req.
+func (c *Client) sign(req *http.Request, body []byte) {
+	payloadHash := sha256Hex(body)
+	t := c.Now().UTC()
+	amzDate, date := t.Format("20060102T150405Z"), t.Format("20060102")
+
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if c.AccessKeyID == "" {
+		return
+	}
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), date)
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns the query string sorted by key with keys & values
+// encoded as required by the signing process.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var a []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			a = append(a, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(a, "&")
+}
+
+// listBucketResult is the response body of a ListObjectsV2 request.
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+// uriEncode percent-encodes s as required by the signing process. All bytes
+// other than unreserved characters are encoded. Slashes are only encoded if
+// encodeSlash is true.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9'),
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			b.WriteByte(ch)
+		case ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
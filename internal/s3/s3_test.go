@@ -0,0 +1,166 @@
+package s3_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/superfly/litefs"
+	"github.com/superfly/litefs/internal/s3"
+	"github.com/superfly/ltx"
+)
+
+func TestClient_WriteLTX(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		var gotPath, gotBody, gotAuth, gotDate string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "PUT" {
+				t.Errorf("unexpected method: %s", r.Method)
+			}
+			body, _ := io.ReadAll(r.Body)
+			gotPath, gotBody = r.URL.EscapedPath(), string(body)
+			gotAuth, gotDate = r.Header.Get("Authorization"), r.Header.Get("X-Amz-Date")
+		}))
+		defer server.Close()
+
+		client := s3.NewClient()
+		client.Endpoint = server.URL
+		client.Bucket, client.Path = "mybkt", "backups"
+		client.AccessKeyID, client.SecretAccessKey = "AKID", "SECRET"
+		client.Now = func() time.Time { return time.Date(2000, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+		hdr := ltx.Header{MinTXID: 1, MaxTXID: 2}
+		if err := client.WriteLTX(context.Background(), "my db", hdr, strings.NewReader("foobar")); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := gotPath, "/mybkt/backups/my%20db/0000000000000001-0000000000000002.ltx"; got != want {
+			t.Fatalf("path=%s, want %s", got, want)
+		} else if got, want := gotBody, "foobar"; got != want {
+			t.Fatalf("body=%s, want %s", got, want)
+		} else if got, want := gotDate, "20000102T030405Z"; got != want {
+			t.Fatalf("date=%s, want %s", got, want)
+		} else if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AKID/20000102/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=") {
+			t.Fatalf("unexpected authorization: %s", gotAuth)
+		}
+	})
+
+	t.Run("Unsigned", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if v := r.Header.Get("Authorization"); v != "" {
+				t.Errorf("unexpected authorization: %s", v)
+			}
+		}))
+		defer server.Close()
+
+		client := s3.NewClient()
+		client.Endpoint, client.Bucket = server.URL, "mybkt"
+		if err := client.WriteLTX(context.Background(), "db", ltx.Header{MinTXID: 1, MaxTXID: 1}, strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrStatus", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "AccessDenied", http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		client := s3.NewClient()
+		client.Endpoint, client.Bucket = server.URL, "mybkt"
+		if err := client.WriteLTX(context.Background(), "db", ltx.Header{MinTXID: 1, MaxTXID: 1}, strings.NewReader("")); err == nil || !strings.Contains(err.Error(), "status=403 AccessDenied") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestClient_LTXFiles(t *testing.T) {
+	// Simple in-memory server that supports PUT, GET & ListObjectsV2.
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/mybkt/")
+		switch {
+		case r.Method == "PUT":
+			objects[key], _ = io.ReadAll(r.Body)
+		case r.URL.Query().Get("list-type") == "2":
+			prefix, delim := r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter")
+			seen := make(map[string]bool)
+			keys := make([]string, 0, len(objects))
+			for k := range objects {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			fmt.Fprint(w, "<ListBucketResult>")
+			for _, k := range keys {
+				if !strings.HasPrefix(k, prefix) {
+					continue
+				} else if i := strings.Index(k[len(prefix):], delim); i >= 0 {
+					if p := k[:len(prefix)+i+1]; !seen[p] {
+						seen[p] = true
+						fmt.Fprintf(w, "<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>", p)
+					}
+					continue
+				}
+				fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", k, len(objects[k]))
+			}
+			fmt.Fprint(w, "</ListBucketResult>")
+		default:
+			buf, ok := objects[key]
+			if !ok {
+				http.Error(w, "NoSuchKey", http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(buf)
+		}
+	}))
+	defer server.Close()
+
+	client := s3.NewClient()
+	client.Endpoint, client.Bucket, client.Path = server.URL, "mybkt", "backups"
+	for _, hdr := range []ltx.Header{{MinTXID: 2, MaxTXID: 3}, {MinTXID: 1, MaxTXID: 1}} {
+		if err := client.WriteLTX(context.Background(), "db1", hdr, strings.NewReader(ltx.FormatFilename(hdr.MinTXID, hdr.MaxTXID))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.WriteLTX(context.Background(), "db0", ltx.Header{MinTXID: 1, MaxTXID: 1}, strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+
+	if names, err := client.DBs(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if got, want := names, []string{"db0", "db1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("DBs=%v, want %v", got, want)
+	}
+
+	if infos, err := client.LTXFiles(context.Background(), "db1"); err != nil {
+		t.Fatal(err)
+	} else if got, want := infos, []litefs.LTXFileInfo{
+		{MinTXID: 1, MaxTXID: 1, Size: 37, Snapshot: true},
+		{MinTXID: 2, MaxTXID: 3, Size: 37},
+	}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("LTXFiles=%#v, want %#v", got, want)
+	}
+
+	rc, err := client.OpenLTX(context.Background(), "db1", 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rc.Close() }()
+	if buf, err := io.ReadAll(rc); err != nil {
+		t.Fatal(err)
+	} else if got, want := string(buf), "0000000000000002-0000000000000003.ltx"; got != want {
+		t.Fatalf("data=%s, want %s", got, want)
+	}
+
+	if _, err := client.OpenLTX(context.Background(), "db1", 4, 4); err == nil || !strings.Contains(err.Error(), "status=404") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
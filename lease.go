@@ -33,10 +33,44 @@ type Lease interface {
 	Close() error
 }
 
+// HandoffLeaser is an optional interface implemented by leasers that can
+// transfer a lease between nodes. Handoffs return ErrLeaseHandoffUnsupported
+// if the primary's lease or the target's leaser does not support them.
+type HandoffLeaser interface {
+	Leaser
+
+	// AcquireExisting acquires a lease that was handed off by the previous
+	// primary using the lease's ID.
+	AcquireExisting(ctx context.Context, leaseID string) (Lease, error)
+}
+
+// HandoffLease is an optional interface implemented by leases that can be
+// acquired by another node using a HandoffLeaser.
+type HandoffLease interface {
+	Lease
+
+	// ID returns the identifier used to hand off the lease to another node.
+	// Returns a blank string if the lease cannot be handed off.
+	ID() string
+}
+
+// leaseID returns the handoff ID of lease. Returns a blank string if the
+// lease does not support handoff.
+func leaseID(lease Lease) string {
+	if lease, ok := lease.(HandoffLease); ok {
+		return lease.ID()
+	}
+	return ""
+}
+
 // PrimaryInfo is the JSON object stored in the Consul lease value.
 type PrimaryInfo struct {
 	Hostname     string `json:"hostname"`
 	AdvertiseURL string `json:"advertise-url"`
+
+	// Candidate weight of the primary when it acquired the lease. Zero if
+	// the leaser does not report weights.
+	Weight int `json:"weight,omitempty"`
 }
 
 // Clone returns a copy of info.
@@ -48,6 +82,8 @@ func (info *PrimaryInfo) Clone() *PrimaryInfo {
 	return &other
 }
 
+var _ Leaser = (*StaticLeaser)(nil)
+
 // StaticLeaser always returns a lease to a static primary.
 type StaticLeaser struct {
 	isPrimary    bool
@@ -118,6 +154,7 @@ func (l *StaticLease) TTL() time.Duration { return staticLeaseExpiresAt.Sub(l.Re
 // Renew is a no-op.
 func (l *StaticLease) Renew(ctx context.Context) error { return nil }
 
+// Close is a no-op.
 func (l *StaticLease) Close() error { return nil }
 
 var staticLeaseExpiresAt = time.Date(3000, time.January, 1, 0, 0, 0, 0, time.UTC)
@@ -2,14 +2,21 @@ package litefs
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"log"
+	"math"
+	"sort"
 	"strconv"
+	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/superfly/ltx"
@@ -28,6 +35,7 @@ var NativeEndian = binary.LittleEndian
 var (
 	ErrDatabaseNotFound = fmt.Errorf("database not found")
 	ErrDatabaseExists   = fmt.Errorf("database already exists")
+	ErrTooManyDatabases = fmt.Errorf("too many databases")
 
 	ErrNoPrimary     = errors.New("no primary")
 	ErrPrimaryExists = errors.New("primary exists")
@@ -35,7 +43,37 @@ var (
 	ErrNoHaltPrimary = errors.New("no remote halt needed on primary node")
 
 	ErrReadOnlyReplica  = fmt.Errorf("read only replica")
+	ErrReadOnlyDatabase = fmt.Errorf("read only database")
+	ErrReadOnly         = fmt.Errorf("read only store")
 	ErrDuplicateLTXFile = fmt.Errorf("duplicate ltx file")
+
+	ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+
+	ErrNotPrimary              = errors.New("not primary")
+	ErrReplicaNotFound         = errors.New("replica not found")
+	ErrLeaseHandoffUnsupported = errors.New("lease handoff not supported")
+	ErrHandoffTimeout          = errors.New("handoff timeout")
+	ErrPreemptRejected         = errors.New("preempt rejected, candidate weight not higher than primary")
+	ErrNotCandidate            = errors.New("node is not a primary candidate")
+	ErrReplicaLagging          = errors.New("replica too far behind primary")
+
+	ErrReplicationAuth = errors.New("replication token rejected by primary")
+	ErrDuplicateNodeID = errors.New("node id already in use by another node")
+
+	ErrPosForked       = errors.New("position checksum mismatch, database history has forked")
+	ErrInvalidPosToken = errors.New("invalid position token")
+	ErrDiverged        = errors.New("database position is ahead of primary")
+
+	ErrRecoverOnPrimary = errors.New("cannot recover database on primary, demote first")
+	ErrTXIDNotRetained  = errors.New("txid not within retained ltx files")
+	ErrLTXFileNotFound  = errors.New("ltx file not found")
+
+	ErrSyncReplicationTimeout = errors.New("timed out waiting for replica acknowledgements")
+	ErrWriteLockTimeout       = errors.New("timed out acquiring database write lock")
+
+	ErrSubscriberOverflow = errors.New("subscriber dirty set limit exceeded, subscriber must be recreated")
+
+	ErrVerifyFailed = errors.New("database verification failed")
 )
 
 // SQLite constants
@@ -100,6 +138,169 @@ func (t FileType) IsValid() bool {
 	}
 }
 
+// ExportCodec represents the encoding of a database exported to external
+// tools. Unlike Store.Compress, it never applies to LTX files used for
+// replication.
+type ExportCodec int
+
+const (
+	ExportCodecNone = ExportCodec(iota)
+	ExportCodecGzip
+)
+
+// ParseExportCodec returns an export codec by name.
+func ParseExportCodec(s string) (ExportCodec, error) {
+	switch s {
+	case "", "none":
+		return ExportCodecNone, nil
+	case "gzip":
+		return ExportCodecGzip, nil
+	default:
+		return ExportCodecNone, fmt.Errorf("invalid export codec: %q", s)
+	}
+}
+
+// String returns the name of the export codec.
+func (c ExportCodec) String() string {
+	switch c {
+	case ExportCodecNone:
+		return "none"
+	case ExportCodecGzip:
+		return "gzip"
+	default:
+		return fmt.Sprintf("ExportCodec<%d>", c)
+	}
+}
+
+// NewWriter returns a writer that encodes data with the codec before writing
+// it to w. The returned writer must be closed to flush any buffered data but
+// closing it does not close w.
+func (c ExportCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case ExportCodecNone:
+		return nopWriteCloser{w}, nil
+	case ExportCodecGzip:
+		return gzip.NewWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported export codec: %s", c)
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// SyncMode determines when LTX files received from the primary are fsynced.
+type SyncMode int
+
+const (
+	// SyncFull fsyncs each LTX file & its directory before it is applied.
+	// The database can always be recovered after a crash. This is the default.
+	SyncFull = SyncMode(iota)
+
+	// SyncBatch fsyncs LTX files on an interval so that multiple applies
+	// share a single fsync. Positions acknowledged to the primary only
+	// include LTX files that have been synced, however, LTX files applied
+	// since the last sync can be lost or torn on a crash & the database may
+	// need to be re-snapshotted from the primary.
+	SyncBatch
+
+	// SyncNone never fsyncs LTX files. THIS IS NOT CRASH-SAFE. A crash can
+	// lose or corrupt any recently applied transaction & leave the database
+	// unable to open. Only use this for ephemeral replicas that are rebuilt
+	// from the primary on restart.
+	SyncNone
+)
+
+// ParseSyncMode returns a sync mode by name.
+func ParseSyncMode(s string) (SyncMode, error) {
+	switch s {
+	case "", "full":
+		return SyncFull, nil
+	case "batch":
+		return SyncBatch, nil
+	case "none":
+		return SyncNone, nil
+	default:
+		return SyncFull, fmt.Errorf("invalid sync mode: %q", s)
+	}
+}
+
+// String returns the name of the sync mode.
+func (m SyncMode) String() string {
+	switch m {
+	case SyncFull:
+		return "full"
+	case SyncBatch:
+		return "batch"
+	case SyncNone:
+		return "none"
+	default:
+		return fmt.Sprintf("SyncMode<%d>", m)
+	}
+}
+
+// DivergencePolicy determines how a replica handles a database that has
+// diverged from the primary. This occurs when an LTX file from the primary
+// does not follow the local position of the database, such as when the
+// replica is ahead of the primary after a failover to a node that did not
+// receive every transaction.
+type DivergencePolicy int
+
+const (
+	// DivergencePolicyRetry rejects the change & reconnects to the primary.
+	// A full snapshot is requested after DivergenceRetryLimit consecutive
+	// attempts fail. This is the default.
+	DivergencePolicyRetry = DivergencePolicy(iota)
+
+	// DivergencePolicyResnapshot discards local LTX files beyond the
+	// primary's position & immediately reconnects to request a full
+	// snapshot of the database. Local transactions that were not
+	// replicated are lost.
+	DivergencePolicyResnapshot
+
+	// DivergencePolicyQuarantine marks the database as quarantined, skips
+	// its changes & immediately reconnects to request a full snapshot.
+	// Other databases continue to replicate.
+	DivergencePolicyQuarantine
+
+	// DivergencePolicyHalt stops replicating the database & leaves its local
+	// state untouched so that it can be inspected & resolved manually.
+	DivergencePolicyHalt
+)
+
+// ParseDivergencePolicy returns a divergence policy by name.
+func ParseDivergencePolicy(s string) (DivergencePolicy, error) {
+	switch s {
+	case "", "retry":
+		return DivergencePolicyRetry, nil
+	case "resnapshot":
+		return DivergencePolicyResnapshot, nil
+	case "quarantine":
+		return DivergencePolicyQuarantine, nil
+	case "halt":
+		return DivergencePolicyHalt, nil
+	default:
+		return DivergencePolicyRetry, fmt.Errorf("invalid divergence policy: %q", s)
+	}
+}
+
+// String returns the name of the divergence policy.
+func (p DivergencePolicy) String() string {
+	switch p {
+	case DivergencePolicyRetry:
+		return "retry"
+	case DivergencePolicyResnapshot:
+		return "resnapshot"
+	case DivergencePolicyQuarantine:
+		return "quarantine"
+	case DivergencePolicyHalt:
+		return "halt"
+	default:
+		return fmt.Sprintf("DivergencePolicy<%d>", p)
+	}
+}
+
 // TraceLogFlags are the flags to be used with TraceLog.
 const TraceLogFlags = log.LstdFlags | log.Lmicroseconds | log.LUTC
 
@@ -126,6 +327,16 @@ func FormatNodeID(id uint64) string {
 type Pos struct {
 	TXID              uint64
 	PostApplyChecksum uint64
+	Timestamp         time.Time // commit time from the LTX header, if known
+}
+
+// NewPosFromLTX returns the position after applying an LTX file.
+func NewPosFromLTX(hdr ltx.Header, trailer ltx.Trailer) Pos {
+	pos := Pos{TXID: hdr.MaxTXID, PostApplyChecksum: trailer.PostApplyChecksum}
+	if hdr.Timestamp != 0 {
+		pos.Timestamp = time.UnixMilli(hdr.Timestamp).UTC()
+	}
+	return pos
 }
 
 // String returns a string representation of the position.
@@ -143,6 +354,9 @@ func (p Pos) MarshalJSON() ([]byte, error) {
 	var v posJSON
 	v.TXID = ltx.FormatTXID(p.TXID)
 	v.PostApplyChecksum = fmt.Sprintf("%016x", p.PostApplyChecksum)
+	if !p.Timestamp.IsZero() {
+		v.Timestamp = p.Timestamp.Format(time.RFC3339Nano)
+	}
 	return json.Marshal(v)
 }
 
@@ -159,14 +373,95 @@ func (p *Pos) UnmarshalJSON(data []byte) (err error) {
 	if p.PostApplyChecksum, err = strconv.ParseUint(v.PostApplyChecksum, 16, 64); err != nil {
 		return fmt.Errorf("cannot parse post-apply checksum: %q", v.PostApplyChecksum)
 	}
+	if v.Timestamp != "" {
+		if p.Timestamp, err = time.Parse(time.RFC3339Nano, v.Timestamp); err != nil {
+			return fmt.Errorf("cannot parse timestamp: %q", v.Timestamp)
+		}
+		p.Timestamp = p.Timestamp.UTC()
+	}
 	return nil
 }
 
 type posJSON struct {
 	TXID              string `json:"txid"`
 	PostApplyChecksum string `json:"postApplyChecksum"`
+	Timestamp         string `json:"timestamp,omitempty"`
+}
+
+// Position token format.
+const (
+	posTokenVersion = 1
+	posTokenSize    = 1 + 8 + 8 + 4 // version, txid, checksum, crc32
+)
+
+// MarshalToken returns an opaque, URL-safe encoding of the TXID & checksum
+// of the position. Clients can store it in a cookie or header and pass it
+// back through ParsePosToken() to wait for the position on another node.
+// The timestamp is not included.
+func (p Pos) MarshalToken() string {
+	b := make([]byte, posTokenSize)
+	b[0] = posTokenVersion
+	binary.BigEndian.PutUint64(b[1:9], p.TXID)
+	binary.BigEndian.PutUint64(b[9:17], p.PostApplyChecksum)
+	binary.BigEndian.PutUint32(b[17:], crc32.ChecksumIEEE(b[:17]))
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParsePosToken parses a token returned by Pos.MarshalToken(). Returns
+// ErrInvalidPosToken if the token is malformed, has an unsupported version,
+// or fails its integrity check.
+func ParsePosToken(s string) (Pos, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Pos{}, fmt.Errorf("%w: %s", ErrInvalidPosToken, err)
+	} else if len(b) == 0 {
+		return Pos{}, fmt.Errorf("%w: empty", ErrInvalidPosToken)
+	} else if b[0] != posTokenVersion {
+		return Pos{}, fmt.Errorf("%w: unsupported version %d", ErrInvalidPosToken, b[0])
+	} else if len(b) != posTokenSize {
+		return Pos{}, fmt.Errorf("%w: invalid size %d", ErrInvalidPosToken, len(b))
+	} else if crc32.ChecksumIEEE(b[:17]) != binary.BigEndian.Uint32(b[17:]) {
+		return Pos{}, fmt.Errorf("%w: checksum mismatch", ErrInvalidPosToken)
+	}
+
+	return Pos{
+		TXID:              binary.BigEndian.Uint64(b[1:9]),
+		PostApplyChecksum: binary.BigEndian.Uint64(b[9:17]),
+	}, nil
 }
 
+// PosMismatchError is returned when an LTX file received from the primary
+// cannot be applied because it does not follow the database's position.
+type PosMismatchError struct {
+	Name     string // database name
+	Pos      Pos    // current position of the database
+	Expected Pos    // position the LTX file expects to be applied to
+}
+
+func (e *PosMismatchError) Error() string {
+	return fmt.Sprintf("position mismatch on db %q: %s <> %s", e.Name, e.Pos, e.Expected)
+}
+
+// ChecksumMismatchError is returned when the checksum computed from the pages
+// of a database does not match the checksum of its current position.
+type ChecksumMismatchError struct {
+	Name     string // database name
+	Pos      Pos    // current position of the database
+	Checksum uint64 // checksum computed from the database pages
+	Pgno     uint32 // first page that does not match its tracked checksum, if any
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	s := fmt.Sprintf("%s: checksum mismatch on db %q: %016x <> %s", ErrVerifyFailed, e.Name, e.Checksum, e.Pos)
+	if e.Pgno != 0 {
+		s += fmt.Sprintf(", first mismatched page %d", e.Pgno)
+	}
+	return s
+}
+
+// Unwrap returns ErrVerifyFailed.
+func (e *ChecksumMismatchError) Unwrap() error { return ErrVerifyFailed }
+
 // Client represents a client for connecting to other LiteFS nodes.
 type Client interface {
 	// AcquireHaltLock attempts to acquire a remote halt lock on the primary node.
@@ -180,16 +475,57 @@ type Client interface {
 	Commit(ctx context.Context, primaryURL string, nodeID uint64, name string, lockID int64, r io.Reader) error
 
 	// Stream starts a long-running connection to stream changes from another node.
-	Stream(ctx context.Context, primaryURL string, nodeID uint64, posMap map[string]Pos) (io.ReadCloser, error)
+	// Returns ErrReplicationAuth if the primary rejects the replication token.
+	Stream(ctx context.Context, primaryURL string, nodeID uint64, posMap map[string]Pos, opts StreamOptions) (io.ReadCloser, error)
+
+	// Ack reports the positions that a replica has applied to the primary.
+	// Used by the primary to release writes waiting on synchronous replication.
+	Ack(ctx context.Context, primaryURL string, nodeID uint64, posMap map[string]Pos, token string) error
+
+	// Preempt asks the primary to hand off its lease to a replica with a
+	// higher candidate weight. Returns ErrPreemptRejected if the primary's
+	// weight is not lower than weight.
+	Preempt(ctx context.Context, primaryURL string, nodeID uint64, weight int, token string) error
+
+	// Handoff asks the primary to hand off its lease to the replica nodeID,
+	// regardless of candidate weight. Returns once the lease has been sent.
+	// Returns ErrReplicaLagging if the replica is too far behind.
+	Handoff(ctx context.Context, primaryURL string, nodeID uint64, token string) error
+}
+
+// StreamOptions represents options for Client.Stream(). Nodes that do not
+// support an option ignore it.
+type StreamOptions struct {
+	// Databases to request a full snapshot for instead of resuming.
+	Snapshots []string
+
+	// If non-empty, only databases matching one of the prefixes are streamed.
+	Prefixes []string
+
+	// Token sent to authenticate the replica, if non-blank.
+	Token string
+
+	// If true, the replica can acquire a lease handed off by the primary so
+	// the primary may send it a handoff frame.
+	Handoff bool
+
+	// If true, the replica understands heartbeat frames so the primary may
+	// send them while the stream is idle.
+	Heartbeat bool
 }
 
 type StreamFrameType uint32
 
 const (
-	StreamFrameTypeLTX    = StreamFrameType(1)
-	StreamFrameTypeReady  = StreamFrameType(2)
-	StreamFrameTypeEnd    = StreamFrameType(3)
-	StreamFrameTypeDropDB = StreamFrameType(4)
+	StreamFrameTypeLTX       = StreamFrameType(1)
+	StreamFrameTypeReady     = StreamFrameType(2)
+	StreamFrameTypeEnd       = StreamFrameType(3)
+	StreamFrameTypeDropDB    = StreamFrameType(4)
+	StreamFrameTypeHeartbeat = StreamFrameType(5)
+	StreamFrameTypeHandoff   = StreamFrameType(6)
+	StreamFrameTypeRenameDB  = StreamFrameType(7)
+
+	StreamFrameTypeSnapshotRequest = StreamFrameType(8)
 )
 
 type StreamFrame interface {
@@ -215,6 +551,14 @@ func ReadStreamFrame(r io.Reader) (StreamFrame, error) {
 		f = &EndStreamFrame{}
 	case StreamFrameTypeDropDB:
 		f = &DropDBStreamFrame{}
+	case StreamFrameTypeHeartbeat:
+		f = &HeartbeatStreamFrame{}
+	case StreamFrameTypeHandoff:
+		f = &HandoffStreamFrame{}
+	case StreamFrameTypeRenameDB:
+		f = &RenameDBStreamFrame{}
+	case StreamFrameTypeSnapshotRequest:
+		f = &SnapshotRequestStreamFrame{}
 	default:
 		return nil, fmt.Errorf("invalid stream frame type: 0x%02x", typ)
 	}
@@ -331,6 +675,235 @@ func (f *DropDBStreamFrame) WriteTo(w io.Writer) (int64, error) {
 	return 0, nil
 }
 
+// HeartbeatStreamFrame is sent periodically by the primary when there are no
+// other changes to send so that replicas can detect a dead connection.
+type HeartbeatStreamFrame struct {
+	Timestamp int64          // primary time, in milliseconds since epoch
+	Interval  int64          // time between heartbeats, in milliseconds
+	PosMap    map[string]Pos // current position & LTX timestamp of each database on the primary
+}
+
+// Type returns the type of stream frame.
+func (*HeartbeatStreamFrame) Type() StreamFrameType { return StreamFrameTypeHeartbeat }
+
+func (f *HeartbeatStreamFrame) ReadFrom(r io.Reader) (int64, error) {
+	if err := binary.Read(r, binary.BigEndian, &f.Timestamp); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	} else if err := binary.Read(r, binary.BigEndian, &f.Interval); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+
+	f.PosMap = make(map[string]Pos, n)
+	for i := uint32(0); i < n; i++ {
+		var nameN uint32
+		if err := binary.Read(r, binary.BigEndian, &nameN); err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+
+		name := make([]byte, nameN)
+		if _, err := io.ReadFull(r, name); err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+
+		var pos Pos
+		if err := binary.Read(r, binary.BigEndian, &pos.TXID); err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		} else if err := binary.Read(r, binary.BigEndian, &pos.PostApplyChecksum); err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+
+		var timestamp int64
+		if err := binary.Read(r, binary.BigEndian, &timestamp); err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		} else if timestamp != 0 {
+			pos.Timestamp = time.UnixMilli(timestamp).UTC()
+		}
+		f.PosMap[string(name)] = pos
+	}
+
+	return 0, nil
+}
+
+func (f *HeartbeatStreamFrame) WriteTo(w io.Writer) (int64, error) {
+	if err := binary.Write(w, binary.BigEndian, f.Timestamp); err != nil {
+		return 0, err
+	} else if err := binary.Write(w, binary.BigEndian, f.Interval); err != nil {
+		return 0, err
+	} else if err := binary.Write(w, binary.BigEndian, uint32(len(f.PosMap))); err != nil {
+		return 0, err
+	}
+
+	// Sort names for consistent output.
+	names := make([]string, 0, len(f.PosMap))
+	for name := range f.PosMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if len(name) > math.MaxUint32 {
+			return 0, fmt.Errorf("database name too long")
+		}
+
+		pos := f.PosMap[name]
+		var timestamp int64
+		if !pos.Timestamp.IsZero() {
+			timestamp = pos.Timestamp.UnixMilli()
+		}
+
+		if err := binary.Write(w, binary.BigEndian, uint32(len(name))); err != nil {
+			return 0, err
+		} else if _, err := w.Write([]byte(name)); err != nil {
+			return 0, err
+		} else if err := binary.Write(w, binary.BigEndian, pos.TXID); err != nil {
+			return 0, err
+		} else if err := binary.Write(w, binary.BigEndian, pos.PostApplyChecksum); err != nil {
+			return 0, err
+		} else if err := binary.Write(w, binary.BigEndian, timestamp); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// HandoffStreamFrame is sent by the primary to transfer its lease to the
+// receiving replica.
+type HandoffStreamFrame struct {
+	LeaseID string
+}
+
+// Type returns the type of stream frame.
+func (*HandoffStreamFrame) Type() StreamFrameType { return StreamFrameTypeHandoff }
+
+func (f *HandoffStreamFrame) ReadFrom(r io.Reader) (int64, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+
+	leaseID := make([]byte, n)
+	if _, err := io.ReadFull(r, leaseID); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+	f.LeaseID = string(leaseID)
+
+	return 0, nil
+}
+
+func (f *HandoffStreamFrame) WriteTo(w io.Writer) (int64, error) {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(f.LeaseID))); err != nil {
+		return 0, err
+	} else if _, err := w.Write([]byte(f.LeaseID)); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// RenameDBStreamFrame is sent by the primary when a database has been renamed
+// so that replicas can rename their copy instead of recreating it.
+type RenameDBStreamFrame struct {
+	OldName string // previous database name
+	NewName string // new database name
+}
+
+// Type returns the type of stream frame.
+func (*RenameDBStreamFrame) Type() StreamFrameType { return StreamFrameTypeRenameDB }
+
+func (f *RenameDBStreamFrame) ReadFrom(r io.Reader) (int64, error) {
+	for _, name := range []*string{&f.OldName, &f.NewName} {
+		var nameN uint32
+		if err := binary.Read(r, binary.BigEndian, &nameN); err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+
+		buf := make([]byte, nameN)
+		if _, err := io.ReadFull(r, buf); err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+		*name = string(buf)
+	}
+
+	return 0, nil
+}
+
+func (f *RenameDBStreamFrame) WriteTo(w io.Writer) (int64, error) {
+	for _, name := range []string{f.OldName, f.NewName} {
+		if err := binary.Write(w, binary.BigEndian, uint32(len(name))); err != nil {
+			return 0, err
+		} else if _, err := w.Write([]byte(name)); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// SnapshotRequestStreamFrame is sent by a replica after its position map when
+// connecting to request a full snapshot of a database. This is used when the
+// replica's copy has diverged and cannot be caught up incrementally.
+type SnapshotRequestStreamFrame struct {
+	Name string // database name
+}
+
+// Type returns the type of stream frame.
+func (*SnapshotRequestStreamFrame) Type() StreamFrameType { return StreamFrameTypeSnapshotRequest }
+
+func (f *SnapshotRequestStreamFrame) ReadFrom(r io.Reader) (int64, error) {
+	var nameN uint32
+	if err := binary.Read(r, binary.BigEndian, &nameN); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+
+	name := make([]byte, nameN)
+	if _, err := io.ReadFull(r, name); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+	f.Name = string(name)
+
+	return 0, nil
+}
+
+func (f *SnapshotRequestStreamFrame) WriteTo(w io.Writer) (int64, error) {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(f.Name))); err != nil {
+		return 0, err
+	} else if _, err := w.Write([]byte(f.Name)); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
 // Invalidator is a callback for the store to use to invalidate the kernel page cache.
 type Invalidator interface {
 	InvalidateDB(db *DB) error
@@ -626,6 +1199,10 @@ func ParseSHMLockRange(start, end uint64) []LockType {
 type GuardSet struct {
 	owner uint64
 
+	// Error to report on the owner's next lock request.
+	mu  sync.Mutex
+	err error
+
 	// Database file locks
 	pending  RWMutexGuard
 	shared   RWMutexGuard
@@ -643,6 +1220,22 @@ type GuardSet struct {
 	dms     RWMutexGuard
 }
 
+// setErr sets an error to be returned on the owner's next lock request.
+func (s *GuardSet) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// takeErr returns & clears the pending error, if any.
+func (s *GuardSet) takeErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.err
+	s.err = nil
+	return err
+}
+
 // Pending returns a reference to the PENDING mutex guard.
 func (s *GuardSet) Pending() *RWMutexGuard { return &s.pending }
 
@@ -782,6 +1375,21 @@ func readSQLiteDatabaseHeader(r io.Reader) (hdr sqliteDatabaseHeader, data []byt
 	return hdr, b, nil
 }
 
+// sqliteDatabasePageN returns the page count from the database header. Legacy
+// SQLite versions do not maintain the in-header page count so the count is
+// derived from the file size, as SQLite does, if it is unset.
+func sqliteDatabasePageN(f File, hdr sqliteDatabaseHeader) (uint32, error) {
+	if hdr.PageN != 0 || !ltx.IsValidPageSize(hdr.PageSize) {
+		return hdr.PageN, nil
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return uint32(fi.Size() / int64(hdr.PageSize)), nil
+}
+
 // encodePageSize returns sz as a uint16. If sz is 64K, it returns 1.
 func encodePageSize(sz uint32) uint16 {
 	if sz == 65536 {
@@ -2,12 +2,17 @@ package litefs_test
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/superfly/litefs"
 )
@@ -37,6 +42,93 @@ func TestFileType_IsValid(t *testing.T) {
 	})
 }
 
+func TestParseSyncMode(t *testing.T) {
+	for _, tt := range []struct {
+		s string
+		m litefs.SyncMode
+	}{
+		{"", litefs.SyncFull},
+		{"full", litefs.SyncFull},
+		{"batch", litefs.SyncBatch},
+		{"none", litefs.SyncNone},
+	} {
+		if m, err := litefs.ParseSyncMode(tt.s); err != nil {
+			t.Fatal(err)
+		} else if m != tt.m {
+			t.Fatalf("ParseSyncMode(%q)=%s, want %s", tt.s, m, tt.m)
+		}
+	}
+
+	if _, err := litefs.ParseSyncMode("async"); err == nil || err.Error() != `invalid sync mode: "async"` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestParseExportCodec(t *testing.T) {
+	for _, tt := range []struct {
+		s string
+		c litefs.ExportCodec
+	}{
+		{"", litefs.ExportCodecNone},
+		{"none", litefs.ExportCodecNone},
+		{"gzip", litefs.ExportCodecGzip},
+	} {
+		if c, err := litefs.ParseExportCodec(tt.s); err != nil {
+			t.Fatal(err)
+		} else if c != tt.c {
+			t.Fatalf("ParseExportCodec(%q)=%s, want %s", tt.s, c, tt.c)
+		}
+	}
+
+	if _, err := litefs.ParseExportCodec("lz4"); err == nil || err.Error() != `invalid export codec: "lz4"` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestExportCodec_NewWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := litefs.ExportCodecGzip.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := w.Write([]byte("foo")); err != nil {
+		t.Fatal(err)
+	} else if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if b, err := io.ReadAll(zr); err != nil {
+		t.Fatal(err)
+	} else if got, want := string(b), "foo"; got != want {
+		t.Fatalf("data=%q, want %q", got, want)
+	}
+}
+
+func TestParseDivergencePolicy(t *testing.T) {
+	for _, tt := range []struct {
+		s string
+		p litefs.DivergencePolicy
+	}{
+		{"", litefs.DivergencePolicyRetry},
+		{"retry", litefs.DivergencePolicyRetry},
+		{"resnapshot", litefs.DivergencePolicyResnapshot},
+		{"quarantine", litefs.DivergencePolicyQuarantine},
+		{"halt", litefs.DivergencePolicyHalt},
+	} {
+		if p, err := litefs.ParseDivergencePolicy(tt.s); err != nil {
+			t.Fatal(err)
+		} else if p != tt.p {
+			t.Fatalf("ParseDivergencePolicy(%q)=%s, want %s", tt.s, p, tt.p)
+		}
+	}
+
+	if _, err := litefs.ParseDivergencePolicy("snapshot"); err == nil || err.Error() != `invalid divergence policy: "snapshot"` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 func TestPos_IsZero(t *testing.T) {
 	if !(litefs.Pos{}).IsZero() {
 		t.Fatal("expected true")
@@ -57,6 +149,13 @@ func TestPos_MarshalJSON(t *testing.T) {
 	} else if got, want := string(data), `{"Pos":{"txid":"00000000000004d2","postApplyChecksum":"0000000000000064"}}`; got != want {
 		t.Fatalf("Marshal=%s, want %s", got, want)
 	}
+
+	pos := litefs.Pos{TXID: 1234, PostApplyChecksum: 100, Timestamp: time.UnixMilli(1700000000123).UTC()}
+	if data, err := json.Marshal(T{Pos: pos}); err != nil {
+		t.Fatal(err)
+	} else if got, want := string(data), `{"Pos":{"txid":"00000000000004d2","postApplyChecksum":"0000000000000064","timestamp":"2023-11-14T22:13:20.123Z"}}`; got != want {
+		t.Fatalf("Marshal=%s, want %s", got, want)
+	}
 }
 
 func TestPos_UnmarshalJSON(t *testing.T) {
@@ -70,6 +169,61 @@ func TestPos_UnmarshalJSON(t *testing.T) {
 	} else if got, want := v.Pos, (litefs.Pos{TXID: 1234, PostApplyChecksum: 100}); got != want {
 		t.Fatalf("Unmarshal=%s, want %s", got, want)
 	}
+
+	data = []byte(`{"Pos":{"txid":"00000000000004d2","postApplyChecksum":"0000000000000064","timestamp":"2023-11-14T22:13:20.123Z"}}`)
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	} else if got, want := v.Pos, (litefs.Pos{TXID: 1234, PostApplyChecksum: 100, Timestamp: time.UnixMilli(1700000000123).UTC()}); got != want {
+		t.Fatalf("Unmarshal=%s, want %s", got, want)
+	}
+}
+
+func TestPos_MarshalToken(t *testing.T) {
+	pos := litefs.Pos{TXID: 1234, PostApplyChecksum: 0x8000000000000064, Timestamp: time.UnixMilli(1700000000123).UTC()}
+	token := pos.MarshalToken()
+	if strings.ContainsAny(token, "+/=") {
+		t.Fatalf("expected url-safe token: %s", token)
+	}
+
+	if other, err := litefs.ParsePosToken(token); err != nil {
+		t.Fatal(err)
+	} else if got, want := other, (litefs.Pos{TXID: 1234, PostApplyChecksum: 0x8000000000000064}); got != want {
+		t.Fatalf("Pos=%s, want %s", got, want)
+	}
+}
+
+func TestParsePosToken(t *testing.T) {
+	token := litefs.Pos{TXID: 1234, PostApplyChecksum: 100}.MarshalToken()
+
+	t.Run("ErrEncoding", func(t *testing.T) {
+		if _, err := litefs.ParsePosToken("!!"); !errors.Is(err, litefs.ErrInvalidPosToken) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("ErrEmpty", func(t *testing.T) {
+		if _, err := litefs.ParsePosToken(""); !errors.Is(err, litefs.ErrInvalidPosToken) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("ErrVersion", func(t *testing.T) {
+		b, _ := base64.RawURLEncoding.DecodeString(token)
+		b[0] = 2
+		if _, err := litefs.ParsePosToken(base64.RawURLEncoding.EncodeToString(b)); err == nil || err.Error() != "invalid position token: unsupported version 2" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("ErrSize", func(t *testing.T) {
+		if _, err := litefs.ParsePosToken(token[:len(token)-2]); !errors.Is(err, litefs.ErrInvalidPosToken) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("ErrTampered", func(t *testing.T) {
+		b, _ := base64.RawURLEncoding.DecodeString(token)
+		b[8]++ // increment txid
+		if _, err := litefs.ParsePosToken(base64.RawURLEncoding.EncodeToString(b)); err == nil || err.Error() != "invalid position token: checksum mismatch" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
 }
 
 func TestReadWriteStreamFrame(t *testing.T) {
@@ -100,6 +254,69 @@ func TestReadWriteStreamFrame(t *testing.T) {
 		}
 	})
 
+	t.Run("HeartbeatStreamFrame", func(t *testing.T) {
+		frame := &litefs.HeartbeatStreamFrame{
+			Timestamp: 1000,
+			Interval:  500,
+			PosMap: map[string]litefs.Pos{
+				"a.db": {TXID: 1, PostApplyChecksum: 0x1111, Timestamp: time.UnixMilli(2000).UTC()},
+				"b.db": {TXID: 2, PostApplyChecksum: 0x2222},
+			},
+		}
+
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+		if other, err := litefs.ReadStreamFrame(&buf); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(frame, other) {
+			t.Fatalf("got %#v, want %#v", frame, other)
+		}
+	})
+
+	t.Run("HandoffStreamFrame", func(t *testing.T) {
+		frame := &litefs.HandoffStreamFrame{LeaseID: "lease1"}
+
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+		if other, err := litefs.ReadStreamFrame(&buf); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(frame, other) {
+			t.Fatalf("got %#v, want %#v", frame, other)
+		}
+	})
+
+	t.Run("RenameDBStreamFrame", func(t *testing.T) {
+		frame := &litefs.RenameDBStreamFrame{OldName: "app_v2", NewName: "app_v3"}
+
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+		if other, err := litefs.ReadStreamFrame(&buf); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(frame, other) {
+			t.Fatalf("got %#v, want %#v", frame, other)
+		}
+	})
+
+	t.Run("SnapshotRequestStreamFrame", func(t *testing.T) {
+		frame := &litefs.SnapshotRequestStreamFrame{Name: "test.db"}
+
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+		if other, err := litefs.ReadStreamFrame(&buf); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(frame, other) {
+			t.Fatalf("got %#v, want %#v", frame, other)
+		}
+	})
+
 	t.Run("ErrEOF", func(t *testing.T) {
 		if _, err := litefs.ReadStreamFrame(bytes.NewReader(nil)); err == nil || err != io.EOF {
 			t.Fatalf("unexpected error: %#v", err)
@@ -159,6 +376,22 @@ func TestLTXStreamFrame_WriteTo(t *testing.T) {
 	})
 }
 
+func TestHeartbeatStreamFrame_ReadFrom(t *testing.T) {
+	t.Run("ErrUnexpectedEOF", func(t *testing.T) {
+		frame := &litefs.HeartbeatStreamFrame{Timestamp: 1000, PosMap: map[string]litefs.Pos{"test.db": {TXID: 1}}}
+		var buf bytes.Buffer
+		if _, err := frame.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < buf.Len(); i++ {
+			var other litefs.HeartbeatStreamFrame
+			if _, err := other.ReadFrom(bytes.NewReader(buf.Bytes()[:i])); err != io.ErrUnexpectedEOF {
+				t.Fatalf("expected error at %d bytes: %s", i, err)
+			}
+		}
+	})
+}
+
 func TestReadyStreamFrame_ReadFrom(t *testing.T) {
 	t.Run("ErrUnexpectedEOF", func(t *testing.T) {
 		frame := &litefs.ReadyStreamFrame{}
@@ -0,0 +1,34 @@
+package mock
+
+import (
+	"context"
+	"io"
+
+	"github.com/superfly/litefs"
+	"github.com/superfly/ltx"
+)
+
+var _ litefs.Backup = (*Backup)(nil)
+
+type Backup struct {
+	WriteLTXFunc func(ctx context.Context, dbName string, hdr ltx.Header, r io.Reader) error
+	DBsFunc      func(ctx context.Context) ([]string, error)
+	LTXFilesFunc func(ctx context.Context, dbName string) ([]litefs.LTXFileInfo, error)
+	OpenLTXFunc  func(ctx context.Context, dbName string, minTXID, maxTXID uint64) (io.ReadCloser, error)
+}
+
+func (b *Backup) WriteLTX(ctx context.Context, dbName string, hdr ltx.Header, r io.Reader) error {
+	return b.WriteLTXFunc(ctx, dbName, hdr, r)
+}
+
+func (b *Backup) DBs(ctx context.Context) ([]string, error) {
+	return b.DBsFunc(ctx)
+}
+
+func (b *Backup) LTXFiles(ctx context.Context, dbName string) ([]litefs.LTXFileInfo, error) {
+	return b.LTXFilesFunc(ctx, dbName)
+}
+
+func (b *Backup) OpenLTX(ctx context.Context, dbName string, minTXID, maxTXID uint64) (io.ReadCloser, error) {
+	return b.OpenLTXFunc(ctx, dbName, minTXID, maxTXID)
+}
@@ -13,7 +13,10 @@ type Client struct {
 	AcquireHaltLockFunc func(ctx context.Context, primaryURL string, nodeID uint64, name string, lockID int64) (*litefs.HaltLock, error)
 	ReleaseHaltLockFunc func(ctx context.Context, primaryURL string, nodeID uint64, name string, lockID int64) error
 	CommitFunc          func(ctx context.Context, primaryURL string, nodeID uint64, name string, lockID int64, r io.Reader) error
-	StreamFunc          func(ctx context.Context, primaryURL string, nodeID uint64, posMap map[string]litefs.Pos) (io.ReadCloser, error)
+	StreamFunc          func(ctx context.Context, primaryURL string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error)
+	AckFunc             func(ctx context.Context, primaryURL string, nodeID uint64, posMap map[string]litefs.Pos, token string) error
+	PreemptFunc         func(ctx context.Context, primaryURL string, nodeID uint64, weight int, token string) error
+	HandoffFunc         func(ctx context.Context, primaryURL string, nodeID uint64, token string) error
 }
 
 func (c *Client) AcquireHaltLock(ctx context.Context, primaryURL string, nodeID uint64, name string, lockID int64) (*litefs.HaltLock, error) {
@@ -28,6 +31,18 @@ func (c *Client) Commit(ctx context.Context, primaryURL string, nodeID uint64, n
 	return c.CommitFunc(ctx, primaryURL, nodeID, name, lockID, r)
 }
 
-func (c *Client) Stream(ctx context.Context, primaryURL string, nodeID uint64, posMap map[string]litefs.Pos) (io.ReadCloser, error) {
-	return c.StreamFunc(ctx, primaryURL, nodeID, posMap)
+func (c *Client) Stream(ctx context.Context, primaryURL string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+	return c.StreamFunc(ctx, primaryURL, nodeID, posMap, opts)
+}
+
+func (c *Client) Ack(ctx context.Context, primaryURL string, nodeID uint64, posMap map[string]litefs.Pos, token string) error {
+	return c.AckFunc(ctx, primaryURL, nodeID, posMap, token)
+}
+
+func (c *Client) Preempt(ctx context.Context, primaryURL string, nodeID uint64, weight int, token string) error {
+	return c.PreemptFunc(ctx, primaryURL, nodeID, weight, token)
+}
+
+func (c *Client) Handoff(ctx context.Context, primaryURL string, nodeID uint64, token string) error {
+	return c.HandoffFunc(ctx, primaryURL, nodeID, token)
 }
@@ -7,13 +7,14 @@ import (
 	"github.com/superfly/litefs"
 )
 
-var _ litefs.Leaser = (*Leaser)(nil)
+var _ litefs.HandoffLeaser = (*Leaser)(nil)
 
 type Leaser struct {
-	CloseFunc        func() error
-	AdvertiseURLFunc func() string
-	AcquireFunc      func(ctx context.Context) (litefs.Lease, error)
-	PrimaryInfoFunc  func(ctx context.Context) (litefs.PrimaryInfo, error)
+	CloseFunc           func() error
+	AdvertiseURLFunc    func() string
+	AcquireFunc         func(ctx context.Context) (litefs.Lease, error)
+	AcquireExistingFunc func(ctx context.Context, leaseID string) (litefs.Lease, error)
+	PrimaryInfoFunc     func(ctx context.Context) (litefs.PrimaryInfo, error)
 }
 
 func (l *Leaser) Close() error {
@@ -28,19 +29,28 @@ func (l *Leaser) Acquire(ctx context.Context) (litefs.Lease, error) {
 	return l.AcquireFunc(ctx)
 }
 
+func (l *Leaser) AcquireExisting(ctx context.Context, leaseID string) (litefs.Lease, error) {
+	return l.AcquireExistingFunc(ctx, leaseID)
+}
+
 func (l *Leaser) PrimaryInfo(ctx context.Context) (litefs.PrimaryInfo, error) {
 	return l.PrimaryInfoFunc(ctx)
 }
 
-var _ litefs.Lease = (*Lease)(nil)
+var _ litefs.HandoffLease = (*Lease)(nil)
 
 type Lease struct {
+	IDFunc        func() string
 	RenewedAtFunc func() time.Time
 	TTLFunc       func() time.Duration
 	RenewFunc     func(ctx context.Context) error
 	CloseFunc     func() error
 }
 
+func (l *Lease) ID() string {
+	return l.IDFunc()
+}
+
 func (l *Lease) RenewedAt() time.Time {
 	return l.RenewedAtFunc()
 }
@@ -0,0 +1,509 @@
+package quorum
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/superfly/litefs"
+)
+
+// Default lease settings.
+const (
+	DefaultTTL            = 10 * time.Second
+	DefaultRequestTimeout = 2 * time.Second
+)
+
+var _ litefs.HandoffLeaser = (*Leaser)(nil)
+
+// Leaser elects a primary among a fixed set of peers without an external
+// lease store. A node holds the lease while a majority of peers have granted
+// it their vote. Each peer votes for at most one lease at a time & its vote
+// expires after the TTL unless renewed, so two nodes can never both hold a
+// majority. Votes are exchanged over the LiteFS HTTP API so the Leaser must
+// be registered as the server's lease handler.
+type Leaser struct {
+	hostname     string
+	advertiseURL string
+	peers        []string // advertise URLs of all other nodes
+
+	mu        sync.Mutex
+	grant     *grant    // vote granted by this node, if any
+	createdAt time.Time // votes are withheld for one TTL after start
+
+	// TTL is the time until a vote expires. Must be the same on all nodes.
+	TTL time.Duration
+
+	// Maximum time to wait for a single peer to respond.
+	RequestTimeout time.Duration
+
+	// Shared secret sent with vote requests & required on incoming ones.
+	// Blank disables authentication.
+	Token string
+
+	// Candidate weight advertised to peers in vote requests.
+	Weight int
+
+	HTTPClient *http.Client
+}
+
+// NewLeaser returns a new instance of Leaser. The peers list contains the
+// advertise URLs of every node in the cluster, including this one.
+func NewLeaser(peers []string, hostname, advertiseURL string) *Leaser {
+	l := &Leaser{
+		hostname:       hostname,
+		advertiseURL:   advertiseURL,
+		TTL:            DefaultTTL,
+		RequestTimeout: DefaultRequestTimeout,
+		HTTPClient:     http.DefaultClient,
+		createdAt:      time.Now(),
+	}
+
+	for _, peer := range peers {
+		if normalizeURL(peer) != normalizeURL(advertiseURL) {
+			l.peers = append(l.peers, peer)
+		}
+	}
+	return l
+}
+
+// Open validates the leaser configuration.
+func (l *Leaser) Open() error {
+	if l.hostname == "" {
+		return fmt.Errorf("must specify a hostname for this node")
+	} else if l.advertiseURL == "" {
+		return fmt.Errorf("must specify an advertise URL for this node")
+	} else if len(l.peers) == 0 {
+		return fmt.Errorf("must specify at least one other peer")
+	}
+	return nil
+}
+
+// Close is a no-op.
+func (l *Leaser) Close() (err error) {
+	return nil
+}
+
+// Hostname returns the hostname for this node.
+func (l *Leaser) Hostname() string {
+	return l.hostname
+}
+
+// AdvertiseURL returns the URL being advertised to nodes when primary.
+func (l *Leaser) AdvertiseURL() string {
+	return l.advertiseURL
+}
+
+// Peers returns the advertise URLs of the other nodes in the cluster.
+func (l *Leaser) Peers() []string {
+	return l.peers
+}
+
+// Majority returns the number of votes required to hold the lease.
+func (l *Leaser) Majority() int {
+	return (len(l.peers)+1)/2 + 1
+}
+
+// Acquire attempts to obtain votes from a majority of peers.
+// Returns ErrPrimaryExists if a peer has voted for another node.
+func (l *Leaser) Acquire(ctx context.Context) (litefs.Lease, error) {
+	id, err := newLeaseID()
+	if err != nil {
+		return nil, err
+	}
+
+	lease := newLease(l, id)
+	if err := lease.Renew(ctx); err != nil {
+		_ = lease.Close() // release any partial votes so another node can win
+		if err == litefs.ErrLeaseExpired {
+			return nil, litefs.ErrPrimaryExists
+		}
+		return nil, err
+	}
+	return lease, nil
+}
+
+// AcquireExisting obtains votes for a lease that was handed off by the
+// previous primary. Peers accept the new holder as the lease ID is unchanged.
+func (l *Leaser) AcquireExisting(ctx context.Context, leaseID string) (litefs.Lease, error) {
+	lease := newLease(l, leaseID)
+	if err := lease.Renew(ctx); err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// PrimaryInfo returns the node that the most reachable peers have voted for.
+// Returns ErrNoPrimary if no reachable peer has an unexpired vote.
+func (l *Leaser) PrimaryInfo(ctx context.Context) (litefs.PrimaryInfo, error) {
+	type candidate struct {
+		info litefs.PrimaryInfo
+		n    int
+	}
+	candidates := make(map[string]*candidate)
+
+	add := func(g *grantJSON) {
+		if g == nil || normalizeURL(g.AdvertiseURL) == normalizeURL(l.advertiseURL) {
+			return
+		}
+		c := candidates[g.LeaseID]
+		if c == nil {
+			c = &candidate{info: litefs.PrimaryInfo{Hostname: g.Hostname, AdvertiseURL: g.AdvertiseURL, Weight: g.Weight}}
+			candidates[g.LeaseID] = c
+		}
+		c.n++
+	}
+
+	add(l.currentGrant())
+	for _, resp := range l.broadcast(ctx, "GET", "/lease/primary", nil) {
+		if resp.err != nil {
+			continue
+		}
+		var body primaryResponse
+		if err := json.Unmarshal(resp.body, &body); err != nil {
+			continue
+		}
+		add(body.Grant)
+	}
+
+	var best *candidate
+	for _, c := range candidates {
+		if best == nil || c.n > best.n {
+			best = c
+		}
+	}
+	if best == nil {
+		return litefs.PrimaryInfo{}, litefs.ErrNoPrimary
+	}
+	return best.info, nil
+}
+
+// ServeHTTP handles vote requests from other peers.
+func (l *Leaser) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !l.isValidToken(r) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/lease/vote":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req voteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, l.vote(&req))
+
+	case "/lease/release":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req releaseRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		l.release(req.LeaseID)
+
+	case "/lease/primary":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, &primaryResponse{Grant: l.currentGrant()})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// vote grants this node's vote to the requested lease if it has not voted
+// for a different lease that is still unexpired.
+func (l *Leaser) vote(req *voteRequest) *voteResponse {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Votes are not persisted so a restarted node may have voted for another
+	// lease before it stopped. Wait until any such vote would have expired.
+	now := time.Now()
+	if now.Sub(l.createdAt) < l.TTL {
+		return &voteResponse{}
+	}
+
+	if g := l.grant; g != nil && g.leaseID != req.LeaseID && now.Before(g.expiresAt) {
+		return &voteResponse{Holder: g.json()}
+	}
+
+	// Expiration is measured from receipt so it always ends after the
+	// holder's own view of the lease, which starts before the request is sent.
+	l.grant = &grant{
+		leaseID:      req.LeaseID,
+		hostname:     req.Hostname,
+		advertiseURL: req.AdvertiseURL,
+		weight:       req.Weight,
+		expiresAt:    now.Add(time.Duration(req.TTL) * time.Millisecond),
+	}
+	return &voteResponse{Granted: true}
+}
+
+// release removes this node's vote if it was granted to leaseID.
+func (l *Leaser) release(leaseID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.grant != nil && l.grant.leaseID == leaseID {
+		l.grant = nil
+	}
+}
+
+// currentGrant returns this node's unexpired vote, if any.
+func (l *Leaser) currentGrant() *grantJSON {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.grant == nil || !time.Now().Before(l.grant.expiresAt) {
+		return nil
+	}
+	return l.grant.json()
+}
+
+// isValidToken returns true if the request's bearer token matches the
+// leaser's token. Always returns true if no token is required.
+func (l *Leaser) isValidToken(r *http.Request) bool {
+	if l.Token == "" {
+		return true
+	}
+
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, prefix)), []byte(l.Token)) == 1
+}
+
+// broadcast sends a request to all peers concurrently and waits for every
+// response or for the request timeout to elapse.
+func (l *Leaser) broadcast(ctx context.Context, method, path string, body any) []*peerResponse {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			panic(err) // request types always marshal
+		}
+	}
+
+	resps := make([]*peerResponse, len(l.peers))
+	var wg sync.WaitGroup
+	for i, peer := range l.peers {
+		i, peer := i, peer
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := l.do(ctx, method, peer, path, data)
+			resps[i] = &peerResponse{peer: peer, body: b, err: err}
+		}()
+	}
+	wg.Wait()
+	return resps
+}
+
+// do sends a single request to a peer and returns the response body.
+func (l *Leaser) do(ctx context.Context, method, peer, path string, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.RequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, normalizeURL(peer)+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if l.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+l.Token)
+	}
+
+	resp, err := l.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("invalid response: code=%d", resp.StatusCode)
+	}
+	return b, nil
+}
+
+var _ litefs.HandoffLease = (*Lease)(nil)
+
+// Lease represents a set of votes held from a majority of peers.
+type Lease struct {
+	leaser    *Leaser
+	id        string
+	renewedAt time.Time
+}
+
+func newLease(leaser *Leaser, id string) *Lease {
+	return &Lease{leaser: leaser, id: id}
+}
+
+// ID returns the lease ID that peers have voted for.
+func (l *Lease) ID() string { return l.id }
+
+// TTL returns the time-to-live of the leaser's votes.
+func (l *Lease) TTL() time.Duration { return l.leaser.TTL }
+
+// RenewedAt returns the time that the last successful round of votes started.
+func (l *Lease) RenewedAt() time.Time { return l.renewedAt }
+
+// Renew requests a new vote from every peer. Returns ErrLeaseExpired if
+// peers have voted for another lease and a majority could not be obtained.
+func (l *Lease) Renew(ctx context.Context) error {
+	// Votes are counted from the start of the round so the lease always
+	// expires locally before it expires on any peer.
+	startedAt := time.Now()
+
+	req := &voteRequest{
+		LeaseID:      l.id,
+		Hostname:     l.leaser.hostname,
+		AdvertiseURL: l.leaser.advertiseURL,
+		Weight:       l.leaser.Weight,
+		TTL:          l.leaser.TTL.Milliseconds(),
+	}
+
+	var n int
+	var holder *grantJSON
+	if resp := l.leaser.vote(req); resp.Granted {
+		n++
+	} else {
+		holder = resp.Holder
+	}
+
+	for _, resp := range l.leaser.broadcast(ctx, "POST", "/lease/vote", req) {
+		if resp.err != nil {
+			continue
+		}
+		var body voteResponse
+		if err := json.Unmarshal(resp.body, &body); err != nil {
+			continue
+		} else if body.Granted {
+			n++
+		} else if holder == nil {
+			holder = body.Holder
+		}
+	}
+
+	if majority := l.leaser.Majority(); n < majority {
+		if holder != nil {
+			return litefs.ErrLeaseExpired
+		}
+		return fmt.Errorf("no quorum: %d of %d votes, %d required", n, len(l.leaser.peers)+1, majority)
+	}
+
+	l.renewedAt = startedAt
+	return nil
+}
+
+// Close releases the votes held by the lease.
+func (l *Lease) Close() error {
+	l.leaser.release(l.id)
+
+	for _, resp := range l.leaser.broadcast(context.Background(), "POST", "/lease/release", &releaseRequest{LeaseID: l.id}) {
+		if resp.err != nil {
+			log.Printf("cannot release quorum vote: peer=%s err=%s", resp.peer, resp.err)
+		}
+	}
+	return nil
+}
+
+// grant represents a vote granted by this node.
+type grant struct {
+	leaseID      string
+	hostname     string
+	advertiseURL string
+	weight       int
+	expiresAt    time.Time
+}
+
+func (g *grant) json() *grantJSON {
+	return &grantJSON{
+		LeaseID:      g.leaseID,
+		Hostname:     g.hostname,
+		AdvertiseURL: g.advertiseURL,
+		Weight:       g.weight,
+	}
+}
+
+type grantJSON struct {
+	LeaseID      string `json:"leaseID"`
+	Hostname     string `json:"hostname"`
+	AdvertiseURL string `json:"advertiseURL"`
+	Weight       int    `json:"weight,omitempty"`
+}
+
+type voteRequest struct {
+	LeaseID      string `json:"leaseID"`
+	Hostname     string `json:"hostname"`
+	AdvertiseURL string `json:"advertiseURL"`
+	Weight       int    `json:"weight,omitempty"`
+	TTL          int64  `json:"ttl"` // milliseconds
+}
+
+type voteResponse struct {
+	Granted bool       `json:"granted"`
+	Holder  *grantJSON `json:"holder,omitempty"`
+}
+
+type releaseRequest struct {
+	LeaseID string `json:"leaseID"`
+}
+
+type primaryResponse struct {
+	Grant *grantJSON `json:"grant"`
+}
+
+type peerResponse struct {
+	peer string
+	body []byte
+	err  error
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("cannot write quorum response: %s", err)
+	}
+}
+
+// normalizeURL removes trailing slashes so URLs can be compared.
+func normalizeURL(s string) string {
+	return strings.TrimRight(s, "/")
+}
+
+// newLeaseID returns a random lease identifier.
+func newLeaseID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", fmt.Errorf("generate lease id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
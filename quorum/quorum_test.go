@@ -0,0 +1,183 @@
+package quorum_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/superfly/litefs"
+	"github.com/superfly/litefs/quorum"
+)
+
+const testTTL = 200 * time.Millisecond
+
+func TestLeaser_Acquire(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		leasers, _ := newCluster(t, 3)
+		leasers[0].Weight = 75
+
+		lease, err := leasers[0].Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = lease.Close() }()
+
+		if _, err := leasers[1].Acquire(context.Background()); err != litefs.ErrPrimaryExists {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if info, err := leasers[2].PrimaryInfo(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if got, want := info.AdvertiseURL, leasers[0].AdvertiseURL(); got != want {
+			t.Fatalf("AdvertiseURL=%s, want %s", got, want)
+		} else if got, want := info.Weight, 75; got != want {
+			t.Fatalf("Weight=%d, want %d", got, want)
+		}
+	})
+
+	// Ensure a node can acquire the lease once the previous holder releases it.
+	t.Run("AfterClose", func(t *testing.T) {
+		leasers, _ := newCluster(t, 3)
+
+		lease, err := leasers[0].Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		} else if err := lease.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := leasers[2].PrimaryInfo(context.Background()); err != litefs.ErrNoPrimary {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := leasers[1].Acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// Ensure a lease cannot be acquired without a majority of peers.
+	t.Run("ErrNoQuorum", func(t *testing.T) {
+		leasers, servers := newCluster(t, 3)
+		servers[1].Close()
+		servers[2].Close()
+
+		if _, err := leasers[0].Acquire(context.Background()); err == nil || err == litefs.ErrPrimaryExists {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Ensure restarted nodes do not vote until earlier votes have expired.
+	t.Run("WithholdVotesOnStart", func(t *testing.T) {
+		leasers, _ := newClusterNoWait(t, 3)
+		if _, err := leasers[0].Acquire(context.Background()); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("ErrInvalidToken", func(t *testing.T) {
+		leasers, _ := newCluster(t, 3)
+		leasers[1].Token, leasers[2].Token = "secret", "secret"
+
+		if _, err := leasers[0].Acquire(context.Background()); err == nil || err == litefs.ErrPrimaryExists {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestLease_Renew(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		leasers, _ := newCluster(t, 3)
+
+		lease, err := leasers[0].Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		renewedAt := lease.RenewedAt()
+
+		// Renewing past the original TTL keeps other nodes from acquiring.
+		time.Sleep(testTTL / 2)
+		if err := lease.Renew(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if !lease.RenewedAt().After(renewedAt) {
+			t.Fatal("expected renewal time to advance")
+		}
+		time.Sleep(testTTL / 2)
+
+		if _, err := leasers[1].Acquire(context.Background()); err != litefs.ErrPrimaryExists {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Ensure the lease is lost once a majority has voted for another node.
+	t.Run("ErrLeaseExpired", func(t *testing.T) {
+		leasers, _ := newCluster(t, 3)
+
+		lease, err := leasers[0].Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(testTTL)
+		if _, err := leasers[1].Acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := lease.Renew(context.Background()); err != litefs.ErrLeaseExpired {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestLeaser_AcquireExisting(t *testing.T) {
+	leasers, _ := newCluster(t, 3)
+
+	lease, err := leasers[0].Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := leasers[1].AcquireExisting(context.Background(), lease.(*quorum.Lease).ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := leasers[2].PrimaryInfo(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if got, want := info.AdvertiseURL, leasers[1].AdvertiseURL(); got != want {
+		t.Fatalf("AdvertiseURL=%s, want %s", got, want)
+	}
+}
+
+// newCluster returns n leasers that are connected to each other and are
+// past their initial vote-withholding period.
+func newCluster(tb testing.TB, n int) ([]*quorum.Leaser, []*httptest.Server) {
+	leasers, servers := newClusterNoWait(tb, n)
+	time.Sleep(testTTL)
+	return leasers, servers
+}
+
+func newClusterNoWait(tb testing.TB, n int) ([]*quorum.Leaser, []*httptest.Server) {
+	tb.Helper()
+
+	leasers := make([]*quorum.Leaser, n)
+	servers := make([]*httptest.Server, n)
+	urls := make([]string, n)
+	for i := range servers {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			leasers[i].ServeHTTP(w, r)
+		}))
+		tb.Cleanup(servers[i].Close)
+		urls[i] = servers[i].URL
+	}
+
+	for i := range leasers {
+		leasers[i] = quorum.NewLeaser(urls, "localhost", urls[i])
+		leasers[i].TTL = testTTL
+		leasers[i].RequestTimeout = testTTL
+		if err := leasers[i].Open(); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return leasers, servers
+}
@@ -6,21 +6,25 @@ import (
 	crand "crypto/rand"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"expvar"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/superfly/litefs/internal"
 	"github.com/superfly/litefs/internal/chunk"
 	"github.com/superfly/ltx"
 	"golang.org/x/sync/errgroup"
@@ -28,20 +32,61 @@ import (
 
 // Default store settings.
 const (
-	DefaultReconnectDelay = 1 * time.Second
-	DefaultDemoteDelay    = 10 * time.Second
+	DefaultReconnectDelay    = 1 * time.Second
+	DefaultMaxReconnectDelay = 30 * time.Second
+	DefaultReconnectJitter   = 0.5
+	DefaultDemoteDelay       = 10 * time.Second
 
 	DefaultRetention                = 10 * time.Minute
 	DefaultRetentionMonitorInterval = 1 * time.Minute
 
+	DefaultLTXHeaderCacheSize = 1024
+
+	DefaultCompactionMinFiles    = 10
+	DefaultCompactionMaxFileSize = 1 << 20 // 1MB
+
 	DefaultHaltAcquireTimeout      = 5 * time.Second
 	DefaultHaltLockTTL             = 30 * time.Second
 	DefaultHaltLockMonitorInterval = 5 * time.Second
 
-	DefaultBeginTimeout = 30 * time.Second
+	DefaultHeartbeatInterval  = 1 * time.Second
+	DefaultHeartbeatMissLimit = 3
+
+	DefaultHandoffMaxLag  = 1000
+	DefaultHandoffTimeout = 10 * time.Second
+
+	DefaultPrimaryInfoMaxAge = 5 * time.Minute
+
+	// Number of consecutive position mismatches on a database before a
+	// replica using DivergencePolicyRetry requests a full snapshot.
+	DivergenceRetryLimit = 3
+
+	DefaultSnapshotRequestInterval = 10 * time.Second
+
+	DefaultSyncTimeout = 5 * time.Second
+
+	DefaultSyncBatchInterval = 100 * time.Millisecond
+
+	DefaultEventBufferSize = 1024
+
+	DefaultSubscriberDirtySetLimit = 10000
+
+	DefaultOpenConcurrency = 8
+
+	DefaultCandidateWeight = MaxCandidateWeight
+
+	DefaultFileMode os.FileMode = 0666
+	DefaultDirMode  os.FileMode = 0777
+
+	// Time to wait before reconnecting after the primary rejects our token.
+	// This is longer than the reconnect delay as it requires a config change.
+	DefaultAuthFailureDelay = 30 * time.Second
 )
 
-var ErrStoreClosed = fmt.Errorf("store closed")
+var (
+	ErrStoreClosed   = fmt.Errorf("store closed")
+	ErrStoreDraining = fmt.Errorf("store draining")
+)
 
 // Store represents a collection of databases.
 type Store struct {
@@ -52,12 +97,57 @@ type Store struct {
 	dbs         map[string]*DB
 	subscribers map[*Subscriber]struct{}
 
+	eventSubscribers map[*EventSubscriber]struct{} // transaction event listeners
+
+	leadershipChs map[<-chan bool]chan bool // primary status change listeners
+
+	draining  atomic.Bool // if true, new write transactions are rejected
+	promoting atomic.Bool // if true, the election delay is skipped
+
+	posMismatchN      map[string]int      // consecutive position mismatches, by database
+	snapshotRequested map[string]struct{} // databases that diverged from the primary
+
+	// Last snapshot request honored for each replica & database, if primary.
+	snapshotRequestTimes map[snapshotRequestKey]time.Time
+
+	backupCh chan backupRequest // LTX files waiting to be written to Backup
+
+	acks map[uint64]map[string]Pos // positions applied by each replica, if primary
+
+	retentionOverrides map[string]time.Duration // copy of RetentionOverrides made on open
+
+	replicaContactAt atomic.Int64  // unix nanoseconds of last request from a replica
+	ackCh            chan struct{} // closed & replaced when an ack is received
+
+	// LTX files written but not yet fsynced when SyncMode is SyncBatch.
+	ltxSync struct {
+		mu       sync.Mutex
+		pending  *ltxSyncBatch // written since the last batch sync began
+		inflight *ltxSyncBatch // currently being synced
+		ch       chan struct{} // closed & replaced when a batch sync completes
+	}
+
+	// Replicated frames are spooled instead of applied while paused.
+	replicationPause struct {
+		mu     sync.Mutex
+		paused bool
+		frames []pausedFrame // spooled frames, in the order received
+		seq    int           // sequence used for spooled LTX filenames
+	}
+
+	cachedPrimaryInfo *PrimaryInfo // last known primary, loaded on open
+	reconnectAttempts int          // consecutive reconnects, only used by monitorLease()
+
 	isPrimary   bool          // if true, store is current primary
 	primaryCh   chan struct{} // closed when primary loses leadership
+	relayCh     chan struct{} // closed when a relay stops relaying from upstream
 	primaryInfo *PrimaryInfo  // contains info about the current primary
+	lease       Lease         // current lease, if primary
+	handoffCh   chan struct{} // receives when lease has been handed off
 	candidate   bool          // if true, we are eligible to become the primary
 	readyCh     chan struct{} // closed when primary found or acquired
 	demoteCh    chan struct{} // closed when Demote() is called
+	promoteCh   chan struct{} // closed when Promote() is called
 
 	ctx    context.Context
 	cancel context.CancelCauseFunc
@@ -71,11 +161,29 @@ type Store struct {
 	// Leaser manages the lease that controls leader election.
 	Leaser Leaser
 
+	// Storage used for the data directory. Defaults to the host's file
+	// system. Must be set before the store is opened.
+	FS FileSystem
+
+	// Determines when LTX files received from the primary are fsynced. See
+	// SyncMode for the durability tradeoffs of each mode.
+	SyncMode SyncMode
+
+	// Time between fsyncs when SyncMode is SyncBatch.
+	SyncBatchInterval time.Duration
+
 	// If true, LTX files are compressed using LZ4.
 	Compress bool
 
-	// Time to wait after disconnecting from the primary to reconnect.
-	ReconnectDelay time.Duration
+	// Time to wait after disconnecting from the primary to reconnect. The
+	// delay doubles with each consecutive failed attempt, with jitter, up to
+	// MaxReconnectDelay. Backoff is disabled if MaxReconnectDelay is zero.
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+
+	// Fraction of the reconnect delay, between zero and one, that is
+	// randomized so that nodes do not reconnect in lockstep.
+	ReconnectJitter float64
 
 	// Time to wait after manually demoting trying to become primary again.
 	DemoteDelay time.Duration
@@ -84,6 +192,74 @@ type Store struct {
 	Retention                time.Duration
 	RetentionMonitorInterval time.Duration
 
+	// Per-database retention durations that override Retention. The map is
+	// copied when the store is opened so later changes are ignored.
+	RetentionOverrides map[string]time.Duration
+
+	// Minimum number of LTX files to retain per database, regardless of age.
+	// The latest LTX file is always retained.
+	RetentionMinCount int
+
+	// Maximum number of LTX files to retain per database, regardless of age.
+	// If zero, files are only removed based on Retention.
+	RetentionMaxFiles int
+
+	// Maximum number of LTX file headers cached in memory per database. The
+	// headers are read when checking the checksum of a past position.
+	// Must be set before databases are opened. Zero disables the cache.
+	LTXHeaderCacheSize int
+
+	// If true, retention enforcement first verifies that each database's LTX
+	// files form a contiguous chain up to its current position. Gaps are
+	// logged & counted and no files are removed from a database with a gap
+	// so that an overly aggressive deletion cannot orphan later files.
+	RetentionSafetyCheck bool
+
+	// Interval between compactions of LTX files. Each contiguous run of at
+	// least CompactionMinFiles incremental LTX files below the current
+	// position is merged into a single LTX file so that replicas apply fewer
+	// files to catch up. Files larger than CompactionMaxFileSize are left
+	// as-is. Replicas positioned within a compacted range receive a snapshot
+	// instead. Zero disables compaction.
+	CompactionInterval    time.Duration
+	CompactionMinFiles    int
+	CompactionMaxFileSize int64
+
+	// If true, LTX files below each database's current position are rewritten
+	// with LZ4 compression after retention is enforced. The latest LTX file is
+	// kept uncompressed so it can be applied & streamed quickly. This has no
+	// effect on files that are already compressed, such as when Compress is
+	// set.
+	CompressAtRest bool
+
+	// Interval between background checksum verifications. One database is
+	// verified per interval so that large fleets do not saturate disk IO.
+	// Mismatches are logged & counted. Zero disables scrubbing.
+	ScrubInterval time.Duration
+
+	// If true, a database file that does not match the checksum of its latest
+	// LTX file on open, such as one torn by a crash, is rebuilt by replaying
+	// its retained LTX files. Otherwise, the database fails to open.
+	RepairOnOpen bool
+
+	// If true, the node never acquires the primary lease & rejects all
+	// application writes with ErrReadOnly, including writes that would be
+	// forwarded to the primary. This is stricter than a non-candidate node.
+	ReadOnly bool
+
+	// Names of databases that reject application writes, even on the
+	// primary. Changes are still replicated & can be imported. See DB.ReadOnly.
+	ReadOnlyDBs []string
+
+	// Names of databases that are only kept on the primary & are never
+	// streamed to replicas. See DB.NoReplicate.
+	NoReplicateDBs []string
+
+	// Maximum number of databases in the store. Creating a database past the
+	// limit returns ErrTooManyDatabases and replicas skip frames for new
+	// databases. Existing databases are still opened. Zero disables the limit.
+	MaxDatabases int
+
 	// Time to wait to acquire the write lock after acquiring the HALT.
 	HaltAcquireTimeout time.Duration
 
@@ -91,12 +267,157 @@ type Store struct {
 	HaltLockTTL             time.Duration
 	HaltLockMonitorInterval time.Duration
 
-	// Transaction timeouts.
+	// Maximum time to wait for a database write lock before failing with
+	// ErrWriteLockTimeout. Can be overridden per database. Defaults to zero
+	// which waits indefinitely.
 	BeginTimeout time.Duration
 
+	// Interval between heartbeats sent by the primary when the stream is idle.
+	// Heartbeats are only sent to replicas that advertise support for them.
+	// Replicas disconnect after HeartbeatMissLimit of the primary's intervals
+	// pass without a frame once the primary has sent its first heartbeat.
+	HeartbeatInterval  time.Duration
+	HeartbeatMissLimit int
+
+	// Time a replica waits for a frame from the primary before treating the
+	// connection as dead & reconnecting. Defaults to the primary's heartbeat
+	// interval multiplied by HeartbeatMissLimit when zero.
+	StreamTimeout time.Duration
+
+	// Shared secret required for replicas to stream from the primary. If set,
+	// the primary rejects streams with a different token and the replica
+	// sends it when connecting. Blank disables authentication.
+	ReplicationToken string
+
+	// Optional filter used by replicas to only replicate a subset of databases.
+	// Frames for databases that do not match are read & discarded. Cannot be
+	// used on a primary candidate as it would not have every database.
+	ReplicationFilter func(name string) bool
+
+	// Database name prefixes that a replica replicates. These are sent to the
+	// primary so it does not stream other databases. Empty replicates all.
+	// Cannot be used on a primary candidate as it would not have every database.
+	ReplicationPrefixes []string
+
+	// Maximum number of bytes per second written to each replica stream by
+	// the primary. Zero is unlimited.
+	MaxReplicationBytesPerSec int64
+
+	// If true, a replica serves streams to other replicas once it has caught
+	// up with its own upstream node. This allows replicas to be chained so
+	// that distant replicas do not all stream from the primary.
+	Relay bool
+
+	// URL of a relay to stream changes from instead of the primary. The
+	// primary is used if the relay cannot be reached.
+	UpstreamURL string
+
+	// Maximum number of transactions that a replica can be behind on any
+	// database for it to be the target of a handoff. Zero disables the limit.
+	HandoffMaxLag uint64
+
+	// Maximum replication lag for a replica to be reported as ready by
+	// Health(). Zero disables the limit.
+	HealthMaxLag time.Duration
+
+	// Maximum age of the last known primary info persisted to disk for it to
+	// be used when reconnecting after a restart. Zero disables the cache.
+	PrimaryInfoMaxAge time.Duration
+
+	// Permissions used when creating files & directories in the data
+	// directory. These are still subject to the process umask. The FUSE
+	// mount reports the same permissions, without write bits on replicas.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// Off-site destination for LTX files. If set, every LTX file written to
+	// disk is sent to the backup in the background. Files already listed in
+	// the backup are not sent again. Failures are logged and counted but do
+	// not stop replication.
+	Backup Backup
+
+	// If true, LTX files are not removed by retention enforcement until they
+	// have been successfully written to Backup.
+	RetentionRequireBackup bool
+
+	// Minimum time between snapshot requests honored for the same replica &
+	// database. Requests within this interval are ignored & the database is
+	// streamed incrementally. Zero disables the limit.
+	SnapshotRequestInterval time.Duration
+
+	// Determines how a replica handles an LTX file from the primary that
+	// does not follow the local position of the database, including when
+	// the database is ahead of the primary.
+	OnDivergence DivergencePolicy
+
+	// If set, the primary demotes itself if it has neither renewed its lease
+	// nor received a request from a replica within this duration. This limits
+	// how long a partitioned primary accepts writes. Replica contact is only
+	// counted for inbound stream connections & acks as outbound writes can
+	// succeed on a partitioned connection. Zero disables the timeout.
+	PrimaryIsolationTimeout time.Duration
+
+	// Maximum time to wait for the target replica to catch up during a
+	// handoff. The handoff is aborted and the node remains primary if the
+	// replica has not caught up in time. Writes are blocked while waiting so
+	// the wait is always bounded; DefaultHandoffTimeout is used if zero.
+	HandoffTimeout time.Duration
+
+	// Number of replicas that must acknowledge applying a transaction before
+	// a write on the primary completes. Replicas only send acknowledgements
+	// if this is also set on them. Zero disables synchronous replication.
+	//
+	// In rollback journal mode, a failed wait is returned when the journal is
+	// deleted so SQLite reports the commit as failed. In WAL mode, SQLite has
+	// already committed & ignores unlock errors so the error is returned by
+	// the unlock & again by the writer's next write lock request. The WAL
+	// write lock is released before waiting so other writers can proceed.
+	SyncReplicas int
+
+	// Maximum time a write waits for replica acknowledgements before it
+	// fails with ErrSyncReplicationTimeout. Zero waits indefinitely.
+	SyncTimeout time.Duration
+
+	// Number of events buffered for each event subscriber before the
+	// subscriber is sent a resync event instead.
+	EventBufferSize int
+
+	// Maximum number of databases that are opened, recovered, or have
+	// retention enforced in parallel. Values less than one are treated as one.
+	OpenConcurrency int
+
+	// Maximum number of databases tracked by each replica stream's dirty set
+	// before it is coalesced into a full resync. Zero is unlimited.
+	SubscriberDirtySetLimit int
+
+	// Optional callback invoked after each transaction is applied to a
+	// database, whether committed locally or received from the primary.
+	// It is called synchronously while the database write lock is held so it
+	// must return quickly or dispatch the work asynchronously.
+	OnApply func(name string, pos Pos)
+
+	// Optional callbacks invoked after a database is added to or removed from
+	// the store, whether locally or by replication. A renamed database is
+	// reported as a drop of the old name followed by a create of the new name.
+	// They are called outside the store lock so they may call back into the
+	// store. They are not invoked for databases loaded when the store opens.
+	OnDBCreated func(name string)
+	OnDBDropped func(name string)
+
+	// Preference of this node to become primary. Lower weight candidates
+	// wait before acquiring the lease so higher weight candidates win the
+	// election. A replica connected to a primary with a lower weight asks it
+	// to hand off once caught up. A weight of zero never becomes primary.
+	CandidateWeight int
+
 	// Callback to notify kernel of file changes.
 	Invalidator Invalidator
 
+	// If set, lease & replication state changes are logged as structured
+	// records with stable field names. Otherwise, they are written to the
+	// standard logger.
+	Logger *slog.Logger
+
 	// If true, computes and verifies the checksum of the entire database
 	// after every transaction. Should only be used during testing.
 	StrictVerify bool
@@ -106,27 +427,73 @@ type Store struct {
 func NewStore(path string, candidate bool) *Store {
 	primaryCh := make(chan struct{})
 	close(primaryCh)
+	relayCh := make(chan struct{})
+	close(relayCh)
 
 	s := &Store{
 		path: path,
+		FS:   OSFileSystem{},
 
 		dbs: make(map[string]*DB),
 
-		subscribers: make(map[*Subscriber]struct{}),
-		candidate:   candidate,
-		primaryCh:   primaryCh,
-		readyCh:     make(chan struct{}),
-		demoteCh:    make(chan struct{}),
-
-		ReconnectDelay: DefaultReconnectDelay,
-		DemoteDelay:    DefaultDemoteDelay,
+		subscribers:       make(map[*Subscriber]struct{}),
+		eventSubscribers:  make(map[*EventSubscriber]struct{}),
+		leadershipChs:     make(map[<-chan bool]chan bool),
+		posMismatchN:      make(map[string]int),
+		snapshotRequested: make(map[string]struct{}),
+		backupCh:          make(chan backupRequest, backupQueueSize),
+		acks:              make(map[uint64]map[string]Pos),
+		ackCh:             make(chan struct{}),
+		candidate:         candidate,
+		primaryCh:         primaryCh,
+		relayCh:           relayCh,
+		readyCh:           make(chan struct{}),
+		demoteCh:          make(chan struct{}),
+		promoteCh:         make(chan struct{}),
+		handoffCh:         make(chan struct{}),
+
+		snapshotRequestTimes: make(map[snapshotRequestKey]time.Time),
+
+		ReconnectDelay:    DefaultReconnectDelay,
+		MaxReconnectDelay: DefaultMaxReconnectDelay,
+		ReconnectJitter:   DefaultReconnectJitter,
+		DemoteDelay:       DefaultDemoteDelay,
 
 		Retention:                DefaultRetention,
 		RetentionMonitorInterval: DefaultRetentionMonitorInterval,
+		LTXHeaderCacheSize:       DefaultLTXHeaderCacheSize,
+
+		CompactionMinFiles:    DefaultCompactionMinFiles,
+		CompactionMaxFileSize: DefaultCompactionMaxFileSize,
 
 		HaltAcquireTimeout:      DefaultHaltAcquireTimeout,
 		HaltLockTTL:             DefaultHaltLockTTL,
 		HaltLockMonitorInterval: DefaultHaltLockMonitorInterval,
+
+		HeartbeatInterval:  DefaultHeartbeatInterval,
+		HeartbeatMissLimit: DefaultHeartbeatMissLimit,
+
+		HandoffMaxLag:  DefaultHandoffMaxLag,
+		HandoffTimeout: DefaultHandoffTimeout,
+
+		PrimaryInfoMaxAge: DefaultPrimaryInfoMaxAge,
+
+		SnapshotRequestInterval: DefaultSnapshotRequestInterval,
+
+		SyncTimeout: DefaultSyncTimeout,
+
+		SyncBatchInterval: DefaultSyncBatchInterval,
+
+		EventBufferSize: DefaultEventBufferSize,
+
+		SubscriberDirtySetLimit: DefaultSubscriberDirtySetLimit,
+
+		OpenConcurrency: DefaultOpenConcurrency,
+
+		CandidateWeight: DefaultCandidateWeight,
+
+		FileMode: DefaultFileMode,
+		DirMode:  DefaultDirMode,
 	}
 	s.ctx, s.cancel = context.WithCancelCause(context.Background())
 	s.logPrefix.Store("")
@@ -158,13 +525,30 @@ func (s *Store) LogPrefix() string {
 	return s.logPrefix.Load().(string)
 }
 
+// logEvent logs a lease or replication state change. If Logger is set, the
+// message is logged with the node ID, event name & additional attributes.
+// Otherwise, it is written to the standard logger prefixed by the node ID.
+func (s *Store) logEvent(level slog.Level, event string, attrs []any, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if s.Logger == nil {
+		log.Printf("%s: %s", FormatNodeID(s.id), msg)
+		return
+	}
+	s.Logger.Log(context.Background(), level, msg, append([]any{"node_id", FormatNodeID(s.id), "event", event}, attrs...)...)
+}
+
 // Open initializes the store based on files in the data directory.
 func (s *Store) Open() error {
 	if s.Leaser == nil {
 		return fmt.Errorf("leaser required")
 	}
 
-	if err := os.MkdirAll(s.path, 0777); err != nil {
+	// A partial replica would lose the filtered databases if it became primary.
+	if s.Candidate() && (len(s.ReplicationPrefixes) > 0 || s.ReplicationFilter != nil) {
+		return fmt.Errorf("replication prefixes & filter cannot be used on a primary candidate")
+	}
+
+	if err := s.FS.MkdirAll(s.path, s.DirMode); err != nil {
 		return err
 	}
 
@@ -172,10 +556,30 @@ func (s *Store) Open() error {
 		return fmt.Errorf("init node id: %w", err)
 	}
 
+	// Copy the retention overrides so that the caller's map is not read
+	// concurrently by the retention monitor.
+	s.mu.Lock()
+	s.retentionOverrides = make(map[string]time.Duration, len(s.RetentionOverrides))
+	for name, d := range s.RetentionOverrides {
+		s.retentionOverrides[name] = d
+	}
+	s.mu.Unlock()
+
+	if err := s.initPrimaryInfo(); err != nil {
+		return fmt.Errorf("init primary info: %w", err)
+	}
+
 	if err := s.openDatabases(); err != nil {
 		return fmt.Errorf("open databases: %w", err)
 	}
 
+	// Rebuild databases from the backup if the node starts with no data.
+	if s.Backup != nil && len(s.dbs) == 0 {
+		if err := s.RestoreFromBackup(s.ctx); err != nil {
+			return fmt.Errorf("restore from backup: %w", err)
+		}
+	}
+
 	// Begin background replication monitor.
 	s.g.Go(func() error { return s.monitorLease(s.ctx) })
 
@@ -187,6 +591,29 @@ func (s *Store) Open() error {
 		s.g.Go(func() error { return s.monitorRetention(s.ctx) })
 	}
 
+	// Begin compaction monitor.
+	if s.CompactionInterval > 0 {
+		s.g.Go(func() error { return s.monitorCompaction(s.ctx) })
+	}
+
+	// Begin scrub monitor.
+	if s.ScrubInterval > 0 {
+		s.g.Go(func() error { return s.monitorScrub(s.ctx) })
+	}
+
+	// Begin backup monitor.
+	if s.Backup != nil {
+		s.g.Go(func() error { return s.monitorBackup(s.ctx) })
+	}
+
+	// Begin LTX sync monitor.
+	switch s.SyncMode {
+	case SyncBatch:
+		s.g.Go(func() error { return s.monitorLTXSync(s.ctx) })
+	case SyncNone:
+		log.Printf("WARNING: sync mode is %q, replicated LTX files are not fsynced & this node is NOT crash-safe", s.SyncMode)
+	}
+
 	return nil
 }
 
@@ -195,7 +622,7 @@ func (s *Store) initID() error {
 	filename := filepath.Join(s.path, "id")
 
 	// Read existing ID from file, if it exists.
-	if buf, err := os.ReadFile(filename); err != nil && !os.IsNotExist(err) {
+	if buf, err := readFile(s.FS, filename); err != nil && !os.IsNotExist(err) {
 		return err
 	} else if err == nil {
 		str := string(bytes.TrimSpace(buf))
@@ -210,13 +637,42 @@ func (s *Store) initID() error {
 	}
 
 	// Generate a new node ID if file doesn't exist.
+	return s.generateID()
+}
+
+// ResetID generates a new node ID and overwrites the "id" file. This is used
+// when a node's data directory was cloned from another node, in which case
+// both nodes would otherwise advertise the same ID. Must be called before Open().
+func (s *Store) ResetID() error {
+	if s.id != 0 {
+		return fmt.Errorf("cannot reset node id while store is open")
+	}
+
+	if err := s.FS.MkdirAll(s.path, s.DirMode); err != nil {
+		return err
+	}
+
+	if err := s.generateID(); err != nil {
+		return err
+	}
+	log.Printf("%s: node id reset", FormatNodeID(s.id))
+
+	// Clear the ID so the store can still be opened afterward.
+	s.id = 0
+	return nil
+}
+
+// generateID generates a random node ID and writes it to the "id" file.
+func (s *Store) generateID() error {
+	filename := filepath.Join(s.path, "id")
+
 	b := make([]byte, 16)
 	if _, err := io.ReadFull(crand.Reader, b); err != nil {
 		return fmt.Errorf("generate id: %w", err)
 	}
 	id := binary.BigEndian.Uint64(b)
 
-	f, err := os.Create(filename)
+	f, err := s.FS.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.FileMode)
 	if err != nil {
 		return err
 	}
@@ -236,38 +692,146 @@ func (s *Store) initID() error {
 	return nil
 }
 
+// PrimaryInfoPath returns the path to the file that caches the last known primary.
+func (s *Store) PrimaryInfoPath() string {
+	return filepath.Join(s.path, "primary")
+}
+
+// primaryInfoCache is the on-disk representation of the last known primary.
+type primaryInfoCache struct {
+	PrimaryInfo
+	Timestamp time.Time `json:"timestamp"` // last time primary was known to be reachable
+}
+
+// initPrimaryInfo loads the last known primary from disk so the store can
+// reconnect to it without waiting on the leaser. Stale entries are ignored.
+func (s *Store) initPrimaryInfo() error {
+	if s.PrimaryInfoMaxAge <= 0 {
+		return nil
+	}
+
+	buf, err := readFile(s.FS, s.PrimaryInfoPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var cache primaryInfoCache
+	if err := json.Unmarshal(buf, &cache); err != nil {
+		log.Printf("%s: ignoring invalid primary info cache: %s", FormatNodeID(s.id), err)
+		return nil
+	} else if age := time.Since(cache.Timestamp); age > s.PrimaryInfoMaxAge {
+		log.Printf("%s: ignoring stale primary info cache: hostname=%s age=%s", FormatNodeID(s.id), cache.Hostname, age.Truncate(time.Second))
+		return nil
+	}
+
+	s.cachedPrimaryInfo = &cache.PrimaryInfo
+	return nil
+}
+
+// writePrimaryInfo persists info as the last known primary.
+func (s *Store) writePrimaryInfo(info *PrimaryInfo) error {
+	if s.PrimaryInfoMaxAge <= 0 {
+		return nil
+	}
+
+	buf, err := json.Marshal(primaryInfoCache{PrimaryInfo: *info, Timestamp: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.PrimaryInfoPath() + ".tmp"
+	if err := writeFile(s.FS, tmpPath, buf, s.FileMode); err != nil {
+		return err
+	}
+	return s.FS.Rename(tmpPath, s.PrimaryInfoPath())
+}
+
 func (s *Store) openDatabases() error {
-	if err := os.MkdirAll(s.DBDir(), 0777); err != nil {
+	if err := s.FS.MkdirAll(s.DBDir(), s.DirMode); err != nil {
 		return err
 	}
 
-	fis, err := os.ReadDir(s.DBDir())
+	fis, err := s.FS.ReadDir(s.DBDir())
 	if err != nil {
 		return fmt.Errorf("readdir: %w", err)
 	}
+
+	// Open databases in parallel. Remaining databases are skipped after the
+	// first error.
+	var mu sync.Mutex
+	g, ctx := s.newDBGroup(context.Background())
 	for _, fi := range fis {
-		if err := s.openDatabase(fi.Name()); err != nil {
-			return fmt.Errorf("open database(%q): %w", fi.Name(), err)
-		}
+		name := fi.Name()
+		g.Go(func() error {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			db, err := s.openDatabase(name)
+			if err != nil {
+				return fmt.Errorf("open database(%q): %w", name, err)
+			}
+
+			// Add to internal lookups.
+			mu.Lock()
+			defer mu.Unlock()
+			s.dbs[db.Name()] = db
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
 	}
 
 	// Update metrics.
 	storeDBCountMetric.Set(float64(len(s.dbs)))
+	storeMaxDBCountMetric.Set(float64(s.MaxDatabases))
 
 	return nil
 }
 
-func (s *Store) openDatabase(name string) error {
+// checkMaxDatabases returns ErrTooManyDatabases if adding a database would
+// exceed MaxDatabases.
+func (s *Store) checkMaxDatabases() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkMaxDatabasesNoLock()
+}
+
+// checkMaxDatabasesNoLock returns ErrTooManyDatabases if adding a database
+// would exceed MaxDatabases. Must hold s.mu.
+func (s *Store) checkMaxDatabasesNoLock() error {
+	if s.MaxDatabases > 0 && len(s.dbs) >= s.MaxDatabases {
+		return ErrTooManyDatabases
+	}
+	return nil
+}
+
+func (s *Store) openDatabase(name string) (*DB, error) {
 	// Instantiate and open database.
 	db := NewDB(s, name, s.DBPath(name))
 	if err := db.Open(); err != nil {
-		return err
+		return nil, err
 	}
+	return db, nil
+}
 
-	// Add to internal lookups.
-	s.dbs[db.Name()] = db
+// newDBGroup returns a group that runs up to OpenConcurrency per-database
+// tasks at a time. The returned context is canceled on the first error.
+func (s *Store) newDBGroup(ctx context.Context) (*errgroup.Group, context.Context) {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(s.openConcurrency())
+	return g, ctx
+}
 
-	return nil
+// openConcurrency returns the effective number of databases processed in parallel.
+func (s *Store) openConcurrency() int {
+	if s.OpenConcurrency < 1 {
+		return 1
+	}
+	return s.OpenConcurrency
 }
 
 // Close signals for the store to shut down.
@@ -292,12 +856,164 @@ func (s *Store) Close() (retErr error) {
 	return retErr
 }
 
+// Drain stops the store from accepting new write transactions and waits for
+// in-flight writes & HALT locks to complete. If the store is primary, it also
+// waits for replicas to receive all transactions and then releases its lease.
+// Returns an error if ctx is done before the store has drained.
+//
+// If SyncReplicas is set, only that many replicas must acknowledge every
+// transaction. Otherwise, all connected replicas must receive them.
+//
+// The store continues serving reads and remains draining until it is closed.
+// A draining store will not become primary again.
+func (s *Store) Drain(ctx context.Context) error {
+	if !s.draining.Swap(true) {
+		s.logEvent(slog.LevelInfo, "store_draining", nil, "draining store, rejecting new write transactions")
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for demoted := false; ; {
+		if s.isDrained() {
+			if !s.IsPrimary() {
+				s.logEvent(slog.LevelInfo, "store_drained", nil, "store drained")
+				return nil
+			}
+
+			// Release the lease once all transactions have been replicated.
+			if !demoted {
+				s.logEvent(slog.LevelInfo, "store_drained", nil, "store drained, releasing primary lease")
+				s.Demote()
+				demoted = true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("drain: %w", context.Cause(ctx))
+		case <-ticker.C:
+		}
+	}
+}
+
+// IsDraining returns true if Drain() has been called on the store.
+func (s *Store) IsDraining() bool {
+	return s.draining.Load()
+}
+
+// PauseReplication stops applying changes received from the primary. Frames
+// are still read so the stream stays connected and they are applied in order
+// once ResumeReplication() is called.
+func (s *Store) PauseReplication() {
+	s.replicationPause.mu.Lock()
+	defer s.replicationPause.mu.Unlock()
+	s.replicationPause.paused = true
+}
+
+// ResumeReplication applies all frames received while paused and resumes
+// applying frames as they arrive. If a spooled frame cannot be applied, the
+// remaining frames are discarded and the replica catches up on reconnect.
+func (s *Store) ResumeReplication(ctx context.Context) error {
+	s.replicationPause.mu.Lock()
+	defer s.replicationPause.mu.Unlock()
+
+	s.replicationPause.paused = false
+	defer s.clearPausedFramesNoLock()
+
+	for len(s.replicationPause.frames) > 0 {
+		pf := s.replicationPause.frames[0]
+		s.replicationPause.frames = s.replicationPause.frames[1:]
+
+		if err := s.applyPausedFrame(ctx, pf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsReplicationPaused returns true if PauseReplication() has been called.
+func (s *Store) IsReplicationPaused() bool {
+	s.replicationPause.mu.Lock()
+	defer s.replicationPause.mu.Unlock()
+	return s.replicationPause.paused
+}
+
+// PausedReplicationPath returns the directory that holds LTX files received
+// while replication is paused.
+func (s *Store) PausedReplicationPath() string {
+	return filepath.Join(s.path, "paused")
+}
+
+// pausedFrame is a replicated frame that was received while paused.
+type pausedFrame struct {
+	frame StreamFrame
+	path  string // spooled LTX file, if an LTX frame
+}
+
+// isDrained returns true if no writes are in-flight and, if primary, the
+// replicas have received every transaction.
+func (s *Store) isDrained() bool {
+	for _, db := range s.DBs() {
+		if db.isWriting() {
+			return false
+		}
+	}
+
+	s.mu.Lock()
+	isPrimary := s.isPrimary
+	subs := s.replicasNoLock()
+	acks := make([]map[string]Pos, 0, len(s.acks))
+	for _, posMap := range s.acks {
+		acks = append(acks, posMap)
+	}
+	s.mu.Unlock()
+
+	if !isPrimary {
+		return true
+	}
+
+	// With synchronous replication, wait for the quorum to acknowledge.
+	if s.SyncReplicas > 0 {
+		var n int
+		for _, posMap := range acks {
+			if s.txIDLag(posMap) == 0 {
+				n++
+			}
+		}
+		return n >= s.SyncReplicas
+	}
+
+	for _, sub := range subs {
+		if s.txIDLag(sub.PosMap()) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// drainPollInterval is the time between checking for in-flight writes & replica positions while draining.
+const drainPollInterval = 10 * time.Millisecond
+
 // ReadyCh returns a channel that is closed once the store has become primary
 // or once it has connected to the primary.
 func (s *Store) ReadyCh() chan struct{} {
 	return s.readyCh
 }
 
+// WaitReady blocks until the store is ready, ctx is done, or the store is
+// closed. Returns ErrStoreClosed if the store closes before becoming ready.
+func (s *Store) WaitReady(ctx context.Context) error {
+	select {
+	case <-s.readyCh:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-s.ctx.Done():
+		return ErrStoreClosed
+	}
+}
+
 // markReady closes the ready channel if it hasn't already been closed.
 func (s *Store) markReady() {
 	select {
@@ -330,9 +1046,22 @@ func (s *Store) setIsPrimary(v bool) {
 	if s.isPrimary != v {
 		if v {
 			s.primaryCh = make(chan struct{})
+
+			// Acks received during a previous term are no longer valid.
+			s.acks = make(map[uint64]map[string]Pos)
+
+			// Clear replication lag as the primary cannot be behind itself.
+			for _, db := range s.dbs {
+				db.setPrimaryTXID(0, 0)
+			}
 		} else {
 			close(s.primaryCh)
 		}
+
+		// Notify leadership listeners of the transition.
+		for _, ch := range s.leadershipChs {
+			sendLatestBool(ch, v)
+		}
 	}
 
 	// Update state.
@@ -343,8 +1072,12 @@ func (s *Store) setIsPrimary(v bool) {
 	// Update metrics.
 	if s.isPrimary {
 		storeIsPrimaryMetric.Set(1)
+		storeLeasePrimarySinceMetric.Set(float64(time.Now().Unix()))
 	} else {
 		storeIsPrimaryMetric.Set(0)
+		storeLeasePrimarySinceMetric.Set(0)
+		storeLeaseTTLMetric.Set(0)
+		storeLeaseRenewedAtMetric.Set(0)
 	}
 }
 
@@ -356,70 +1089,229 @@ func (s *Store) updateLogPrefix() {
 	s.logPrefix.Store(fmt.Sprintf("%s/%s", prefix, FormatNodeID(s.id)))
 }
 
-// PrimaryCtx wraps ctx with another context that will cancel when no longer primary.
-func (s *Store) PrimaryCtx(ctx context.Context) context.Context {
+// SubscribeLeadership returns a channel that receives the primary status of
+// the store whenever it changes. The current status is sent immediately.
+//
+// The channel only holds the latest status so a slow consumer may miss
+// intermediate transitions but it will never block the store. The channel
+// should be released with UnsubscribeLeadership().
+func (s *Store) SubscribeLeadership() <-chan bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return newPrimaryCtx(ctx, s.primaryCh)
+
+	ch := make(chan bool, 1)
+	ch <- s.isPrimary
+	s.leadershipChs[ch] = ch
+	return ch
 }
 
-// PrimaryInfo returns info about the current primary.
-func (s *Store) PrimaryInfo() (isPrimary bool, info *PrimaryInfo) {
+// UnsubscribeLeadership removes & closes a channel returned from SubscribeLeadership().
+func (s *Store) UnsubscribeLeadership(ch <-chan bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.isPrimary, s.primaryInfo.Clone()
-}
 
-// Candidate returns true if store is eligible to be the primary.
-func (s *Store) Candidate() bool {
-	return s.candidate
+	if c, ok := s.leadershipChs[ch]; ok {
+		delete(s.leadershipChs, ch)
+		close(c)
+	}
 }
 
-// DBByName returns a database by name.
-// Returns nil if the database does not exist.
-func (s *Store) DB(name string) *DB {
+// PrimaryCtx wraps ctx with another context that will cancel when no longer primary.
+func (s *Store) PrimaryCtx(ctx context.Context) context.Context {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.dbs[name]
+	return newPrimaryCtx(ctx, s.primaryCh)
 }
 
-// DBs returns a list of databases.
-func (s *Store) DBs() []*DB {
+// StreamCtx wraps ctx with another context that will cancel when the store
+// can no longer serve replication streams. The primary can serve streams until
+// it loses its lease. A relay can serve streams while it is connected to its
+// upstream node. The returned context is already canceled otherwise.
+func (s *Store) StreamCtx(ctx context.Context) context.Context {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	a := make([]*DB, 0, len(s.dbs))
-	for _, db := range s.dbs {
-		a = append(a, db)
+	if s.isPrimary {
+		return newPrimaryCtx(ctx, s.primaryCh)
 	}
-	return a
+	return newPrimaryCtx(ctx, s.relayCh)
 }
 
-// CreateDB creates a new database with the given name. The returned file handle
-// must be closed by the caller. Returns an error if a database with the same
-// name already exists.
-func (s *Store) CreateDB(name string) (db *DB, f *os.File, err error) {
-	defer func() {
-		TraceLog.Printf("[CreateDatabase(%s)]: %s", name, errorKeyValue(err))
-	}()
-
+// startRelay allows streams to be served by a relay until stopRelay() is called.
+func (s *Store) startRelay() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Verify database doesn't already exist.
-	if _, ok := s.dbs[name]; ok {
-		return nil, nil, ErrDatabaseExists
+	select {
+	case <-s.relayCh:
+		s.relayCh = make(chan struct{})
+	default: // already relaying
 	}
+}
 
-	// Generate database directory with name file & empty database file.
-	dbPath := s.DBPath(name)
-	if err := os.MkdirAll(dbPath, 0777); err != nil {
-		return nil, nil, err
-	}
+// stopRelay cancels all streams served by a relay.
+func (s *Store) stopRelay() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	f, err = os.OpenFile(filepath.Join(dbPath, "database"), os.O_RDWR|os.O_CREATE|os.O_EXCL|os.O_TRUNC, 0666)
-	if err != nil {
-		return nil, nil, err
+	select {
+	case <-s.relayCh:
+	default:
+		close(s.relayCh)
+	}
+}
+
+// PrimaryInfo returns info about the current primary.
+func (s *Store) PrimaryInfo() (isPrimary bool, info *PrimaryInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isPrimary, s.primaryInfo.Clone()
+}
+
+// PrimaryURL returns the advertise URL of the current primary & true if this
+// node is the primary. Returns a blank URL if this node is a replica that is
+// not currently connected to a primary.
+func (s *Store) PrimaryURL() (url string, isPrimary bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isPrimary {
+		return s.Leaser.AdvertiseURL(), true
+	} else if s.primaryInfo == nil {
+		return "", false
+	}
+	return s.primaryInfo.AdvertiseURL, false
+}
+
+// ReplicationLag returns the maximum replication lag across all databases.
+// Returns zero on the primary and ErrNoPrimary if a replica is not currently
+// connected to a primary.
+func (s *Store) ReplicationLag() (time.Duration, error) {
+	isPrimary, info := s.PrimaryInfo()
+	if isPrimary {
+		return 0, nil
+	} else if info == nil {
+		return 0, ErrNoPrimary
+	}
+
+	var lag time.Duration
+	for _, db := range s.DBs() {
+		if v := db.ReplicationLag(); v > lag {
+			lag = v
+		}
+	}
+	return lag, nil
+}
+
+// HealthStatus summarizes whether a node can serve traffic.
+type HealthStatus struct {
+	// True if the node is the primary or if it has caught up with the primary
+	// & is not lagging more than Store.HealthMaxLag.
+	Ready bool `json:"ready"`
+
+	IsPrimary bool `json:"isPrimary"`
+
+	// True if the node is the primary or is connected to the primary.
+	PrimaryReachable bool `json:"primaryReachable"`
+
+	// Maximum replication lag across all databases. Always zero on the primary.
+	MaxReplicaLag time.Duration `json:"maxReplicaLag"`
+
+	// Names of databases that have diverged from or are quarantined until a
+	// snapshot is received from the primary, sorted by name.
+	UnhealthyDBs []string `json:"unhealthyDBs,omitempty"`
+}
+
+// Health returns a summary of the node's readiness.
+func (s *Store) Health() HealthStatus {
+	var status HealthStatus
+	isPrimary, info := s.PrimaryInfo()
+	status.IsPrimary = isPrimary
+	status.PrimaryReachable = isPrimary || info != nil
+
+	for _, db := range s.DBs() {
+		if db.Diverged() || db.Quarantined() {
+			status.UnhealthyDBs = append(status.UnhealthyDBs, db.Name())
+		}
+		if !isPrimary {
+			if v := db.ReplicationLag(); v > status.MaxReplicaLag {
+				status.MaxReplicaLag = v
+			}
+		}
+	}
+	sort.Strings(status.UnhealthyDBs)
+
+	// Replicas are only ready once they have received the initial replication
+	// set from the primary & are not lagging too far behind it.
+	select {
+	case <-s.readyCh:
+		status.Ready = status.PrimaryReachable &&
+			(s.HealthMaxLag <= 0 || status.MaxReplicaLag <= s.HealthMaxLag)
+	default:
+	}
+
+	return status
+}
+
+// Candidate returns true if store is eligible to be the primary.
+func (s *Store) Candidate() bool {
+	return s.candidate && s.CandidateWeight > 0 && !s.ReadOnly
+}
+
+// DBByName returns a database by name.
+// Returns nil if the database does not exist.
+func (s *Store) DB(name string) *DB {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dbs[name]
+}
+
+// DBs returns a list of databases.
+func (s *Store) DBs() []*DB {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := make([]*DB, 0, len(s.dbs))
+	for _, db := range s.dbs {
+		a = append(a, db)
+	}
+	return a
+}
+
+// CreateDB creates a new database with the given name. The returned file handle
+// must be closed by the caller. Returns an error if a database with the same
+// name already exists.
+func (s *Store) CreateDB(name string) (db *DB, f File, err error) {
+	defer func() {
+		TraceLog.Printf("[CreateDatabase(%s)]: %s", name, errorKeyValue(err))
+	}()
+
+	// Notify after the store lock is released.
+	defer func() {
+		if err == nil {
+			s.notifyDBCreated(name)
+		}
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Verify database doesn't already exist.
+	if _, ok := s.dbs[name]; ok {
+		return nil, nil, ErrDatabaseExists
+	} else if err := s.checkMaxDatabasesNoLock(); err != nil {
+		return nil, nil, err
+	}
+
+	// Generate database directory with name file & empty database file.
+	dbPath := s.DBPath(name)
+	if err := s.FS.MkdirAll(dbPath, s.DirMode); err != nil {
+		return nil, nil, err
+	}
+
+	f, err = s.FS.OpenFile(filepath.Join(dbPath, "database"), os.O_RDWR|os.O_CREATE|os.O_EXCL|os.O_TRUNC, s.FileMode)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Create new database instance and add to maps.
@@ -439,23 +1331,208 @@ func (s *Store) CreateDB(name string) (db *DB, f *os.File, err error) {
 	return db, f, nil
 }
 
+// CreateDBFromReader creates a new database with the given name and initializes
+// it with the SQLite database file contents from r. The contents are written
+// as the first LTX file so they are replicated like any other transaction.
+//
+// The database directory is removed if any step fails. Returns
+// ErrDatabaseExists if a database with the same name already exists.
+func (s *Store) CreateDBFromReader(ctx context.Context, name string, r io.Reader) (db *DB, err error) {
+	defer func() {
+		TraceLog.Printf("[CreateDatabaseFromReader(%s)]: %s", name, errorKeyValue(err))
+	}()
+
+	// Verify database doesn't already exist.
+	if s.DB(name) != nil {
+		return nil, ErrDatabaseExists
+	} else if err := s.checkMaxDatabases(); err != nil {
+		return nil, err
+	}
+
+	// Create the database directory exclusively so concurrent creates fail.
+	dbPath := s.DBPath(name)
+	if err := s.FS.Mkdir(dbPath, s.DirMode); os.IsExist(err) {
+		return nil, ErrDatabaseExists
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Remove the partially created database on failure.
+	defer func() {
+		if err != nil {
+			_ = s.FS.RemoveAll(dbPath)
+		}
+	}()
+
+	if err := writeFile(s.FS, filepath.Join(dbPath, "database"), nil, s.FileMode); err != nil {
+		return nil, err
+	}
+
+	db = NewDB(s, name, dbPath)
+	if err := db.Open(); err != nil {
+		return nil, err
+	}
+
+	// Write the contents to an LTX file and apply it to the empty database.
+	pos, err := db.importToLTX(ctx, r)
+	if errors.Is(err, errInvalidDatabaseHeader) {
+		return nil, fmt.Errorf("invalid sqlite database header")
+	} else if err != nil {
+		return nil, fmt.Errorf("import: %w", err)
+	}
+	if err := db.ApplyLTX(ctx, db.LTXPath(pos.TXID, pos.TXID)); err != nil {
+		return nil, fmt.Errorf("apply ltx: %w", err)
+	}
+
+	// Notify after the store lock is released.
+	defer func() {
+		if err == nil {
+			s.notifyDBCreated(name)
+		}
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.dbs[name]; ok {
+		return nil, ErrDatabaseExists
+	} else if err := s.checkMaxDatabasesNoLock(); err != nil {
+		return nil, err
+	}
+	s.dbs[name] = db
+
+	// Notify listeners of change.
+	s.markDirty(name)
+
+	// Update metrics
+	storeDBCountMetric.Set(float64(len(s.dbs)))
+
+	return db, nil
+}
+
+// CopyDB creates a new database named dstName as a point-in-time copy of the
+// current committed state of srcName. The source is snapshotted while only
+// briefly blocking writes and the snapshot is streamed directly into the new
+// database so it starts at the same position as the source.
+//
+// Returns ErrDatabaseNotFound if the source does not exist or
+// ErrDatabaseExists if the destination already exists.
+func (s *Store) CopyDB(ctx context.Context, srcName, dstName string) (db *DB, err error) {
+	defer func() {
+		TraceLog.Printf("[CopyDatabase(%s,%s)]: %s", srcName, dstName, errorKeyValue(err))
+	}()
+
+	srcDB := s.DB(srcName)
+	if srcDB == nil {
+		return nil, ErrDatabaseNotFound
+	} else if s.DB(dstName) != nil {
+		return nil, ErrDatabaseExists
+	} else if err := s.checkMaxDatabases(); err != nil {
+		return nil, err
+	}
+
+	// Create the database directory exclusively so concurrent creates fail.
+	dbPath := s.DBPath(dstName)
+	if err := s.FS.Mkdir(dbPath, s.DirMode); os.IsExist(err) {
+		return nil, ErrDatabaseExists
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Remove the partially created database on failure.
+	defer func() {
+		if err != nil {
+			_ = s.FS.RemoveAll(dbPath)
+		}
+	}()
+
+	if err := writeFile(s.FS, filepath.Join(dbPath, "database"), nil, s.FileMode); err != nil {
+		return nil, err
+	}
+
+	db = NewDB(s, dstName, dbPath)
+	if err := db.Open(); err != nil {
+		return nil, err
+	}
+
+	// Stream the snapshot from the source directly into the new database.
+	pr, pw := io.Pipe()
+	defer func() { _ = pr.Close() }()
+	go func() {
+		_, _, err := srcDB.WriteSnapshotTo(ctx, pw)
+		_ = pw.CloseWithError(err)
+	}()
+
+	hdr, data, err := ltx.DecodeHeader(pr)
+	if err != nil {
+		return nil, fmt.Errorf("decode snapshot header: %w", err)
+	}
+
+	guardSet, err := db.acquireWriteLock(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer guardSet.Unlock()
+
+	if err := s.writeAndApplyLTX(ctx, db, hdr, io.MultiReader(bytes.NewReader(data), pr), false); err != nil {
+		return nil, fmt.Errorf("apply snapshot: %w", err)
+	}
+
+	// Notify after the store lock is released.
+	defer func() {
+		if err == nil {
+			s.notifyDBCreated(dstName)
+		}
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.dbs[dstName]; ok {
+		return nil, ErrDatabaseExists
+	} else if err := s.checkMaxDatabasesNoLock(); err != nil {
+		return nil, err
+	}
+	s.dbs[dstName] = db
+
+	// Notify listeners of change.
+	s.markDirty(dstName)
+
+	// Update metrics
+	storeDBCountMetric.Set(float64(len(s.dbs)))
+
+	return db, nil
+}
+
 // CreateDBIfNotExists creates an empty database with the given name.
+// Returns ErrTooManyDatabases if the database does not exist and the store
+// has reached MaxDatabases.
 func (s *Store) CreateDBIfNotExists(name string) (*DB, error) {
+	// Notify after the store lock is released if the database was created.
+	var created bool
+	defer func() {
+		if created {
+			s.notifyDBCreated(name)
+		}
+	}()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// Exit if database with same name already exists.
 	if db := s.dbs[name]; db != nil {
 		return db, nil
+	} else if err := s.checkMaxDatabasesNoLock(); err != nil {
+		return nil, err
 	}
 
 	// Generate database directory with name file & empty database file.
 	dbPath := s.DBPath(name)
-	if err := os.MkdirAll(dbPath, 0777); err != nil {
+	if err := s.FS.MkdirAll(dbPath, s.DirMode); err != nil {
 		return nil, err
 	}
 
-	if err := os.WriteFile(filepath.Join(dbPath, "database"), nil, 0666); err != nil {
+	if err := writeFile(s.FS, filepath.Join(dbPath, "database"), nil, s.FileMode); err != nil {
 		return nil, err
 	}
 
@@ -465,6 +1542,7 @@ func (s *Store) CreateDBIfNotExists(name string) (*DB, error) {
 		return nil, err
 	}
 	s.dbs[name] = db
+	created = true
 
 	// Notify listeners of change.
 	s.markDirty(name)
@@ -481,6 +1559,13 @@ func (s *Store) DropDB(ctx context.Context, name string) (err error) {
 		TraceLog.Printf("[DropDatabase(%s)]: %s", name, errorKeyValue(err))
 	}()
 
+	// Notify after the store lock is released.
+	defer func() {
+		if err == nil {
+			s.notifyDBDropped(name)
+		}
+	}()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -490,13 +1575,22 @@ func (s *Store) DropDB(ctx context.Context, name string) (err error) {
 		return ErrDatabaseNotFound
 	}
 
+	if plan, err := newDropDBPlan(db); err != nil {
+		TraceLog.Printf("[DropDatabase(%s)]: cannot build plan: %s", name, err)
+	} else {
+		TraceLog.Printf("[DropDatabase(%s)]: path=%s files=%d size=%d", name, plan.Path, plan.FileN, plan.Size)
+	}
+
 	// Remove data directory for the database.
-	if err := os.RemoveAll(db.Path()); err != nil {
+	if err := s.FS.RemoveAll(db.Path()); err != nil {
 		return fmt.Errorf("remove db path: %w", err)
 	}
 
 	// Remove from lookup on store.
 	delete(s.dbs, name)
+	deleteDBWriteMetrics(name)
+	db.removed.Store(true)
+	db.notifyPos()
 
 	// Notify listeners of change.
 	s.markDirty(name)
@@ -507,346 +1601,2050 @@ func (s *Store) DropDB(ctx context.Context, name string) (err error) {
 	return nil
 }
 
-// PosMap returns a map of databases and their transactional position.
-func (s *Store) PosMap() map[string]Pos {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// DropDBPlan describes the files that DropDB() would remove for a database.
+type DropDBPlan struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`  // database data directory
+	FileN int    `json:"fileN"` // number of files
+	Size  int64  `json:"size"`  // total size of files, in bytes
+}
 
-	m := make(map[string]Pos, len(s.dbs))
-	for _, db := range s.dbs {
-		m[db.Name()] = db.Pos()
+// DropDBDryRun returns the files that DropDB() would remove for the database
+// without deleting anything. Returns ErrDatabaseNotFound if the database
+// does not exist.
+func (s *Store) DropDBDryRun(name string) (DropDBPlan, error) {
+	db := s.DB(name)
+	if db == nil {
+		return DropDBPlan{}, ErrDatabaseNotFound
 	}
-	return m
+	return newDropDBPlan(db)
 }
 
-// Subscribe creates a new subscriber for store changes.
-func (s *Store) Subscribe() *Subscriber {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	sub := newSubscriber(s)
-	s.subscribers[sub] = struct{}{}
-
-	storeSubscriberCountMetric.Set(float64(len(s.subscribers)))
-	return sub
+// newDropDBPlan walks the data directory of db to count its files.
+func newDropDBPlan(db *DB) (DropDBPlan, error) {
+	plan := DropDBPlan{Name: db.Name(), Path: db.Path()}
+	// Files removed concurrently, e.g. by retention, are skipped.
+	if err := walkFiles(db.store.FS, plan.Path, func(path string, fi os.FileInfo) error {
+		plan.FileN++
+		plan.Size += fi.Size()
+		return nil
+	}); err != nil {
+		return plan, fmt.Errorf("walk db path: %w", err)
+	}
+	return plan, nil
 }
 
-// Unsubscribe removes a subscriber from the store.
-func (s *Store) Unsubscribe(sub *Subscriber) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// RenameDB renames an existing database. The database directory is renamed on
+// disk so its LTX history is retained and replicas are notified to rename
+// their copy rather than recreating it.
+//
+// Returns ErrDatabaseNotFound if oldName does not exist or ErrDatabaseExists
+// if newName already exists.
+func (s *Store) RenameDB(ctx context.Context, oldName, newName string) (err error) {
+	defer func() {
+		TraceLog.Printf("[RenameDatabase(%s,%s)]: %s", oldName, newName, errorKeyValue(err))
+	}()
 
-	delete(s.subscribers, sub)
-	storeSubscriberCountMetric.Set(float64(len(s.subscribers)))
-}
+	db := s.DB(oldName)
+	if db == nil {
+		return ErrDatabaseNotFound
+	} else if s.DB(newName) != nil {
+		return ErrDatabaseExists
+	}
 
-// MarkDirty marks a database dirty on all subscribers.
-func (s *Store) MarkDirty(name string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.markDirty(name)
-}
+	// Prevent reads & writes to the database while its files are moved. The
+	// write lock set holds every lock that a transaction requires.
+	guardSet, err := db.acquireWriteLock(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("acquire write lock: %w", err)
+	}
+	defer guardSet.Unlock()
 
-func (s *Store) markDirty(name string) {
+	// Recheck after locking in case the databases changed while waiting.
+	if s.DB(oldName) != db {
+		return ErrDatabaseNotFound
+	} else if s.DB(newName) != nil {
+		return ErrDatabaseExists
+	}
+
+	newPath := s.DBPath(newName)
+	if _, err := s.FS.Stat(newPath); err == nil {
+		return ErrDatabaseExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := s.FS.Rename(db.Path(), newPath); err != nil {
+		return fmt.Errorf("rename db path: %w", err)
+	}
+
+	// Reopen the database under its new name. The previous instance is
+	// discarded in the same way as when a database is dropped. This must
+	// occur outside the store lock as opening may notify subscribers.
+	newDB := NewDB(s, newName, newPath)
+	newDB.primaryTXID.Store(db.primaryTXID.Load())
+	newDB.primaryTimestamp.Store(db.primaryTimestamp.Load())
+	if err := newDB.Open(); err != nil {
+		return fmt.Errorf("open renamed db: %w", err)
+	}
+
+	// Notify after the store lock is released.
+	defer func() {
+		if err == nil {
+			s.notifyDBDropped(oldName)
+			s.notifyDBCreated(newName)
+		}
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.dbs[newName]; ok {
+		return ErrDatabaseExists
+	}
+	delete(s.dbs, oldName)
+	s.dbs[newName] = newDB
+	deleteDBWriteMetrics(oldName)
+
+	// Fail anything waiting on the previous instance. This is set while the
+	// write locks are held so waiters see it once they acquire the locks.
+	db.removed.Store(true)
+	db.notifyPos()
+
+	// Remove stale entries from the kernel cache for both names.
+	if invalidator := s.Invalidator; invalidator != nil {
+		for _, name := range []string{oldName, newName} {
+			if err := invalidator.InvalidateEntry(name); err != nil {
+				log.Printf("cannot invalidate entry %q after rename: %s", name, err)
+			}
+		}
+	}
+
+	// Notify listeners of change.
 	for sub := range s.subscribers {
-		sub.MarkDirty(name)
+		sub.MarkRenamed(oldName, newName)
+	}
+	s.markDirty(oldName)
+	s.markDirty(newName)
+
+	return nil
+}
+
+// Snapshot writes a point-in-time LTX snapshot of the named database to w.
+// Returns the position that the snapshot corresponds to. The database is only
+// write-locked momentarily to determine its position.
+func (s *Store) Snapshot(ctx context.Context, name string, w io.Writer) (Pos, error) {
+	db := s.DB(name)
+	if db == nil {
+		return Pos{}, ErrDatabaseNotFound
+	}
+
+	header, trailer, err := db.WriteSnapshotTo(ctx, w)
+	if err != nil {
+		return Pos{}, err
+	}
+	return NewPosFromLTX(header, trailer), nil
+}
+
+// StreamFrom writes an LTX stream frame to w for each LTX file of the named
+// database from txID through its current position. Frames are encoded the
+// same as the replication stream so they can be read with ReadStreamFrame().
+// Returns the position after the last transaction written.
+//
+// Returns ErrTXIDNotRetained if the LTX file starting at txID is no longer
+// available, in which case the caller should request a snapshot instead.
+func (s *Store) StreamFrom(ctx context.Context, name string, txID uint64, w io.Writer) (Pos, error) {
+	db := s.DB(name)
+	if db == nil {
+		return Pos{}, ErrDatabaseNotFound
+	}
+
+	pos := db.Pos()
+	if txID == 0 {
+		return Pos{}, fmt.Errorf("txid required")
+	} else if txID > pos.TXID+1 {
+		return Pos{}, fmt.Errorf("txid %s is beyond current position %s", ltx.FormatTXID(txID), pos)
+	}
+
+	newPos := pos
+	for txID <= pos.TXID {
+		if err := ctx.Err(); err != nil {
+			return Pos{}, context.Cause(ctx)
+		}
+
+		var err error
+		if newPos, err = writeLTXStreamFrame(w, db, txID); err != nil {
+			return Pos{}, err
+		}
+		txID = newPos.TXID + 1
+	}
+	return newPos, nil
+}
+
+// writeLTXStreamFrame writes the LTX file starting at txID to w as a chunked
+// LTX stream frame. Returns the position after the file is applied.
+func writeLTXStreamFrame(w io.Writer, db *DB, txID uint64) (Pos, error) {
+	f, err := db.OpenLTXFile(txID)
+	if os.IsNotExist(err) {
+		return Pos{}, fmt.Errorf("%w: txid %s, request a snapshot instead", ErrTXIDNotRetained, ltx.FormatTXID(txID))
+	} else if err != nil {
+		return Pos{}, fmt.Errorf("open ltx file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	// Verify the file before sending so a corrupt file is not streamed.
+	dec := ltx.NewDecoder(f)
+	if err := dec.Verify(); err != nil {
+		return Pos{}, fmt.Errorf("verify ltx: %w", err)
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Pos{}, fmt.Errorf("seek ltx to start: %w", err)
+	}
+
+	if err := WriteStreamFrame(w, &LTXStreamFrame{Name: db.Name()}); err != nil {
+		return Pos{}, fmt.Errorf("write ltx stream frame: %w", err)
+	}
+
+	cw := chunk.NewWriter(w)
+	if _, err := io.Copy(cw, f); err != nil {
+		return Pos{}, fmt.Errorf("write ltx chunked stream: %w", err)
+	} else if err := cw.Close(); err != nil {
+		return Pos{}, fmt.Errorf("close ltx chunked stream: %w", err)
+	}
+
+	return NewPosFromLTX(dec.Header(), dec.Trailer()), nil
+}
+
+// WaitForPos blocks until the named database has reached or passed the TXID
+// of pos or until ctx is done. See DB.WaitForPos() for details.
+func (s *Store) WaitForPos(ctx context.Context, name string, pos Pos) error {
+	db := s.DB(name)
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	return db.WaitForPos(ctx, pos)
+}
+
+// ExportDB writes a consistent copy of the named database to w as a standard
+// SQLite database file. Writes are only blocked momentarily to determine the
+// position & WAL frames. Returns the position that the copy corresponds to.
+func (s *Store) ExportDB(ctx context.Context, name string, w io.Writer) (Pos, error) {
+	db := s.DB(name)
+	if db == nil {
+		return Pos{}, ErrDatabaseNotFound
+	}
+	return db.Export(ctx, w)
+}
+
+// RestoreFromBackup rebuilds each database in Backup that does not exist
+// locally by applying its latest snapshot and all subsequent LTX files.
+// Returns an error if the LTX files after the snapshot are not contiguous or
+// if the restored database does not match the final checksum.
+func (s *Store) RestoreFromBackup(ctx context.Context) error {
+	if s.Backup == nil {
+		return fmt.Errorf("no backup configured")
+	}
+
+	names, err := s.Backup.DBs(ctx)
+	if err != nil {
+		return fmt.Errorf("list backup databases: %w", err)
+	}
+
+	for _, name := range names {
+		if s.DB(name) != nil {
+			continue // only restore databases that do not exist locally
+		}
+		if err := s.restoreDBFromBackup(ctx, name); err != nil {
+			return fmt.Errorf("restore database(%q): %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) restoreDBFromBackup(ctx context.Context, name string) error {
+	infos, err := s.Backup.LTXFiles(ctx, name)
+	if err != nil {
+		return fmt.Errorf("list ltx files: %w", err)
+	} else if len(infos) == 0 {
+		return nil // nothing to restore
+	}
+
+	chain, err := ltxRestoreChain(infos)
+	if err != nil {
+		return err
+	}
+
+	db, err := s.CreateDBIfNotExists(name)
+	if err != nil {
+		return fmt.Errorf("create database: %w", err)
+	}
+
+	for _, info := range chain {
+		if err := s.restoreLTXFromBackup(ctx, db, info); err != nil {
+			return fmt.Errorf("apply %s: %w", ltx.FormatFilename(info.MinTXID, info.MaxTXID), err)
+		}
+	}
+
+	// Verify the restored database against the checksum of the last LTX file.
+	// A restored database has no WAL so the checksum is computed from the
+	// database file alone.
+	dbFile, err := s.FS.Open(db.DatabasePath())
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dbFile.Close() }()
+
+	pos := db.Pos()
+	if chksum, err := db.onDiskChecksum(dbFile, nil); err != nil {
+		return fmt.Errorf("checksum: %w", err)
+	} else if chksum != pos.PostApplyChecksum {
+		return fmt.Errorf("restored database checksum %016x does not match %016x at TXID %s", chksum, pos.PostApplyChecksum, ltx.FormatTXID(pos.TXID))
+	}
+
+	log.Printf("%s: database restored from backup: db=%s pos=%s", s.LogPrefix(), name, pos)
+	return nil
+}
+
+// restoreLTXFromBackup fetches an LTX file from the backup and applies it.
+func (s *Store) restoreLTXFromBackup(ctx context.Context, db *DB, info LTXFileInfo) error {
+	rc, err := s.Backup.OpenLTX(ctx, db.Name(), info.MinTXID, info.MaxTXID)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+
+	hdr, data, err := ltx.DecodeHeader(rc)
+	if err != nil {
+		return fmt.Errorf("decode ltx header: %w", err)
+	} else if hdr.MinTXID != info.MinTXID || hdr.MaxTXID != info.MaxTXID {
+		return fmt.Errorf("ltx header txid %s-%s does not match filename", ltx.FormatTXID(hdr.MinTXID), ltx.FormatTXID(hdr.MaxTXID))
+	}
+
+	guard, err := db.acquireWriteLock(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer guard.Unlock()
+
+	if !hdr.IsSnapshot() {
+		expectedPos := Pos{TXID: hdr.MinTXID - 1, PostApplyChecksum: hdr.PreApplyChecksum}
+		if pos := db.Pos(); pos.TXID != expectedPos.TXID || pos.PostApplyChecksum != expectedPos.PostApplyChecksum {
+			return &PosMismatchError{Name: db.Name(), Pos: pos, Expected: expectedPos}
+		}
+	}
+
+	// The file is already in the backup so it does not need to be sent again.
+	db.backedUpLTX.Store(ltx.FormatFilename(hdr.MinTXID, hdr.MaxTXID), struct{}{})
+
+	return s.writeAndApplyLTX(ctx, db, hdr, io.MultiReader(bytes.NewReader(data), rc), false)
+}
+
+// ltxRestoreChain returns the latest snapshot in infos followed by every LTX
+// file after it. Returns an error if there is no snapshot or if there is a
+// gap between files. Files must be sorted by TXID.
+func ltxRestoreChain(infos []LTXFileInfo) ([]LTXFileInfo, error) {
+	snapshot := -1
+	for i, info := range infos {
+		if info.Snapshot && (snapshot == -1 || info.MaxTXID >= infos[snapshot].MaxTXID) {
+			snapshot = i
+		}
+	}
+	if snapshot == -1 {
+		return nil, fmt.Errorf("no snapshot found")
+	}
+
+	chain := []LTXFileInfo{infos[snapshot]}
+	for _, info := range infos {
+		txID := chain[len(chain)-1].MaxTXID
+		if info.MaxTXID <= txID {
+			continue // already covered
+		} else if info.MinTXID != txID+1 {
+			return nil, fmt.Errorf("gap in ltx files: %s follows TXID %s",
+				ltx.FormatFilename(info.MinTXID, info.MaxTXID), ltx.FormatTXID(txID))
+		}
+		chain = append(chain, info)
+	}
+	return chain, nil
+}
+
+// RecoverToTXID rewinds the named database to txID using its retained LTX
+// files. The node must not be the primary to avoid diverging from replicas
+// that continue to receive writes. See DB.RecoverToTXID() for details.
+func (s *Store) RecoverToTXID(ctx context.Context, name string, txID uint64) error {
+	if s.IsPrimary() {
+		return ErrRecoverOnPrimary
+	}
+
+	db := s.DB(name)
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	return db.RecoverToTXID(ctx, txID)
+}
+
+// Verify checks the integrity of the named database against its LTX files.
+// See DB.Verify() for details.
+func (s *Store) Verify(ctx context.Context, name string) error {
+	db := s.DB(name)
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	return db.Verify(ctx)
+}
+
+// VerifyDB verifies the checksum of the named database against its current
+// position. See DB.VerifyChecksum() for details.
+func (s *Store) VerifyDB(ctx context.Context, name string) error {
+	db := s.DB(name)
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	return db.VerifyChecksum(ctx)
+}
+
+// ForEachLTX calls fn for every LTX file of the named database in ascending
+// TXID order. See DB.ForEachLTX() for details.
+func (s *Store) ForEachLTX(name string, fn func(info LTXFileInfo) error) error {
+	db := s.DB(name)
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	return db.ForEachLTX(fn)
+}
+
+// PosMap returns a map of databases and their transactional position.
+func (s *Store) PosMap() map[string]Pos {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := make(map[string]Pos, len(s.dbs))
+	for _, db := range s.dbs {
+		m[db.Name()] = db.Pos()
+	}
+	return m
+}
+
+// Subscribe creates a new subscriber for store changes.
+func (s *Store) Subscribe() *Subscriber {
+	return s.SubscribeWithOptions(SubscribeOptions{})
+}
+
+// SubscribeWithOptions creates a new subscriber for store changes, such as
+// on behalf of a replica node.
+func (s *Store) SubscribeWithOptions(opts SubscribeOptions) *Subscriber {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub := newSubscriber(s, opts)
+	s.subscribers[sub] = struct{}{}
+	s.replicaContactAt.Store(time.Now().UnixNano())
+
+	storeSubscriberCountMetric.Set(float64(len(s.subscribers)))
+	storeConnectedReplicaCountMetric.Set(float64(len(s.replicasNoLock())))
+	return sub
+}
+
+// SubscribeContext creates a new subscriber like Subscribe() except that it is
+// closed automatically once ctx is done. Use Subscriber.CloseOnDone() to do the
+// same for a subscriber created by SubscribeWithOptions().
+func (s *Store) SubscribeContext(ctx context.Context) *Subscriber {
+	sub := s.Subscribe()
+	sub.CloseOnDone(ctx)
+	return sub
+}
+
+// SubscribeEvents creates a new subscriber that receives an event for every
+// transaction committed or applied to a database. See EventSubscriber.
+func (s *Store) SubscribeEvents() *EventSubscriber {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bufferSize := s.EventBufferSize
+	if bufferSize <= 0 {
+		bufferSize = DefaultEventBufferSize
+	}
+
+	sub := newEventSubscriber(s, bufferSize)
+	s.eventSubscribers[sub] = struct{}{}
+	return sub
+}
+
+// UnsubscribeEvents removes an event subscriber from the store.
+func (s *Store) UnsubscribeEvents(sub *EventSubscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.eventSubscribers, sub)
+}
+
+// publishEvent sends an event to every event subscriber without blocking.
+func (s *Store) publishEvent(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sub := range s.eventSubscribers {
+		sub.push(event)
+	}
+}
+
+// notifyApply invokes the OnApply callback, if set.
+func (s *Store) notifyApply(name string, pos Pos) {
+	if s.OnApply != nil {
+		s.OnApply(name, pos)
+	}
+}
+
+// notifyDBCreated invokes the OnDBCreated callback, if set. Must be called
+// without holding the store lock.
+func (s *Store) notifyDBCreated(name string) {
+	if s.OnDBCreated != nil {
+		s.OnDBCreated(name)
+	}
+}
+
+// notifyDBDropped invokes the OnDBDropped callback, if set. Must be called
+// without holding the store lock.
+func (s *Store) notifyDBDropped(name string) {
+	if s.OnDBDropped != nil {
+		s.OnDBDropped(name)
+	}
+}
+
+// SubscriberRemoteAddr returns the remote address of the connected subscriber
+// with the given node ID. Returns blank if no subscriber exists.
+func (s *Store) SubscriberRemoteAddr(nodeID uint64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sub := s.subscriberByNodeID(nodeID); sub != nil {
+		return sub.opts.RemoteAddr
+	}
+	return ""
+}
+
+// subscriberByNodeID returns the subscriber for a node. Must hold s.mu.
+func (s *Store) subscriberByNodeID(nodeID uint64) *Subscriber {
+	for sub := range s.subscribers {
+		if sub.NodeID() == nodeID {
+			return sub
+		}
+	}
+	return nil
+}
+
+// Unsubscribe removes a subscriber from the store.
+func (s *Store) Unsubscribe(sub *Subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.subscribers, sub)
+	storeSubscriberCountMetric.Set(float64(len(s.subscribers)))
+	storeConnectedReplicaCountMetric.Set(float64(len(s.replicasNoLock())))
+
+	// A disconnected replica no longer counts towards synchronous replication
+	// unless it has another stream open.
+	if s.subscriberByNodeID(sub.NodeID()) == nil {
+		delete(s.acks, sub.NodeID())
+	}
+}
+
+// NodeInfo describes the current state of a node.
+type NodeInfo struct {
+	ID        uint64       `json:"id"`
+	IsPrimary bool         `json:"isPrimary"`
+	Primary   *PrimaryInfo `json:"primary,omitempty"` // current primary, if a replica
+}
+
+// Info returns the current state of this node.
+func (s *Store) Info() NodeInfo {
+	isPrimary, info := s.PrimaryInfo()
+	return NodeInfo{ID: s.ID(), IsPrimary: isPrimary, Primary: info}
+}
+
+// ReplicaInfo describes a replica that is connected to this node's stream.
+type ReplicaInfo struct {
+	NodeID      uint64         `json:"nodeID"`
+	RemoteAddr  string         `json:"remoteAddr,omitempty"`
+	ConnectedAt time.Time      `json:"connectedAt"`
+	PosMap      map[string]Pos `json:"posMap"`              // last positions sent to the replica
+	AckPosMap   map[string]Pos `json:"ackPosMap,omitempty"` // last positions acknowledged, if acks are sent
+}
+
+// Replicas returns the replicas connected to this node, sorted by node ID.
+// A node with multiple streams is listed once per stream.
+func (s *Store) Replicas() []ReplicaInfo {
+	s.mu.Lock()
+	subs := s.replicasNoLock()
+	acks := make(map[uint64]map[string]Pos, len(s.acks))
+	for nodeID, posMap := range s.acks {
+		acks[nodeID] = posMap
+	}
+	s.mu.Unlock()
+
+	a := make([]ReplicaInfo, 0, len(subs))
+	for _, sub := range subs {
+		info := ReplicaInfo{
+			NodeID:      sub.NodeID(),
+			RemoteAddr:  sub.opts.RemoteAddr,
+			ConnectedAt: sub.connectedAt,
+			PosMap:      sub.PosMap(),
+		}
+		if posMap := acks[sub.NodeID()]; posMap != nil {
+			info.AckPosMap = make(map[string]Pos, len(posMap))
+			for name, pos := range posMap {
+				info.AckPosMap[name] = pos
+			}
+		}
+		a = append(a, info)
+	}
+
+	sort.Slice(a, func(i, j int) bool {
+		if a[i].NodeID != a[j].NodeID {
+			return a[i].NodeID < a[j].NodeID
+		}
+		return a[i].ConnectedAt.Before(a[j].ConnectedAt)
+	})
+	return a
+}
+
+// HaltLockInfo describes a halt lock held on a database.
+type HaltLockInfo struct {
+	Name   string `json:"name"`   // database name
+	Remote bool   `json:"remote"` // if true, lock is held by this node on the primary
+	HaltLock
+}
+
+// HaltLocks returns a snapshot of the current halt locks, sorted by database
+// name. On the primary, these are the locks held on behalf of replicas. On a
+// replica, these are the locks it holds on the primary. Databases without a
+// halt lock are excluded.
+func (s *Store) HaltLocks() []HaltLockInfo {
+	dbs := s.DBs()
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].Name() < dbs[j].Name() })
+
+	a := make([]HaltLockInfo, 0)
+	for _, db := range dbs {
+		if haltLock := db.HaltLock(); haltLock != nil {
+			a = append(a, HaltLockInfo{Name: db.Name(), HaltLock: *haltLock})
+		} else if haltLock := db.RemoteHaltLock(); haltLock != nil {
+			a = append(a, HaltLockInfo{Name: db.Name(), Remote: true, HaltLock: *haltLock})
+		}
+	}
+	return a
+}
+
+// replicaMinTXID returns the lowest TXID of a database that has been sent to
+// or acknowledged by a connected replica. LTX files after this TXID are needed
+// by the replica to advance. Returns zero if no replica has the database.
+func (s *Store) replicaMinTXID(name string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var minTXID uint64
+	for _, sub := range s.replicasNoLock() {
+		txIDs := []uint64{sub.PosMap()[name].TXID}
+		if posMap := s.acks[sub.NodeID()]; posMap != nil {
+			txIDs = append(txIDs, posMap[name].TXID)
+		}
+		for _, txID := range txIDs {
+			if txID != 0 && (minTXID == 0 || txID < minTXID) {
+				minTXID = txID
+			}
+		}
+	}
+	return minTXID
+}
+
+// replicasNoLock returns the subscribers for replica streams. Must hold s.mu.
+func (s *Store) replicasNoLock() []*Subscriber {
+	subs := make([]*Subscriber, 0, len(s.subscribers))
+	for sub := range s.subscribers {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// Ack records the positions that a replica has applied and wakes any writes
+// waiting on synchronous replication. Returns ErrNotPrimary if this node is
+// not the primary.
+func (s *Store) Ack(nodeID uint64, posMap map[string]Pos) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isPrimary {
+		return ErrNotPrimary
+	}
+
+	s.acks[nodeID] = posMap
+	s.replicaContactAt.Store(time.Now().UnixNano())
+
+	close(s.ackCh)
+	s.ackCh = make(chan struct{})
+	return nil
+}
+
+// WaitForSyncReplicas blocks until SyncReplicas replicas have acknowledged
+// applying txID on the database. Returns immediately if synchronous
+// replication is disabled or if this node is not the primary, such as when
+// a replica commits under a remote halt lock.
+func (s *Store) WaitForSyncReplicas(ctx context.Context, name string, txID uint64) error {
+	if s.SyncReplicas <= 0 || !s.IsPrimary() {
+		return nil
+	}
+
+	var timeoutCh <-chan time.Time
+	if s.SyncTimeout > 0 {
+		timer := time.NewTimer(s.SyncTimeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	for {
+		s.mu.Lock()
+		var n int
+		for _, posMap := range s.acks {
+			if posMap[name].TXID >= txID {
+				n++
+			}
+		}
+		ackCh, primaryCh := s.ackCh, s.primaryCh
+		s.mu.Unlock()
+
+		if n >= s.SyncReplicas {
+			return nil
+		}
+
+		select {
+		case <-ackCh:
+		case <-primaryCh:
+			return ErrNotPrimary
+		case <-timeoutCh:
+			storeSyncTimeoutCountMetric.Inc()
+			return ErrSyncReplicationTimeout
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// MarkDirty marks a database dirty on all subscribers.
+func (s *Store) MarkDirty(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.markDirty(name)
+}
+
+func (s *Store) markDirty(name string) {
+	for sub := range s.subscribers {
+		sub.MarkDirty(name)
+	}
+}
+
+// monitorLease continuously handles either the leader lease or replicates from the primary.
+func (s *Store) monitorLease(ctx context.Context) error {
+	var handoffLease Lease
+	for {
+		// Exit if store is closed.
+		if err := ctx.Err(); err != nil {
+			return nil
+		}
+
+		// Use the lease handed off by the previous primary, if any. Otherwise
+		// attempt to either obtain a primary lock or read the current primary.
+		// On startup, optimistically try the last known primary first.
+		var lease Lease
+		var info *PrimaryInfo
+		var err error
+		var cached bool
+		if handoffLease != nil {
+			lease, handoffLease = handoffLease, nil
+		} else if s.cachedPrimaryInfo != nil {
+			info, s.cachedPrimaryInfo, cached = s.cachedPrimaryInfo, nil, true
+		} else {
+			lease, info, err = s.acquireLeaseOrPrimaryInfo(ctx)
+		}
+
+		if err == ErrNoPrimary && !s.Candidate() {
+			s.logEvent(slog.LevelWarn, "primary_not_found", []any{"error", err}, "cannot find primary & ineligible to become primary, retrying: %s", err)
+			s.sleepUnlessPromoted(ctx, s.reconnectDelay())
+			continue
+		} else if err != nil {
+			s.logEvent(slog.LevelError, "lease_error", []any{"error", err}, "cannot acquire lease or find primary, retrying: %s", err)
+			s.sleepUnlessPromoted(ctx, s.reconnectDelay())
+			continue
+		}
+
+		// Monitor as primary if we have obtained a lease.
+		if lease != nil {
+			s.reconnectAttempts = 0
+			s.logEvent(slog.LevelInfo, "lease_acquired", []any{"advertise_url", s.Leaser.AdvertiseURL()}, "primary lease acquired, advertising as %s", s.Leaser.AdvertiseURL())
+			if err := s.monitorLeaseAsPrimary(ctx, lease); err != nil {
+				s.logEvent(slog.LevelWarn, "lease_lost", []any{"error", err}, "primary lease lost, retrying: %s", err)
+			}
+			if err := s.Recover(ctx); err != nil {
+				s.logEvent(slog.LevelError, "recovery_error", []any{"role", "primary", "error", err}, "state change recovery error (primary): %s", err)
+			}
+			continue
+		}
+
+		// Monitor as replica if another primary already exists.
+		if cached {
+			s.logEvent(slog.LevelInfo, "replica_connecting", []any{"primary", info.Hostname, "cached", true}, "connecting to last known primary (%s) as replica", info.Hostname)
+		} else {
+			s.logEvent(slog.LevelInfo, "replica_connecting", []any{"primary", info.Hostname, "cached", false}, "existing primary found (%s), connecting as replica", info.Hostname)
+		}
+		if handoffLease, err = s.monitorLeaseAsReplica(ctx, info); handoffLease != nil {
+			s.logEvent(slog.LevelInfo, "lease_handoff_received", nil, "primary lease handed off to this node")
+		} else if errors.Is(err, ErrReplicationAuth) {
+			s.logEvent(slog.LevelError, "replication_auth_error", []any{"error", err}, "replication token rejected by primary, check the replication token configuration; retrying in %s: %s", DefaultAuthFailureDelay, err)
+			sleepWithContext(ctx, DefaultAuthFailureDelay)
+		} else if errors.Is(err, ErrDuplicateNodeID) {
+			s.logEvent(slog.LevelError, "duplicate_node_id", nil, "WARNING: primary has the same node id as this node, restart with 'litefs mount -reset-id' to generate a new id; retrying in %s", DefaultAuthFailureDelay)
+			sleepWithContext(ctx, DefaultAuthFailureDelay)
+		} else if err == nil {
+			s.logEvent(slog.LevelInfo, "replica_disconnected", nil, "disconnected from primary, retrying")
+		} else {
+			s.logEvent(slog.LevelWarn, "replica_disconnected", []any{"error", err}, "disconnected from primary with error, retrying: %s", err)
+		}
+		if err := s.Recover(ctx); err != nil {
+			s.logEvent(slog.LevelError, "recovery_error", []any{"role", "replica", "error", err}, "state change recovery error (replica): %s", err)
+		}
+
+		// Become primary immediately if the lease was handed off to us. Also
+		// fall back to the leaser immediately if the last known primary failed
+		// & reconnect immediately if a snapshot needs to be requested.
+		if handoffLease == nil && !cached && !errors.Is(err, errSnapshotRequested) {
+			s.sleepUnlessPromoted(ctx, s.reconnectDelay())
+		}
+	}
+}
+
+// sleepUnlessPromoted waits for d to elapse, for ctx to be done, or for
+// Promote() to be called so that a promotion does not wait for the retry.
+func (s *Store) sleepUnlessPromoted(ctx context.Context, d time.Duration) {
+	s.mu.Lock()
+	promoteCh := s.promoteCh
+	s.mu.Unlock()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	case <-promoteCh:
+	}
+}
+
+// reconnectDelay returns the time to wait before the next attempt to connect to
+// or become the primary. The base delay doubles with each consecutive attempt
+// up to MaxReconnectDelay. Jitter is applied so that replicas which lost the
+// same primary do not reconnect in lockstep.
+func (s *Store) reconnectDelay() time.Duration {
+	d, max := s.ReconnectDelay, s.MaxReconnectDelay
+	if d <= 0 || max <= 0 {
+		return d
+	}
+
+	for i := 0; i < s.reconnectAttempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	s.reconnectAttempts++
+
+	// Randomize the jittered fraction so the delay never drops below the
+	// remainder of the backoff.
+	frac := s.ReconnectJitter
+	if frac < 0 {
+		frac = 0
+	} else if frac > 1 {
+		frac = 1
+	}
+	jitter := time.Duration(float64(d) * frac)
+	return d - jitter + time.Duration(rand.Int63n(int64(jitter)+1))
+}
+
+func (s *Store) acquireLeaseOrPrimaryInfo(ctx context.Context) (Lease, *PrimaryInfo, error) {
+	// Attempt to find an existing primary first.
+	info, err := s.Leaser.PrimaryInfo(ctx)
+	if err == ErrNoPrimary && (!s.Candidate() || s.IsDraining()) {
+		return nil, nil, err // no primary, not eligible to become primary
+	} else if err != nil && err != ErrNoPrimary {
+		return nil, nil, fmt.Errorf("fetch primary url: %w", err)
+	} else if err == nil {
+		return nil, &info, nil
+	}
+
+	// Give higher weight candidates a head start and then check whether one
+	// of them has become primary in the meantime.
+	if delay := s.electionDelay(); delay > 0 {
+		if sleepWithContext(ctx, delay); ctx.Err() != nil {
+			return nil, nil, context.Cause(ctx)
+		}
+
+		info, err := s.Leaser.PrimaryInfo(ctx)
+		if err != nil && err != ErrNoPrimary {
+			return nil, nil, fmt.Errorf("fetch primary url: %w", err)
+		} else if err == nil {
+			return nil, &info, nil
+		}
+	}
+
+	// Read-only stores never acquire the lease, even if candidacy changes.
+	if s.ReadOnly {
+		return nil, nil, ErrNoPrimary
+	}
+
+	// If no primary, attempt to become primary.
+	lease, err := s.Leaser.Acquire(ctx)
+	if err == ErrPrimaryExists {
+		storeLeaseAcquireCountMetricVec.WithLabelValues("primary_exists").Inc()
+		// passthrough and retry primary info fetch
+	} else if err != nil {
+		storeLeaseAcquireCountMetricVec.WithLabelValues("error").Inc()
+		return nil, nil, fmt.Errorf("acquire lease: %w", err)
+	} else if lease != nil {
+		storeLeaseAcquireCountMetricVec.WithLabelValues("acquired").Inc()
+		return lease, nil, nil
+	}
+
+	// If we raced to become primary and another node beat us, retry the fetch.
+	info, err = s.Leaser.PrimaryInfo(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, &info, nil
+}
+
+// monitorLeaseAsPrimary monitors & renews the current lease.
+// NOTE: This code is borrowed from the consul/api's RenewPeriodic() implementation.
+func (s *Store) monitorLeaseAsPrimary(ctx context.Context, lease Lease) error {
+	const timeout = 1 * time.Second
+
+	// Attempt to destroy lease when we exit this function. The lease is kept
+	// if it has been handed off to another node.
+	var demoted, handedOff bool
+	defer func() {
+		if handedOff {
+			s.logEvent(slog.LevelInfo, "primary_exit", []any{"handed_off", true}, "exiting primary, lease handed off")
+		} else {
+			s.logEvent(slog.LevelInfo, "primary_exit", []any{"handed_off", false}, "exiting primary, destroying lease")
+			if err := lease.Close(); err != nil {
+				s.logEvent(slog.LevelError, "lease_close_error", []any{"error", err}, "cannot remove lease: %s", err)
+			}
+		}
+
+		// Pause momentarily if this was a manual demotion.
+		if demoted {
+			s.logEvent(slog.LevelInfo, "demote_delay", []any{"delay", s.DemoteDelay}, "waiting for %s after demotion", s.DemoteDelay)
+			sleepWithContext(ctx, s.DemoteDelay)
+		}
+	}()
+
+	// Mark as the primary node while we're in this function.
+	s.mu.Lock()
+	s.setIsPrimary(true)
+	s.lease = lease
+	demoteCh, handoffCh := s.demoteCh, s.handoffCh
+	s.mu.Unlock()
+
+	// Mark store as ready if we've obtained primary status.
+	s.markReady()
+
+	// Remove the last known primary as it is no longer accurate.
+	if err := s.FS.Remove(s.PrimaryInfoPath()); err != nil && !os.IsNotExist(err) {
+		s.logEvent(slog.LevelWarn, "primary_info_error", []any{"error", err}, "cannot remove primary info cache: %s", err)
+	}
+
+	// Ensure that we are no longer marked as primary once we exit this function.
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.setIsPrimary(false)
+		s.lease = nil
+	}()
+
+	// Periodically check if we have lost contact with both the lease & all
+	// replicas so a partitioned primary stops accepting writes early.
+	lastRenewedAt := time.Now()
+	setLeaseRenewMetrics(lease, lastRenewedAt)
+	var isolationCh <-chan time.Time
+	if s.PrimaryIsolationTimeout > 0 {
+		ticker := time.NewTicker(s.PrimaryIsolationTimeout / 4)
+		defer ticker.Stop()
+		isolationCh = ticker.C
+	}
+
+	waitDur := s.leaseRenewInterval(lease)
+
+	// The renewal timer is only recreated after it fires so that other
+	// events in the loop do not postpone the renewal.
+	var renewCh <-chan time.Time
+	for {
+		if renewCh == nil {
+			renewCh = time.After(waitDur)
+		}
+
+		select {
+		case <-renewCh:
+			renewCh = nil
+
+			// Attempt to renew the lease. If the lease is gone then we need to
+			// just exit and we can start over or connect to the new primary.
+			//
+			// If we just have a connection error then we'll try to more
+			// aggressively retry the renewal until we exceed TTL.
+			err := lease.Renew(ctx)
+			if err != nil {
+				storeLeaseRenewCountMetricVec.WithLabelValues("error").Inc()
+				storeLeaseRenewFailureCountMetric.Inc()
+			} else {
+				storeLeaseRenewCountMetricVec.WithLabelValues("success").Inc()
+			}
+
+			if err == ErrLeaseExpired {
+				return err
+			} else if err != nil {
+				// If our next renewal will exceed TTL, exit now.
+				if d := time.Since(lease.RenewedAt()); d+timeout > lease.TTL() {
+					s.logEvent(slog.LevelError, "lease_expired", []any{"error", err, "since_renewal", d.Truncate(time.Millisecond)},
+						"lease renewal failed, no successful renewal for %s (ttl %s): %s", d.Truncate(time.Millisecond), lease.TTL(), err)
+					time.Sleep(timeout)
+					return ErrLeaseExpired
+				}
+
+				// Otherwise log error and try again after a shorter period.
+				s.logEvent(slog.LevelWarn, "lease_renew_error", []any{"error", err}, "lease renewal error, retrying: %s", err)
+				waitDur = time.Second
+				continue
+			}
+
+			// Renewal was successful, restart with low frequency.
+			lastRenewedAt = time.Now()
+			setLeaseRenewMetrics(lease, lastRenewedAt)
+			waitDur = s.leaseRenewInterval(lease)
+
+		case <-isolationCh:
+			lastContactAt := lastRenewedAt
+			if t := time.Unix(0, s.replicaContactAt.Load()); t.After(lastContactAt) {
+				lastContactAt = t
+			}
+			if d := time.Since(lastContactAt); d > s.PrimaryIsolationTimeout {
+				demoted = true
+				s.logEvent(slog.LevelWarn, "primary_isolated", []any{"since_contact", d.Truncate(time.Millisecond)},
+					"demoting, no lease renewal or replica contact for %s (primary isolation timeout %s)", d.Truncate(time.Millisecond), s.PrimaryIsolationTimeout)
+				return nil
+			}
+
+		case <-demoteCh:
+			demoted = true
+			s.logEvent(slog.LevelInfo, "primary_demoted", nil, "node manually demoted")
+			return nil
+
+		case <-handoffCh:
+			demoted, handedOff = true, true
+			s.logEvent(slog.LevelInfo, "lease_handoff_sent", nil, "primary lease handed off")
+			return nil
+
+		case <-ctx.Done():
+			return nil // release lease when we shut down
+		}
+	}
+}
+
+// leaseRenewInterval returns the time between lease renewals while primary.
+// Leases are renewed at least twice per isolation timeout so that a healthy
+// primary is not demoted.
+func (s *Store) leaseRenewInterval(lease Lease) time.Duration {
+	d := lease.TTL() / 2
+	if timeout := s.PrimaryIsolationTimeout; timeout > 0 && timeout/2 < d {
+		d = timeout / 2
+	}
+	return d
+}
+
+// setLeaseRenewMetrics updates the lease gauges after the lease is acquired or renewed.
+func setLeaseRenewMetrics(lease Lease, renewedAt time.Time) {
+	storeLeaseTTLMetric.Set(lease.TTL().Seconds())
+	storeLeaseRenewedAtMetric.Set(float64(renewedAt.Unix()))
+}
+
+// monitorLeaseAsReplica tries to connect to the primary node and stream down changes.
+// Returns a lease if the primary hands off its lease to this node.
+func (s *Store) monitorLeaseAsReplica(ctx context.Context, info *PrimaryInfo) (Lease, error) {
+	if s.Client == nil {
+		return nil, fmt.Errorf("no client set, skipping replica monitor")
+	}
+
+	// Store the URL of the primary while we're in this function.
+	s.mu.Lock()
+	s.primaryInfo = info
+	s.mu.Unlock()
+
+	// Clear the primary URL once we leave this function since we can no longer connect.
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.primaryInfo = nil
+	}()
+
+	// Only report positions for replicated databases so the primary does not
+	// stream the others back to us.
+	posMap := s.PosMap()
+	for name := range posMap {
+		if !s.isReplicatedDB(name) {
+			delete(posMap, name)
+		}
+	}
+
+	snapshots := s.snapshotRequests()
+	for _, name := range snapshots {
+		s.logEvent(slog.LevelWarn, "snapshot_requested", []any{"db", name}, "position mismatch limit reached on %q, requesting snapshot", name)
+	}
+
+	opts := StreamOptions{
+		Snapshots: snapshots,
+		Prefixes:  s.ReplicationPrefixes,
+		Token:     s.ReplicationToken,
+		Handoff:   s.canAcquireHandoff(),
+		Heartbeat: true,
+	}
+
+	// Stream from an upstream relay, if set, to offload the primary.
+	var st io.ReadCloser
+	var err error
+	if s.UpstreamURL != "" {
+		if st, err = s.Client.Stream(ctx, s.UpstreamURL, s.id, posMap, opts); err != nil {
+			s.logEvent(slog.LevelWarn, "relay_connect_error", []any{"upstream_url", s.UpstreamURL, "error", err}, "cannot connect to upstream relay, connecting to primary: %s ('%s')", err, s.UpstreamURL)
+		}
+	}
+	if st == nil {
+		st, err = s.Client.Stream(ctx, info.AdvertiseURL, s.id, posMap, opts)
+	}
+	if errors.Is(err, ErrReplicationAuth) || errors.Is(err, ErrDuplicateNodeID) {
+		return nil, fmt.Errorf("connect to primary: %w ('%s')", err, info.AdvertiseURL)
+	} else if err != nil {
+		return nil, fmt.Errorf("connect to primary: %s ('%s')", err, info.AdvertiseURL)
+	}
+	defer func() { _ = st.Close() }()
+
+	// Reset backoff now that the primary is reachable.
+	s.reconnectAttempts = 0
+
+	// Stop serving downstream replicas once we are no longer connected.
+	defer s.stopRelay()
+
+	// Persist the primary so we can reconnect quickly after a restart. The
+	// timestamp is refreshed on disconnect as the primary was reachable until then.
+	if err := s.writePrimaryInfo(info); err != nil {
+		s.logEvent(slog.LevelWarn, "primary_info_error", []any{"error", err}, "cannot write primary info cache: %s", err)
+	}
+	defer func() {
+		if err := s.writePrimaryInfo(info); err != nil {
+			s.logEvent(slog.LevelWarn, "primary_info_error", []any{"error", err}, "cannot write primary info cache: %s", err)
+		}
+	}()
+
+	// Frames spooled while paused are resent by the primary on reconnect.
+	defer s.clearPausedFrames()
+
+	// Acknowledge applied positions if synchronous replication is enabled or
+	// if the primary may hand off its lease to this node, as the primary only
+	// hands off once the node has acknowledged every transaction. Acks are
+	// sent by a separate request as the stream only flows from the primary.
+	ackCh := make(chan struct{}, 1)
+	if s.SyncReplicas > 0 || opts.Handoff {
+		ackCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() { defer close(done); s.monitorAcks(ackCtx, info, ackCh) }()
+		defer func() { cancel(); <-done }()
+	}
+
+	// Ask a lower weight primary to hand off once the initial catch up is done.
+	var preempting bool
+	var preemptWG sync.WaitGroup
+	preemptCtx, cancelPreempt := context.WithCancel(ctx)
+	defer func() { cancelPreempt(); preemptWG.Wait() }()
+
+	// Heartbeat timeouts are only enforced once the primary has shown that
+	// it sends heartbeats & at which interval.
+	var heartbeatInterval time.Duration
+	for {
+		frame, err := s.readStreamFrame(st, s.streamTimeout(heartbeatInterval))
+		if err == io.EOF {
+			return nil, nil // clean disconnect
+		} else if err != nil {
+			return nil, fmt.Errorf("next frame: %w", err)
+		}
+
+		switch frame := frame.(type) {
+		case *LTXStreamFrame, *RenameDBStreamFrame, *DropDBStreamFrame:
+			if ok, err := s.filterReplicatedStreamFrame(ctx, frame, chunk.NewReader(st)); err != nil {
+				return nil, err
+			} else if !ok {
+				continue
+			}
+
+			if err := s.processReplicatedStreamFrame(ctx, frame, chunk.NewReader(st)); err != nil {
+				return nil, err
+			}
+			notifyAck(ackCh)
+		case *ReadyStreamFrame:
+			// Mark store as ready once we've received an initial replication set.
+			s.markReady()
+			notifyAck(ackCh)
+
+			// Serve downstream replicas once caught up with the upstream node.
+			if s.Relay {
+				s.startRelay()
+			}
+
+			if !preempting && s.canPreempt(info) {
+				preempting = true
+				preemptWG.Add(1)
+				go func() { defer preemptWG.Done(); s.monitorPreempt(preemptCtx, info) }()
+			}
+		case *EndStreamFrame:
+			// Server cleanly disconnected
+			return nil, nil
+		case *HandoffStreamFrame:
+			lease, err := s.processHandoffStreamFrame(ctx, frame)
+			if err != nil {
+				return nil, fmt.Errorf("process handoff stream frame: %w", err)
+			}
+			return lease, nil
+		case *HeartbeatStreamFrame:
+			heartbeatInterval = time.Duration(frame.Interval) * time.Millisecond
+			s.processHeartbeatStreamFrame(ctx, frame)
+			notifyAck(ackCh) // resend in case frames were applied on resume
+		default:
+			return nil, fmt.Errorf("invalid stream frame type: 0x%02x", frame.Type())
+		}
+	}
+}
+
+// monitorAcks sends the current position map to the primary each time ch is
+// notified. Notifications that arrive while an ack is in-flight are coalesced
+// into a single ack. Unchanged positions are not resent.
+func (s *Store) monitorAcks(ctx context.Context, info *PrimaryInfo, ch <-chan struct{}) {
+	var prev map[string]Pos
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+		case <-s.ltxSyncCh():
+		}
+
+		posMap := s.DurablePosMap()
+		if reflect.DeepEqual(posMap, prev) {
+			continue
+		}
+
+		if err := s.Client.Ack(ctx, info.AdvertiseURL, s.id, posMap, s.ReplicationToken); err != nil {
+			if ctx.Err() == nil {
+				s.logEvent(slog.LevelWarn, "ack_error", []any{"error", err}, "cannot send ack to primary: %s", err)
+			}
+			continue
+		}
+		prev = posMap
+	}
+}
+
+// canPreempt returns true if this node outranks the primary described by info.
+// Primaries that do not report a weight are never preempted.
+func (s *Store) canPreempt(info *PrimaryInfo) bool {
+	return s.Candidate() && info.Weight > 0 && s.CandidateWeight > info.Weight
+}
+
+// monitorPreempt asks the primary to hand off its lease to this node until
+// it succeeds, the primary rejects the request, or ctx is canceled.
+func (s *Store) monitorPreempt(ctx context.Context, info *PrimaryInfo) {
+	for {
+		s.logEvent(slog.LevelInfo, "preempt_requested", []any{"weight", s.CandidateWeight, "primary_weight", info.Weight},
+			"requesting handoff from lower weight primary: weight=%d primary=%d", s.CandidateWeight, info.Weight)
+
+		err := s.Client.Preempt(ctx, info.AdvertiseURL, s.id, s.CandidateWeight, s.ReplicationToken)
+		if err == nil || ctx.Err() != nil {
+			return
+		} else if errors.Is(err, ErrPreemptRejected) {
+			s.logEvent(slog.LevelInfo, "preempt_rejected", []any{"error", err}, "primary rejected preempt request: %s", err)
+			return
+		}
+
+		s.logEvent(slog.LevelWarn, "preempt_error", []any{"error", err}, "cannot preempt primary, retrying: %s", err)
+		sleepWithContext(ctx, preemptRetryInterval)
+	}
+}
+
+// notifyAck signals the ack monitor without blocking.
+func notifyAck(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// filterReplicatedStreamFrame returns true if frame should be applied. Frames
+// for databases excluded by the replication filter are discarded from src so
+// the stream stays aligned. A rename to an excluded name drops the local copy.
+func (s *Store) filterReplicatedStreamFrame(ctx context.Context, frame StreamFrame, src io.Reader) (bool, error) {
+	switch frame := frame.(type) {
+	case *LTXStreamFrame:
+		if s.isReplicatedDB(frame.Name) {
+			return true, nil
+		}
+		if _, err := io.Copy(io.Discard, src); err != nil {
+			return false, fmt.Errorf("discard ltx stream frame: %w", err)
+		}
+		return false, nil
+
+	case *RenameDBStreamFrame:
+		if s.isReplicatedDB(frame.NewName) {
+			return true, nil
+		} else if !s.isReplicatedDB(frame.OldName) {
+			return false, nil
+		}
+		if err := s.processReplicatedStreamFrame(ctx, &DropDBStreamFrame{Name: frame.OldName}, src); err != nil {
+			return false, err
+		}
+		return false, nil
+
+	case *DropDBStreamFrame:
+		return s.isReplicatedDB(frame.Name), nil
+
+	default:
+		return true, nil
+	}
+}
+
+// processReplicatedStreamFrame applies a frame that changes database state.
+// If replication is paused then the frame is spooled to be applied later.
+func (s *Store) processReplicatedStreamFrame(ctx context.Context, frame StreamFrame, src io.Reader) error {
+	s.replicationPause.mu.Lock()
+	defer s.replicationPause.mu.Unlock()
+
+	if s.replicationPause.paused {
+		return s.spoolPausedFrameNoLock(frame, src)
 	}
+	return s.applyReplicatedStreamFrame(ctx, frame, src)
 }
 
-// monitorLease continuously handles either the leader lease or replicates from the primary.
-func (s *Store) monitorLease(ctx context.Context) error {
-	for {
-		// Exit if store is closed.
-		if err := ctx.Err(); err != nil {
-			return nil
+// applyReplicatedStreamFrame applies an LTX, rename or drop frame.
+func (s *Store) applyReplicatedStreamFrame(ctx context.Context, frame StreamFrame, src io.Reader) error {
+	switch frame := frame.(type) {
+	case *LTXStreamFrame:
+		if err := s.processLTXStreamFrame(ctx, frame, src); err != nil {
+			var mismatchErr *PosMismatchError
+			if errors.As(err, &mismatchErr) {
+				s.incrPosMismatch(mismatchErr.Name)
+			}
+			return fmt.Errorf("process ltx stream frame: %w", err)
 		}
 
-		// Attempt to either obtain a primary lock or read the current primary.
-		lease, info, err := s.acquireLeaseOrPrimaryInfo(ctx)
-		if err == ErrNoPrimary && !s.candidate {
-			log.Printf("%s: cannot find primary & ineligible to become primary, retrying: %s", FormatNodeID(s.id), err)
-			sleepWithContext(ctx, s.ReconnectDelay)
-			continue
-		} else if err != nil {
-			log.Printf("%s: cannot acquire lease or find primary, retrying: %s", FormatNodeID(s.id), err)
-			sleepWithContext(ctx, s.ReconnectDelay)
-			continue
+		// Quarantined databases keep their snapshot request until one arrives.
+		if db := s.DB(frame.Name); db == nil || !db.Quarantined() {
+			s.resetPosMismatch(frame.Name)
+		}
+	case *RenameDBStreamFrame:
+		if err := s.processRenameDBStreamFrame(ctx, frame); err != nil {
+			return fmt.Errorf("process rename db stream frame: %w", err)
+		}
+	case *DropDBStreamFrame:
+		if err := s.processDropDBStreamFrame(ctx, frame); err != nil {
+			return fmt.Errorf("process drop db stream frame: %w", err)
 		}
+	default:
+		return fmt.Errorf("invalid replicated stream frame type: 0x%02x", frame.Type())
+	}
+	return nil
+}
 
-		// Monitor as primary if we have obtained a lease.
-		if lease != nil {
-			log.Printf("%s: primary lease acquired, advertising as %s", FormatNodeID(s.id), s.Leaser.AdvertiseURL())
-			if err := s.monitorLeaseAsPrimary(ctx, lease); err != nil {
-				log.Printf("%s: primary lease lost, retrying: %s", FormatNodeID(s.id), err)
-			}
-			if err := s.Recover(ctx); err != nil {
-				log.Printf("%s: state change recovery error (primary): %s", FormatNodeID(s.id), err)
-			}
-			continue
+// spoolPausedFrameNoLock adds a frame to the paused queue. LTX files are
+// written to disk so they do not need to be held in memory.
+func (s *Store) spoolPausedFrameNoLock(frame StreamFrame, src io.Reader) error {
+	pf := pausedFrame{frame: frame}
+	if _, ok := frame.(*LTXStreamFrame); ok {
+		if err := s.FS.MkdirAll(s.PausedReplicationPath(), s.DirMode); err != nil {
+			return err
 		}
 
-		// Monitor as replica if another primary already exists.
-		log.Printf("%s: existing primary found (%s), connecting as replica", FormatNodeID(s.id), info.Hostname)
-		if err := s.monitorLeaseAsReplica(ctx, info); err == nil {
-			log.Printf("%s: disconnected from primary, retrying", FormatNodeID(s.id))
-		} else {
-			log.Printf("%s: disconnected from primary with error, retrying: %s", FormatNodeID(s.id), err)
+		s.replicationPause.seq++
+		pf.path = filepath.Join(s.PausedReplicationPath(), fmt.Sprintf("%016x.ltx", s.replicationPause.seq))
+
+		f, err := s.FS.OpenFile(pf.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.FileMode)
+		if err != nil {
+			return fmt.Errorf("create paused ltx file: %w", err)
 		}
-		if err := s.Recover(ctx); err != nil {
-			log.Printf("%s: state change recovery error (replica): %s", FormatNodeID(s.id), err)
+		defer func() { _ = f.Close() }()
+
+		if _, err := io.Copy(f, src); err != nil {
+			_ = s.FS.Remove(pf.path)
+			return fmt.Errorf("write paused ltx file: %w", err)
 		}
-		sleepWithContext(ctx, s.ReconnectDelay)
 	}
-}
 
-func (s *Store) acquireLeaseOrPrimaryInfo(ctx context.Context) (Lease, *PrimaryInfo, error) {
-	// Attempt to find an existing primary first.
-	info, err := s.Leaser.PrimaryInfo(ctx)
-	if err == ErrNoPrimary && !s.candidate {
-		return nil, nil, err // no primary, not eligible to become primary
-	} else if err != nil && err != ErrNoPrimary {
-		return nil, nil, fmt.Errorf("fetch primary url: %w", err)
-	} else if err == nil {
-		return nil, &info, nil
-	}
+	s.replicationPause.frames = append(s.replicationPause.frames, pf)
+	return nil
+}
 
-	// If no primary, attempt to become primary.
-	lease, err := s.Leaser.Acquire(ctx)
-	if err == ErrPrimaryExists {
-		// passthrough and retry primary info fetch
-	} else if err != nil {
-		return nil, nil, fmt.Errorf("acquire lease: %w", err)
-	} else if lease != nil {
-		return lease, nil, nil
+// applyPausedFrame applies a frame that was spooled while paused.
+func (s *Store) applyPausedFrame(ctx context.Context, pf pausedFrame) error {
+	if pf.path == "" {
+		return s.applyReplicatedStreamFrame(ctx, pf.frame, nil)
 	}
 
-	// If we raced to become primary and another node beat us, retry the fetch.
-	info, err = s.Leaser.PrimaryInfo(ctx)
+	f, err := s.FS.Open(pf.path)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
-	return nil, &info, nil
+	defer func() { _ = f.Close() }()
+
+	return s.applyReplicatedStreamFrame(ctx, pf.frame, f)
 }
 
-// monitorLeaseAsPrimary monitors & renews the current lease.
-// NOTE: This code is borrowed from the consul/api's RenewPeriodic() implementation.
-func (s *Store) monitorLeaseAsPrimary(ctx context.Context, lease Lease) error {
-	const timeout = 1 * time.Second
+// clearPausedFrames discards all spooled frames.
+func (s *Store) clearPausedFrames() {
+	s.replicationPause.mu.Lock()
+	defer s.replicationPause.mu.Unlock()
+	s.clearPausedFramesNoLock()
+}
 
-	// Attempt to destroy lease when we exit this function.
-	var demoted bool
-	defer func() {
-		log.Printf("%s: exiting primary, destroying lease", FormatNodeID(s.id))
-		if err := lease.Close(); err != nil {
-			log.Printf("%s: cannot remove lease: %s", FormatNodeID(s.id), err)
-		}
+func (s *Store) clearPausedFramesNoLock() {
+	s.replicationPause.frames = nil
+	if err := s.FS.RemoveAll(s.PausedReplicationPath()); err != nil {
+		log.Printf("%s: cannot remove paused ltx files: %s", FormatNodeID(s.id), err)
+	}
+}
 
-		// Pause momentarily if this was a manual demotion.
-		if demoted {
-			log.Printf("%s: waiting for %s after demotion", FormatNodeID(s.id), s.DemoteDelay)
-			sleepWithContext(ctx, s.DemoteDelay)
-		}
-	}()
+// readStreamFrame reads the next frame from the primary's stream. If timeout
+// is positive, the stream is closed if no frame arrives before it elapses.
+func (s *Store) readStreamFrame(st io.ReadCloser, timeout time.Duration) (StreamFrame, error) {
+	if timeout <= 0 {
+		return ReadStreamFrame(st)
+	}
 
-	// Mark as the primary node while we're in this function.
-	s.mu.Lock()
-	s.setIsPrimary(true)
-	demoteCh := s.demoteCh
-	s.mu.Unlock()
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		_ = st.Close()
+	})
+	defer timer.Stop()
 
-	// Mark store as ready if we've obtained primary status.
-	s.markReady()
+	frame, err := ReadStreamFrame(st)
+	if timedOut.Load() {
+		return nil, ErrHeartbeatTimeout
+	}
+	return frame, err
+}
 
-	// Ensure that we are no longer marked as primary once we exit this function.
+// streamTimeout returns the time to wait for a frame before disconnecting
+// given the heartbeat interval advertised by the primary. Returns zero if the
+// primary has not sent a heartbeat.
+func (s *Store) streamTimeout(heartbeatInterval time.Duration) time.Duration {
+	if heartbeatInterval <= 0 {
+		return 0
+	} else if s.StreamTimeout > 0 {
+		return s.StreamTimeout
+	}
+	return heartbeatInterval * time.Duration(s.HeartbeatMissLimit)
+}
+
+// processHandoffStreamFrame acquires the lease handed off by the primary.
+func (s *Store) processHandoffStreamFrame(ctx context.Context, frame *HandoffStreamFrame) (_ Lease, err error) {
+	TraceLog.Printf("%s [ProcessHandoffStreamFrame]: %s", s.LogPrefix(), frame.LeaseID)
 	defer func() {
-		s.mu.Lock()
-		defer s.mu.Unlock()
-		s.setIsPrimary(false)
+		TraceLog.Printf("%s [ProcessHandoffStreamFrame.End]: %s", s.LogPrefix(), errorKeyValue(err))
 	}()
 
-	waitDur := lease.TTL() / 2
+	if !s.canAcquireHandoff() {
+		return nil, ErrLeaseHandoffUnsupported
+	}
+	return s.Leaser.(HandoffLeaser).AcquireExisting(ctx, frame.LeaseID)
+}
+
+// canAcquireHandoff returns true if this node can become primary by acquiring
+// a lease handed off by the current primary.
+func (s *Store) canAcquireHandoff() bool {
+	_, ok := s.Leaser.(HandoffLeaser)
+	return ok && s.Candidate()
+}
+
+// processHeartbeatStreamFrame records the primary's positions so replication
+// lag can be reported. The primary sends them when idle & ahead of each batch
+// of changes so the lag reflects frames that have not been applied yet.
+func (s *Store) processHeartbeatStreamFrame(ctx context.Context, frame *HeartbeatStreamFrame) {
+	TraceLog.Printf("%s [ProcessHeartbeatStreamFrame]: timestamp=%s dbs=%d", s.LogPrefix(), time.UnixMilli(frame.Timestamp).UTC().Format(time.RFC3339Nano), len(frame.PosMap))
+
+	// The primary's position replaces the previous one as the primary may
+	// have changed & restarted from a lower TXID.
+	for name, pos := range frame.PosMap {
+		db := s.DB(name)
+		if db == nil {
+			continue
+		}
+
+		var timestamp int64
+		if !pos.Timestamp.IsZero() {
+			timestamp = pos.Timestamp.UnixMilli()
+		}
+		db.setPrimaryTXID(pos.TXID, timestamp)
+	}
+}
+
+// monitorRetention periodically enforces retention of LTX files on the databases.
+func (s *Store) monitorRetention(ctx context.Context) error {
+	ticker := time.NewTicker(s.RetentionMonitorInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-time.After(waitDur):
-			// Attempt to renew the lease. If the lease is gone then we need to
-			// just exit and we can start over or connect to the new primary.
-			//
-			// If we just have a connection error then we'll try to more
-			// aggressively retry the renewal until we exceed TTL.
-			if err := lease.Renew(ctx); err == ErrLeaseExpired {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := s.EnforceRetention(ctx); err != nil {
 				return err
-			} else if err != nil {
-				// If our next renewal will exceed TTL, exit now.
-				if time.Since(lease.RenewedAt())+timeout > lease.TTL() {
-					time.Sleep(timeout)
-					return ErrLeaseExpired
+			}
+
+			if s.CompressAtRest {
+				if err := s.CompressLTX(ctx); err != nil {
+					return err
 				}
+			}
+		}
+	}
+}
 
-				// Otherwise log error and try again after a shorter period.
-				log.Printf("%s: lease renewal error, retrying: %s", FormatNodeID(s.id), err)
-				waitDur = time.Second
-				continue
+// monitorCompaction periodically compacts LTX files on the databases.
+func (s *Store) monitorCompaction(ctx context.Context) error {
+	ticker := time.NewTicker(s.CompactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := s.Compact(ctx); err != nil {
+				s.logEvent(slog.LevelError, "compaction_error", []any{"error", err}, "compaction error: %s", err)
 			}
+		}
+	}
+}
 
-			// Renewal was successful, restart with low frequency.
-			waitDur = lease.TTL() / 2
+// monitorScrub periodically verifies the checksum of a single database so
+// that on-disk corruption is caught before it propagates to replicas.
+func (s *Store) monitorScrub(ctx context.Context) error {
+	ticker := time.NewTicker(s.ScrubInterval)
+	defer ticker.Stop()
 
-		case <-demoteCh:
-			demoted = true
-			log.Printf("%s: node manually demoted", FormatNodeID(s.id))
+	var prev string
+	for {
+		select {
+		case <-ctx.Done():
 			return nil
+		case <-ticker.C:
+			prev = s.scrubNext(ctx, prev)
+		}
+	}
+}
+
+// scrubNext verifies the database that follows prev in name order, wrapping
+// around at the end. Databases with a write in progress are skipped until
+// their next turn. Returns the name of the database that was visited.
+func (s *Store) scrubNext(ctx context.Context, prev string) string {
+	dbs := s.DBs()
+	if len(dbs) == 0 {
+		return ""
+	}
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].Name() < dbs[j].Name() })
+
+	db := dbs[0]
+	for _, other := range dbs {
+		if other.Name() > prev {
+			db = other
+			break
+		}
+	}
+
+	if db.InWriteTx() {
+		TraceLog.Printf("%s [Scrub(%s)]: write in progress, skipping", s.LogPrefix(), db.Name())
+		return db.Name()
+	}
+
+	if err := db.VerifyChecksum(ctx); errors.Is(err, ErrVerifyFailed) {
+		s.logEvent(slog.LevelError, "scrub_failed", []any{"db", db.Name(), "txid", ltx.FormatTXID(db.TXID()), "error", err}, "scrub failed, database may be corrupt: %s", err)
+		storeScrubErrorCountMetricVec.WithLabelValues(db.Name()).Inc()
+	} else if err != nil && ctx.Err() == nil {
+		s.logEvent(slog.LevelWarn, "scrub_error", []any{"db", db.Name(), "error", err}, "cannot scrub database %q: %s", db.Name(), err)
+	}
+	return db.Name()
+}
+
+// backupQueueSize is the number of LTX files that can wait to be backed up
+// before new files are skipped.
+const backupQueueSize = 1024
+
+// backupRequest represents an LTX file waiting to be written to Backup.
+type backupRequest struct {
+	db      *DB
+	minTXID uint64
+	maxTXID uint64
+}
+
+// enqueueBackup adds an LTX file to the backup queue. If the queue is full,
+// the file is skipped and counted as a backup error. Skipped files are retried
+// by retention enforcement if RetentionRequireBackup is set.
+func (s *Store) enqueueBackup(db *DB, hdr ltx.Header) {
+	if s.Backup == nil {
+		return
+	}
+
+	select {
+	case s.backupCh <- backupRequest{db: db, minTXID: hdr.MinTXID, maxTXID: hdr.MaxTXID}:
+	default:
+		backupErrorCountMetric.Inc()
+		s.logEvent(slog.LevelWarn, "backup_skipped", []any{"db", db.Name(), "txid", ltx.FormatTXID(hdr.MaxTXID)},
+			"backup queue full, skipping ltx file: db=%s file=%s", db.Name(), ltx.FormatFilename(hdr.MinTXID, hdr.MaxTXID))
+	}
+}
 
+// monitorBackup writes queued LTX files to Backup until ctx is canceled.
+func (s *Store) monitorBackup(ctx context.Context) error {
+	for {
+		select {
 		case <-ctx.Done():
-			return nil // release lease when we shut down
+			return nil
+		case req := <-s.backupCh:
+			if err := req.db.backupLTX(ctx, req.minTXID, req.maxTXID); err != nil {
+				s.logEvent(slog.LevelError, "backup_error", []any{"db", req.db.Name(), "txid", ltx.FormatTXID(req.maxTXID), "error", err},
+					"backup error: db=%s file=%s err=%s", req.db.Name(), ltx.FormatFilename(req.minTXID, req.maxTXID), err)
+			}
 		}
 	}
 }
 
-// monitorLeaseAsReplica tries to connect to the primary node and stream down changes.
-func (s *Store) monitorLeaseAsReplica(ctx context.Context, info *PrimaryInfo) error {
-	if s.Client == nil {
-		return fmt.Errorf("no client set, skipping replica monitor")
+// monitorHaltLock periodically check all halt locks for expiration.
+func (s *Store) monitorHaltLock(ctx context.Context) error {
+	ticker := time.NewTicker(s.HaltLockMonitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.EnforceHaltLockExpiration(ctx)
+		}
 	}
+}
 
-	// Store the URL of the primary while we're in this function.
+// EnforceHaltLockExpiration expires any overdue HALT locks.
+func (s *Store) EnforceHaltLockExpiration(ctx context.Context) {
 	s.mu.Lock()
-	s.primaryInfo = info
-	s.mu.Unlock()
+	defer s.mu.Unlock()
 
-	// Clear the primary URL once we leave this function since we can no longer connect.
+	for _, db := range s.dbs {
+		db.EnforceHaltLockExpiration(ctx)
+	}
+}
+
+// Recover forces a rollback (journal) or checkpoint (wal) on all open databases.
+// This is done when switching the primary/replica state.
+func (s *Store) Recover(ctx context.Context) (err error) {
+	g, ctx := s.newDBGroup(ctx)
+	for _, db := range s.DBs() {
+		db := db
+		g.Go(func() error {
+			if ctx.Err() != nil {
+				return nil // skip remaining databases after the first error
+			}
+			if err := db.Recover(ctx); err != nil {
+				return fmt.Errorf("db %q: %w", db.Name(), err)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// Handoff transfers the primary lease to the connected replica with the given
+// node ID. Writes are blocked on all databases while waiting for the replica
+// to apply every transaction and the lease is only handed off once the
+// replica has acknowledged applying them.
+//
+// Returns ErrLeaseHandoffUnsupported if the lease cannot be handed off or if
+// the replica cannot acquire a handed off lease. Returns an error if the node
+// is not a connected replica or if it is further behind than HandoffMaxLag
+// when the handoff begins. Returns ErrHandoffTimeout if the replica does not
+// catch up within HandoffTimeout, in which case this node remains the primary.
+func (s *Store) Handoff(ctx context.Context, nodeID uint64) (err error) {
+	TraceLog.Printf("%s [Handoff(%s)]", s.LogPrefix(), FormatNodeID(nodeID))
 	defer func() {
-		s.mu.Lock()
-		defer s.mu.Unlock()
-		s.primaryInfo = nil
+		TraceLog.Printf("%s [Handoff.End(%s)]: %s", s.LogPrefix(), FormatNodeID(nodeID), errorKeyValue(err))
 	}()
 
-	posMap := s.PosMap()
-	st, err := s.Client.Stream(ctx, info.AdvertiseURL, s.id, posMap)
-	if err != nil {
-		return fmt.Errorf("connect to primary: %s ('%s')", err, info.AdvertiseURL)
+	s.mu.Lock()
+	isPrimary, lease, primaryCh := s.isPrimary, s.lease, s.primaryCh
+	sub := s.subscriberByNodeID(nodeID)
+	s.mu.Unlock()
+
+	if !isPrimary || lease == nil {
+		return ErrNotPrimary
+	} else if leaseID(lease) == "" {
+		return ErrLeaseHandoffUnsupported
+	} else if sub == nil {
+		return fmt.Errorf("%w: %s", ErrReplicaNotFound, FormatNodeID(nodeID))
+	} else if !sub.opts.Handoff {
+		return fmt.Errorf("%w: replica %s cannot acquire lease", ErrLeaseHandoffUnsupported, FormatNodeID(nodeID))
 	}
-	defer func() { _ = st.Close() }()
+
+	// Reject the handoff before blocking writes if the target is too far behind.
+	if lag := s.txIDLag(s.ackPosMap(nodeID)); s.HandoffMaxLag > 0 && lag > s.HandoffMaxLag {
+		return fmt.Errorf("%w: lag=%d max=%d", ErrReplicaLagging, lag, s.HandoffMaxLag)
+	}
+
+	// Block writes so the target can catch up to a fixed position.
+	for _, db := range s.DBs() {
+		guardSet, err := db.acquireWriteLock(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("acquire write lock(%q): %w", db.Name(), err)
+		}
+		defer guardSet.Unlock()
+	}
+
+	// Wait for the target to acknowledge applying all transactions. The
+	// positions sent to the target are not used as it may not have applied
+	// them yet.
+	timeout := s.HandoffTimeout
+	if timeout <= 0 {
+		timeout = DefaultHandoffTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 
 	for {
-		frame, err := ReadStreamFrame(st)
-		if err == io.EOF {
-			return nil // clean disconnect
-		} else if err != nil {
-			return fmt.Errorf("next frame: %w", err)
+		s.mu.Lock()
+		posMap, ackCh := s.acks[nodeID], s.ackCh
+		s.mu.Unlock()
+
+		if s.txIDLag(posMap) == 0 {
+			break
 		}
 
-		switch frame := frame.(type) {
-		case *LTXStreamFrame:
-			if err := s.processLTXStreamFrame(ctx, frame, chunk.NewReader(st)); err != nil {
-				return fmt.Errorf("process ltx stream frame: %w", err)
-			}
-		case *ReadyStreamFrame:
-			// Mark store as ready once we've received an initial replication set.
-			s.markReady()
-		case *EndStreamFrame:
-			// Server cleanly disconnected
-			return nil
-		case *DropDBStreamFrame:
-			if err := s.processDropDBStreamFrame(ctx, frame); err != nil {
-				return fmt.Errorf("process drop db stream frame: %w", err)
-			}
-		default:
-			return fmt.Errorf("invalid stream frame type: 0x%02x", frame.Type())
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-timer.C:
+			return ErrHandoffTimeout
+		case <-primaryCh:
+			return ErrNotPrimary
+		case <-ackCh:
 		}
 	}
+
+	s.logEvent(slog.LevelInfo, "lease_handoff", []any{"target_node_id", FormatNodeID(nodeID)}, "handing off primary lease to %s", FormatNodeID(nodeID))
+	if err := sub.handoff(ctx, leaseID(lease)); err != nil {
+		return fmt.Errorf("send handoff: %w", err)
+	}
+
+	// Step down without releasing the lease. Once the handoff has been sent,
+	// we cannot remain primary so this ignores context cancellation.
+	select {
+	case s.handoffCh <- struct{}{}:
+	case <-primaryCh:
+	}
+	<-primaryCh
+
+	return nil
+}
+
+// ackPosMap returns the positions last acknowledged by a replica.
+func (s *Store) ackPosMap(nodeID uint64) map[string]Pos {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.acks[nodeID]
+}
+
+// Preempt hands off the lease to the replica nodeID if weight is higher than
+// this node's candidate weight. Returns ErrPreemptRejected otherwise.
+func (s *Store) Preempt(ctx context.Context, nodeID uint64, weight int) error {
+	if !s.IsPrimary() {
+		return ErrNotPrimary
+	} else if weight <= s.CandidateWeight {
+		return ErrPreemptRejected
+	}
+
+	s.logEvent(slog.LevelInfo, "preempted", []any{"target_node_id", FormatNodeID(nodeID), "weight", weight, "primary_weight", s.CandidateWeight},
+		"preempted by higher weight candidate %s: weight=%d primary=%d", FormatNodeID(nodeID), weight, s.CandidateWeight)
+	return s.Handoff(ctx, nodeID)
 }
 
-// monitorRetention periodically enforces retention of LTX files on the databases.
-func (s *Store) monitorRetention(ctx context.Context) error {
-	ticker := time.NewTicker(s.RetentionMonitorInterval)
-	defer ticker.Stop()
+// Promote makes this node the primary by asking the current primary to hand
+// off its lease. If there is no primary, the node immediately attempts to
+// acquire the lease itself, skipping the election delay. Blocks until the
+// node is primary or ctx is done.
+//
+// Returns ErrNotCandidate if the node is not eligible to become primary.
+// Returns ErrReplicaLagging if the node is further behind the primary than
+// HandoffMaxLag so that a promotion cannot lose transactions.
+func (s *Store) Promote(ctx context.Context) error {
+	if !s.Candidate() {
+		return ErrNotCandidate
+	}
+
+	s.promoting.Store(true)
+	defer s.promoting.Store(false)
+
+	// Wake the lease monitor if it is waiting to retry.
+	s.mu.Lock()
+	close(s.promoteCh)
+	s.promoteCh = make(chan struct{})
+	s.mu.Unlock()
 
+	var requestedURL string
 	for {
-		select {
-		case <-ctx.Done():
+		isPrimary, info := s.PrimaryInfo()
+		if isPrimary {
 			return nil
-		case <-ticker.C:
-			if err := s.EnforceRetention(ctx); err != nil {
+		}
+
+		// Request a handoff once per primary. Failed requests are retried
+		// unless the primary has rejected this node as its replacement.
+		if info != nil && info.AdvertiseURL != requestedURL {
+			if lag := s.maxTXIDLag(); s.HandoffMaxLag > 0 && lag > s.HandoffMaxLag {
+				return fmt.Errorf("%w: lag=%d max=%d", ErrReplicaLagging, lag, s.HandoffMaxLag)
+			}
+
+			s.logEvent(slog.LevelInfo, "promote_requested", nil, "requesting handoff from primary for promotion")
+			if err := s.Client.Handoff(ctx, info.AdvertiseURL, s.id, s.ReplicationToken); errors.Is(err, ErrReplicaLagging) || errors.Is(err, ErrReplicationAuth) {
 				return err
+			} else if err != nil {
+				if ctx.Err() == nil {
+					s.logEvent(slog.LevelWarn, "promote_error", []any{"error", err}, "cannot request handoff, retrying: %s", err)
+				}
+			} else {
+				requestedURL = info.AdvertiseURL
 			}
 		}
+
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(promotePollInterval):
+		}
 	}
 }
 
-// monitorHaltLock periodically check all halt locks for expiration.
-func (s *Store) monitorHaltLock(ctx context.Context) error {
-	ticker := time.NewTicker(s.HaltLockMonitorInterval)
-	defer ticker.Stop()
+// maxTXIDLag returns the highest number of transactions that any database is
+// behind the primary, as last reported by the replication stream.
+func (s *Store) maxTXIDLag() (lag uint64) {
+	for _, db := range s.DBs() {
+		if v := db.TXIDLag(); v > lag {
+			lag = v
+		}
+	}
+	return lag
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case <-ticker.C:
-			s.EnforceHaltLockExpiration(ctx)
+// electionDelay returns the time to wait before acquiring the lease so that
+// higher weight candidates acquire it first. No delay is used while the node
+// is being promoted.
+func (s *Store) electionDelay() time.Duration {
+	if s.CandidateWeight >= MaxCandidateWeight || s.promoting.Load() {
+		return 0
+	}
+	return time.Duration(MaxCandidateWeight-s.CandidateWeight) * electionDelayPerWeight
+}
+
+// promotePollInterval is the time between checking for primary status during a promotion.
+const promotePollInterval = 100 * time.Millisecond
+
+// MaxCandidateWeight is the highest candidate weight. Higher weights are
+// treated as the maximum when delaying elections.
+const MaxCandidateWeight = 100
+
+// electionDelayPerWeight is the time a candidate waits before acquiring the
+// lease for each point its weight is below MaxCandidateWeight.
+const electionDelayPerWeight = 10 * time.Millisecond
+
+// preemptRetryInterval is the time between preempt requests to a lower
+// weight primary that failed to hand off.
+const preemptRetryInterval = 10 * time.Second
+
+// txIDLag returns the maximum number of transactions that posMap is behind
+// the current database positions.
+func (s *Store) txIDLag(posMap map[string]Pos) (lag uint64) {
+	for _, db := range s.DBs() {
+		if txID, other := db.TXID(), posMap[db.Name()].TXID; txID > other && txID-other > lag {
+			lag = txID - other
 		}
 	}
+	return lag
 }
 
-// EnforceHaltLockExpiration expires any overdue HALT locks.
-func (s *Store) EnforceHaltLockExpiration(ctx context.Context) {
+// DBRetention returns the effective retention for a database. This is the
+// per-database override, if one exists, or the store's default retention.
+func (s *Store) DBRetention(name string) time.Duration {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for _, db := range s.dbs {
-		db.EnforceHaltLockExpiration(ctx)
+	if d, ok := s.retentionOverrides[name]; ok {
+		return d
 	}
+	return s.Retention
 }
 
-// Recover forces a rollback (journal) or checkpoint (wal) on all open databases.
-// This is done when switching the primary/replica state.
-func (s *Store) Recover(ctx context.Context) (err error) {
-	for _, db := range s.DBs() {
-		if err := db.Recover(ctx); err != nil {
-			return fmt.Errorf("db %q: %w", db.Name(), err)
+// isReadOnlyDB returns true if name is listed in ReadOnlyDBs.
+func (s *Store) isReadOnlyDB(name string) bool {
+	for _, v := range s.ReadOnlyDBs {
+		if v == name {
+			return true
 		}
 	}
-	return nil
+	return false
+}
+
+// isNoReplicateDB returns true if name is listed in NoReplicateDBs.
+func (s *Store) isNoReplicateDB(name string) bool {
+	for _, v := range s.NoReplicateDBs {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isReplicatedDB returns true if name matches ReplicationPrefixes & ReplicationFilter.
+func (s *Store) isReplicatedDB(name string) bool {
+	if !MatchPrefixes(name, s.ReplicationPrefixes) {
+		return false
+	}
+	return s.ReplicationFilter == nil || s.ReplicationFilter(name)
+}
+
+// MatchPrefixes returns true if name starts with any of prefixes or if no
+// prefixes are specified.
+func MatchPrefixes(name string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
 // EnforceRetention enforces retention of LTX files on all databases.
+// Returns the first error encountered but continues to enforce retention
+// on the remaining databases.
 func (s *Store) EnforceRetention(ctx context.Context) (err error) {
-	// Skip enforcement if not set.
-	if s.Retention <= 0 {
-		return nil
+	now := time.Now()
+
+	// Every database is processed, even after an error, so this does not use
+	// newDBGroup() as its context would be canceled on the first error.
+	var g errgroup.Group
+	g.SetLimit(s.openConcurrency())
+	for _, db := range s.DBs() {
+		// Skip enforcement if neither an age or count limit is set.
+		retention := s.DBRetention(db.Name())
+		if retention <= 0 && db.MaxLTXFileCount() <= 0 {
+			continue
+		}
+
+		var minTime time.Time
+		if retention > 0 {
+			minTime = now.Add(-retention).UTC()
+		}
+
+		db := db
+		g.Go(func() error {
+			if err := db.EnforceRetention(ctx, minTime); err != nil {
+				return fmt.Errorf("cannot enforce retention on db %q: %w", db.Name(), err)
+			}
+			return nil
+		})
 	}
+	return g.Wait()
+}
 
-	minTime := time.Now().Add(-s.Retention).UTC()
+// Compact compacts the LTX files of all databases. Returns the first error
+// encountered but continues to compact the remaining databases.
+func (s *Store) Compact(ctx context.Context) error {
+	var g errgroup.Group
+	g.SetLimit(s.openConcurrency())
+	for _, db := range s.DBs() {
+		db := db
+		g.Go(func() error {
+			if err := db.Compact(ctx); err != nil {
+				return fmt.Errorf("cannot compact db %q: %w", db.Name(), err)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
 
+// CompressLTX compresses the LTX files below the current position of all
+// databases. Returns the first error encountered but continues to compress
+// the remaining databases.
+func (s *Store) CompressLTX(ctx context.Context) error {
+	var g errgroup.Group
+	g.SetLimit(s.openConcurrency())
 	for _, db := range s.DBs() {
-		if e := db.EnforceRetention(ctx, minTime); err == nil {
-			err = fmt.Errorf("cannot enforce retention on db %q: %w", db.Name(), e)
-		}
+		db := db
+		g.Go(func() error {
+			if err := db.CompressLTX(ctx); err != nil {
+				return fmt.Errorf("cannot compress ltx files on db %q: %w", db.Name(), err)
+			}
+			return nil
+		})
 	}
-	return nil
+	return g.Wait()
 }
 
 func (s *Store) processLTXStreamFrame(ctx context.Context, frame *LTXStreamFrame, src io.Reader) (err error) {
 	db, err := s.CreateDBIfNotExists(frame.Name)
-	if err != nil {
+	if err == ErrTooManyDatabases {
+		// Skip the frame rather than reconnecting so that a runaway primary
+		// does not cause the replica to continuously retry the stream.
+		s.logEvent(slog.LevelWarn, "ltx_skipped", []any{"db", frame.Name}, "skipping ltx frame for %q, database limit of %d reached", frame.Name, s.MaxDatabases)
+		storeMaxDBSkipCountMetric.Inc()
+		if _, err := io.Copy(io.Discard, src); err != nil {
+			return fmt.Errorf("discard ltx body: %w", err)
+		}
+		return nil
+	} else if err != nil {
 		return fmt.Errorf("create database: %w", err)
 	}
 
 	hdr, data, err := ltx.DecodeHeader(src)
-	if err != nil {
+	if err != nil && len(data) == ltx.HeaderSize && !ltx.IsValidHeaderFlags(hdr.Flags) {
+		return fmt.Errorf("peek ltx header: unsupported ltx compression: flags=0x%08x", hdr.Flags)
+	} else if err != nil {
 		return fmt.Errorf("peek ltx header: %w", err)
 	}
 	src = io.MultiReader(bytes.NewReader(data), src)
 
-	TraceLog.Printf("%s [ProcessLTXStreamFrame.Begin(%s)]: txid=%s-%s, preApplyChecksum=%016x", s.LogPrefix(), db.Name(), ltx.FormatTXID(hdr.MinTXID), ltx.FormatTXID(hdr.MaxTXID), hdr.PreApplyChecksum)
+	TraceLog.Printf("%s [ProcessLTXStreamFrame.Begin(%s)]: txid=%s-%s, preApplyChecksum=%016x compression=%s", s.LogPrefix(), db.Name(), ltx.FormatTXID(hdr.MinTXID), ltx.FormatTXID(hdr.MaxTXID), hdr.PreApplyChecksum, ltxCompression(hdr.Flags))
 	defer func() {
 		TraceLog.Printf("%s [ProcessLTXStreamFrame.End(%s)]: %s", db.store.LogPrefix(), db.name, errorKeyValue(err))
 	}()
 
+	// Discard frames for a database that halted replication after diverging
+	// or that is quarantined & waiting on a snapshot.
+	if db.Diverged() || (db.Quarantined() && !hdr.IsSnapshot()) {
+		if _, err := io.Copy(io.Discard, src); err != nil {
+			return fmt.Errorf("discard ltx body: %w", err)
+		}
+		return nil
+	}
+
 	// Acquire lock unless we are waiting for a database position, in which case,
 	// we already have the lock.
-	guardSet, err := db.AcquireWriteLock(ctx, nil)
+	guardSet, err := db.acquireWriteLock(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -876,73 +3674,448 @@ func (s *Store) processLTXStreamFrame(ctx context.Context, frame *LTXStreamFrame
 		}
 	}
 
-	// Verify LTX file pre-apply checksum matches the current database position
-	// unless this is a snapshot, which will overwrite all data.
-	if !hdr.IsSnapshot() {
-		expectedPos := Pos{
-			TXID:              hdr.MinTXID - 1,
-			PostApplyChecksum: hdr.PreApplyChecksum,
-		}
-		if pos := db.Pos(); pos != expectedPos {
-			return fmt.Errorf("position mismatch on db %q: %s <> %s", db.Name(), pos, expectedPos)
+	// Determine if the database has transactions the primary does not have.
+	// Snapshots always end at the primary's position.
+	primaryTXID := hdr.MinTXID - 1
+	if hdr.IsSnapshot() {
+		primaryTXID = hdr.MaxTXID
+	}
+	if pos := db.Pos(); pos.TXID > primaryTXID {
+		if err := s.handleDivergence(db, pos, primaryTXID, hdr.IsSnapshot()); err != nil {
+			return err
+		} else if db.Diverged() {
+			if _, err := io.Copy(io.Discard, src); err != nil {
+				return fmt.Errorf("discard ltx body: %w", err)
+			}
+			return nil
+		}
+	}
+
+	// Verify LTX file pre-apply checksum matches the current database position
+	// unless this is a snapshot, which will overwrite all data.
+	if !hdr.IsSnapshot() {
+		expectedPos := Pos{
+			TXID:              hdr.MinTXID - 1,
+			PostApplyChecksum: hdr.PreApplyChecksum,
+		}
+		if pos := db.Pos(); pos.TXID != expectedPos.TXID || pos.PostApplyChecksum != expectedPos.PostApplyChecksum {
+			return s.handlePosMismatch(db, &PosMismatchError{Name: db.Name(), Pos: pos, Expected: expectedPos}, src)
+		}
+	}
+
+	if err := s.writeAndApplyLTX(ctx, db, hdr, src, true); err != nil {
+		return err
+	}
+	lag := time.Since(time.UnixMilli(hdr.Timestamp))
+	dbLTXStreamLagSecondsMetricVec.WithLabelValues(db.Name()).Observe(lag.Seconds())
+
+	// A snapshot replaces the database so it no longer needs to be quarantined.
+	if hdr.IsSnapshot() && db.quarantined.CompareAndSwap(true, false) {
+		s.logEvent(slog.LevelInfo, "db_unquarantined", []any{"db", db.Name(), "txid", ltx.FormatTXID(hdr.MaxTXID)},
+			"snapshot applied, database %q is no longer quarantined", db.Name())
+	}
+	return nil
+}
+
+// posMismatchAttrs returns the structured log attributes for a position mismatch.
+func posMismatchAttrs(err *PosMismatchError) []any {
+	return []any{"db", err.Name, "txid", ltx.FormatTXID(err.Pos.TXID), "expected_txid", ltx.FormatTXID(err.Expected.TXID)}
+}
+
+// handlePosMismatch applies the store's divergence policy to an LTX file that
+// does not follow the database's position. Must hold the database write lock.
+func (s *Store) handlePosMismatch(db *DB, mismatchErr *PosMismatchError, src io.Reader) error {
+	switch s.OnDivergence {
+	case DivergencePolicyResnapshot:
+		s.logEvent(slog.LevelWarn, "snapshot_requested", posMismatchAttrs(mismatchErr), "%s, requesting snapshot", mismatchErr)
+		if s.requestSnapshot(db.Name()) {
+			return fmt.Errorf("%w, %w", mismatchErr, errSnapshotRequested)
+		}
+		return fmt.Errorf("%w, snapshot pending", mismatchErr)
+
+	case DivergencePolicyQuarantine:
+		if !db.quarantined.Swap(true) {
+			s.logEvent(slog.LevelWarn, "db_quarantined", posMismatchAttrs(mismatchErr), "%s, database quarantined until a snapshot is received", mismatchErr)
+		}
+		if _, err := io.Copy(io.Discard, src); err != nil {
+			return fmt.Errorf("discard ltx body: %w", err)
+		}
+
+		// Reconnect immediately so the snapshot is requested from the primary.
+		// If the request was already sent, keep streaming other databases.
+		if s.requestSnapshot(db.Name()) {
+			return fmt.Errorf("database %q quarantined: %w", db.Name(), errSnapshotRequested)
+		}
+		return nil
+
+	case DivergencePolicyHalt:
+		db.diverged.Store(true)
+		s.logEvent(slog.LevelWarn, "db_halted", posMismatchAttrs(mismatchErr), "%s, replication halted on database %q until it is resolved manually", mismatchErr, db.Name())
+		if _, err := io.Copy(io.Discard, src); err != nil {
+			return fmt.Errorf("discard ltx body: %w", err)
+		}
+		return nil
+
+	default:
+		return mismatchErr
+	}
+}
+
+// handleDivergence applies the store's divergence policy to a database whose
+// position is ahead of the primary's position at primaryTXID. Returns an error
+// if the LTX file cannot be applied. Must hold the database write lock.
+func (s *Store) handleDivergence(db *DB, pos Pos, primaryTXID uint64, snapshot bool) error {
+	s.logEvent(slog.LevelWarn, "db_diverged", []any{"db", db.Name(), "txid", ltx.FormatTXID(pos.TXID), "primary_txid", ltx.FormatTXID(primaryTXID)},
+		"database %q is ahead of primary: pos=%s primary=%s policy=%s", db.Name(), pos, ltx.FormatTXID(primaryTXID), s.OnDivergence)
+	storeDivergenceCountMetricVec.WithLabelValues(s.OnDivergence.String()).Inc()
+
+	switch s.OnDivergence {
+	case DivergencePolicyResnapshot:
+		n, err := db.discardLTXAfter(primaryTXID)
+		if err != nil {
+			return fmt.Errorf("discard diverged ltx files: %w", err)
+		}
+		s.logEvent(slog.LevelWarn, "ltx_discarded", []any{"db", db.Name(), "txid", ltx.FormatTXID(primaryTXID), "n", n},
+			"discarded %d ltx file(s) after %s on database %q", n, ltx.FormatTXID(primaryTXID), db.Name())
+
+		// Snapshots overwrite the remaining local state so they can be applied.
+		if snapshot {
+			return nil
+		} else if s.requestSnapshot(db.Name()) {
+			return fmt.Errorf("%w: db=%s pos=%s primary=%s, %w", ErrDiverged, db.Name(), pos, ltx.FormatTXID(primaryTXID), errSnapshotRequested)
+		}
+		return fmt.Errorf("%w: db=%s pos=%s primary=%s, snapshot pending", ErrDiverged, db.Name(), pos, ltx.FormatTXID(primaryTXID))
+
+	case DivergencePolicyHalt:
+		db.diverged.Store(true)
+		s.logEvent(slog.LevelWarn, "db_halted", []any{"db", db.Name(), "txid", ltx.FormatTXID(pos.TXID), "primary_txid", ltx.FormatTXID(primaryTXID)},
+			"replication halted on database %q until it is resolved manually", db.Name())
+		return nil
+
+	default:
+		return nil // handled by the position check, if not a snapshot
+	}
+}
+
+// writeAndApplyLTX writes the LTX file from src to the database's LTX
+// directory and applies it. The header must have already been read from src
+// and rejoined with it. Must hold the database write lock.
+func (s *Store) writeAndApplyLTX(ctx context.Context, db *DB, hdr ltx.Header, src io.Reader, replicated bool) error {
+	// Only LTX files received from the primary follow the configured sync
+	// mode. Imported & restored files are always synced.
+	mode := SyncFull
+	if replicated {
+		mode = s.SyncMode
+	}
+
+	// Write LTX file to a temporary file and we'll atomically rename later.
+	path := db.LTXPath(hdr.MinTXID, hdr.MaxTXID)
+	tmpPath := fmt.Sprintf("%s.%d.tmp", path, rand.Int())
+	defer func() { _ = s.FS.Remove(tmpPath) }()
+
+	f, err := s.FS.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.FileMode)
+	if err != nil {
+		return fmt.Errorf("cannot create temp ltx file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	n, err := io.Copy(f, src)
+	if err != nil {
+		return fmt.Errorf("write ltx file: %w", err)
+	} else if mode == SyncFull {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("fsync ltx file: %w", err)
+		}
+	}
+
+	// Atomically rename file.
+	if err := s.FS.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename ltx file: %w", err)
+	}
+	db.ltxHeaders.Remove(filepath.Base(path))
+
+	if mode == SyncFull {
+		if err := s.FS.SyncDir(filepath.Dir(path)); err != nil {
+			return fmt.Errorf("sync ltx dir: %w", err)
+		}
+	}
+	s.enqueueBackup(db, hdr)
+
+	// Update metrics
+	dbLTXCountMetricVec.WithLabelValues(db.Name()).Inc()
+	dbLTXBytesMetricVec.WithLabelValues(db.Name()).Set(float64(n))
+
+	// Remove other LTX files after a snapshot.
+	if hdr.IsSnapshot() {
+		dir, file := filepath.Split(path)
+		s.logEvent(slog.LevelInfo, "snapshot_received", []any{"db", db.Name(), "txid", ltx.FormatTXID(hdr.MaxTXID)}, "snapshot received for %q, removing other ltx files: %s", db.Name(), file)
+		if err := removeFilesExcept(s.FS, dir, file); err != nil {
+			return fmt.Errorf("remove ltx after snapshot: %w", err)
+		}
+		db.ltxHeaders.Purge()
+	}
+
+	// Attempt to apply the LTX file to the database.
+	prevPos := db.Pos()
+	if err := db.ApplyLTXNoLock(ctx, path); err != nil {
+		return fmt.Errorf("apply ltx: %w", err)
+	}
+
+	// Track the file until the next batch sync so the position is only
+	// acknowledged once the file is durable.
+	if mode == SyncBatch {
+		s.addPendingLTXSync(db.Name(), path, prevPos)
+	}
+
+	return nil
+}
+
+// DurablePosMap returns the position of each database that is guaranteed to
+// survive a crash. This is the same as PosMap() unless SyncMode is SyncBatch,
+// in which case databases with unsynced LTX files report the position before
+// the first unsynced file.
+func (s *Store) DurablePosMap() map[string]Pos {
+	m := s.PosMap()
+	if s.SyncMode != SyncBatch {
+		return m
+	}
+
+	s.ltxSync.mu.Lock()
+	defer s.ltxSync.mu.Unlock()
+
+	for _, batch := range []*ltxSyncBatch{s.ltxSync.pending, s.ltxSync.inflight} {
+		if batch == nil {
+			continue
+		}
+		for name, pos := range batch.posMap {
+			if _, ok := m[name]; ok {
+				m[name] = pos
+			}
+		}
+	}
+	return m
+}
+
+// ltxSyncBatch is a set of LTX files waiting to be fsynced.
+type ltxSyncBatch struct {
+	paths  map[string]struct{}
+	posMap map[string]Pos // durable position, by database, before the first file
+}
+
+// ltxSyncCh returns a channel that is closed when the next batch sync completes.
+func (s *Store) ltxSyncCh() <-chan struct{} {
+	s.ltxSync.mu.Lock()
+	defer s.ltxSync.mu.Unlock()
+	if s.ltxSync.ch == nil {
+		s.ltxSync.ch = make(chan struct{})
+	}
+	return s.ltxSync.ch
+}
+
+// addPendingLTXSync adds an LTX file to the next batch sync. The pos is the
+// position of the database before the file was applied.
+func (s *Store) addPendingLTXSync(name, path string, pos Pos) {
+	s.ltxSync.mu.Lock()
+	defer s.ltxSync.mu.Unlock()
+
+	if s.ltxSync.pending == nil {
+		s.ltxSync.pending = &ltxSyncBatch{
+			paths:  make(map[string]struct{}),
+			posMap: make(map[string]Pos),
+		}
+	}
+	s.ltxSync.pending.paths[path] = struct{}{}
+	if _, ok := s.ltxSync.pending.posMap[name]; !ok {
+		s.ltxSync.pending.posMap[name] = pos
+	}
+}
+
+// monitorLTXSync fsyncs pending LTX files every SyncBatchInterval. Pending
+// files are synced one final time when ctx is done.
+func (s *Store) monitorLTXSync(ctx context.Context) error {
+	ticker := time.NewTicker(s.SyncBatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.syncPendingLTX(); err != nil {
+				s.logEvent(slog.LevelError, "sync_error", []any{"error", err}, "cannot sync ltx files on close: %s", err)
+			}
+			return nil
+		case <-ticker.C:
+			if err := s.syncPendingLTX(); err != nil {
+				s.logEvent(slog.LevelWarn, "sync_error", []any{"error", err}, "cannot sync ltx files, retrying: %s", err)
+			}
+		}
+	}
+}
+
+// syncPendingLTX fsyncs all pending LTX files & their directories. On
+// failure, the files are returned to the pending batch to be retried.
+func (s *Store) syncPendingLTX() (err error) {
+	s.ltxSync.mu.Lock()
+	batch := s.ltxSync.pending
+	s.ltxSync.pending, s.ltxSync.inflight = nil, batch
+	s.ltxSync.mu.Unlock()
+
+	if batch == nil {
+		return nil
+	}
+
+	defer func() {
+		s.ltxSync.mu.Lock()
+		defer s.ltxSync.mu.Unlock()
+		s.ltxSync.inflight = nil
+
+		if err != nil {
+			s.requeueLTXSyncBatchNoLock(batch)
+			return
+		}
+
+		if s.ltxSync.ch != nil {
+			close(s.ltxSync.ch)
+			s.ltxSync.ch = nil
+		}
+	}()
+
+	dirs := make(map[string]struct{})
+	for path := range batch.paths {
+		// Files may be removed by a later snapshot before they are synced.
+		if err := s.FS.SyncDir(path); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("fsync ltx file: %w", err)
+		}
+		dirs[filepath.Dir(path)] = struct{}{}
+	}
+
+	for dir := range dirs {
+		if err := s.FS.SyncDir(dir); err != nil {
+			return fmt.Errorf("sync ltx dir: %w", err)
 		}
 	}
+	return nil
+}
 
-	// Write LTX file to a temporary file and we'll atomically rename later.
-	path := db.LTXPath(hdr.MinTXID, hdr.MaxTXID)
-	tmpPath := fmt.Sprintf("%s.%d.tmp", path, rand.Int())
-	defer func() { _ = os.Remove(tmpPath) }()
-
-	f, err := os.Create(tmpPath)
-	if err != nil {
-		return fmt.Errorf("cannot create temp ltx file: %w", err)
+// requeueLTXSyncBatchNoLock merges a batch that failed to sync back into the
+// pending batch. Positions from the failed batch are earlier so they win.
+func (s *Store) requeueLTXSyncBatchNoLock(batch *ltxSyncBatch) {
+	if s.ltxSync.pending == nil {
+		s.ltxSync.pending = batch
+		return
 	}
-	defer func() { _ = f.Close() }()
+	for path := range batch.paths {
+		s.ltxSync.pending.paths[path] = struct{}{}
+	}
+	for name, pos := range batch.posMap {
+		s.ltxSync.pending.posMap[name] = pos
+	}
+}
 
-	n, err := io.Copy(f, src)
-	if err != nil {
-		return fmt.Errorf("write ltx file: %w", err)
-	} else if err := f.Sync(); err != nil {
-		return fmt.Errorf("fsync ltx file: %w", err)
+// incrPosMismatch increments the consecutive position mismatch count for a database.
+func (s *Store) incrPosMismatch(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.posMismatchN[name]++
+}
+
+// resetPosMismatch clears the position mismatch count after a successful apply.
+func (s *Store) resetPosMismatch(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.posMismatchN, name)
+	delete(s.snapshotRequested, name)
+}
+
+// errSnapshotRequested is returned when a replica disconnects so that it can
+// immediately reconnect to the primary & request a snapshot.
+var errSnapshotRequested = errors.New("snapshot requested")
+
+// requestSnapshot marks a database to be snapshotted on the next connection
+// to the primary, regardless of the position mismatch count. Returns false if
+// a snapshot was already requested & has not been received yet.
+func (s *Store) requestSnapshot(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.snapshotRequested[name]; ok {
+		return false
 	}
+	s.snapshotRequested[name] = struct{}{}
+	return true
+}
 
-	// Atomically rename file.
-	if err := os.Rename(tmpPath, path); err != nil {
-		return fmt.Errorf("rename ltx file: %w", err)
-	} else if err := internal.Sync(filepath.Dir(path)); err != nil {
-		return fmt.Errorf("sync ltx dir: %w", err)
+// snapshotRequests returns the names of databases that have reached the
+// position mismatch limit and require a snapshot from the primary.
+func (s *Store) snapshotRequests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	for name := range s.snapshotRequested {
+		names = append(names, name)
+	}
+	for name, n := range s.posMismatchN {
+		if _, ok := s.snapshotRequested[name]; !ok && n >= DivergenceRetryLimit {
+			names = append(names, name)
+		}
 	}
+	sort.Strings(names)
+	return names
+}
 
-	// Update metrics
-	dbLTXCountMetricVec.WithLabelValues(db.Name()).Inc()
-	dbLTXBytesMetricVec.WithLabelValues(db.Name()).Set(float64(n))
+// AllowSnapshotRequest returns true if a snapshot request from a replica for
+// a database should be honored. This prevents a replica that is stuck in a
+// reconnect loop from forcing the primary to continuously write snapshots.
+func (s *Store) AllowSnapshotRequest(nodeID uint64, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	// Remove other LTX files after a snapshot.
-	if hdr.IsSnapshot() {
-		dir, file := filepath.Split(path)
-		log.Printf("snapshot received for %q, removing other ltx files: %s", db.Name(), file)
-		if err := removeFilesExcept(dir, file); err != nil {
-			return fmt.Errorf("remove ltx after snapshot: %w", err)
+	if s.SnapshotRequestInterval <= 0 {
+		return true
+	}
+
+	// Remove expired entries so the map does not grow unbounded.
+	now := time.Now()
+	for key, t := range s.snapshotRequestTimes {
+		if now.Sub(t) >= s.SnapshotRequestInterval {
+			delete(s.snapshotRequestTimes, key)
 		}
 	}
 
-	// Attempt to apply the LTX file to the database.
-	if err := db.ApplyLTXNoLock(ctx, path); err != nil {
-		return fmt.Errorf("apply ltx: %w", err)
+	key := snapshotRequestKey{nodeID: nodeID, name: name}
+	if _, ok := s.snapshotRequestTimes[key]; ok {
+		return false
 	}
+	s.snapshotRequestTimes[key] = now
+	return true
+}
 
-	return nil
+// snapshotRequestKey identifies snapshot requests by replica & database.
+type snapshotRequestKey struct {
+	nodeID uint64
+	name   string
 }
 
 func (s *Store) processDropDBStreamFrame(ctx context.Context, frame *DropDBStreamFrame) (err error) {
 	if err := s.DropDB(ctx, frame.Name); err == ErrDatabaseNotFound {
-		log.Printf("dropped database does not exist, skipping")
+		s.logEvent(slog.LevelInfo, "drop_skipped", []any{"db", frame.Name}, "dropped database %q does not exist, skipping", frame.Name)
 	} else if err != nil {
 		return fmt.Errorf("drop database: %w", err)
 	}
 	return nil
 }
 
+func (s *Store) processRenameDBStreamFrame(ctx context.Context, frame *RenameDBStreamFrame) (err error) {
+	if err := s.RenameDB(ctx, frame.OldName, frame.NewName); err == ErrDatabaseNotFound {
+		s.logEvent(slog.LevelInfo, "rename_skipped", []any{"db", frame.OldName}, "renamed database %q does not exist, skipping", frame.OldName)
+	} else if err != nil {
+		return fmt.Errorf("rename database: %w", err)
+	}
+	return nil
+}
+
 // Expvar returns a variable for debugging output.
 func (s *Store) Expvar() expvar.Var { return (*StoreVar)(s) }
 
@@ -953,9 +4126,15 @@ type StoreVar Store
 func (v *StoreVar) String() string {
 	s := (*Store)(v)
 	m := &storeVarJSON{
-		IsPrimary: s.IsPrimary(),
-		Candidate: s.candidate,
-		DBs:       make(map[string]*dbVarJSON),
+		IsPrimary:         s.IsPrimary(),
+		Candidate:         s.candidate,
+		CandidateWeight:   s.CandidateWeight,
+		Compression:       "none",
+		ReplicationPaused: s.IsReplicationPaused(),
+		DBs:               make(map[string]*dbVarJSON),
+	}
+	if s.Compress {
+		m.Compression = "lz4"
 	}
 
 	for _, db := range s.DBs() {
@@ -965,6 +4144,16 @@ func (v *StoreVar) String() string {
 			Name:     db.Name(),
 			TXID:     ltx.FormatTXID(pos.TXID),
 			Checksum: fmt.Sprintf("%016x", pos.PostApplyChecksum),
+			Mode:     db.Mode().String(),
+			ReadOnly: db.ReadOnly(),
+			TXIDLag:  db.TXIDLag(),
+			Lag:      db.ReplicationLag().String(),
+
+			NoReplicate: db.NoReplicate(),
+			Quarantined: db.Quarantined(),
+		}
+		if !pos.Timestamp.IsZero() {
+			dbJSON.Timestamp = pos.Timestamp.Format(time.RFC3339Nano)
 		}
 
 		dbJSON.Locks.Pending = db.pendingLock.State().String()
@@ -981,9 +4170,15 @@ func (v *StoreVar) String() string {
 		dbJSON.Locks.Read4 = db.read4Lock.State().String()
 		dbJSON.Locks.DMS = db.dmsLock.State().String()
 
+		dbJSON.HaltLock = db.HaltLock()
+		dbJSON.RemoteHaltLock = db.RemoteHaltLock()
+
 		m.DBs[db.Name()] = dbJSON
 	}
 
+	m.Replicas = s.Replicas()
+	m.HaltLocks = s.HaltLocks()
+
 	b, err := json.Marshal(m)
 	if err != nil {
 		return "null"
@@ -992,9 +4187,14 @@ func (v *StoreVar) String() string {
 }
 
 type storeVarJSON struct {
-	IsPrimary bool                  `json:"isPrimary"`
-	Candidate bool                  `json:"candidate"`
-	DBs       map[string]*dbVarJSON `json:"dbs"`
+	IsPrimary         bool                  `json:"isPrimary"`
+	Candidate         bool                  `json:"candidate"`
+	CandidateWeight   int                   `json:"candidateWeight"`
+	Compression       string                `json:"compression"`
+	ReplicationPaused bool                  `json:"replicationPaused"`
+	DBs               map[string]*dbVarJSON `json:"dbs"`
+	Replicas          []ReplicaInfo         `json:"replicas"`
+	HaltLocks         []HaltLockInfo        `json:"haltLocks"`
 }
 
 // Subscriber subscribes to changes to databases in the store.
@@ -1004,55 +4204,434 @@ type storeVarJSON struct {
 // is the responsibility of the caller to determine the state changes which is
 // usually just checking the position of the client versus the store's database.
 type Subscriber struct {
-	store *Store
-
-	mu       sync.Mutex
-	notifyCh chan struct{}
-	dirtySet map[string]struct{}
+	store       *Store
+	opts        SubscribeOptions
+	connectedAt time.Time
+
+	mu         sync.Mutex
+	notifyCh   chan error
+	dirtySet   map[string]struct{}
+	dirtyOrder []string              // insertion order, only for SubscriberPolicyDropOldest
+	coalesced  bool                  // if true, all databases are dirty
+	err        error                 // set once the subscriber has overflowed
+	renames    []RenameDBStreamFrame // pending renames, in order
+	posMap     map[string]Pos        // last position sent to the node
+	filter     func(name string) bool
+
+	handoffCh    chan string // receives lease ID to send to node
+	handoffErrCh chan error  // receives result of sending handoff
+	closeOnce    sync.Once
+	closed       chan struct{}
 }
 
 // newSubscriber returns a new instance of Subscriber associated with a store.
-func newSubscriber(store *Store) *Subscriber {
+func newSubscriber(store *Store, opts SubscribeOptions) *Subscriber {
 	s := &Subscriber{
-		store:    store,
-		notifyCh: make(chan struct{}, 1),
-		dirtySet: make(map[string]struct{}),
+		store:        store,
+		opts:         opts,
+		connectedAt:  time.Now(),
+		notifyCh:     make(chan error, 1),
+		dirtySet:     make(map[string]struct{}),
+		posMap:       make(map[string]Pos),
+		handoffCh:    make(chan string),
+		handoffErrCh: make(chan error, 1),
+		closed:       make(chan struct{}),
 	}
 	return s
 }
 
 // Close removes the subscriber from the store.
 func (s *Subscriber) Close() error {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		close(s.closed)
+		close(s.notifyCh)
+	})
 	s.store.Unsubscribe(s)
 	return nil
 }
 
-// NotifyCh returns a channel that receives a value when the dirty set has changed.
-func (s *Subscriber) NotifyCh() <-chan struct{} { return s.notifyCh }
+// CloseOnDone closes the subscriber once ctx is done. Closing also closes its
+// notify channel so that loops ranging over it exit.
+func (s *Subscriber) CloseOnDone(ctx context.Context) {
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = s.Close()
+		case <-s.closed:
+		}
+	}()
+}
+
+// isClosedNoLock returns true if the subscriber has been closed. Must hold s.mu.
+func (s *Subscriber) isClosedNoLock() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// NodeID returns the ID of the node that the subscriber is streaming to.
+func (s *Subscriber) NodeID() uint64 { return s.opts.NodeID }
+
+// PosMap returns a copy of the last positions sent to the node.
+func (s *Subscriber) PosMap() map[string]Pos {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := make(map[string]Pos, len(s.posMap))
+	for name, pos := range s.posMap {
+		m[name] = pos
+	}
+	return m
+}
+
+// SetPosMap records the positions that have been sent to the node.
+func (s *Subscriber) SetPosMap(m map[string]Pos) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.posMap = make(map[string]Pos, len(m))
+	for name, pos := range m {
+		s.posMap[name] = pos
+	}
+}
+
+// HandoffCh returns a channel that receives a lease ID when the primary is
+// handing off its lease to the node. The result of sending the lease ID to
+// the node must be reported with HandoffSent().
+func (s *Subscriber) HandoffCh() <-chan string { return s.handoffCh }
+
+// HandoffSent reports the result of sending a handoff to the node.
+func (s *Subscriber) HandoffSent(err error) {
+	select {
+	case s.handoffErrCh <- err:
+	default:
+	}
+}
+
+// handoff sends a lease ID to the node and waits for it to be sent.
+func (s *Subscriber) handoff(ctx context.Context, leaseID string) error {
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-s.closed:
+		return ErrReplicaNotFound
+	case s.handoffCh <- leaseID:
+	}
+
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-s.closed:
+		return ErrReplicaNotFound
+	case err := <-s.handoffErrCh:
+		return err
+	}
+}
+
+// NotifyCh returns a channel that receives a nil value when the dirty set has
+// changed. It receives ErrSubscriberOverflow if the dirty set limit is exceeded
+// under SubscriberPolicyError, after which the subscriber must be recreated.
+// The channel is closed when the subscriber is closed.
+func (s *Subscriber) NotifyCh() <-chan error { return s.notifyCh }
 
-// MarkDirty marks a database ID as dirty.
+// MarkDirty marks a database ID as dirty. If the dirty set is full then the
+// subscriber's policy is applied so memory use stays bounded by the limit.
 func (s *Subscriber) MarkDirty(name string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.dirtySet[name] = struct{}{}
 
+	if s.err != nil || s.isClosedNoLock() {
+		return // subscriber must be recreated, stop tracking changes
+	} else if s.filter != nil && !s.filter(name) {
+		return // not watching this database
+	}
+
+	if _, ok := s.dirtySet[name]; !ok && !s.coalesced {
+		if limit := s.opts.DirtySetLimit; limit > 0 && len(s.dirtySet) >= limit {
+			subscriberOverflowCountMetricVec.WithLabelValues(s.opts.Policy.String()).Inc()
+
+			switch s.opts.Policy {
+			case SubscriberPolicyDropOldest:
+				delete(s.dirtySet, s.dirtyOrder[0])
+				s.dirtyOrder = s.dirtyOrder[1:]
+			case SubscriberPolicyError:
+				s.err = ErrSubscriberOverflow
+				s.dirtySet, s.dirtyOrder = make(map[string]struct{}), nil
+
+				// Replace any pending notification with the error.
+				select {
+				case <-s.notifyCh:
+				default:
+				}
+				s.notifyCh <- s.err
+				return
+			default:
+				s.dirtySet, s.dirtyOrder, s.coalesced = make(map[string]struct{}), nil, true
+			}
+		}
+
+		if !s.coalesced {
+			s.dirtySet[name] = struct{}{}
+			if s.opts.Policy == SubscriberPolicyDropOldest {
+				s.dirtyOrder = append(s.dirtyOrder, name)
+			}
+		}
+	}
+
+	s.notifyNoLock()
+}
+
+// SetFilter restricts the subscriber to databases for which fn returns true.
+// Other databases are never marked dirty so the subscriber is not woken for
+// them. Databases already in the dirty set that do not match are removed.
+// A nil filter tracks every database.
+func (s *Subscriber) SetFilter(fn func(name string) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.filter = fn
+	if fn == nil {
+		return
+	}
+
+	for name := range s.dirtySet {
+		if !fn(name) {
+			delete(s.dirtySet, name)
+		}
+	}
+
+	order := s.dirtyOrder[:0]
+	for _, name := range s.dirtyOrder {
+		if fn(name) {
+			order = append(order, name)
+		}
+	}
+	s.dirtyOrder = order
+}
+
+// MarkRenamed records that a database has been renamed. Renames should be
+// sent to the node before the dirty set is processed.
+func (s *Subscriber) MarkRenamed(oldName, newName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil || s.isClosedNoLock() {
+		return
+	}
+	s.renames = append(s.renames, RenameDBStreamFrame{OldName: oldName, NewName: newName})
+	s.notifyNoLock()
+}
+
+// notifyNoLock signals the notify channel without blocking. Must hold s.mu.
+func (s *Subscriber) notifyNoLock() {
 	select {
-	case s.notifyCh <- struct{}{}:
+	case s.notifyCh <- nil:
 	default:
 	}
 }
 
+// Renames returns the databases renamed since the last call to Renames().
+// This call clears the list.
+func (s *Subscriber) Renames() []RenameDBStreamFrame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	renames := s.renames
+	s.renames = nil
+	return renames
+}
+
 // DirtySet returns a set of database IDs that have changed since the last call
 // to DirtySet(). This call clears the set.
+//
+// If the set was coalesced then every database in the store and every
+// database last sent to the node is returned so that drops are not missed.
 func (s *Subscriber) DirtySet() map[string]struct{} {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	dirtySet, coalesced, filter := s.dirtySet, s.coalesced, s.filter
+	s.dirtySet, s.dirtyOrder, s.coalesced = make(map[string]struct{}), nil, false
+	s.mu.Unlock()
 
-	dirtySet := s.dirtySet
-	s.dirtySet = make(map[string]struct{})
+	// The store lock is acquired by DBs() so it must be called without
+	// holding the subscriber lock.
+	if coalesced {
+		for _, db := range s.store.DBs() {
+			dirtySet[db.Name()] = struct{}{}
+		}
+		for name := range s.PosMap() {
+			dirtySet[name] = struct{}{}
+		}
+		for name := range dirtySet {
+			if filter != nil && !filter(name) {
+				delete(dirtySet, name)
+			}
+		}
+	}
 	return dirtySet
 }
 
+// SubscriberPolicy determines how a Subscriber behaves once its dirty set has
+// reached its limit because the consumer is not keeping up.
+type SubscriberPolicy int
+
+const (
+	// SubscriberPolicyCoalesce discards the dirty set and reports every
+	// database on the next call to DirtySet(). No changes are lost.
+	SubscriberPolicyCoalesce = SubscriberPolicy(iota)
+
+	// SubscriberPolicyDropOldest evicts the database that was marked dirty
+	// first. Changes to evicted databases are not reported.
+	SubscriberPolicyDropOldest
+
+	// SubscriberPolicyError stops tracking changes and sends
+	// ErrSubscriberOverflow on the notify channel.
+	SubscriberPolicyError
+)
+
+// String returns the string representation of the policy.
+func (p SubscriberPolicy) String() string {
+	switch p {
+	case SubscriberPolicyCoalesce:
+		return "coalesce"
+	case SubscriberPolicyDropOldest:
+		return "drop-oldest"
+	case SubscriberPolicyError:
+		return "error"
+	default:
+		return fmt.Sprintf("SubscriberPolicy<%d>", p)
+	}
+}
+
+// SubscribeOptions configures a Subscriber.
+type SubscribeOptions struct {
+	// ID of the node that the subscriber streams to. Zero for local subscribers.
+	NodeID uint64
+
+	// If true, the node can acquire a lease handed off by this node.
+	Handoff bool
+
+	// Maximum number of databases held in the dirty set. Zero is unlimited.
+	DirtySetLimit int
+
+	// Behavior once DirtySetLimit is reached.
+	Policy SubscriberPolicy
+
+	// Network address of the node. Only used for reporting.
+	RemoteAddr string
+}
+
+// EventType represents the type of an event delivered to an EventSubscriber.
+type EventType string
+
+// Event types.
+const (
+	// EventTypeTx is sent for every LTX file committed or applied to a database.
+	EventTypeTx = EventType("tx")
+
+	// EventTypeResync is sent in place of buffered events when the subscriber
+	// has fallen behind. The subscriber must re-read the database positions.
+	EventTypeResync = EventType("resync")
+)
+
+// Event represents a change to a database in the store.
+type Event struct {
+	Type EventType
+	Name string // database name
+
+	// Range of transactions & checksums of the LTX file.
+	MinTXID           uint64
+	MaxTXID           uint64
+	PreApplyChecksum  uint64
+	PostApplyChecksum uint64
+
+	Size      int64     // size of the LTX file, in bytes
+	Timestamp time.Time // commit time of the transaction, if known
+}
+
+// newTxEvent returns an event for an LTX file committed or applied to a database.
+func newTxEvent(name string, hdr ltx.Header, trailer ltx.Trailer, size int64) Event {
+	return Event{
+		Type:              EventTypeTx,
+		Name:              name,
+		MinTXID:           hdr.MinTXID,
+		MaxTXID:           hdr.MaxTXID,
+		PreApplyChecksum:  hdr.PreApplyChecksum,
+		PostApplyChecksum: trailer.PostApplyChecksum,
+		Size:              size,
+		Timestamp:         NewPosFromLTX(hdr, trailer).Timestamp,
+	}
+}
+
+// EventSubscriber receives an ordered event for every transaction committed
+// or applied to the store's databases.
+//
+// Events are held in a bounded buffer so that a slow subscriber never blocks
+// the commit path. If the buffer is full, all buffered events are replaced by
+// a single resync event. Events that follow a resync event may already be
+// reflected in the positions read during the resync and can be skipped by
+// comparing TXIDs.
+type EventSubscriber struct {
+	store *Store
+
+	mu         sync.Mutex
+	notifyCh   chan struct{}
+	events     []Event
+	bufferSize int
+}
+
+// newEventSubscriber returns a new instance of EventSubscriber associated with a store.
+func newEventSubscriber(store *Store, bufferSize int) *EventSubscriber {
+	return &EventSubscriber{
+		store:      store,
+		notifyCh:   make(chan struct{}, 1),
+		bufferSize: bufferSize,
+	}
+}
+
+// Close removes the subscriber from the store.
+func (s *EventSubscriber) Close() error {
+	s.store.UnsubscribeEvents(s)
+	return nil
+}
+
+// NotifyCh returns a channel that receives a value when events are available.
+func (s *EventSubscriber) NotifyCh() <-chan struct{} { return s.notifyCh }
+
+// Events returns the events received since the last call to Events(), in
+// order. This call clears the buffer.
+func (s *EventSubscriber) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := s.events
+	s.events = nil
+	return events
+}
+
+// push adds an event to the buffer. If the buffer is full then the buffered
+// events & the new event are dropped and replaced with a resync event.
+func (s *EventSubscriber) push(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.events) >= s.bufferSize {
+		s.events = append(s.events[:0], Event{Type: EventTypeResync})
+		storeEventResyncCountMetric.Inc()
+	} else {
+		s.events = append(s.events, event)
+	}
+
+	select {
+	case s.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
 var _ context.Context = (*primaryCtx)(nil)
 
 // primaryCtx represents a context that is marked done when the node loses its primary status.
@@ -1105,8 +4684,8 @@ func (ctx *primaryCtx) Value(key any) any {
 // removeFilesExcept removes all files from a directory except a given filename.
 // Attempts to remove all files, even in the event of an error. Returns the
 // first error encountered.
-func removeFilesExcept(dir, filename string) (retErr error) {
-	ents, err := os.ReadDir(dir)
+func removeFilesExcept(fsys FileSystem, dir, filename string) (retErr error) {
+	ents, err := fsys.ReadDir(dir)
 	if err != nil {
 		return err
 	}
@@ -1116,7 +4695,7 @@ func removeFilesExcept(dir, filename string) (retErr error) {
 		if ent.IsDir() || ent.Name() == filename {
 			continue
 		}
-		if err := os.Remove(filepath.Join(dir, ent.Name())); retErr == nil {
+		if err := fsys.Remove(filepath.Join(dir, ent.Name())); retErr == nil {
 			retErr = err
 		}
 	}
@@ -1124,6 +4703,20 @@ func removeFilesExcept(dir, filename string) (retErr error) {
 	return retErr
 }
 
+// sendLatestBool sends v to a buffered channel, replacing any unread value.
+// Must only be called by a single sender at a time.
+func sendLatestBool(ch chan bool, v bool) {
+	select {
+	case <-ch:
+	default:
+	}
+
+	select {
+	case ch <- v:
+	default:
+	}
+}
+
 // sleepWithContext sleeps for a given amount of time or until the context is canceled.
 func sleepWithContext(ctx context.Context, d time.Duration) {
 	// Skip timer creation if context is already canceled.
@@ -1147,13 +4740,88 @@ var (
 		Help: "Number of managed databases.",
 	})
 
+	storeMaxDBCountMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_db_max_count",
+		Help: "Maximum number of managed databases. Zero if unlimited.",
+	})
+
+	storeMaxDBSkipCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_db_max_skip_count",
+		Help: "Number of replicated frames skipped because the database limit was reached.",
+	})
+
 	storeIsPrimaryMetric = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "litefs_is_primary",
 		Help: "Primary status of the node.",
 	})
 
+	storeLeaseAcquireCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_lease_acquire_total",
+		Help: "Number of attempts to acquire the primary lease, by outcome.",
+	}, []string{"outcome"})
+
+	storeLeaseRenewCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_lease_renew_total",
+		Help: "Number of attempts to renew the primary lease, by outcome.",
+	}, []string{"outcome"})
+
+	storeLeasePrimarySinceMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_lease_is_primary_since_seconds",
+		Help: "Unix time that the node became primary. Zero if not primary.",
+	})
+
+	storeLeaseRenewFailureCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_lease_renew_failures_total",
+		Help: "Number of failed attempts to renew the primary lease.",
+	})
+
+	storeLeaseTTLMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_lease_ttl_seconds",
+		Help: "Time-to-live of the primary lease. Zero if not primary.",
+	})
+
+	storeLeaseRenewedAtMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_lease_renewed_at_seconds",
+		Help: "Unix time of the last successful lease acquisition or renewal. Zero if not primary.",
+	})
+
 	storeSubscriberCountMetric = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "litefs_subscriber_count",
 		Help: "Number of connected subscribers",
 	})
+
+	storeSyncTimeoutCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_sync_timeout_total",
+		Help: "Number of writes that timed out waiting for replica acknowledgements.",
+	})
+
+	subscriberOverflowCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_subscriber_overflow_total",
+		Help: "Number of times a subscriber's dirty set limit was exceeded.",
+	}, []string{"policy"})
+
+	storeConnectedReplicaCountMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "litefs_connected_replicas",
+		Help: "Number of replica streams connected to this node.",
+	})
+
+	storeEventResyncCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_event_resync_total",
+		Help: "Number of times an event subscriber fell behind and was sent a resync event.",
+	})
+
+	storeScrubErrorCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_scrub_errors_total",
+		Help: "Number of databases that failed checksum verification by the background scrubber.",
+	}, []string{"db"})
+
+	storeDivergenceCountMetricVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "litefs_divergence_total",
+		Help: "Number of times a database was found to be ahead of the primary.",
+	}, []string{"handler"})
+
+	backupErrorCountMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "litefs_backup_errors_total",
+		Help: "Number of LTX files that failed to be written to the backup.",
+	})
 )
@@ -3,18 +3,30 @@ package litefs_test
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/superfly/litefs"
+	"github.com/superfly/litefs/internal/chunk"
+	"github.com/superfly/litefs/internal/memfs"
 	"github.com/superfly/litefs/internal/testingutil"
 	"github.com/superfly/litefs/mock"
 	"github.com/superfly/ltx"
+	"golang.org/x/sync/errgroup"
 )
 
 // Ensure store can create a new, empty database.
@@ -58,6 +70,20 @@ func TestStore_CreateDB(t *testing.T) {
 }
 
 func TestStore_Open(t *testing.T) {
+	t.Run("ErrFilteredCandidate", func(t *testing.T) {
+		store := newStore(t, newPrimaryStaticLeaser(), nil)
+		store.ReplicationPrefixes = []string{"tenant-"}
+		if err := store.Open(); err == nil || err.Error() != `replication prefixes & filter cannot be used on a primary candidate` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		store = newStore(t, newPrimaryStaticLeaser(), nil)
+		store.ReplicationFilter = func(name string) bool { return true }
+		if err := store.Open(); err == nil || err.Error() != `replication prefixes & filter cannot be used on a primary candidate` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
 	t.Run("ExistingEmptyDB", func(t *testing.T) {
 		store := newStoreFromFixture(t, newPrimaryStaticLeaser(), nil, "testdata/store/open-name-only")
 		if err := store.Open(); err != nil {
@@ -122,207 +148,5193 @@ func TestStore_Open(t *testing.T) {
 			t.Fatalf("pos=%v, want %v", got, want)
 		}
 	})
+
+	// Ensure a database without an in-header page count, such as one written
+	// by a legacy version of SQLite, is not discarded on open.
+	t.Run("ZeroPageCount", func(t *testing.T) {
+		store := newStoreFromFixture(t, newPrimaryStaticLeaser(), nil, "testdata/store/open-and-write-snapshot")
+		dbPath := filepath.Join(store.DBDir(), "sqlite.db", "database")
+		data, err := os.ReadFile(dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		binary.BigEndian.PutUint32(data[28:], 0)
+		if err := os.WriteFile(dbPath, data, 0o666); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		if db := store.DB("sqlite.db"); db == nil {
+			t.Fatal("expected database")
+		} else if db.Pos().IsZero() {
+			t.Fatal("expected position")
+		} else if fi, err := os.Stat(dbPath); err != nil {
+			t.Fatal(err)
+		} else if got, want := fi.Size(), int64(len(data)); got != want {
+			t.Fatalf("Size=%d, want %d", got, want)
+		}
+	})
+
+	// Ensure databases are opened & recovered in parallel.
+	t.Run("OpenConcurrency", func(t *testing.T) {
+		const n = 20
+		store := newStore(t, newPrimaryStaticLeaser(), nil)
+		store.OpenConcurrency = 4
+		for i := 0; i < n; i++ {
+			testingutil.MustCopyDir(t, "testdata/store/open-and-write-snapshot/dbs/sqlite.db", filepath.Join(store.DBDir(), fmt.Sprintf("db%d", i)))
+		}
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := len(store.DBs()), n; got != want {
+			t.Fatalf("len(DBs)=%d, want %d", got, want)
+		}
+		want := store.DB("db0").Pos()
+		for i := 1; i < n; i++ {
+			if got := store.DB(fmt.Sprintf("db%d", i)).Pos(); got != want {
+				t.Fatalf("db%d: Pos=%s, want %s", i, got, want)
+			}
+		}
+
+		if err := store.Recover(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
 }
 
-// Ensures that an existing database can write a snapshot after open.
-// See: https://github.com/superfly/litefs/issues/173
-func TestStore_OpenAndWriteSnapshot(t *testing.T) {
-	store := newStoreFromFixture(t, newPrimaryStaticLeaser(), nil, "testdata/store/open-and-write-snapshot")
-	if err := store.Open(); err != nil {
+// Ensure the store can run entirely on an alternate file system.
+func TestStore_FS(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
 		t.Fatal(err)
 	}
 
-	var buf bytes.Buffer
-	db := store.DB("sqlite.db")
-	if _, _, err := db.WriteSnapshotTo(context.Background(), &buf); err != nil {
+	fsys := memfs.New()
+	path := filepath.Join(t.TempDir(), "data")
+	newMemStore := func() *litefs.Store {
+		store := litefs.NewStore(path, true)
+		store.FS = fsys
+		store.Leaser = newPrimaryStaticLeaser()
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+		return store
+	}
+
+	store := newMemStore()
+	db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	} else if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	pos := db.Pos()
+	if err := store.Close(); err != nil {
 		t.Fatal(err)
 	}
 
-	dec := ltx.NewDecoder(&buf)
-	if err := dec.Verify(); err != nil {
+	// Nothing is written to the real disk.
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected data directory to not exist on disk: %v", err)
+	} else if _, err := fsys.Stat(db.LTXPath(2, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen the store from the same file system.
+	store = newMemStore()
+	defer func() { _ = store.Close() }()
+	if db = store.DB("sqlite.db"); db == nil {
+		t.Fatal("expected database")
+	} else if got, want := db.Pos(), pos; got != want {
+		t.Fatalf("Pos=%s, want %s", got, want)
+	} else if err := store.VerifyDB(context.Background(), "sqlite.db"); err != nil {
 		t.Fatal(err)
 	}
+
+	if err := store.RenameDB(context.Background(), "sqlite.db", "other.db"); err != nil {
+		t.Fatal(err)
+	} else if err := store.DropDB(context.Background(), "other.db"); err != nil {
+		t.Fatal(err)
+	} else if ents, err := fsys.ReadDir(store.DBDir()); err != nil {
+		t.Fatal(err)
+	} else if len(ents) != 0 {
+		t.Fatalf("unexpected entries: %v", ents)
+	}
 }
 
-func TestPrimaryInfo_Clone(t *testing.T) {
+func TestStore_ResetID(t *testing.T) {
 	t.Run("OK", func(t *testing.T) {
-		info := &litefs.PrimaryInfo{Hostname: "foo", AdvertiseURL: "bar"}
-		if other := info.Clone(); *other != *info {
-			t.Fatal("mismatch")
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		prevID := store.ID()
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Reopen with the same data directory & reset the ID first.
+		other := litefs.NewStore(store.Path(), true)
+		other.Leaser = newPrimaryStaticLeaser()
+		if err := other.ResetID(); err != nil {
+			t.Fatal(err)
+		} else if err := other.Open(); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = other.Close() }()
+
+		if other.ID() == 0 {
+			t.Fatal("expected id")
+		} else if other.ID() == prevID {
+			t.Fatalf("expected new id, got %s", litefs.FormatNodeID(other.ID()))
 		}
 	})
-	t.Run("Nil", func(t *testing.T) {
-		var info *litefs.PrimaryInfo
-		if other := info.Clone(); other != nil {
-			t.Fatal("expected nil")
+
+	t.Run("ErrOpen", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if err := store.ResetID(); err == nil || err.Error() != `cannot reset node id while store is open` {
+			t.Fatalf("unexpected error: %v", err)
 		}
 	})
 }
 
-// Ensure store generates a unique ID that is persistent across restarts.
-func TestStore_ID(t *testing.T) {
-	store := newStore(t, newPrimaryStaticLeaser(), nil)
+// Ensures that an existing database can write a snapshot after open.
+// See: https://github.com/superfly/litefs/issues/173
+func TestStore_OpenAndWriteSnapshot(t *testing.T) {
+	store := newStoreFromFixture(t, newPrimaryStaticLeaser(), nil, "testdata/store/open-and-write-snapshot")
 	if err := store.Open(); err != nil {
 		t.Fatal(err)
-	} else if err := store.Close(); err != nil {
-		t.Fatal(err)
 	}
 
-	id := store.ID()
-	if id == 0 {
-		t.Fatal("expected id")
+	var buf bytes.Buffer
+	db := store.DB("sqlite.db")
+	if _, _, err := db.WriteSnapshotTo(context.Background(), &buf); err != nil {
+		t.Fatal(err)
 	}
 
-	// Reopen as a new instance.
-	store = litefs.NewStore(store.Path(), true)
-	store.Leaser = newPrimaryStaticLeaser()
-	if err := store.Open(); err != nil {
+	dec := ltx.NewDecoder(&buf)
+	if err := dec.Verify(); err != nil {
 		t.Fatal(err)
 	}
-	defer func() { _ = store.Close() }()
+}
 
-	// Ensure ID is the same.
-	if got, want := store.ID(), id; got != want {
-		t.Fatalf("id=%d, want %d", got, want)
+// Ensure store can create a new database from the contents of a reader.
+func TestStore_CreateDBFromReader(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
 	}
-}
 
-// Ensure store returns a context that is done when node loses primary status.
-func TestStore_PrimaryCtx(t *testing.T) {
-	t.Run("InitialPrimary", func(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
 		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
-		if ctx := store.PrimaryCtx(context.Background()); ctx.Err() != nil {
-			t.Fatal("expected no error")
-		}
-	})
 
-	t.Run("PrimaryLost", func(t *testing.T) {
-		var isPrimary atomic.Bool
-		isPrimary.Store(true)
+		db, err := store.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := store.DB("test.db"), db; got != want {
+			t.Fatalf("DB=%p, want %p", got, want)
+		} else if got, want := db.TXID(), uint64(1); got != want {
+			t.Fatalf("TXID=%d, want %d", got, want)
+		}
 
-		lease := mock.Lease{
-			RenewedAtFunc: func() time.Time { return time.Time{} },
-			TTLFunc:       func() time.Duration { return 10 * time.Millisecond },
-			RenewFunc: func(ctx context.Context) error {
-				if !isPrimary.Load() {
-					return litefs.ErrLeaseExpired
-				}
-				return nil
-			},
-			CloseFunc: func() error { return nil },
+		if fi, err := os.Stat(db.DatabasePath()); err != nil {
+			t.Fatal(err)
+		} else if got, want := fi.Size(), int64(len(data)); got != want {
+			t.Fatalf("Size=%d, want %d", got, want)
 		}
-		leaser := mock.Leaser{
-			CloseFunc:        func() error { return nil },
-			AdvertiseURLFunc: func() string { return "http://localhost:20202" },
-			AcquireFunc: func(ctx context.Context) (litefs.Lease, error) {
-				return &lease, nil
-			},
-			PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
-				return litefs.PrimaryInfo{}, litefs.ErrNoPrimary
-			},
+	})
+
+	t.Run("ErrDatabaseExists", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if _, err := store.CreateDBIfNotExists("test.db"); err != nil {
+			t.Fatal(err)
 		}
 
-		client := mock.Client{
-			StreamFunc: func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos) (io.ReadCloser, error) {
-				return io.NopCloser(&bytes.Buffer{}), nil
-			},
+		if _, err := store.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data)); err != litefs.ErrDatabaseExists {
+			t.Fatalf("unexpected error: %v", err)
 		}
+	})
 
-		store := newOpenStore(t, &leaser, &client)
+	t.Run("ErrInvalidHeader", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
 
-		// Ensure store starts in primary state.
-		ctx := store.PrimaryCtx(context.Background())
-		if ctx.Err() != nil {
-			t.Fatal("expected no error")
+		if _, err := store.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(make([]byte, 4096))); err == nil || err.Error() != `invalid sqlite database header` {
+			t.Fatalf("unexpected error: %v", err)
+		} else if store.DB("test.db") != nil {
+			t.Fatal("expected no database")
+		} else if _, err := os.Stat(store.DBPath("test.db")); !os.IsNotExist(err) {
+			t.Fatalf("expected database directory to be removed: %v", err)
 		}
+	})
 
-		// Mark lease as unrenewable so that store loses lease.
-		isPrimary.Store(false)
+	t.Run("ErrInvalidPageSize", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
 
-		// Check context until it closes.
-		testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
-			select {
-			case <-ctx.Done():
-				return nil // ok
-			default:
-				return fmt.Errorf("expected closed context")
-			}
-		})
+		other := bytes.Clone(data)
+		other[16], other[17] = 0x03, 0xE8 // 1000 bytes
+		if _, err := store.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(other)); err == nil || err.Error() != `import: invalid sqlite page size: 1000` {
+			t.Fatalf("unexpected error: %v", err)
+		} else if _, err := os.Stat(store.DBPath("test.db")); !os.IsNotExist(err) {
+			t.Fatalf("expected database directory to be removed: %v", err)
+		}
 	})
 
-	t.Run("InitialReplica", func(t *testing.T) {
-		leaser := litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
-		client := mock.Client{
-			StreamFunc: func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos) (io.ReadCloser, error) {
-				var buf bytes.Buffer
-				if err := litefs.WriteStreamFrame(&buf, &litefs.ReadyStreamFrame{}); err != nil {
-					return nil, err
-				}
-				return io.NopCloser(&buf), nil
-			},
+	t.Run("ErrZeroPageCount", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+
+		other := bytes.Clone(data)
+		binary.BigEndian.PutUint32(other[28:], 0)
+		if _, err := store.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(other)); err == nil || err.Error() != `import: sqlite database header has no page count, vacuum the database before importing` {
+			t.Fatalf("unexpected error: %v", err)
+		} else if _, err := os.Stat(store.DBPath("test.db")); !os.IsNotExist(err) {
+			t.Fatalf("expected database directory to be removed: %v", err)
 		}
+	})
 
-		store := newOpenStore(t, leaser, &client)
-		ctx := store.PrimaryCtx(context.Background())
-		if err := ctx.Err(); err != litefs.ErrLeaseExpired {
-			t.Fatalf("unexpected error: %#v", err)
+	t.Run("ErrShortRead", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+
+		if _, err := store.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data[:len(data)-1])); err == nil {
+			t.Fatal("expected error")
+		} else if _, err := os.Stat(store.DBPath("test.db")); !os.IsNotExist(err) {
+			t.Fatalf("expected database directory to be removed: %v", err)
 		}
 	})
+}
 
-	t.Run("ParentCancelation", func(t *testing.T) {
+func TestStore_ExportDB(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("OK", func(t *testing.T) {
 		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		db, err := store.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
 
-		ctx, cancel := context.WithCancel(context.Background())
-		if ctx := store.PrimaryCtx(ctx); ctx.Err() != nil {
-			t.Fatal("expected no error")
+		var buf bytes.Buffer
+		if pos, err := store.ExportDB(context.Background(), "test.db", &buf); err != nil {
+			t.Fatal(err)
+		} else if got, want := pos, db.Pos(); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
 		}
 
-		// Cancel & wait for propagation.
-		cancel()
-		select {
-		case <-time.After(5 * time.Second):
-			t.Fatal("timeout waiting for parent cancelation")
-		case <-ctx.Done():
-			if err := ctx.Err(); err != context.Canceled {
-				t.Fatalf("unexpected error: %s", err)
-			}
+		if other, err := os.ReadFile(db.DatabasePath()); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf.Bytes(), other) {
+			t.Fatal("exported contents mismatch")
+		}
+
+		// Ensure the export can be imported as a new database at the same checksum.
+		if other, err := store.CreateDBFromReader(context.Background(), "other.db", &buf); err != nil {
+			t.Fatal(err)
+		} else if got, want := other.Pos().PostApplyChecksum, db.Pos().PostApplyChecksum; got != want {
+			t.Fatalf("PostApplyChecksum=%016x, want %016x", got, want)
 		}
 	})
-}
 
-// newStore returns a new instance of a Store on a temporary directory.
-// This store will automatically close when the test ends.
-func newStore(tb testing.TB, leaser litefs.Leaser, client litefs.Client) *litefs.Store {
-	store := litefs.NewStore(tb.TempDir(), true)
-	store.Leaser = leaser
-	store.Client = client
-	tb.Cleanup(func() {
-		if err := store.Close(); err != nil {
-			tb.Fatalf("cannot close store: %s", err)
+	t.Run("ErrDatabaseNotFound", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if _, err := store.ExportDB(context.Background(), "test.db", io.Discard); err != litefs.ErrDatabaseNotFound {
+			t.Fatalf("unexpected error: %v", err)
 		}
 	})
-	return store
 }
 
-// newOpenStore returns a new instance of an empty, opened store.
-func newOpenStore(tb testing.TB, leaser litefs.Leaser, client litefs.Client) *litefs.Store {
-	tb.Helper()
-	store := newStore(tb, leaser, client)
+func TestStore_FileMode(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newStore(t, newPrimaryStaticLeaser(), nil)
+	store.FileMode, store.DirMode = 0600, 0700
 	if err := store.Open(); err != nil {
-		tb.Fatal(err)
+		t.Fatal(err)
 	}
+	<-store.ReadyCh()
 
-	select {
-	case <-time.After(5 * time.Second):
-		tb.Fatal("timeout waiting for store ready")
-	case <-store.ReadyCh(): // wait for lease
+	db, err := store.CreateDBIfNotExists("test.db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]os.FileMode{
+		filepath.Join(store.Path(), "id"): 0600,
+		store.DBDir():                     os.ModeDir | 0700,
+		db.Path():                         os.ModeDir | 0700,
+		db.LTXDir():                       os.ModeDir | 0700,
+		db.DatabasePath():                 0600,
+		db.LTXPath(1, 1):                  0600,
+	} {
+		if fi, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		} else if got := fi.Mode(); got != want {
+			t.Fatalf("%s: mode=%s, want %s", path, got, want)
+		}
 	}
-	return store
 }
 
-func newStoreFromFixture(tb testing.TB, leaser litefs.Leaser, client litefs.Client, path string) *litefs.Store {
-	tb.Helper()
-	store := newStore(tb, leaser, client)
-	testingutil.MustCopyDir(tb, path, store.Path())
+func TestStore_ForEachLTX(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		db, err := store.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		} else if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		// Leave a temporary file behind to ensure it is skipped.
+		if err := os.WriteFile(db.LTXPath(3, 3)+".tmp", nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		var infos []litefs.LTXFileInfo
+		if err := store.ForEachLTX("test.db", func(info litefs.LTXFileInfo) error {
+			if info.Size <= 0 {
+				t.Fatalf("expected positive size: %d", info.Size)
+			}
+			info.Size = 0
+			infos = append(infos, info)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := infos, []litefs.LTXFileInfo{
+			{MinTXID: 1, MaxTXID: 1, Snapshot: true},
+			{MinTXID: 2, MaxTXID: 2},
+		}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("infos=%#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("StopOnError", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		db, err := store.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		} else if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		var n int
+		errMarker := errors.New("marker")
+		if err := db.ForEachLTX(func(info litefs.LTXFileInfo) error {
+			n++
+			return errMarker
+		}); err != errMarker {
+			t.Fatalf("unexpected error: %v", err)
+		} else if got, want := n, 1; got != want {
+			t.Fatalf("n=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("ErrDatabaseNotFound", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if err := store.ForEachLTX("test.db", func(litefs.LTXFileInfo) error { return nil }); err != litefs.ErrDatabaseNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestDB_OpenLTX(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Compress=%v", compress), func(t *testing.T) {
+			store := newStore(t, newPrimaryStaticLeaser(), nil)
+			store.Compress = compress
+			if err := store.Open(); err != nil {
+				t.Fatal(err)
+			}
+			<-store.ReadyCh()
+
+			db, err := store.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Ensure the file on disk uses the store's compression.
+			var flags uint32
+			if compress {
+				flags = ltx.HeaderFlagCompressLZ4
+			}
+			if raw, err := os.ReadFile(db.LTXPath(1, 1)); err != nil {
+				t.Fatal(err)
+			} else if hdr, _, err := ltx.DecodeHeader(bytes.NewReader(raw)); err != nil {
+				t.Fatal(err)
+			} else if got, want := hdr.Flags, flags; got != want {
+				t.Fatalf("on-disk Flags=%x, want %x", got, want)
+			}
+
+			rc, err := db.OpenLTX(1, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = rc.Close() }()
+
+			buf, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dec := ltx.NewDecoder(bytes.NewReader(buf))
+			if err := dec.Verify(); err != nil {
+				t.Fatal(err)
+			} else if got := dec.Header().Flags; got != 0 {
+				t.Fatalf("Flags=%x, want 0", got)
+			}
+
+			// Seek into the middle of the stream to simulate a resumed download.
+			off := int64(len(buf) / 2)
+			if _, err := rc.Seek(off, io.SeekStart); err != nil {
+				t.Fatal(err)
+			} else if rest, err := io.ReadAll(rc); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(rest, buf[off:]) {
+				t.Fatal("resumed read mismatch")
+			}
+
+			// Temporary files must not be left in the LTX directory.
+			var n int
+			if err := db.ForEachLTX(func(info litefs.LTXFileInfo) error { n++; return nil }); err != nil {
+				t.Fatal(err)
+			} else if ents, err := os.ReadDir(db.LTXDir()); err != nil {
+				t.Fatal(err)
+			} else if got, want := len(ents), n; got != want {
+				t.Fatalf("len(ents)=%d, want %d", got, want)
+			}
+		})
+	}
+
+	t.Run("ErrLTXFileNotFound", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		db, err := store.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.OpenLTX(2, 5); !errors.Is(err, litefs.ErrLTXFileNotFound) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestStore_Backup(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		var mu sync.Mutex
+		var filenames []string
+		store := newStore(t, newPrimaryStaticLeaser(), nil)
+		store.Backup = &mock.Backup{
+			WriteLTXFunc: func(ctx context.Context, dbName string, hdr ltx.Header, r io.Reader) error {
+				if _, err := io.Copy(io.Discard, r); err != nil {
+					return err
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				filenames = append(filenames, dbName+"/"+ltx.FormatFilename(hdr.MinTXID, hdr.MaxTXID))
+				return nil
+			},
+			DBsFunc:      func(ctx context.Context) ([]string, error) { return nil, nil },
+			LTXFilesFunc: func(ctx context.Context, dbName string) ([]litefs.LTXFileInfo, error) { return nil, nil },
+		}
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		db, err := store.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		} else if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			if got, want := filenames, []string{
+				"test.db/0000000000000001-0000000000000001.ltx",
+				"test.db/0000000000000002-0000000000000002.ltx",
+			}; !reflect.DeepEqual(got, want) {
+				return fmt.Errorf("filenames=%v, want %v", got, want)
+			}
+			return nil
+		})
+	})
+
+	// Ensure LTX files are not removed by retention until they are backed up.
+	t.Run("RetentionRequireBackup", func(t *testing.T) {
+		var available atomic.Bool
+		store := newStore(t, newPrimaryStaticLeaser(), nil)
+		store.Retention = 0
+		store.RetentionRequireBackup = true
+		store.Backup = &mock.Backup{
+			WriteLTXFunc: func(ctx context.Context, dbName string, hdr ltx.Header, r io.Reader) error {
+				if !available.Load() {
+					return errors.New("marker")
+				}
+				return nil
+			},
+			DBsFunc:      func(ctx context.Context) ([]string, error) { return nil, nil },
+			LTXFilesFunc: func(ctx context.Context, dbName string) ([]litefs.LTXFileInfo, error) { return nil, nil },
+		}
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		db, err := store.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		} else if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.EnforceRetention(context.Background(), time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		} else if _, err := os.Stat(db.LTXPath(1, 1)); err != nil {
+			t.Fatalf("expected ltx file to be retained: %v", err)
+		}
+
+		// Once the backup is available, retention can remove the file.
+		available.Store(true)
+		if err := db.EnforceRetention(context.Background(), time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		} else if _, err := os.Stat(db.LTXPath(1, 1)); !os.IsNotExist(err) {
+			t.Fatalf("expected ltx file to be removed: %v", err)
+		} else if _, err := os.Stat(db.LTXPath(2, 2)); err != nil {
+			t.Fatalf("expected latest ltx file to be retained: %v", err)
+		}
+	})
+
+	// Ensure files that already exist in the backup, such as those retained
+	// across a restart, are not written again before retention removes them.
+	t.Run("AlreadyBackedUp", func(t *testing.T) {
+		var writeN atomic.Int64
+		store := newStore(t, newPrimaryStaticLeaser(), nil)
+		store.Retention = 0
+		store.RetentionRequireBackup = true
+		store.Backup = &mock.Backup{
+			WriteLTXFunc: func(ctx context.Context, dbName string, hdr ltx.Header, r io.Reader) error {
+				writeN.Add(1)
+				return errors.New("marker")
+			},
+			DBsFunc: func(ctx context.Context) ([]string, error) { return nil, nil },
+			LTXFilesFunc: func(ctx context.Context, dbName string) ([]litefs.LTXFileInfo, error) {
+				return []litefs.LTXFileInfo{{MinTXID: 1, MaxTXID: 1}}, nil
+			},
+		}
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		db, err := store.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		} else if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.EnforceRetention(context.Background(), time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		} else if _, err := os.Stat(db.LTXPath(1, 1)); !os.IsNotExist(err) {
+			t.Fatalf("expected ltx file to be removed: %v", err)
+		}
+
+		// Only the file missing from the backup is written.
+		testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+			if got, want := writeN.Load(), int64(1); got != want {
+				return fmt.Errorf("writes=%d, want %d", got, want)
+			}
+			return nil
+		})
+	})
+}
+
+func TestStore_RestoreFromBackup(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// newBackup returns a backup containing three transactions.
+	newBackup := func(t *testing.T) (*memBackup, litefs.Pos) {
+		backup := newMemBackup()
+		store := newStore(t, newPrimaryStaticLeaser(), nil)
+		store.Backup = backup.Mock()
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		db, err := store.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+			if got, want := backup.Len(), 3; got != want {
+				return fmt.Errorf("backup files=%d, want %d", got, want)
+			}
+			return nil
+		})
+		return backup, db.Pos()
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		backup, pos := newBackup(t)
+
+		store := newStore(t, newPrimaryStaticLeaser(), nil)
+		store.Backup = backup.Mock()
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		if db := store.DB("test.db"); db == nil {
+			t.Fatal("expected database to be restored")
+		} else if got, want := db.Pos(), pos; got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		} else if ents, err := db.ReadLTXDir(); err != nil {
+			t.Fatal(err)
+		} else if got, want := len(ents), 3; got != want {
+			t.Fatalf("ltx files=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("ErrGap", func(t *testing.T) {
+		backup, _ := newBackup(t)
+		backup.Delete("test.db", 2, 2)
+
+		store := newStore(t, newPrimaryStaticLeaser(), nil)
+		store.Backup = backup.Mock()
+		if err := store.Open(); err == nil || !strings.Contains(err.Error(), "gap in ltx files") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNoSnapshot", func(t *testing.T) {
+		backup, _ := newBackup(t)
+		backup.Delete("test.db", 1, 1)
+
+		store := newStore(t, newPrimaryStaticLeaser(), nil)
+		store.Backup = backup.Mock()
+		if err := store.Open(); err == nil || !strings.Contains(err.Error(), "no snapshot found") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Ensure existing local databases are not restored.
+	t.Run("SkipLocal", func(t *testing.T) {
+		backup, _ := newBackup(t)
+
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		db, err := store.CreateDBIfNotExists("test.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store.Backup = backup.Mock()
+		if err := store.RestoreFromBackup(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if got, want := db.Pos(), (litefs.Pos{}); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+	})
+}
+
+// Ensure a WAL-mode database is detected and that recovery on a state change
+// truncates the WAL without copying uncommitted frames into the database.
+func TestStore_Recover_WAL(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[18], data[19] = 2, 2 // file format write/read version for WAL mode
+
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := db.Mode(), litefs.DBModeWAL; got != want {
+		t.Fatalf("Mode=%s, want %s", got, want)
+	} else if s := store.Expvar().String(); !strings.Contains(s, `"mode":"WAL_MODE"`) {
+		t.Fatalf("unexpected expvar: %s", s)
+	}
+	pos := db.Pos()
+
+	// Write a WAL with a single uncommitted frame, as if a writer was
+	// interrupted before the node changed state.
+	pageSize := uint32(binary.BigEndian.Uint16(data[16:]))
+	hdr := make([]byte, litefs.WALHeaderSize)
+	binary.BigEndian.PutUint32(hdr[0:], 0x377f0682)
+	binary.BigEndian.PutUint32(hdr[4:], 3007000)
+	binary.BigEndian.PutUint32(hdr[8:], pageSize)
+	binary.BigEndian.PutUint32(hdr[16:], 1000) // salt1
+	binary.BigEndian.PutUint32(hdr[20:], 2000) // salt2
+	chksum1, chksum2 := litefs.WALChecksum(binary.LittleEndian, 0, 0, hdr[:24])
+	binary.BigEndian.PutUint32(hdr[24:], chksum1)
+	binary.BigEndian.PutUint32(hdr[28:], chksum2)
+
+	frameHdr := make([]byte, litefs.WALFrameHeaderSize)
+	page := bytes.Repeat([]byte{0xFF}, int(pageSize))
+	binary.BigEndian.PutUint32(frameHdr[0:], 2) // pgno, no commit
+	copy(frameHdr[8:], hdr[16:24])
+	chksum1, chksum2 = litefs.WALChecksum(binary.LittleEndian, chksum1, chksum2, frameHdr[:8])
+	chksum1, chksum2 = litefs.WALChecksum(binary.LittleEndian, chksum1, chksum2, page)
+	binary.BigEndian.PutUint32(frameHdr[16:], chksum1)
+	binary.BigEndian.PutUint32(frameHdr[20:], chksum2)
+
+	wal := append(append(hdr, frameHdr...), page...)
+	if err := os.WriteFile(db.WALPath(), wal, 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Recover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if fi, err := os.Stat(db.WALPath()); err != nil {
+		t.Fatal(err)
+	} else if got, want := fi.Size(), int64(0); got != want {
+		t.Fatalf("wal size=%d, want %d", got, want)
+	}
+	if got, want := db.Pos(), pos; got != want {
+		t.Fatalf("Pos=%s, want %s", got, want)
+	} else if err := store.VerifyDB(context.Background(), "sqlite.db"); err != nil {
+		t.Fatal(err)
+	} else if got, want := db.Mode(), litefs.DBModeWAL; got != want {
+		t.Fatalf("Mode=%s, want %s", got, want)
+	}
+}
+
+func TestStore_RecoverToTXID(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Alter the last byte of the database so the second transaction differs.
+	data2 := append([]byte{}, data...)
+	data2[len(data2)-1] ^= 1
+
+	// Write three transactions as the primary.
+	dir := t.TempDir()
+	primary := litefs.NewStore(dir, true)
+	primary.Leaser = newPrimaryStaticLeaser()
+	if err := primary.Open(); err != nil {
+		t.Fatal(err)
+	}
+	<-primary.ReadyCh()
+
+	db, err := primary.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	} else if err := db.Import(context.Background(), bytes.NewReader(data2)); err != nil {
+		t.Fatal(err)
+	}
+	pos2 := db.Pos()
+	if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	} else if db.Pos().PostApplyChecksum == pos2.PostApplyChecksum {
+		t.Fatal("expected checksum to change")
+	}
+
+	if err := primary.RecoverToTXID(context.Background(), "test.db", 2); err != litefs.ErrRecoverOnPrimary {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := primary.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen the data directory as a replica.
+	store := litefs.NewStore(dir, false)
+	store.Leaser = litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+	store.Client = newStreamClient(t, readyStreamFrame(t))
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	<-store.ReadyCh()
+
+	for _, txID := range []uint64{0, 4} {
+		if err := store.RecoverToTXID(context.Background(), "test.db", txID); !errors.Is(err, litefs.ErrTXIDNotRetained) {
+			t.Fatalf("txid=%d: unexpected error: %v", txID, err)
+		}
+	}
+
+	if err := store.RecoverToTXID(context.Background(), "test.db", 2); err != nil {
+		t.Fatal(err)
+	} else if got, want := store.DB("test.db").Pos(), pos2; got != want {
+		t.Fatalf("Pos=%s, want %s", got, want)
+	}
+
+	// Only a snapshot at the recovered position should remain.
+	var infos []litefs.LTXFileInfo
+	if err := store.ForEachLTX("test.db", func(info litefs.LTXFileInfo) error {
+		info.Size = 0
+		infos = append(infos, info)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	} else if got, want := infos, []litefs.LTXFileInfo{{MinTXID: 1, MaxTXID: 2, Snapshot: true}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("infos=%#v, want %#v", got, want)
+	}
+
+	// Ensure the database file matches the recovered transaction.
+	var buf bytes.Buffer
+	if _, err := store.ExportDB(context.Background(), "test.db", &buf); err != nil {
+		t.Fatal(err)
+	} else if got, want := buf.Bytes()[len(data2)-1], data2[len(data2)-1]; got != want {
+		t.Fatalf("last byte=%02x, want %02x", got, want)
+	}
+
+	t.Run("ErrDatabaseNotFound", func(t *testing.T) {
+		if err := store.RecoverToTXID(context.Background(), "no_such.db", 1); err != litefs.ErrDatabaseNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestStore_Verify(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newDB := func(tb testing.TB) (*litefs.Store, *litefs.DB) {
+		return newOpenStoreWithDB(tb, "test.db", 3)
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		store, _ := newDB(t)
+		if err := store.Verify(context.Background(), "test.db"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrDatabaseNotFound", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if err := store.Verify(context.Background(), "nosuchdb"); err != litefs.ErrDatabaseNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Ensure silent corruption of the database file is detected.
+	t.Run("CorruptDatabase", func(t *testing.T) {
+		store, db := newDB(t)
+
+		f, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666)
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err := f.WriteAt([]byte{0xff}, int64(len(data)-1)); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Verify(context.Background(), "test.db"); !errors.Is(err, litefs.ErrVerifyFailed) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Ensure corruption within an LTX file is detected.
+	t.Run("CorruptLTX", func(t *testing.T) {
+		store, db := newDB(t)
+
+		path := db.LTXPath(2, 2)
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf[len(buf)/2] ^= 1
+		if err := os.WriteFile(path, buf, 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Verify(context.Background(), "test.db"); !errors.Is(err, litefs.ErrVerifyFailed) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Ensure a missing LTX file in the middle of the chain is detected.
+	t.Run("Gap", func(t *testing.T) {
+		store, db := newDB(t)
+		if err := os.Remove(db.LTXPath(2, 2)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Verify(context.Background(), "test.db"); !errors.Is(err, litefs.ErrVerifyFailed) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestStore_RepairOnOpen(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// newTornDir returns a data directory with a snapshot & an incremental
+	// transaction where the database file has a page overwritten that is
+	// not in the latest LTX file.
+	newTornDir := func(t *testing.T) string {
+		dir := t.TempDir()
+		primary := litefs.NewStore(dir, true)
+		primary.Leaser = newPrimaryStaticLeaser()
+		if err := primary.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-primary.ReadyCh()
+
+		db, err := primary.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Write an incremental transaction that only changes the last page.
+		const pgno, pageSize = 10, 4096
+		page := make([]byte, pageSize)
+		copy(page, data[(pgno-1)*pageSize:])
+		prevChksum := ltx.ChecksumPage(pgno, page)
+		page[pageSize-1] ^= 1
+
+		var buf bytes.Buffer
+		pos := db.Pos()
+		enc := ltx.NewEncoder(&buf)
+		if err := enc.EncodeHeader(ltx.Header{Version: ltx.Version, PageSize: pageSize, Commit: 10, MinTXID: 2, MaxTXID: 2, PreApplyChecksum: pos.PostApplyChecksum}); err != nil {
+			t.Fatal(err)
+		} else if err := enc.EncodePage(ltx.PageHeader{Pgno: pgno}, page); err != nil {
+			t.Fatal(err)
+		}
+		enc.SetPostApplyChecksum(ltx.ChecksumFlag | (pos.PostApplyChecksum ^ prevChksum ^ ltx.ChecksumPage(pgno, page)))
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if path, err := db.WriteLTXFileAt(context.Background(), &buf); err != nil {
+			t.Fatal(err)
+		} else if err := db.ApplyLTX(context.Background(), path); err != nil {
+			t.Fatal(err)
+		} else if err := primary.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Tear the second page of the database file.
+		f, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666)
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err := f.WriteAt([]byte{0xff}, pageSize+100); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		store := litefs.NewStore(newTornDir(t), true)
+		store.Leaser = newPrimaryStaticLeaser()
+		store.RepairOnOpen = true
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = store.Close() }()
+
+		if err := store.VerifyDB(context.Background(), "test.db"); err != nil {
+			t.Fatal(err)
+		} else if got, want := store.DB("test.db").TXID(), uint64(2); got != want {
+			t.Fatalf("TXID=%d, want %d", got, want)
+		}
+	})
+
+	// Ensure the store fails to open if repair is disabled.
+	t.Run("Disabled", func(t *testing.T) {
+		store := litefs.NewStore(newTornDir(t), true)
+		store.Leaser = newPrimaryStaticLeaser()
+		defer func() { _ = store.Close() }()
+		if err := store.Open(); err == nil || !strings.Contains(err.Error(), "does not match LTX post-apply checksum") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestStore_VerifyDB(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newDB := func(tb testing.TB) (*litefs.Store, *litefs.DB) {
+		return newOpenStoreWithDB(tb, "test.db", 1)
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		store, _ := newDB(t)
+		if err := store.VerifyDB(context.Background(), "test.db"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrDatabaseNotFound", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if err := store.VerifyDB(context.Background(), "nosuchdb"); err != litefs.ErrDatabaseNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Ensure the first corrupted page is reported. LTX files are not read so
+	// removing them should not matter.
+	t.Run("CorruptDatabase", func(t *testing.T) {
+		store, db := newDB(t)
+		if err := os.RemoveAll(db.LTXDir()); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.OpenFile(db.DatabasePath(), os.O_RDWR, 0666)
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err := f.WriteAt([]byte{0xff}, int64(len(data)-1)); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		var mismatchErr *litefs.ChecksumMismatchError
+		if err := store.VerifyDB(context.Background(), "test.db"); !errors.As(err, &mismatchErr) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !errors.Is(err, litefs.ErrVerifyFailed) {
+			t.Fatalf("expected ErrVerifyFailed: %v", err)
+		} else if got, want := mismatchErr.Pgno, uint32(len(data)/4096); got != want {
+			t.Fatalf("Pgno=%d, want %d", got, want)
+		} else if got, want := mismatchErr.Pos, db.Pos(); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+	})
+}
+
+// Ensure the background scrubber detects a corrupted database.
+func TestStore_Scrub(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newStore(t, newPrimaryStaticLeaser(), nil)
+	store.ScrubInterval = 10 * time.Millisecond
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	<-store.ReadyCh()
+
+	for _, name := range []string{"scrub-ok.db", "scrub-corrupt.db"} {
+		if _, err := store.CreateDBFromReader(context.Background(), name, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.OpenFile(store.DB("scrub-corrupt.db").DatabasePath(), os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := f.WriteAt([]byte{0xff}, int64(len(data)-1)); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if n := gatherDBMetric(t, "litefs_scrub_errors_total", "scrub-corrupt.db"); n == 0 {
+			return fmt.Errorf("expected scrub error")
+		}
+		return nil
+	})
+	if got, want := gatherDBMetric(t, "litefs_scrub_errors_total", "scrub-ok.db"), 0.0; got != want {
+		t.Fatalf("scrub errors=%v, want %v", got, want)
+	}
+}
+
+func TestStore_WriteMetrics(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Metrics are registered globally so use a name unique to this test.
+	const name = "write-metrics.db"
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	db, err := store.CreateDBFromReader(context.Background(), name, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := gatherDBMetric(t, "litefs_db_tx_total", name), 1.0; got != want {
+		t.Fatalf("tx_total=%v, want %v", got, want)
+	} else if got := gatherDBMetric(t, "litefs_db_write_bytes_total", name); got <= 0 {
+		t.Fatalf("write_bytes_total=%v, expected positive", got)
+	}
+
+	if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	} else if got, want := gatherDBMetric(t, "litefs_db_tx_total", name), 2.0; got != want {
+		t.Fatalf("tx_total=%v, want %v", got, want)
+	}
+
+	// Dropping the database should reset the counters.
+	if err := store.DropDB(context.Background(), name); err != nil {
+		t.Fatal(err)
+	} else if got, want := gatherDBMetric(t, "litefs_db_tx_total", name), 0.0; got != want {
+		t.Fatalf("tx_total=%v, want %v", got, want)
+	} else if got, want := gatherDBMetric(t, "litefs_db_write_bytes_total", name), 0.0; got != want {
+		t.Fatalf("write_bytes_total=%v, want %v", got, want)
+	}
+}
+
+// Ensure the position carries the commit timestamp of the last LTX file.
+func TestStore_PosTimestamp(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.Now = func() time.Time { return time.UnixMilli(1700000000123) }
+	if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := time.UnixMilli(1700000000123).UTC()
+	if got := db.Pos().Timestamp; !got.Equal(want) {
+		t.Fatalf("Timestamp=%s, want %s", got, want)
+	}
+
+	var m struct {
+		DBs map[string]struct {
+			Timestamp string `json:"timestamp"`
+		} `json:"dbs"`
+	}
+	if err := json.Unmarshal([]byte(store.Expvar().String()), &m); err != nil {
+		t.Fatal(err)
+	} else if got, want := m.DBs["sqlite.db"].Timestamp, "2023-11-14T22:13:20.123Z"; got != want {
+		t.Fatalf("timestamp=%q, want %q", got, want)
+	}
+}
+
+func TestStore_CopyDB(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		src, err := store.CreateDBFromReader(context.Background(), "prod.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		} else if err := src.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 1})
+		defer func() { _ = sub.Close() }()
+
+		dst, err := store.CopyDB(context.Background(), "prod.db", "staging.db")
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := store.DB("staging.db"), dst; got != want {
+			t.Fatalf("DB=%p, want %p", got, want)
+		} else if got, want := dst.Pos(), src.Pos(); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+
+		if buf, err := os.ReadFile(dst.DatabasePath()); err != nil {
+			t.Fatal(err)
+		} else if other, err := os.ReadFile(src.DatabasePath()); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf, other) {
+			t.Fatal("database contents mismatch")
+		}
+
+		// Ensure the copy is streamed to replicas like any other database.
+		if _, ok := sub.DirtySet()["staging.db"]; !ok {
+			t.Fatal("expected destination to be marked dirty")
+		}
+
+		// Ensure the source is unaffected by writes to the copy.
+		pos := src.Pos()
+		if err := dst.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		} else if got, want := src.Pos(), pos; got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("ErrDatabaseNotFound", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if _, err := store.CopyDB(context.Background(), "prod.db", "staging.db"); err != litefs.ErrDatabaseNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrDatabaseExists", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if _, err := store.CreateDBFromReader(context.Background(), "prod.db", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		} else if _, err := store.CreateDBIfNotExists("staging.db"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.CopyDB(context.Background(), "prod.db", "staging.db"); err != litefs.ErrDatabaseExists {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestStore_DropDBDryRun(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Sum the files on disk to compare against the plan.
+		var fileN int
+		var size int64
+		for _, path := range []string{db.DatabasePath(), db.SHMPath(), db.LTXPath(1, 1)} {
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fileN, size = fileN+1, size+fi.Size()
+		}
+
+		plan, err := store.DropDBDryRun("sqlite.db")
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := plan.Name, "sqlite.db"; got != want {
+			t.Fatalf("Name=%s, want %s", got, want)
+		} else if got, want := plan.Path, db.Path(); got != want {
+			t.Fatalf("Path=%s, want %s", got, want)
+		} else if got, want := plan.FileN, fileN; got != want {
+			t.Fatalf("FileN=%d, want %d", got, want)
+		} else if got, want := plan.Size, size; got != want {
+			t.Fatalf("Size=%d, want %d", got, want)
+		}
+
+		// Ensure nothing was removed.
+		if store.DB("sqlite.db") == nil {
+			t.Fatal("expected database to exist")
+		} else if _, err := os.Stat(db.DatabasePath()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrDatabaseNotFound", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if _, err := store.DropDBDryRun("missing.db"); err != litefs.ErrDatabaseNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestStore_RenameDB(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		db, err := store.CreateDBFromReader(context.Background(), "app_v2", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos := db.Pos()
+
+		sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 1})
+		defer func() { _ = sub.Close() }()
+
+		if err := store.RenameDB(context.Background(), "app_v2", "app_v3"); err != nil {
+			t.Fatal(err)
+		} else if store.DB("app_v2") != nil {
+			t.Fatal("expected old database to be removed")
+		} else if _, err := os.Stat(store.DBPath("app_v2")); !os.IsNotExist(err) {
+			t.Fatalf("expected old database directory to be removed: %v", err)
+		}
+
+		// Ensure position & LTX history are retained under the new name.
+		other := store.DB("app_v3")
+		if other == nil {
+			t.Fatal("expected renamed database")
+		} else if got, want := other.Pos(), pos; got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		} else if _, err := os.Stat(other.LTXPath(1, 1)); err != nil {
+			t.Fatal(err)
+		}
+
+		// Ensure subscribers are notified about both names & the rename.
+		if got, want := sub.DirtySet(), map[string]struct{}{"app_v2": {}, "app_v3": {}}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("DirtySet=%v, want %v", got, want)
+		} else if got, want := sub.Renames(), []litefs.RenameDBStreamFrame{{OldName: "app_v2", NewName: "app_v3"}}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("Renames=%v, want %v", got, want)
+		}
+
+		// Ensure locks can no longer be acquired on the previous instance.
+		if _, err := db.AcquireWriteLock(context.Background(), nil); err != litefs.ErrDatabaseNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		} else if _, err := db.TryLocks(context.Background(), 1, []litefs.LockType{litefs.LockTypeWrite}); err != litefs.ErrDatabaseNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrDatabaseNotFound", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if err := store.RenameDB(context.Background(), "app_v2", "app_v3"); err != litefs.ErrDatabaseNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrDatabaseExists", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		for _, name := range []string{"app_v2", "app_v3"} {
+			if _, err := store.CreateDBIfNotExists(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := store.RenameDB(context.Background(), "app_v2", "app_v3"); err != litefs.ErrDatabaseExists {
+			t.Fatalf("unexpected error: %v", err)
+		} else if store.DB("app_v2") == nil {
+			t.Fatal("expected original database to remain")
+		}
+	})
+}
+
+func TestStore_WaitForPos(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos1 := db.Pos()
+
+		// Position already reached.
+		if err := store.WaitForPos(context.Background(), "sqlite.db", pos1); err != nil {
+			t.Fatal(err)
+		}
+
+		// Wait for the next transaction to be applied. Importing the same
+		// contents again produces the same checksum.
+		pos2 := litefs.Pos{TXID: 2, PostApplyChecksum: pos1.PostApplyChecksum}
+		errCh := make(chan error)
+		go func() { errCh <- store.WaitForPos(context.Background(), "sqlite.db", pos2) }()
+
+		select {
+		case err := <-errCh:
+			t.Fatalf("unexpected return: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+
+		// A previous position is verified against its LTX file.
+		if err := store.WaitForPos(context.Background(), "sqlite.db", pos1); err != nil {
+			t.Fatal(err)
+		}
+
+		// Verify again using the cached LTX header.
+		forked := pos1
+		forked.PostApplyChecksum++
+		if err := store.WaitForPos(context.Background(), "sqlite.db", forked); !errors.Is(err, litefs.ErrPosForked) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("NoChecksum", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		errCh := make(chan error)
+		go func() { errCh <- store.WaitForPos(context.Background(), "sqlite.db", litefs.Pos{TXID: 2}) }()
+
+		if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	})
+
+	t.Run("ErrPosForked", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pos := db.Pos()
+		pos.PostApplyChecksum++
+		if err := store.WaitForPos(context.Background(), "sqlite.db", pos); !errors.Is(err, litefs.ErrPosForked) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrContextCanceled", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if _, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := store.WaitForPos(ctx, "sqlite.db", litefs.Pos{TXID: 100}); err != context.DeadlineExceeded {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrDatabaseNotFound", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if err := store.WaitForPos(context.Background(), "sqlite.db", litefs.Pos{TXID: 1}); err != litefs.ErrDatabaseNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Ensure waiters are woken when the database is dropped.
+	t.Run("ErrDatabaseNotFound/Dropped", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if _, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		errCh := make(chan error)
+		go func() { errCh <- store.WaitForPos(context.Background(), "sqlite.db", litefs.Pos{TXID: 100}) }()
+
+		time.Sleep(10 * time.Millisecond)
+		if err := store.DropDB(context.Background(), "sqlite.db"); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-errCh:
+			if err != litefs.ErrDatabaseNotFound {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	})
+}
+
+// Ensure store can write a snapshot of a single database by name.
+func TestStore_Snapshot(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		store := newStoreFromFixture(t, newPrimaryStaticLeaser(), nil, "testdata/store/open-and-write-snapshot")
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		pos, err := store.Snapshot(context.Background(), "sqlite.db", &buf)
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := pos, store.DB("sqlite.db").Pos(); got != want {
+			t.Fatalf("pos=%s, want %s", got, want)
+		}
+
+		dec := ltx.NewDecoder(&buf)
+		if err := dec.Verify(); err != nil {
+			t.Fatal(err)
+		} else if hdr := dec.Header(); !hdr.IsSnapshot() {
+			t.Fatal("expected snapshot")
+		} else if got, want := hdr.MaxTXID, pos.TXID; got != want {
+			t.Fatalf("MaxTXID=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("ErrDatabaseNotFound", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if _, err := store.Snapshot(context.Background(), "no_such_db", io.Discard); err != litefs.ErrDatabaseNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestStore_StreamFrom(t *testing.T) {
+	newDB := func(tb testing.TB) (*litefs.Store, *litefs.DB) {
+		return newOpenStoreWithDB(tb, "sqlite.db", 3)
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		store, db := newDB(t)
+
+		var buf bytes.Buffer
+		pos, err := store.StreamFrom(context.Background(), "sqlite.db", 2, &buf)
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := pos, db.Pos(); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+
+		for _, txID := range []uint64{2, 3} {
+			frame, err := litefs.ReadStreamFrame(&buf)
+			if err != nil {
+				t.Fatal(err)
+			} else if got, want := frame.(*litefs.LTXStreamFrame).Name, "sqlite.db"; got != want {
+				t.Fatalf("Name=%s, want %s", got, want)
+			}
+
+			cr := chunk.NewReader(&buf)
+			dec := ltx.NewDecoder(cr)
+			if err := dec.Verify(); err != nil {
+				t.Fatal(err)
+			} else if got, want := dec.Header().MinTXID, txID; got != want {
+				t.Fatalf("MinTXID=%d, want %d", got, want)
+			} else if _, err := io.Copy(io.Discard, cr); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if buf.Len() != 0 {
+			t.Fatalf("unexpected trailing data: %d bytes", buf.Len())
+		}
+	})
+
+	t.Run("CurrentPosition", func(t *testing.T) {
+		store, db := newDB(t)
+
+		var buf bytes.Buffer
+		if pos, err := store.StreamFrom(context.Background(), "sqlite.db", 4, &buf); err != nil {
+			t.Fatal(err)
+		} else if got, want := pos, db.Pos(); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		} else if buf.Len() != 0 {
+			t.Fatalf("unexpected data: %d bytes", buf.Len())
+		}
+	})
+
+	t.Run("ErrTXIDNotRetained", func(t *testing.T) {
+		store, db := newDB(t)
+		if err := os.Remove(db.LTXPath(2, 2)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.StreamFrom(context.Background(), "sqlite.db", 2, io.Discard); !errors.Is(err, litefs.ErrTXIDNotRetained) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrDatabaseNotFound", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if _, err := store.StreamFrom(context.Background(), "sqlite.db", 1, io.Discard); err != litefs.ErrDatabaseNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure retention uses per-database overrides and retains a minimum number of files.
+func TestStore_EnforceRetention(t *testing.T) {
+	store := newStore(t, newPrimaryStaticLeaser(), nil)
+	store.Retention = 1 * time.Minute
+	store.RetentionOverrides = map[string]time.Duration{"a.db": 1 * time.Hour}
+	store.RetentionMinCount = 2
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	<-store.ReadyCh()
+
+	// Generate LTX files on each database that are older than the default retention.
+	for _, name := range []string{"a.db", "b.db"} {
+		db, f, err := store.CreateDB(name)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.MkdirAll(db.LTXDir(), 0777); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-10 * time.Minute)
+		for txID := uint64(1); txID <= 5; txID++ {
+			path := db.LTXPath(txID, txID)
+			if err := os.WriteFile(path, nil, 0666); err != nil {
+				t.Fatal(err)
+			} else if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := store.EnforceRetention(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Database with an override should retain all files.
+	if ents, err := store.DB("a.db").ReadLTXDir(); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(ents), 5; got != want {
+		t.Fatalf("a.db: n=%d, want %d", got, want)
+	}
+
+	// Database without an override should only retain the minimum count.
+	if ents, err := store.DB("b.db").ReadLTXDir(); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(ents), 2; got != want {
+		t.Fatalf("b.db: n=%d, want %d", got, want)
+	} else if got, want := ents[0].Name(), ltx.FormatFilename(4, 4); got != want {
+		t.Fatalf("b.db: ents[0]=%s, want %s", got, want)
+	}
+
+	if got, want := store.DBRetention("a.db"), 1*time.Hour; got != want {
+		t.Fatalf("DBRetention(a.db)=%s, want %s", got, want)
+	} else if got, want := store.DBRetention("b.db"), 1*time.Minute; got != want {
+		t.Fatalf("DBRetention(b.db)=%s, want %s", got, want)
+	}
+}
+
+// Ensure retention keeps the LTX files needed by a connected replica, even if
+// they are older than the retention period.
+func TestStore_EnforceRetention_ReplicaPos(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	store.Retention = 1 * time.Minute
+
+	db, f, err := store.CreateDB("sqlite.db")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(db.LTXDir(), 0777); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-10 * time.Minute)
+	for txID := uint64(1); txID <= 5; txID++ {
+		path := db.LTXPath(txID, txID)
+		if err := os.WriteFile(path, nil, 0666); err != nil {
+			t.Fatal(err)
+		} else if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Replica still needs every transaction after TXID 2.
+	sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 2})
+	defer func() { _ = sub.Close() }()
+	sub.SetPosMap(map[string]litefs.Pos{"sqlite.db": {TXID: 2}})
+
+	if err := store.EnforceRetention(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if ents, err := db.ReadLTXDir(); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(ents), 3; got != want {
+		t.Fatalf("n=%d, want %d", got, want)
+	} else if got, want := ents[0].Name(), ltx.FormatFilename(3, 3); got != want {
+		t.Fatalf("ents[0]=%s, want %s", got, want)
+	}
+
+	// Once the replica disconnects, the files are removed.
+	if err := sub.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := store.EnforceRetention(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if ents, err := db.ReadLTXDir(); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(ents), 1; got != want {
+		t.Fatalf("n=%d, want %d", got, want)
+	}
+}
+
+// Ensure retention removes the oldest files beyond the max count, even if
+// they are within the retention period.
+func TestStore_EnforceRetention_MaxFiles(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	store.Retention = 1 * time.Hour
+	store.RetentionMaxFiles = 3
+
+	// Generate recent LTX files on each database.
+	for _, name := range []string{"a.db", "b.db"} {
+		db, f, err := store.CreateDB(name)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.MkdirAll(db.LTXDir(), 0777); err != nil {
+			t.Fatal(err)
+		}
+		for txID := uint64(1); txID <= 5; txID++ {
+			if err := os.WriteFile(db.LTXPath(txID, txID), nil, 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Override the max count on a single database.
+	store.DB("b.db").SetRetentionMaxFiles(1)
+
+	if err := store.EnforceRetention(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if ents, err := store.DB("a.db").ReadLTXDir(); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(ents), 3; got != want {
+		t.Fatalf("a.db: n=%d, want %d", got, want)
+	} else if got, want := ents[0].Name(), ltx.FormatFilename(3, 3); got != want {
+		t.Fatalf("a.db: ents[0]=%s, want %s", got, want)
+	}
+
+	// The latest file should always be retained.
+	if ents, err := store.DB("b.db").ReadLTXDir(); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(ents), 1; got != want {
+		t.Fatalf("b.db: n=%d, want %d", got, want)
+	} else if got, want := ents[0].Name(), ltx.FormatFilename(5, 5); got != want {
+		t.Fatalf("b.db: ents[0]=%s, want %s", got, want)
+	}
+}
+
+// Ensure retention does not remove files from a database with a broken chain
+// when the safety check is enabled.
+func TestStore_EnforceRetention_SafetyCheck(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	store.Retention = 1 * time.Minute
+	store.RetentionSafetyCheck = true
+
+	// Generate old LTX files with a gap on one database.
+	for name, txIDs := range map[string][]uint64{
+		"a.db": {1, 2, 3, 4, 5},
+		"b.db": {1, 2, 4, 5},
+	} {
+		db, f, err := store.CreateDB(name)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.MkdirAll(db.LTXDir(), 0777); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-10 * time.Minute)
+		for _, txID := range txIDs {
+			path := db.LTXPath(txID, txID)
+			if err := os.WriteFile(path, nil, 0666); err != nil {
+				t.Fatal(err)
+			} else if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := store.EnforceRetention(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Contiguous chain should be reduced to the latest file.
+	if ents, err := store.DB("a.db").ReadLTXDir(); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(ents), 1; got != want {
+		t.Fatalf("a.db: n=%d, want %d", got, want)
+	} else if got, want := gatherDBMetric(t, "litefs_db_ltx_chain_gap_count", "a.db"), 0.0; got != want {
+		t.Fatalf("a.db: gaps=%v, want %v", got, want)
+	}
+
+	// Chain with a gap should retain all files.
+	if ents, err := store.DB("b.db").ReadLTXDir(); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(ents), 4; got != want {
+		t.Fatalf("b.db: n=%d, want %d", got, want)
+	} else if got, want := gatherDBMetric(t, "litefs_db_ltx_chain_gap_count", "b.db"), 1.0; got != want {
+		t.Fatalf("b.db: gaps=%v, want %v", got, want)
+	}
+}
+
+// Ensure compaction merges incremental LTX files & that a replica can apply
+// the merged file.
+func TestDB_Compact(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	primary.CompactionMinFiles = 2
+	db, err := primary.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pos := db.Pos()
+
+	if err := db.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The snapshot & the latest file are left in place.
+	var infos []litefs.LTXFileInfo
+	if err := db.ForEachLTX(func(info litefs.LTXFileInfo) error {
+		info.Size = 0
+		infos = append(infos, info)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := infos, []litefs.LTXFileInfo{
+		{MinTXID: 1, MaxTXID: 1, Snapshot: true},
+		{MinTXID: 2, MaxTXID: 5},
+		{MinTXID: 6, MaxTXID: 6},
+	}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("infos=%#v, want %#v", got, want)
+	}
+
+	// Stream every file to a replica as the primary would.
+	var frames [][]byte
+	for _, txID := range []uint64{1, 2, 6} {
+		f, err := db.OpenLTXFile(txID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, encodeLTXStreamFrame(t, "test.db", buf))
+	}
+	frames = append(frames, readyStreamFrame(t))
+
+	leaser := litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+	replica := newOpenStore(t, leaser, newStreamClient(t, frames...))
+	if got, want := replica.DB("test.db").Pos(), pos; got != want {
+		t.Fatalf("Pos=%s, want %s", got, want)
+	}
+
+	// Compacting again is a no-op.
+	if err := db.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if ents, err := db.ReadLTXDir(); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(ents), 3; got != want {
+		t.Fatalf("len(ents)=%d, want %d", got, want)
+	}
+}
+
+// Ensure LTX files below the current position are compressed on disk and
+// remain readable by OpenLTX() & replicas.
+func TestDB_CompressLTX(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	db, err := primary.CreateDBFromReader(context.Background(), "test.db", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pos := db.Pos()
+
+	totalSize := func() (n int64) {
+		if err := db.ForEachLTX(func(info litefs.LTXFileInfo) error {
+			n += info.Size
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	before := totalSize()
+	if err := primary.CompressLTX(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	after := totalSize()
+	t.Logf("ltx size: before=%d after=%d saved=%.1f%%", before, after, 100*float64(before-after)/float64(before))
+	if after >= before {
+		t.Fatalf("expected compression to reduce size: before=%d after=%d", before, after)
+	}
+
+	// Only the latest file is left uncompressed.
+	var frames [][]byte
+	for txID := uint64(1); txID <= pos.TXID; txID++ {
+		f, err := db.OpenLTXFile(txID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		hdr, _, err := ltx.DecodeHeader(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := hdr.Flags&ltx.HeaderFlagCompressLZ4 != 0, txID < pos.TXID; got != want {
+			t.Fatalf("txid=%d compressed=%v, want %v", txID, got, want)
+		}
+		frames = append(frames, encodeLTXStreamFrame(t, "test.db", buf))
+
+		// Files are decompressed when opened for reading.
+		rc, err := db.OpenLTX(txID, txID)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := ltx.NewDecoder(rc).Verify(); err != nil {
+			t.Fatal(err)
+		} else if err := rc.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	frames = append(frames, readyStreamFrame(t))
+
+	// Past positions are still verified against the compressed files.
+	if err := primary.WaitForPos(context.Background(), "test.db", litefs.Pos{TXID: 1, PostApplyChecksum: pos.PostApplyChecksum}); err != nil {
+		t.Fatal(err)
+	}
+
+	leaser := litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+	replica := newOpenStore(t, leaser, newStreamClient(t, frames...))
+	if got, want := replica.DB("test.db").Pos(), pos; got != want {
+		t.Fatalf("Pos=%s, want %s", got, want)
+	}
+
+	// Compressing again is a no-op.
+	if err := db.CompressLTX(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if got := totalSize(); got != after {
+		t.Fatalf("size=%d, want %d", got, after)
+	}
+}
+
+func TestDB_Compact_Skip(t *testing.T) {
+	newDB := func(tb testing.TB) (*litefs.Store, *litefs.DB) {
+		store, db := newOpenStoreWithDB(tb, "test.db", 6)
+		store.CompactionMinFiles = 2
+		return store, db
+	}
+
+	t.Run("MaxFileSize", func(t *testing.T) {
+		store, db := newDB(t)
+
+		// Only files at or below the size limit are merged.
+		fi, err := os.Stat(db.LTXPath(2, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.CompactionMaxFileSize = fi.Size() - 1
+
+		if err := db.Compact(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if ents, err := db.ReadLTXDir(); err != nil {
+			t.Fatal(err)
+		} else if got, want := len(ents), 6; got != want {
+			t.Fatalf("len(ents)=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("ReplicaPos", func(t *testing.T) {
+		store, db := newDB(t)
+
+		// Replica still needs every transaction after TXID 3.
+		sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 2})
+		defer func() { _ = sub.Close() }()
+		sub.SetPosMap(map[string]litefs.Pos{"test.db": {TXID: 3}})
+
+		if err := db.Compact(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if ents, err := db.ReadLTXDir(); err != nil {
+			t.Fatal(err)
+		} else if got, want := len(ents), 5; got != want {
+			t.Fatalf("len(ents)=%d, want %d", got, want)
+		} else if got, want := ents[1].Name(), ltx.FormatFilename(2, 3); got != want {
+			t.Fatalf("ents[1]=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("HaltLock", func(t *testing.T) {
+		_, db := newDB(t)
+		if _, err := db.AcquireHaltLock(context.Background(), 2, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.Compact(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if ents, err := db.ReadLTXDir(); err != nil {
+			t.Fatal(err)
+		} else if got, want := len(ents), 6; got != want {
+			t.Fatalf("len(ents)=%d, want %d", got, want)
+		}
+
+		// Compaction resumes once the lock is released.
+		db.ReleaseHaltLock(context.Background(), 1)
+		if err := db.Compact(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if ents, err := db.ReadLTXDir(); err != nil {
+			t.Fatal(err)
+		} else if got, want := len(ents), 3; got != want {
+			t.Fatalf("len(ents)=%d, want %d", got, want)
+		}
+	})
+}
+
+func TestPrimaryInfo_Clone(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		info := &litefs.PrimaryInfo{Hostname: "foo", AdvertiseURL: "bar"}
+		if other := info.Clone(); *other != *info {
+			t.Fatal("mismatch")
+		}
+	})
+	t.Run("Nil", func(t *testing.T) {
+		var info *litefs.PrimaryInfo
+		if other := info.Clone(); other != nil {
+			t.Fatal("expected nil")
+		}
+	})
+}
+
+// Ensure store generates a unique ID that is persistent across restarts.
+func TestStore_ID(t *testing.T) {
+	store := newStore(t, newPrimaryStaticLeaser(), nil)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	} else if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	id := store.ID()
+	if id == 0 {
+		t.Fatal("expected id")
+	}
+
+	// Reopen as a new instance.
+	store = litefs.NewStore(store.Path(), true)
+	store.Leaser = newPrimaryStaticLeaser()
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = store.Close() }()
+
+	// Ensure ID is the same.
+	if got, want := store.ID(), id; got != want {
+		t.Fatalf("id=%d, want %d", got, want)
+	}
+}
+
+// Ensure store returns a context that is done when node loses primary status.
+func TestStore_PrimaryCtx(t *testing.T) {
+	t.Run("InitialPrimary", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if ctx := store.PrimaryCtx(context.Background()); ctx.Err() != nil {
+			t.Fatal("expected no error")
+		}
+	})
+
+	t.Run("PrimaryLost", func(t *testing.T) {
+		var isPrimary atomic.Bool
+		isPrimary.Store(true)
+
+		lease := mock.Lease{
+			RenewedAtFunc: func() time.Time { return time.Time{} },
+			TTLFunc:       func() time.Duration { return 10 * time.Millisecond },
+			RenewFunc: func(ctx context.Context) error {
+				if !isPrimary.Load() {
+					return litefs.ErrLeaseExpired
+				}
+				return nil
+			},
+			CloseFunc: func() error { return nil },
+		}
+		leaser := mock.Leaser{
+			CloseFunc:        func() error { return nil },
+			AdvertiseURLFunc: func() string { return "http://localhost:20202" },
+			AcquireFunc: func(ctx context.Context) (litefs.Lease, error) {
+				return &lease, nil
+			},
+			PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
+				return litefs.PrimaryInfo{}, litefs.ErrNoPrimary
+			},
+		}
+
+		client := mock.Client{
+			StreamFunc: func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+				return io.NopCloser(&bytes.Buffer{}), nil
+			},
+		}
+
+		store := newOpenStore(t, &leaser, &client)
+
+		// Ensure store starts in primary state.
+		ctx := store.PrimaryCtx(context.Background())
+		if ctx.Err() != nil {
+			t.Fatal("expected no error")
+		}
+
+		// Mark lease as unrenewable so that store loses lease.
+		isPrimary.Store(false)
+
+		// Check context until it closes.
+		testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+			select {
+			case <-ctx.Done():
+				return nil // ok
+			default:
+				return fmt.Errorf("expected closed context")
+			}
+		})
+	})
+
+	t.Run("InitialReplica", func(t *testing.T) {
+		leaser := litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+		client := mock.Client{
+			StreamFunc: func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+				var buf bytes.Buffer
+				if err := litefs.WriteStreamFrame(&buf, &litefs.ReadyStreamFrame{}); err != nil {
+					return nil, err
+				}
+				return io.NopCloser(&buf), nil
+			},
+		}
+
+		store := newOpenStore(t, leaser, &client)
+		ctx := store.PrimaryCtx(context.Background())
+		if err := ctx.Err(); err != litefs.ErrLeaseExpired {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+	})
+
+	t.Run("ParentCancelation", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if ctx := store.PrimaryCtx(ctx); ctx.Err() != nil {
+			t.Fatal("expected no error")
+		}
+
+		// Cancel & wait for propagation.
+		cancel()
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for parent cancelation")
+		case <-ctx.Done():
+			if err := ctx.Err(); err != context.Canceled {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+	})
+}
+
+func TestStore_PrimaryIsolationTimeout(t *testing.T) {
+	newLeaser := func(renewErr error) *mock.Leaser {
+		lease := &mock.Lease{
+			IDFunc:        func() string { return "lease1" },
+			RenewedAtFunc: func() time.Time { return time.Now() },
+			TTLFunc:       func() time.Duration { return 10 * time.Second },
+			RenewFunc:     func(ctx context.Context) error { return renewErr },
+			CloseFunc:     func() error { return nil },
+		}
+		return &mock.Leaser{
+			CloseFunc:        func() error { return nil },
+			AdvertiseURLFunc: func() string { return "http://localhost:20202" },
+			AcquireFunc:      func(ctx context.Context) (litefs.Lease, error) { return lease, nil },
+			PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
+				return litefs.PrimaryInfo{}, litefs.ErrNoPrimary
+			},
+		}
+	}
+
+	// Ensure the primary demotes itself if it cannot renew & has no replicas.
+	t.Run("Isolated", func(t *testing.T) {
+		failureN := gatherMetric(t, "litefs_lease_renew_failures_total")
+
+		store := newStore(t, newLeaser(errors.New("connection refused")), nil)
+		store.PrimaryIsolationTimeout = 100 * time.Millisecond
+		store.DemoteDelay = time.Minute
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		ctx := store.PrimaryCtx(context.Background())
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for demotion")
+		case <-ctx.Done():
+		}
+
+		// Failed renewals should be counted & lease gauges cleared on demotion.
+		if got := gatherMetric(t, "litefs_lease_renew_failures_total"); got <= failureN {
+			t.Fatalf("renew failures=%v, expected more than %v", got, failureN)
+		}
+		testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+			if v := gatherMetric(t, "litefs_lease_ttl_seconds"); v != 0 {
+				return fmt.Errorf("lease ttl=%v, want 0", v)
+			} else if v := gatherMetric(t, "litefs_lease_renewed_at_seconds"); v != 0 {
+				return fmt.Errorf("lease renewed at=%v, want 0", v)
+			}
+			return nil
+		})
+	})
+
+	// Ensure the primary remains primary while lease renewals succeed.
+	t.Run("Renewed", func(t *testing.T) {
+		store := newStore(t, newLeaser(nil), nil)
+		store.PrimaryIsolationTimeout = 100 * time.Millisecond
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		ctx := store.PrimaryCtx(context.Background())
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("unexpected demotion")
+		}
+
+		if got, want := gatherMetric(t, "litefs_lease_ttl_seconds"), 10.0; got != want {
+			t.Fatalf("lease ttl=%v, want %v", got, want)
+		} else if got := gatherMetric(t, "litefs_lease_renewed_at_seconds"); got < float64(time.Now().Add(-time.Minute).Unix()) {
+			t.Fatalf("unexpected lease renewed at: %v", got)
+		}
+	})
+}
+
+// Ensure store notifies leadership subscribers of primary status changes.
+func TestStore_CandidateWeight(t *testing.T) {
+	// Ensure a zero weight candidate never acquires the lease.
+	t.Run("Zero", func(t *testing.T) {
+		var primaryInfoN atomic.Int64
+		leaser := &mock.Leaser{
+			CloseFunc:        func() error { return nil },
+			AdvertiseURLFunc: func() string { return "http://localhost:20202" },
+			AcquireFunc: func(ctx context.Context) (litefs.Lease, error) {
+				t.Error("unexpected lease acquisition")
+				return nil, litefs.ErrPrimaryExists
+			},
+			PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
+				primaryInfoN.Add(1)
+				return litefs.PrimaryInfo{}, litefs.ErrNoPrimary
+			},
+		}
+
+		store := newStore(t, leaser, nil)
+		store.CandidateWeight = 0
+		store.ReconnectDelay = 10 * time.Millisecond
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		} else if store.Candidate() {
+			t.Fatal("expected zero weight store to not be a candidate")
+		}
+
+		testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+			if primaryInfoN.Load() < 3 {
+				return fmt.Errorf("waiting for election attempts")
+			}
+			return nil
+		})
+		if store.IsPrimary() {
+			t.Fatal("expected store to not be primary")
+		}
+	})
+
+	// Ensure a lower weight candidate defers to a primary elected while it
+	// waits out its election delay.
+	t.Run("ElectionDelay", func(t *testing.T) {
+		var primaryInfoN atomic.Int64
+		leaser := &mock.Leaser{
+			CloseFunc:        func() error { return nil },
+			AdvertiseURLFunc: func() string { return "http://localhost:20202" },
+			AcquireFunc: func(ctx context.Context) (litefs.Lease, error) {
+				t.Error("unexpected lease acquisition")
+				return nil, litefs.ErrPrimaryExists
+			},
+			PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
+				if primaryInfoN.Add(1) == 1 {
+					return litefs.PrimaryInfo{}, litefs.ErrNoPrimary
+				}
+				return litefs.PrimaryInfo{Hostname: "primary", AdvertiseURL: "http://localhost:20203", Weight: 100}, nil
+			},
+		}
+
+		store := newStore(t, leaser, newStreamClient(t, readyStreamFrame(t)))
+		store.CandidateWeight = 90
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for store ready")
+		case <-store.ReadyCh():
+		}
+		if store.IsPrimary() {
+			t.Fatal("expected store to be a replica")
+		} else if _, info := store.PrimaryInfo(); info == nil || info.Weight != 100 {
+			t.Fatalf("unexpected primary info: %#v", info)
+		}
+	})
+
+	// Ensure a replica asks a lower weight primary to hand off after catching up.
+	t.Run("Preempt", func(t *testing.T) {
+		leaser := &mock.Leaser{
+			CloseFunc:        func() error { return nil },
+			AdvertiseURLFunc: func() string { return "http://localhost:20202" },
+			AcquireFunc: func(ctx context.Context) (litefs.Lease, error) {
+				return nil, litefs.ErrPrimaryExists
+			},
+			PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
+				return litefs.PrimaryInfo{Hostname: "primary", AdvertiseURL: "http://localhost:20203", Weight: 50}, nil
+			},
+		}
+
+		preemptCh := make(chan int, 1)
+		client := newStreamClient(t, readyStreamFrame(t))
+		client.PreemptFunc = func(ctx context.Context, primaryURL string, nodeID uint64, weight int, token string) error {
+			preemptCh <- weight
+			return nil
+		}
+
+		store := newStore(t, leaser, client)
+		store.CandidateWeight = 80
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for preempt request")
+		case weight := <-preemptCh:
+			if got, want := weight, 80; got != want {
+				t.Fatalf("weight=%d, want %d", got, want)
+			}
+		}
+	})
+
+	// Ensure the primary only hands off to a higher weight replica.
+	t.Run("ErrPreemptRejected", func(t *testing.T) {
+		store := newOpenStore(t, newHandoffLeaser(), nil)
+		store.CandidateWeight = 50
+		if err := store.Preempt(context.Background(), 2, 50); err != litefs.ErrPreemptRejected {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestStore_SubscribeEvents(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure each transaction is delivered in order with its LTX details.
+	t.Run("OK", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		sub := store.SubscribeEvents()
+		defer func() { _ = sub.Close() }()
+
+		db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		} else if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case <-sub.NotifyCh():
+		default:
+			t.Fatal("expected notification")
+		}
+
+		events := sub.Events()
+		if got, want := len(events), 2; got != want {
+			t.Fatalf("len(events)=%d, want %d", got, want)
+		}
+		for i, event := range events {
+			txID := uint64(i + 1)
+			if got, want := event.Type, litefs.EventTypeTx; got != want {
+				t.Fatalf("events[%d].Type=%s, want %s", i, got, want)
+			} else if got, want := event.Name, "sqlite.db"; got != want {
+				t.Fatalf("events[%d].Name=%s, want %s", i, got, want)
+			} else if event.MinTXID != txID || event.MaxTXID != txID {
+				t.Fatalf("events[%d] txid=%d-%d, want %d", i, event.MinTXID, event.MaxTXID, txID)
+			} else if event.Size <= 0 {
+				t.Fatalf("events[%d].Size=%d, expected positive", i, event.Size)
+			} else if event.Timestamp.IsZero() {
+				t.Fatalf("events[%d].Timestamp expected", i)
+			}
+		}
+		if got, want := events[1].PreApplyChecksum, events[0].PostApplyChecksum; got != want {
+			t.Fatalf("PreApplyChecksum=%016x, want %016x", got, want)
+		} else if got, want := events[1].PostApplyChecksum, db.Pos().PostApplyChecksum; got != want {
+			t.Fatalf("PostApplyChecksum=%016x, want %016x", got, want)
+		}
+
+		if events := sub.Events(); len(events) != 0 {
+			t.Fatalf("expected events to be cleared, got %d", len(events))
+		}
+	})
+
+	// Ensure a subscriber that falls behind receives a resync event instead
+	// of blocking the commit path.
+	t.Run("Resync", func(t *testing.T) {
+		store := newStore(t, newPrimaryStaticLeaser(), nil)
+		store.EventBufferSize = 2
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		sub := store.SubscribeEvents()
+		defer func() { _ = sub.Close() }()
+
+		db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		events := sub.Events()
+		if got, want := len(events), 1; got != want {
+			t.Fatalf("len(events)=%d, want %d", got, want)
+		} else if got, want := events[0].Type, litefs.EventTypeResync; got != want {
+			t.Fatalf("Type=%s, want %s", got, want)
+		}
+
+		// Events resume after the resync.
+		if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		} else if events := sub.Events(); len(events) != 1 || events[0].MaxTXID != 4 {
+			t.Fatalf("unexpected events: %#v", events)
+		}
+	})
+
+	// Ensure closed subscribers no longer receive events.
+	t.Run("Close", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		sub := store.SubscribeEvents()
+		if err := sub.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		} else if events := sub.Events(); len(events) != 0 {
+			t.Fatalf("unexpected events: %#v", events)
+		}
+	})
+}
+
+func TestStore_OnApply(t *testing.T) {
+	// Ensure the callback is invoked for each transaction on the primary.
+	t.Run("Primary", func(t *testing.T) {
+		data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var positions []litefs.Pos
+		store := newStore(t, newPrimaryStaticLeaser(), nil)
+		store.OnApply = func(name string, pos litefs.Pos) {
+			if name != "sqlite.db" {
+				t.Errorf("unexpected name: %q", name)
+			}
+			positions = append(positions, pos)
+		}
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		} else if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := len(positions), 2; got != want {
+			t.Fatalf("len(positions)=%d, want %d", got, want)
+		} else if got, want := positions[0].TXID, uint64(1); got != want {
+			t.Fatalf("positions[0].TXID=%d, want %d", got, want)
+		} else if got, want := positions[1], db.Pos(); got != want {
+			t.Fatalf("positions[1]=%s, want %s", got, want)
+		}
+	})
+
+	// Ensure the callback is invoked for transactions received from the primary.
+	t.Run("Replica", func(t *testing.T) {
+		primary := newStoreFromFixture(t, newPrimaryStaticLeaser(), nil, "testdata/store/open-and-write-snapshot")
+		if err := primary.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		pos, err := primary.Snapshot(context.Background(), "sqlite.db", &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ch := make(chan litefs.Pos, 1)
+		leaser := litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+		replica := newStore(t, leaser, newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", buf.Bytes()), readyStreamFrame(t)))
+		replica.OnApply = func(name string, pos litefs.Pos) { ch <- pos }
+		if err := replica.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for callback")
+		case got := <-ch:
+			if got != pos {
+				t.Fatalf("Pos=%s, want %s", got, pos)
+			}
+		}
+	})
+}
+
+func TestStore_OnDBCreated(t *testing.T) {
+	// Ensure callbacks fire for local creates & drops after the store is
+	// updated and without holding the store lock.
+	t.Run("Primary", func(t *testing.T) {
+		var events []string
+		store := newStore(t, newPrimaryStaticLeaser(), nil)
+		store.OnDBCreated = func(name string) {
+			if store.DB(name) == nil {
+				t.Errorf("expected database %q to exist", name)
+			}
+			events = append(events, "create:"+name)
+		}
+		store.OnDBDropped = func(name string) {
+			if store.DB(name) != nil {
+				t.Errorf("expected database %q to not exist", name)
+			}
+			events = append(events, "drop:"+name)
+		}
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		if _, f, err := store.CreateDB("db1"); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.CreateDBIfNotExists("db2"); err != nil {
+			t.Fatal(err)
+		} else if _, err := store.CreateDBIfNotExists("db2"); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := store.CreateDB("db1"); err != litefs.ErrDatabaseExists {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := store.DropDB(context.Background(), "db1"); err != nil {
+			t.Fatal(err)
+		} else if err := store.DropDB(context.Background(), "db1"); err != litefs.ErrDatabaseNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got, want := strings.Join(events, ","), "create:db1,create:db2,drop:db1"; got != want {
+			t.Fatalf("events=%s, want %s", got, want)
+		}
+	})
+
+	// Ensure callbacks fire for databases created & dropped by the primary.
+	t.Run("Replica", func(t *testing.T) {
+		primary := newStoreFromFixture(t, newPrimaryStaticLeaser(), nil, "testdata/store/open-and-write-snapshot")
+		if err := primary.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := primary.Snapshot(context.Background(), "sqlite.db", &buf); err != nil {
+			t.Fatal(err)
+		}
+
+		var dropFrame bytes.Buffer
+		if err := litefs.WriteStreamFrame(&dropFrame, &litefs.DropDBStreamFrame{Name: "sqlite.db"}); err != nil {
+			t.Fatal(err)
+		}
+
+		ch := make(chan string, 2)
+		leaser := litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+		replica := newStore(t, leaser, newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", buf.Bytes()), dropFrame.Bytes(), readyStreamFrame(t)))
+		replica.OnDBCreated = func(name string) { ch <- "create:" + name }
+		replica.OnDBDropped = func(name string) { ch <- "drop:" + name }
+		if err := replica.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, want := range []string{"create:sqlite.db", "drop:sqlite.db"} {
+			select {
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for callback")
+			case got := <-ch:
+				if got != want {
+					t.Fatalf("event=%s, want %s", got, want)
+				}
+			}
+		}
+	})
+}
+
+// Ensure replicated positions are only reported as durable once synced.
+func TestStore_SyncMode(t *testing.T) {
+	newReplica := func(tb testing.TB, mode litefs.SyncMode, interval time.Duration) (*litefs.Store, litefs.Pos) {
+		primary := newStoreFromFixture(tb, newPrimaryStaticLeaser(), nil, "testdata/store/open-and-write-snapshot")
+		if err := primary.Open(); err != nil {
+			tb.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		pos, err := primary.Snapshot(context.Background(), "sqlite.db", &buf)
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		leaser := litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+		replica := newStore(tb, leaser, newStreamClient(tb, encodeLTXStreamFrame(tb, "sqlite.db", buf.Bytes()), readyStreamFrame(tb)))
+		replica.SyncMode = mode
+		replica.SyncBatchInterval = interval
+		if err := replica.Open(); err != nil {
+			tb.Fatal(err)
+		}
+		<-replica.ReadyCh()
+		return replica, pos
+	}
+
+	t.Run("Full", func(t *testing.T) {
+		replica, pos := newReplica(t, litefs.SyncFull, litefs.DefaultSyncBatchInterval)
+		if got := replica.DurablePosMap()["sqlite.db"]; got != pos {
+			t.Fatalf("DurablePos=%s, want %s", got, pos)
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		replica, pos := newReplica(t, litefs.SyncBatch, time.Hour)
+		if got := replica.PosMap()["sqlite.db"]; got != pos {
+			t.Fatalf("Pos=%s, want %s", got, pos)
+		} else if got, want := replica.DurablePosMap()["sqlite.db"], (litefs.Pos{}); got != want {
+			t.Fatalf("DurablePos=%s, want %s", got, want)
+		}
+
+		// Pending files are synced when the store is closed.
+		if err := replica.Close(); err != nil {
+			t.Fatal(err)
+		} else if got := replica.DurablePosMap()["sqlite.db"]; got != pos {
+			t.Fatalf("DurablePos=%s, want %s", got, pos)
+		}
+	})
+
+	t.Run("BatchInterval", func(t *testing.T) {
+		replica, pos := newReplica(t, litefs.SyncBatch, 10*time.Millisecond)
+		testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+			if got := replica.DurablePosMap()["sqlite.db"]; got != pos {
+				return fmt.Errorf("DurablePos=%s, want %s", got, pos)
+			}
+			return nil
+		})
+	})
+
+	t.Run("None", func(t *testing.T) {
+		replica, pos := newReplica(t, litefs.SyncNone, litefs.DefaultSyncBatchInterval)
+		if got := replica.DurablePosMap()["sqlite.db"]; got != pos {
+			t.Fatalf("DurablePos=%s, want %s", got, pos)
+		}
+	})
+}
+
+// Ensure a slow consumer's dirty set does not grow past its limit.
+func TestSubscriber_SetFilter(t *testing.T) {
+	filter := func(name string) bool { return strings.HasPrefix(name, "a") }
+
+	t.Run("OK", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 1})
+		defer func() { _ = sub.Close() }()
+
+		// Existing entries that do not match are removed.
+		sub.MarkDirty("a.db")
+		sub.MarkDirty("b.db")
+		sub.SetFilter(filter)
+		if got, want := sub.DirtySet(), map[string]struct{}{"a.db": {}}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("DirtySet=%v, want %v", got, want)
+		}
+		<-sub.NotifyCh()
+
+		// Non-matching databases do not notify the subscriber.
+		sub.MarkDirty("b.db")
+		select {
+		case <-sub.NotifyCh():
+			t.Fatal("unexpected notification")
+		default:
+		}
+
+		sub.MarkDirty("a2.db")
+		select {
+		case <-sub.NotifyCh():
+		default:
+			t.Fatal("expected notification")
+		}
+		if got, want := sub.DirtySet(), map[string]struct{}{"a2.db": {}}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("DirtySet=%v, want %v", got, want)
+		}
+
+		// Clearing the filter tracks every database.
+		sub.SetFilter(nil)
+		sub.MarkDirty("b.db")
+		if got, want := sub.DirtySet(), map[string]struct{}{"b.db": {}}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("DirtySet=%v, want %v", got, want)
+		}
+	})
+
+	// Ensure a coalesced set only reports matching databases.
+	t.Run("Coalesce", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		for _, name := range []string{"a.db", "b.db"} {
+			if _, err := store.CreateDBIfNotExists(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 1, DirtySetLimit: 1, Policy: litefs.SubscriberPolicyCoalesce})
+		defer func() { _ = sub.Close() }()
+		sub.SetFilter(filter)
+
+		sub.MarkDirty("a1.db")
+		sub.MarkDirty("a2.db")
+		if got, want := sub.DirtySet(), map[string]struct{}{"a.db": {}}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("DirtySet=%v, want %v", got, want)
+		}
+	})
+}
+
+func TestSubscriber_DirtySetLimit(t *testing.T) {
+	const limit, n = 10, 1000
+
+	// Ensure an overflowed set reports every database in the store instead
+	// of growing with each database name marked dirty.
+	t.Run("Coalesce", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if _, _, err := store.CreateDB("sqlite.db"); err != nil {
+			t.Fatal(err)
+		}
+
+		sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 1, DirtySetLimit: limit, Policy: litefs.SubscriberPolicyCoalesce})
+		defer func() { _ = sub.Close() }()
+		sub.SetPosMap(map[string]litefs.Pos{"dropped.db": {TXID: 1}})
+
+		for i := 0; i < n; i++ {
+			sub.MarkDirty(fmt.Sprintf("db%04d", i))
+		}
+
+		if err := <-sub.NotifyCh(); err != nil {
+			t.Fatal(err)
+		}
+		dirtySet := sub.DirtySet()
+		if got, want := len(dirtySet), 2; got != want {
+			t.Fatalf("len(DirtySet)=%d, want %d", got, want)
+		} else if _, ok := dirtySet["sqlite.db"]; !ok {
+			t.Fatal("expected store database")
+		} else if _, ok := dirtySet["dropped.db"]; !ok {
+			t.Fatal("expected database sent to node")
+		}
+
+		// Ensure the subscriber returns to tracking individual databases.
+		sub.MarkDirty("db0000")
+		if got, want := len(sub.DirtySet()), 1; got != want {
+			t.Fatalf("len(DirtySet)=%d, want %d", got, want)
+		}
+	})
+
+	// Ensure only the most recently marked databases are retained.
+	t.Run("DropOldest", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 1, DirtySetLimit: limit, Policy: litefs.SubscriberPolicyDropOldest})
+		defer func() { _ = sub.Close() }()
+
+		for i := 0; i < n; i++ {
+			sub.MarkDirty(fmt.Sprintf("db%04d", i))
+		}
+
+		dirtySet := sub.DirtySet()
+		if got, want := len(dirtySet), limit; got != want {
+			t.Fatalf("len(DirtySet)=%d, want %d", got, want)
+		}
+		for i := n - limit; i < n; i++ {
+			if _, ok := dirtySet[fmt.Sprintf("db%04d", i)]; !ok {
+				t.Fatalf("expected db%04d", i)
+			}
+		}
+	})
+
+	// Ensure the subscriber is notified with a sentinel error and stops
+	// tracking changes once the limit is exceeded.
+	t.Run("Error", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 1, DirtySetLimit: limit, Policy: litefs.SubscriberPolicyError})
+		defer func() { _ = sub.Close() }()
+
+		for i := 0; i < n; i++ {
+			sub.MarkDirty(fmt.Sprintf("db%04d", i))
+		}
+
+		if err := <-sub.NotifyCh(); err != litefs.ErrSubscriberOverflow {
+			t.Fatalf("unexpected error: %v", err)
+		} else if got, want := len(sub.DirtySet()), 0; got != want {
+			t.Fatalf("len(DirtySet)=%d, want %d", got, want)
+		}
+	})
+}
+
+// Ensure a context subscriber is closed when its context is canceled.
+func TestStore_SubscribeContext(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := store.SubscribeContext(ctx)
+
+	done := make(chan int)
+	go func() {
+		var n int
+		for range sub.NotifyCh() {
+			n++
+		}
+		done <- n
+	}()
+
+	store.MarkDirty("sqlite.db")
+	cancel()
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for notify channel to close")
+	case n := <-done:
+		if n == 0 {
+			t.Fatal("expected notification before close")
+		}
+	}
+
+	// Ensure changes after cancellation are ignored.
+	_ = sub.DirtySet()
+	store.MarkDirty("sqlite.db")
+	if got, want := len(sub.DirtySet()), 0; got != want {
+		t.Fatalf("len(DirtySet)=%d, want %d", got, want)
+	}
+}
+
+// Ensure a subscriber created with options is closed once its context is done.
+func TestSubscriber_CloseOnDone(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 1})
+	sub.CloseOnDone(ctx)
+	cancel()
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for notify channel to close")
+	case _, ok := <-sub.NotifyCh():
+		if ok {
+			t.Fatal("expected notify channel to be closed")
+		}
+	}
+}
+
+// Ensure connected replica streams are reported with their positions.
+func TestStore_Replicas(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+
+	sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 2, RemoteAddr: "10.0.0.1:1234"})
+	sub.SetPosMap(map[string]litefs.Pos{"sqlite.db": {TXID: 2, PostApplyChecksum: 2000}})
+	if err := store.Ack(2, map[string]litefs.Pos{"sqlite.db": {TXID: 1, PostApplyChecksum: 1000}}); err != nil {
+		t.Fatal(err)
+	}
+
+	replicas := store.Replicas()
+	if got, want := len(replicas), 1; got != want {
+		t.Fatalf("len(Replicas)=%d, want %d", got, want)
+	} else if got, want := replicas[0].NodeID, uint64(2); got != want {
+		t.Fatalf("NodeID=%d, want %d", got, want)
+	} else if got, want := replicas[0].RemoteAddr, "10.0.0.1:1234"; got != want {
+		t.Fatalf("RemoteAddr=%s, want %s", got, want)
+	} else if replicas[0].ConnectedAt.IsZero() {
+		t.Fatal("expected connect time")
+	} else if got, want := replicas[0].PosMap["sqlite.db"].TXID, uint64(2); got != want {
+		t.Fatalf("PosMap.TXID=%d, want %d", got, want)
+	} else if got, want := replicas[0].AckPosMap["sqlite.db"].TXID, uint64(1); got != want {
+		t.Fatalf("AckPosMap.TXID=%d, want %d", got, want)
+	} else if got, want := gatherMetric(t, "litefs_connected_replicas"), 1.0; got != want {
+		t.Fatalf("litefs_connected_replicas=%v, want %v", got, want)
+	}
+
+	if !strings.Contains(store.Expvar().String(), `"remoteAddr":"10.0.0.1:1234"`) {
+		t.Fatal("expected replica in expvar")
+	}
+
+	// Ensure the replica is removed once disconnected.
+	if err := sub.Close(); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(store.Replicas()), 0; got != want {
+		t.Fatalf("len(Replicas)=%d, want %d", got, want)
+	} else if got, want := gatherMetric(t, "litefs_connected_replicas"), 0.0; got != want {
+		t.Fatalf("litefs_connected_replicas=%v, want %v", got, want)
+	}
+}
+
+func TestStore_SubscribeLeadership(t *testing.T) {
+	var isPrimary atomic.Bool
+	isPrimary.Store(true)
+
+	lease := mock.Lease{
+		RenewedAtFunc: func() time.Time { return time.Time{} },
+		TTLFunc:       func() time.Duration { return 10 * time.Millisecond },
+		RenewFunc: func(ctx context.Context) error {
+			if !isPrimary.Load() {
+				return litefs.ErrLeaseExpired
+			}
+			return nil
+		},
+		CloseFunc: func() error { return nil },
+	}
+	leaser := mock.Leaser{
+		CloseFunc:        func() error { return nil },
+		AdvertiseURLFunc: func() string { return "http://localhost:20202" },
+		AcquireFunc: func(ctx context.Context) (litefs.Lease, error) {
+			if !isPrimary.Load() {
+				return nil, litefs.ErrPrimaryExists
+			}
+			return &lease, nil
+		},
+		PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
+			return litefs.PrimaryInfo{}, litefs.ErrNoPrimary
+		},
+	}
+
+	store := newOpenStore(t, &leaser, nil)
+	ch := store.SubscribeLeadership()
+	defer store.UnsubscribeLeadership(ch)
+
+	// Ensure the current status is sent immediately.
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for initial status")
+	case v := <-ch:
+		if !v {
+			t.Fatal("expected primary")
+		}
+	}
+
+	// Mark lease as unrenewable so that store loses lease.
+	isPrimary.Store(false)
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for status change")
+	case v := <-ch:
+		if v {
+			t.Fatal("expected replica")
+		}
+	}
+}
+
+// Ensure a replica tracks the primary's transaction ID as it receives frames.
+func TestStore_TXIDLag(t *testing.T) {
+	primary := newStoreFromFixture(t, newPrimaryStaticLeaser(), nil, "testdata/store/open-and-write-snapshot")
+	if err := primary.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	pos, err := primary.Snapshot(context.Background(), "sqlite.db", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The primary reports that it is two transactions ahead of the snapshot.
+	var hbuf bytes.Buffer
+	if err := litefs.WriteStreamFrame(&hbuf, &litefs.HeartbeatStreamFrame{
+		Timestamp: time.Now().UnixMilli(),
+		Interval:  time.Hour.Milliseconds(),
+		PosMap:    map[string]litefs.Pos{"sqlite.db": {TXID: pos.TXID + 2}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	leaser := litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+	replica := newOpenStore(t, leaser, newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", buf.Bytes()), hbuf.Bytes(), readyStreamFrame(t)))
+
+	db := replica.DB("sqlite.db")
+	if db == nil {
+		t.Fatal("expected database")
+	} else if got, want := db.Pos(), pos; got != want {
+		t.Fatalf("Pos=%s, want %s", got, want)
+	} else if got, want := db.TXIDLag(), uint64(2); got != want {
+		t.Fatalf("TXIDLag=%d, want %d", got, want)
+	}
+
+	// The primary should never report lag.
+	if got, want := primary.DB("sqlite.db").TXIDLag(), uint64(0); got != want {
+		t.Fatalf("TXIDLag=%d, want %d", got, want)
+	}
+}
+
+// Ensure a replica backs off between failed attempts to reach the primary.
+func TestStore_ReconnectBackoff(t *testing.T) {
+	ch := make(chan time.Time, 10)
+	client := &mock.Client{
+		StreamFunc: func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+			select {
+			case ch <- time.Now():
+			default:
+			}
+			return nil, fmt.Errorf("marker")
+		},
+	}
+
+	store := newStore(t, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202"), client)
+	store.ReconnectDelay = 5 * time.Millisecond
+	store.MaxReconnectDelay = 40 * time.Millisecond
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	var times []time.Time
+	for i := 0; i < 6; i++ {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for reconnect")
+		case v := <-ch:
+			times = append(times, v)
+		}
+	}
+
+	// The delay is capped at the max & jitter never drops below half of it.
+	if d := times[5].Sub(times[4]); d < 20*time.Millisecond {
+		t.Fatalf("expected backoff, got delay of %s", d)
+	}
+}
+
+// Ensure the full backoff is used between attempts if jitter is disabled.
+func TestStore_ReconnectBackoff_NoJitter(t *testing.T) {
+	ch := make(chan time.Time, 10)
+	client := &mock.Client{
+		StreamFunc: func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+			select {
+			case ch <- time.Now():
+			default:
+			}
+			return nil, fmt.Errorf("marker")
+		},
+	}
+
+	store := newStore(t, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202"), client)
+	store.ReconnectDelay = 5 * time.Millisecond
+	store.MaxReconnectDelay = 40 * time.Millisecond
+	store.ReconnectJitter = 0
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	var times []time.Time
+	for i := 0; i < 4; i++ {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for reconnect")
+		case v := <-ch:
+			times = append(times, v)
+		}
+	}
+
+	// Delays double from the base: 5ms, 10ms, 20ms.
+	if d := times[3].Sub(times[2]); d < 20*time.Millisecond {
+		t.Fatalf("expected full backoff, got delay of %s", d)
+	}
+}
+
+// Ensure a replica skips frames for databases excluded by its replication
+// filter without breaking the stream for included databases.
+func TestStore_ReplicationFilter(t *testing.T) {
+	primary := newStoreFromFixture(t, newPrimaryStaticLeaser(), nil, "testdata/store/open-and-write-snapshot")
+	if err := primary.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	pos, err := primary.Snapshot(context.Background(), "sqlite.db", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newStreamClient(t,
+		encodeLTXStreamFrame(t, "other.db", buf.Bytes()),
+		encodeLTXStreamFrame(t, "sqlite.db", buf.Bytes()),
+		readyStreamFrame(t),
+	)
+
+	// Ensure the prefixes are advertised to the primary.
+	var prefixes []string
+	streamFunc := client.StreamFunc
+	client.StreamFunc = func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+		prefixes = opts.Prefixes
+		return streamFunc(ctx, rawurl, nodeID, posMap, opts)
+	}
+
+	replica := litefs.NewStore(t.TempDir(), false)
+	replica.Leaser = litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+	replica.Client = client
+	t.Cleanup(func() { _ = replica.Close() })
+	replica.ReplicationPrefixes = []string{"sqlite", "other"}
+	replica.ReplicationFilter = func(name string) bool { return name != "other.db" }
+	if err := replica.Open(); err != nil {
+		t.Fatal(err)
+	}
+	<-replica.ReadyCh()
+
+	if got, want := strings.Join(prefixes, ","), "sqlite,other"; got != want {
+		t.Fatalf("prefixes=%s, want %s", got, want)
+	} else if db := replica.DB("other.db"); db != nil {
+		t.Fatal("expected excluded database to be skipped")
+	} else if db := replica.DB("sqlite.db"); db == nil {
+		t.Fatal("expected database")
+	} else if got, want := db.Pos(), pos; got != want {
+		t.Fatalf("Pos=%s, want %s", got, want)
+	}
+}
+
+// Ensure write lock acquisition times out using the database's override or
+// the store's default.
+func TestDB_AcquireWriteLock_Timeout(t *testing.T) {
+	t.Run("DB", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		db, f, err := store.CreateDB("sqlite.db")
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		guardSet, err := db.AcquireWriteLock(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer guardSet.Unlock()
+
+		store.BeginTimeout = 1 * time.Minute
+		db.SetBeginTimeout(10 * time.Millisecond)
+		t0 := time.Now()
+		if _, err := db.AcquireWriteLock(context.Background(), nil); !errors.Is(err, litefs.ErrWriteLockTimeout) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if elapsed := time.Since(t0); elapsed > store.BeginTimeout {
+			t.Fatalf("expected database timeout, elapsed %s", elapsed)
+		}
+	})
+
+	t.Run("Store", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		store.BeginTimeout = 10 * time.Millisecond
+		db, f, err := store.CreateDB("sqlite.db")
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		guardSet, err := db.AcquireWriteLock(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer guardSet.Unlock()
+
+		if _, err := db.AcquireWriteLock(context.Background(), nil); !errors.Is(err, litefs.ErrWriteLockTimeout) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ContextCanceled", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		db, f, err := store.CreateDB("sqlite.db")
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		guardSet, err := db.AcquireWriteLock(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer guardSet.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, err := db.AcquireWriteLock(ctx, nil); err != context.DeadlineExceeded {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure read-only databases reject application writes but still accept
+// imports & replicated changes.
+func TestStore_ReadOnlyDBs(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Primary", func(t *testing.T) {
+		store := newStore(t, newPrimaryStaticLeaser(), nil)
+		store.ReadOnlyDBs = []string{"sqlite.db"}
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		} else if !db.ReadOnly() {
+			t.Fatal("expected read-only database")
+		}
+
+		if _, err := db.AcquireWriteLock(context.Background(), nil); err != litefs.ErrReadOnlyDatabase {
+			t.Fatalf("unexpected error: %v", err)
+		} else if _, err := db.CreateJournal(); err != litefs.ErrReadOnlyDatabase {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := db.WriteDatabaseAt(context.Background(), nil, []byte{0}, 0, 0); err != litefs.ErrReadOnlyDatabase {
+			t.Fatalf("unexpected error: %v", err)
+		} else if db.Writeable() {
+			t.Fatal("expected database to not be writeable")
+		}
+
+		// Administrative imports are still allowed.
+		pos := db.Pos()
+		if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		} else if got, want := db.TXID(), pos.TXID+1; got != want {
+			t.Fatalf("TXID=%d, want %d", got, want)
+		}
+
+		if s := store.Expvar().String(); !strings.Contains(s, `"readOnly":true`) {
+			t.Fatalf("expected read-only state in expvar: %s", s)
+		}
+	})
+
+	t.Run("Replica", func(t *testing.T) {
+		primary := newStoreFromFixture(t, newPrimaryStaticLeaser(), nil, "testdata/store/open-and-write-snapshot")
+		if err := primary.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		pos, err := primary.Snapshot(context.Background(), "sqlite.db", &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		leaser := litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+		replica := newStore(t, leaser, newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", buf.Bytes()), readyStreamFrame(t)))
+		replica.ReadOnlyDBs = []string{"sqlite.db"}
+		if err := replica.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-replica.ReadyCh()
+
+		if db := replica.DB("sqlite.db"); db == nil {
+			t.Fatal("expected database")
+		} else if !db.ReadOnly() {
+			t.Fatal("expected read-only database")
+		} else if got, want := db.Pos(), pos; got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+	})
+}
+
+// Ensure a read-only store never acquires the lease & rejects writes.
+func TestStore_ReadOnly(t *testing.T) {
+	var primaryInfoN atomic.Int32
+	leaser := &mock.Leaser{
+		CloseFunc:        func() error { return nil },
+		AdvertiseURLFunc: func() string { return "http://localhost:20202" },
+		AcquireFunc: func(ctx context.Context) (litefs.Lease, error) {
+			t.Error("unexpected lease acquisition")
+			return nil, litefs.ErrPrimaryExists
+		},
+		PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
+			primaryInfoN.Add(1)
+			return litefs.PrimaryInfo{}, litefs.ErrNoPrimary
+		},
+	}
+
+	store := newStore(t, leaser, nil)
+	store.ReadOnly = true
+	store.ReconnectDelay = 10 * time.Millisecond
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	} else if store.Candidate() {
+		t.Fatal("expected read-only store to not be a candidate")
+	}
+
+	// Wait for several attempts to find a primary.
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if primaryInfoN.Load() < 3 {
+			return fmt.Errorf("waiting for primary info checks")
+		}
+		return nil
+	})
+	if store.IsPrimary() {
+		t.Fatal("expected replica")
+	}
+
+	db, err := store.CreateDBIfNotExists("sqlite.db")
+	if err != nil {
+		t.Fatal(err)
+	} else if db.Writeable() {
+		t.Fatal("expected database to not be writeable")
+	} else if _, err := db.AcquireWriteLock(context.Background(), nil); err != litefs.ErrReadOnly {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := db.AcquireRemoteHaltLock(context.Background(), 1); err != litefs.ErrReadOnly {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a store reports replication lag based on LTX timestamps.
+func TestStore_ReplicationLag(t *testing.T) {
+	primary := newStoreFromFixture(t, newPrimaryStaticLeaser(), nil, "testdata/store/open-and-write-snapshot")
+	if err := primary.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	pos, err := primary.Snapshot(context.Background(), "sqlite.db", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Primary", func(t *testing.T) {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for store ready")
+		case <-primary.ReadyCh():
+		}
+
+		if lag, err := primary.ReplicationLag(); err != nil {
+			t.Fatal(err)
+		} else if got, want := lag, time.Duration(0); got != want {
+			t.Fatalf("lag=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("Replica", func(t *testing.T) {
+		// The primary reports the same TXID with a known LTX timestamp. The
+		// replica is caught up so it should not report any lag.
+		var hbuf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&hbuf, &litefs.HeartbeatStreamFrame{
+			Timestamp: time.Now().UnixMilli(),
+			Interval:  time.Hour.Milliseconds(),
+			PosMap:    map[string]litefs.Pos{"sqlite.db": {TXID: pos.TXID, PostApplyChecksum: pos.PostApplyChecksum, Timestamp: time.Now()}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		leaser := litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+		replica := newOpenStore(t, leaser, newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", buf.Bytes()), hbuf.Bytes(), readyStreamFrame(t)))
+
+		if lag, err := replica.ReplicationLag(); err != nil {
+			t.Fatal(err)
+		} else if got, want := lag, time.Duration(0); got != want {
+			t.Fatalf("lag=%s, want %s", got, want)
+		}
+
+		var m map[string]any
+		if err := json.Unmarshal([]byte(replica.Expvar().String()), &m); err != nil {
+			t.Fatal(err)
+		} else if got, want := m["dbs"].(map[string]any)["sqlite.db"].(map[string]any)["lag"], "0s"; got != want {
+			t.Fatalf("lag=%v, want %v", got, want)
+		}
+	})
+}
+
+// Ensure a replica reconnects if the primary stops sending heartbeats.
+// Ensure a replica requests a snapshot after repeated position mismatches.
+func TestStore_PauseReplication(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	db, err := primary.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos1 := db.Pos()
+	if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	var frames [][]byte
+	for _, txID := range []uint64{1, 2} {
+		buf, err := os.ReadFile(db.LTXPath(txID, txID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, encodeLTXStreamFrame(t, "sqlite.db", buf))
+	}
+
+	// Stream frames to the replica as they are sent on the channel.
+	frameCh := make(chan []byte)
+	client := &mock.Client{
+		StreamFunc: func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+			pr, pw := io.Pipe()
+			go func() {
+				for {
+					select {
+					case <-ctx.Done():
+						_ = pw.CloseWithError(ctx.Err())
+						return
+					case frame := <-frameCh:
+						if _, err := pw.Write(frame); err != nil {
+							return
+						}
+					}
+				}
+			}()
+			return pr, nil
+		},
+	}
+
+	replica := newStore(t, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202"), client)
+	if err := replica.Open(); err != nil {
+		t.Fatal(err)
+	}
+	frameCh <- frames[0]
+	frameCh <- readyStreamFrame(t)
+	<-replica.ReadyCh()
+
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if db := replica.DB("sqlite.db"); db == nil || db.Pos() != pos1 {
+			return fmt.Errorf("first transaction not applied yet")
+		}
+		return nil
+	})
+
+	// Frames received while paused should be spooled but not applied.
+	replica.PauseReplication()
+	frameCh <- frames[1]
+	testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+		if ents, err := os.ReadDir(replica.PausedReplicationPath()); err != nil || len(ents) != 1 {
+			return fmt.Errorf("ltx file not spooled yet")
+		}
+		return nil
+	})
+	if got, want := replica.DB("sqlite.db").Pos(), pos1; got != want {
+		t.Fatalf("Pos=%s, want %s", got, want)
+	} else if s := replica.Expvar().String(); !strings.Contains(s, `"replicationPaused":true`) {
+		t.Fatalf("expected paused state in expvar: %s", s)
+	}
+
+	if err := replica.ResumeReplication(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if got, want := replica.DB("sqlite.db").Pos(), db.Pos(); got != want {
+		t.Fatalf("Pos=%s, want %s", got, want)
+	} else if replica.IsReplicationPaused() {
+		t.Fatal("expected replication to be resumed")
+	} else if _, err := os.Stat(replica.PausedReplicationPath()); !os.IsNotExist(err) {
+		t.Fatalf("expected spooled files to be removed: %v", err)
+	}
+}
+
+func TestStore_SyncReplicas(t *testing.T) {
+	t.Run("Ack", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		store.SyncReplicas = 2
+		store.SyncTimeout = 5 * time.Second
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- store.WaitForSyncReplicas(context.Background(), "sqlite.db", 2) }()
+
+		// Replicas that are behind or acking other databases do not count.
+		if err := store.Ack(2, map[string]litefs.Pos{"sqlite.db": {TXID: 2}}); err != nil {
+			t.Fatal(err)
+		} else if err := store.Ack(3, map[string]litefs.Pos{"sqlite.db": {TXID: 1}, "other.db": {TXID: 5}}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-errCh:
+			t.Fatalf("unexpected return: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		if err := store.Ack(3, map[string]litefs.Pos{"sqlite.db": {TXID: 3}}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for acks")
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if err := store.WaitForSyncReplicas(context.Background(), "sqlite.db", 1); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrSyncReplicationTimeout", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		store.SyncReplicas = 1
+		store.SyncTimeout = 50 * time.Millisecond
+		if err := store.WaitForSyncReplicas(context.Background(), "sqlite.db", 1); err != litefs.ErrSyncReplicationTimeout {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Ensure a WAL write fails on ack timeout without holding the write lock.
+	t.Run("ErrSyncReplicationTimeoutWAL", func(t *testing.T) {
+		data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[18], data[19] = 2, 2 // file format write/read version for WAL mode
+
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		store.SyncReplicas = 1
+		store.SyncTimeout = 1 * time.Second
+		db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		walFile, err := db.CreateWAL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = walFile.Close() }()
+
+		const owner = 1
+		lockTypes := []litefs.LockType{litefs.LockTypeWrite}
+		if ok, err := db.TryLocks(context.Background(), owner, lockTypes); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatal("expected write lock")
+		}
+
+		// Write a single frame transaction to the WAL.
+		pageSize := uint32(binary.BigEndian.Uint16(data[16:]))
+		hdr := make([]byte, litefs.WALHeaderSize)
+		binary.BigEndian.PutUint32(hdr[0:], 0x377f0682)
+		binary.BigEndian.PutUint32(hdr[4:], 3007000)
+		binary.BigEndian.PutUint32(hdr[8:], pageSize)
+		binary.BigEndian.PutUint32(hdr[16:], 1000) // salt1
+		binary.BigEndian.PutUint32(hdr[20:], 2000) // salt2
+		chksum1, chksum2 := litefs.WALChecksum(binary.LittleEndian, 0, 0, hdr[:24])
+		binary.BigEndian.PutUint32(hdr[24:], chksum1)
+		binary.BigEndian.PutUint32(hdr[28:], chksum2)
+
+		frameHdr := make([]byte, litefs.WALFrameHeaderSize)
+		page := bytes.Repeat([]byte{0xFF}, int(pageSize))
+		binary.BigEndian.PutUint32(frameHdr[0:], 2)                                  // pgno
+		binary.BigEndian.PutUint32(frameHdr[4:], binary.BigEndian.Uint32(data[28:])) // commit
+		copy(frameHdr[8:], hdr[16:24])
+		chksum1, chksum2 = litefs.WALChecksum(binary.LittleEndian, chksum1, chksum2, frameHdr[:8])
+		chksum1, chksum2 = litefs.WALChecksum(binary.LittleEndian, chksum1, chksum2, page)
+		binary.BigEndian.PutUint32(frameHdr[16:], chksum1)
+		binary.BigEndian.PutUint32(frameHdr[20:], chksum2)
+
+		if err := db.WriteWALAt(context.Background(), walFile, hdr, 0, owner); err != nil {
+			t.Fatal(err)
+		} else if err := db.WriteWALAt(context.Background(), walFile, frameHdr, litefs.WALHeaderSize, owner); err != nil {
+			t.Fatal(err)
+		} else if err := db.WriteWALAt(context.Background(), walFile, page, litefs.WALHeaderSize+litefs.WALFrameHeaderSize, owner); err != nil {
+			t.Fatal(err)
+		}
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- db.Unlock(context.Background(), owner, lockTypes) }()
+
+		// Other writers can proceed while the writer waits for acknowledgements.
+		testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+			if ok, err := db.TryLocks(context.Background(), 2, lockTypes); err != nil {
+				return err
+			} else if !ok {
+				return fmt.Errorf("write lock not released")
+			}
+			return db.Unlock(context.Background(), 2, lockTypes)
+		})
+		select {
+		case err := <-errCh:
+			t.Fatalf("unexpected unlock return: %v", err)
+		default:
+		}
+
+		// The transaction is committed locally but the write fails.
+		if err := <-errCh; !errors.Is(err, litefs.ErrSyncReplicationTimeout) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if got, want := db.TXID(), uint64(2); got != want {
+			t.Fatalf("TXID=%d, want %d", got, want)
+		}
+
+		// The writer's next write lock reports the failure once.
+		if _, err := db.TryLocks(context.Background(), owner, lockTypes); !errors.Is(err, litefs.ErrSyncReplicationTimeout) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if ok, err := db.TryLocks(context.Background(), owner, lockTypes); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatal("expected write lock")
+		}
+	})
+
+	t.Run("ErrNotPrimary", func(t *testing.T) {
+		store := newOpenStore(t, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202"), newStreamClient(t, readyStreamFrame(t)))
+		if err := store.Ack(2, map[string]litefs.Pos{"sqlite.db": {TXID: 1}}); err != litefs.ErrNotPrimary {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Ensure a replica sends its position to the primary after applying a transaction.
+	t.Run("SendAck", func(t *testing.T) {
+		data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		primary := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		db, err := primary.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ltxData, err := os.ReadFile(db.LTXPath(1, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ackCh := make(chan map[string]litefs.Pos, 10)
+		client := newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", ltxData), readyStreamFrame(t))
+		client.AckFunc = func(ctx context.Context, primaryURL string, nodeID uint64, posMap map[string]litefs.Pos, token string) error {
+			if got, want := token, "secret"; got != want {
+				t.Errorf("token=%q, want %q", got, want)
+			}
+			ackCh <- posMap
+			return nil
+		}
+
+		replica := newStore(t, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202"), client)
+		replica.SyncReplicas = 1
+		replica.ReplicationToken = "secret"
+		if err := replica.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case <-timeout:
+				t.Fatal("timeout waiting for ack")
+			case posMap := <-ackCh:
+				if posMap["sqlite.db"] != db.Pos() {
+					continue
+				}
+			}
+			break
+		}
+	})
+}
+
+func TestStore_PosMismatchSnapshotRequest(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	db, err := primary.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	} else if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second LTX file cannot be applied to an empty replica.
+	ltxData, err := os.ReadFile(db.LTXPath(2, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot bytes.Buffer
+	if _, err := primary.Snapshot(context.Background(), "sqlite.db", &snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatchClient := newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", ltxData))
+	snapshotClient := newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", snapshot.Bytes()), readyStreamFrame(t))
+
+	snapshotsCh := make(chan []string, 10)
+	client := &mock.Client{
+		StreamFunc: func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+			snapshotsCh <- opts.Snapshots
+			if len(opts.Snapshots) == 0 {
+				return mismatchClient.Stream(ctx, rawurl, nodeID, posMap, opts)
+			}
+			return snapshotClient.Stream(ctx, rawurl, nodeID, posMap, opts)
+		},
+	}
+
+	replica := newStore(t, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202"), client)
+	replica.ReconnectDelay = 10 * time.Millisecond
+	if err := replica.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for store ready")
+	case <-replica.ReadyCh():
+	}
+
+	// Ensure the snapshot is only requested after reaching the limit.
+	for i, want := range [][]string{nil, nil, nil, {"sqlite.db"}} {
+		if got := <-snapshotsCh; !reflect.DeepEqual(got, want) {
+			t.Fatalf("%d. snapshots=%v, want %v", i, got, want)
+		}
+	}
+
+	if got, want := replica.DB("sqlite.db").Pos(), db.Pos(); got != want {
+		t.Fatalf("Pos=%s, want %s", got, want)
+	}
+}
+
+// Ensure repeated snapshot requests from the same replica are rate limited.
+func TestStore_AllowSnapshotRequest(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		store.SnapshotRequestInterval = 100 * time.Millisecond
+
+		if !store.AllowSnapshotRequest(1, "db") {
+			t.Fatal("expected first request to be allowed")
+		} else if store.AllowSnapshotRequest(1, "db") {
+			t.Fatal("expected repeated request to be ignored")
+		}
+
+		// Other replicas & databases are tracked separately.
+		if !store.AllowSnapshotRequest(2, "db") {
+			t.Fatal("expected request from other replica to be allowed")
+		} else if !store.AllowSnapshotRequest(1, "other") {
+			t.Fatal("expected request for other database to be allowed")
+		}
+
+		time.Sleep(store.SnapshotRequestInterval)
+		if !store.AllowSnapshotRequest(1, "db") {
+			t.Fatal("expected request to be allowed after interval")
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		store.SnapshotRequestInterval = 0
+		for i := 0; i < 3; i++ {
+			if !store.AllowSnapshotRequest(1, "db") {
+				t.Fatalf("%d. expected request to be allowed", i)
+			}
+		}
+	})
+}
+
+// Ensure a position mismatch is handled according to the store's divergence policy.
+func TestStore_OnDivergence(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	db, err := primary.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	} else if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second LTX file cannot be applied to an empty replica.
+	ltxData, err := os.ReadFile(db.LTXPath(2, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot bytes.Buffer
+	if _, err := primary.Snapshot(context.Background(), "sqlite.db", &snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reconnects to request a snapshot must not wait for the reconnect delay.
+	newReplica := func(tb testing.TB, policy litefs.DivergencePolicy, client litefs.Client) *litefs.Store {
+		replica := newStore(tb, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202"), client)
+		replica.ReconnectDelay = time.Minute
+		replica.OnDivergence = policy
+		if err := replica.Open(); err != nil {
+			tb.Fatal(err)
+		}
+
+		select {
+		case <-time.After(5 * time.Second):
+			tb.Fatal("timeout waiting for store ready")
+		case <-replica.ReadyCh():
+		}
+		return replica
+	}
+
+	t.Run("Resnapshot", func(t *testing.T) {
+		mismatchClient := newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", ltxData))
+		snapshotClient := newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", snapshot.Bytes()), readyStreamFrame(t))
+
+		snapshotsCh := make(chan []string, 10)
+		client := &mock.Client{
+			StreamFunc: func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+				snapshotsCh <- opts.Snapshots
+				if len(opts.Snapshots) == 0 {
+					return mismatchClient.Stream(ctx, rawurl, nodeID, posMap, opts)
+				}
+				return snapshotClient.Stream(ctx, rawurl, nodeID, posMap, opts)
+			},
+		}
+		replica := newReplica(t, litefs.DivergencePolicyResnapshot, client)
+
+		// A snapshot is requested on the first reconnect.
+		for i, want := range [][]string{nil, {"sqlite.db"}} {
+			if got := <-snapshotsCh; !reflect.DeepEqual(got, want) {
+				t.Fatalf("%d. snapshots=%v, want %v", i, got, want)
+			}
+		}
+		if got, want := replica.DB("sqlite.db").Pos(), db.Pos(); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("Quarantine", func(t *testing.T) {
+		var buf syncBuffer
+		replica := newStore(t, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202"), newStreamClient(t,
+			encodeLTXStreamFrame(t, "sqlite.db", ltxData),
+			readyStreamFrame(t),
+		))
+		replica.OnDivergence = litefs.DivergencePolicyQuarantine
+		replica.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+		if err := replica.Open(); err != nil {
+			t.Fatal(err)
+		} else if err := replica.WaitReady(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		// The primary does not send a snapshot so the database stays quarantined.
+		rdb := replica.DB("sqlite.db")
+		if !rdb.Quarantined() {
+			t.Fatal("expected quarantined")
+		} else if got, want := rdb.Pos(), (litefs.Pos{}); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		} else if s := replica.Expvar().String(); !strings.Contains(s, `"quarantined":true`) {
+			t.Fatalf("expected quarantined state in expvar: %s", s)
+		}
+
+		// Ensure the quarantine is logged with the database & position.
+		var rec map[string]any
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if strings.Contains(line, `"event":"db_quarantined"`) {
+				if err := json.Unmarshal([]byte(line), &rec); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if got, want := rec["db"], "sqlite.db"; got != want {
+			t.Fatalf("db=%v, want %v", got, want)
+		} else if got, want := rec["txid"], ltx.FormatTXID(0); got != want {
+			t.Fatalf("txid=%v, want %v", got, want)
+		} else if got, want := rec["expected_txid"], ltx.FormatTXID(1); got != want {
+			t.Fatalf("expected_txid=%v, want %v", got, want)
+		} else if got, want := rec["node_id"], litefs.FormatNodeID(replica.ID()); got != want {
+			t.Fatalf("node_id=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("QuarantineSnapshot", func(t *testing.T) {
+		mismatchClient := newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", ltxData), readyStreamFrame(t))
+		snapshotClient := newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", snapshot.Bytes()), readyStreamFrame(t))
+
+		snapshotsCh := make(chan []string, 10)
+		quarantinedCh := make(chan bool, 10)
+		var replica *litefs.Store
+		client := &mock.Client{
+			StreamFunc: func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+				snapshotsCh <- opts.Snapshots
+				if len(opts.Snapshots) == 0 {
+					return mismatchClient.Stream(ctx, rawurl, nodeID, posMap, opts)
+				}
+				quarantinedCh <- replica.DB("sqlite.db").Quarantined()
+				return snapshotClient.Stream(ctx, rawurl, nodeID, posMap, opts)
+			},
+		}
+		replica = newStore(t, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202"), client)
+		replica.ReconnectDelay = time.Minute
+		replica.OnDivergence = litefs.DivergencePolicyQuarantine
+		if err := replica.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The replica reconnects immediately to request the snapshot while
+		// the database is quarantined.
+		for i, want := range [][]string{nil, {"sqlite.db"}} {
+			select {
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%d. timeout waiting for stream", i)
+			case got := <-snapshotsCh:
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("%d. snapshots=%v, want %v", i, got, want)
+				}
+			}
+		}
+		if !<-quarantinedCh {
+			t.Fatal("expected quarantined before snapshot")
+		}
+
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for store ready")
+		case <-replica.ReadyCh():
+		}
+
+		// The snapshot clears the quarantine.
+		rdb := replica.DB("sqlite.db")
+		if rdb.Quarantined() {
+			t.Fatal("expected quarantine to be cleared")
+		} else if got, want := rdb.Pos(), db.Pos(); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("Halt", func(t *testing.T) {
+		replica := newReplica(t, litefs.DivergencePolicyHalt, newStreamClient(t,
+			encodeLTXStreamFrame(t, "sqlite.db", ltxData),
+			encodeLTXStreamFrame(t, "sqlite.db", snapshot.Bytes()),
+			readyStreamFrame(t),
+		))
+
+		// Changes, including snapshots, are skipped until resolved manually.
+		rdb := replica.DB("sqlite.db")
+		if !rdb.Diverged() {
+			t.Fatal("expected diverged")
+		} else if got, want := rdb.Pos(), (litefs.Pos{}); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+	})
+}
+
+// Ensure a replica that is ahead of the primary is handled according to the
+// store's divergence policy.
+func TestStore_OnDivergence_AheadOfPrimary(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The replica starts with three transactions but the primary only has one.
+	ahead := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	aheadDB, err := ahead.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := aheadDB.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	aheadPos := aheadDB.Pos()
+
+	ltxData, err := os.ReadFile(aheadDB.LTXPath(2, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	db, err := primary.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot bytes.Buffer
+	if _, err := primary.Snapshot(context.Background(), "sqlite.db", &snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	newReplica := func(tb testing.TB, policy litefs.DivergencePolicy, client litefs.Client) *litefs.Store {
+		replica := newStore(tb, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202"), client)
+		testingutil.MustCopyDir(tb, filepath.Join(ahead.Path(), "dbs"), filepath.Join(replica.Path(), "dbs"))
+		replica.ReconnectDelay = time.Minute
+		replica.OnDivergence = policy
+		if err := replica.Open(); err != nil {
+			tb.Fatal(err)
+		}
+
+		select {
+		case <-time.After(5 * time.Second):
+			tb.Fatal("timeout waiting for store ready")
+		case <-replica.ReadyCh():
+		}
+		return replica
+	}
+
+	t.Run("ResnapshotSnapshot", func(t *testing.T) {
+		replica := newReplica(t, litefs.DivergencePolicyResnapshot, newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", snapshot.Bytes()), readyStreamFrame(t)))
+
+		rdb := replica.DB("sqlite.db")
+		if got, want := rdb.Pos(), db.Pos(); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		} else if ents, err := rdb.ReadLTXDir(); err != nil {
+			t.Fatal(err)
+		} else if got, want := len(ents), 1; got != want {
+			t.Fatalf("len(ents)=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("ResnapshotIncremental", func(t *testing.T) {
+		mismatchClient := newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", ltxData))
+		snapshotClient := newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", snapshot.Bytes()), readyStreamFrame(t))
+
+		snapshotsCh := make(chan []string, 10)
+		client := &mock.Client{
+			StreamFunc: func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+				snapshotsCh <- opts.Snapshots
+				if len(opts.Snapshots) == 0 {
+					return mismatchClient.Stream(ctx, rawurl, nodeID, posMap, opts)
+				}
+				return snapshotClient.Stream(ctx, rawurl, nodeID, posMap, opts)
+			},
+		}
+		replica := newReplica(t, litefs.DivergencePolicyResnapshot, client)
+
+		// A snapshot is requested immediately rather than after retrying.
+		for i, want := range [][]string{nil, {"sqlite.db"}} {
+			if got := <-snapshotsCh; !reflect.DeepEqual(got, want) {
+				t.Fatalf("%d. snapshots=%v, want %v", i, got, want)
+			}
+		}
+		if got, want := replica.DB("sqlite.db").Pos(), db.Pos(); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("Halt", func(t *testing.T) {
+		replica := newReplica(t, litefs.DivergencePolicyHalt, newStreamClient(t, encodeLTXStreamFrame(t, "sqlite.db", snapshot.Bytes()), readyStreamFrame(t)))
+
+		rdb := replica.DB("sqlite.db")
+		if !rdb.Diverged() {
+			t.Fatal("expected diverged")
+		} else if got, want := rdb.Pos(), aheadPos; got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		} else if ents, err := rdb.ReadLTXDir(); err != nil {
+			t.Fatal(err)
+		} else if got, want := len(ents), 3; got != want {
+			t.Fatalf("len(ents)=%d, want %d", got, want)
+		}
+	})
+}
+
+func TestStore_HeartbeatTimeout(t *testing.T) {
+	heartbeat := func() []byte {
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, &litefs.HeartbeatStreamFrame{Timestamp: time.Now().UnixMilli(), Interval: 10}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}()
+
+	var streamN atomic.Int32
+	client := newStreamClient(t, readyStreamFrame(t), heartbeat)
+	streamFunc := client.StreamFunc
+	client.StreamFunc = func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+		if !opts.Heartbeat {
+			t.Error("expected replica to advertise heartbeat support")
+		}
+		streamN.Add(1)
+		return streamFunc(ctx, rawurl, nodeID, posMap, opts)
+	}
+
+	// The timeout is based on the interval sent by the primary, not the
+	// replica's own heartbeat interval.
+	leaser := litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+	store := newStore(t, leaser, client)
+	store.HeartbeatInterval = 1 * time.Hour
+	store.ReconnectDelay = 10 * time.Millisecond
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stream stays open after the heartbeat so the replica should time
+	// out and reconnect to the primary.
+	for deadline := time.Now().Add(5 * time.Second); streamN.Load() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for reconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Ensure an explicit stream timeout overrides the heartbeat-based timeout.
+func TestStore_StreamTimeout(t *testing.T) {
+	heartbeat := func() []byte {
+		var buf bytes.Buffer
+		if err := litefs.WriteStreamFrame(&buf, &litefs.HeartbeatStreamFrame{Timestamp: time.Now().UnixMilli(), Interval: time.Hour.Milliseconds()}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}()
+
+	var streamN atomic.Int32
+	client := newStreamClient(t, readyStreamFrame(t), heartbeat)
+	streamFunc := client.StreamFunc
+	client.StreamFunc = func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+		streamN.Add(1)
+		return streamFunc(ctx, rawurl, nodeID, posMap, opts)
+	}
+
+	leaser := litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+	store := newStore(t, leaser, client)
+	store.StreamTimeout = 20 * time.Millisecond
+	store.ReconnectDelay = 10 * time.Millisecond
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); streamN.Load() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for reconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStore_Handoff(t *testing.T) {
+	t.Run("ErrNotPrimary", func(t *testing.T) {
+		leaser := litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+		store := newOpenStore(t, leaser, newStreamClient(t, readyStreamFrame(t)))
+		if err := store.Handoff(context.Background(), 1); err != litefs.ErrNotPrimary {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrLeaseHandoffUnsupported", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if err := store.Handoff(context.Background(), 1); err != litefs.ErrLeaseHandoffUnsupported {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrHandoffTimeout", func(t *testing.T) {
+		data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store := newStore(t, newHandoffLeaser(), nil)
+		store.HandoffTimeout = 50 * time.Millisecond
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		if _, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		// Subscriber never receives any transactions so it never catches up.
+		sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 2, Handoff: true})
+		defer func() { _ = sub.Close() }()
+
+		if err := store.Handoff(context.Background(), 2); err != litefs.ErrHandoffTimeout {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !store.IsPrimary() {
+			t.Fatal("expected store to remain primary")
+		}
+
+		// Ensure writes are unblocked after the aborted handoff.
+		if err := store.DB("sqlite.db").Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrReplicaNotFound", func(t *testing.T) {
+		store := newOpenStore(t, newHandoffLeaser(), nil)
+
+		sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 2})
+		defer func() { _ = sub.Close() }()
+
+		if err := store.Handoff(context.Background(), 1); !errors.Is(err, litefs.ErrReplicaNotFound) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrReplicaLagging", func(t *testing.T) {
+		data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store := newStore(t, newHandoffLeaser(), nil)
+		store.HandoffMaxLag = 1
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		} else if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		// Subscriber has not received either transaction.
+		sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 2, Handoff: true})
+		defer func() { _ = sub.Close() }()
+
+		if err := store.Handoff(context.Background(), 2); !errors.Is(err, litefs.ErrReplicaLagging) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !store.IsPrimary() {
+			t.Fatal("expected store to remain primary")
+		}
+	})
+
+	t.Run("ErrReplicaCannotAcquire", func(t *testing.T) {
+		store := newOpenStore(t, newHandoffLeaser(), nil)
+
+		// Replica did not advertise that it can acquire a handed off lease.
+		sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 2})
+		defer func() { _ = sub.Close() }()
+
+		if err := store.Handoff(context.Background(), 2); !errors.Is(err, litefs.ErrLeaseHandoffUnsupported) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Ensure the handoff waits for the replica to acknowledge applying
+	// transactions rather than relying on the positions sent to it.
+	t.Run("WaitForAck", func(t *testing.T) {
+		data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store := newStore(t, newHandoffLeaser(), nil)
+		store.HandoffTimeout = 5 * time.Second
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Positions have been sent but not acknowledged.
+		sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 2, Handoff: true})
+		defer func() { _ = sub.Close() }()
+		sub.SetPosMap(map[string]litefs.Pos{"sqlite.db": db.Pos()})
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- store.Handoff(context.Background(), 2) }()
+
+		select {
+		case <-sub.HandoffCh():
+			t.Fatal("handoff sent before replica acknowledged position")
+		case err := <-errCh:
+			t.Fatalf("unexpected error: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		if err := store.Ack(2, map[string]litefs.Pos{"sqlite.db": db.Pos()}); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case leaseID := <-sub.HandoffCh():
+			if got, want := leaseID, "lease1"; got != want {
+				t.Fatalf("lease=%q, want %q", got, want)
+			}
+			sub.HandoffSent(nil)
+		case err := <-errCh:
+			t.Fatalf("unexpected error: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for handoff")
+		}
+
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		} else if store.IsPrimary() {
+			t.Fatal("expected store to step down")
+		}
+	})
+}
+
+func TestStore_Promote(t *testing.T) {
+	t.Run("NoPrimary", func(t *testing.T) {
+		// The first lease acquisition fails so the store waits to retry.
+		leaser := newHandoffLeaser()
+		acquire := leaser.AcquireFunc
+		acquiredCh := make(chan struct{})
+		var n atomic.Int32
+		leaser.AcquireFunc = func(ctx context.Context) (litefs.Lease, error) {
+			if n.Add(1) == 1 {
+				close(acquiredCh)
+				return nil, fmt.Errorf("marker")
+			}
+			return acquire(ctx)
+		}
+
+		store := newStore(t, leaser, nil)
+		store.ReconnectDelay = time.Minute
+		store.MaxReconnectDelay = time.Minute
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-acquiredCh
+
+		// Promotion acquires the lease without waiting for the retry delay.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := store.Promote(ctx); err != nil {
+			t.Fatal(err)
+		} else if !store.IsPrimary() {
+			t.Fatal("expected primary")
+		}
+	})
+
+	t.Run("ErrNotCandidate", func(t *testing.T) {
+		store := newStore(t, newHandoffLeaser(), nil)
+		store.CandidateWeight = 0
+		if err := store.Promote(context.Background()); err != litefs.ErrNotCandidate {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure a replica reconnects to the last known primary before asking the leaser.
+func TestStore_PrimaryInfoCache(t *testing.T) {
+	newClient := func(tb testing.TB, urlCh chan string) *mock.Client {
+		client := newStreamClient(tb, readyStreamFrame(tb))
+		streamFunc := client.StreamFunc
+		client.StreamFunc = func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+			urlCh <- rawurl
+			if rawurl == "http://cached:20202" {
+				return nil, fmt.Errorf("connection refused")
+			}
+			return streamFunc(ctx, rawurl, nodeID, posMap, opts)
+		}
+		return client
+	}
+
+	writeCache := func(tb testing.TB, path string, timestamp time.Time) {
+		buf, err := json.Marshal(map[string]any{"hostname": "cached", "advertise-url": "http://cached:20202", "timestamp": timestamp})
+		if err != nil {
+			tb.Fatal(err)
+		} else if err := os.WriteFile(path, buf, 0666); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		urlCh := make(chan string, 2)
+		store := newStore(t, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202"), newClient(t, urlCh))
+		writeCache(t, store.PrimaryInfoPath(), time.Now())
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		if got, want := <-urlCh, "http://cached:20202"; got != want {
+			t.Fatalf("url=%s, want %s", got, want)
+		} else if got, want := <-urlCh, "http://localhost:20202"; got != want {
+			t.Fatalf("url=%s, want %s", got, want)
+		}
+
+		// Cache should be replaced by the primary we connected to.
+		testingutil.RetryUntil(t, 10*time.Millisecond, 5*time.Second, func() error {
+			buf, err := os.ReadFile(store.PrimaryInfoPath())
+			if err != nil {
+				return err
+			}
+			var info litefs.PrimaryInfo
+			if err := json.Unmarshal(buf, &info); err != nil {
+				return err
+			} else if got, want := info.AdvertiseURL, "http://localhost:20202"; got != want {
+				return fmt.Errorf("AdvertiseURL=%s, want %s", got, want)
+			}
+			return nil
+		})
+	})
+
+	t.Run("Stale", func(t *testing.T) {
+		urlCh := make(chan string, 2)
+		store := newStore(t, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202"), newClient(t, urlCh))
+		writeCache(t, store.PrimaryInfoPath(), time.Now().Add(-2*litefs.DefaultPrimaryInfoMaxAge))
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		if got, want := <-urlCh, "http://localhost:20202"; got != want {
+			t.Fatalf("url=%s, want %s", got, want)
+		}
+	})
+}
+
+func TestStore_Logger(t *testing.T) {
+	var buf syncBuffer
+	store := newStore(t, newPrimaryStaticLeaser(), nil)
+	store.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	} else if err := store.WaitReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	line, _, _ := strings.Cut(buf.String(), "\n")
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		t.Fatal(err)
+	} else if got, want := rec["event"], "lease_acquired"; got != want {
+		t.Fatalf("event=%v, want %v", got, want)
+	} else if got, want := rec["node_id"], litefs.FormatNodeID(store.ID()); got != want {
+		t.Fatalf("node_id=%v, want %v", got, want)
+	} else if got, want := rec["advertise_url"], "http://localhost:20202"; got != want {
+		t.Fatalf("advertise_url=%v, want %v", got, want)
+	}
+}
+
+func TestStore_WaitReady(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+
+		// Ensure concurrent callers are all released.
+		var g errgroup.Group
+		for i := 0; i < 3; i++ {
+			g.Go(func() error { return store.WaitReady(context.Background()) })
+		}
+		if err := g.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ContextCanceled", func(t *testing.T) {
+		store := newStore(t, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202"), nil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := store.WaitReady(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrStoreClosed", func(t *testing.T) {
+		store := litefs.NewStore(t.TempDir(), false)
+		store.Leaser = litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202")
+		store.Client = newStreamClient(t)
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- store.WaitReady(context.Background()) }()
+
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		} else if err := <-errCh; err != litefs.ErrStoreClosed {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestStore_PrimaryURL(t *testing.T) {
+	t.Run("Primary", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if url, isPrimary := store.PrimaryURL(); !isPrimary {
+			t.Fatal("expected primary")
+		} else if got, want := url, "http://localhost:20202"; got != want {
+			t.Fatalf("url=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("Replica", func(t *testing.T) {
+		store := newStore(t, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20203"), newStreamClient(t, readyStreamFrame(t)))
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		if url, isPrimary := store.PrimaryURL(); isPrimary {
+			t.Fatal("expected replica")
+		} else if got, want := url, "http://localhost:20203"; got != want {
+			t.Fatalf("url=%s, want %s", got, want)
+		}
+	})
+}
+
+func TestStore_Health(t *testing.T) {
+	t.Run("Primary", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if got, want := store.Health(), (litefs.HealthStatus{Ready: true, IsPrimary: true, PrimaryReachable: true}); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Health()=%#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("Replica", func(t *testing.T) {
+		store := newStore(t, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20203"), newStreamClient(t, readyStreamFrame(t)))
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		if got, want := store.Health(), (litefs.HealthStatus{Ready: true, PrimaryReachable: true}); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Health()=%#v, want %#v", got, want)
+		}
+	})
+
+	// Ensure a replica is not ready until it receives the initial replication set.
+	t.Run("NotReady", func(t *testing.T) {
+		store := newStore(t, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20203"), newStreamClient(t))
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		testingutil.RetryUntil(t, 1*time.Millisecond, 5*time.Second, func() error {
+			if health := store.Health(); !health.PrimaryReachable {
+				return fmt.Errorf("expected primary to be reachable")
+			} else if health.Ready {
+				return fmt.Errorf("expected not ready")
+			}
+			return nil
+		})
+	})
+}
+
+func TestStore_HaltLocks(t *testing.T) {
+	store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+	db, err := store.CreateDBIfNotExists("test.db")
+	if err != nil {
+		t.Fatal(err)
+	} else if a := store.HaltLocks(); len(a) != 0 {
+		t.Fatalf("unexpected halt locks: %v", a)
+	}
+
+	if _, err := db.AcquireHaltLock(context.Background(), 2, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	a := store.HaltLocks()
+	if got, want := len(a), 1; got != want {
+		t.Fatalf("len=%d, want %d", got, want)
+	}
+	haltLock := a[0]
+	if got, want := haltLock.Name, "test.db"; got != want {
+		t.Fatalf("Name=%s, want %s", got, want)
+	} else if haltLock.Remote {
+		t.Fatal("expected local halt lock")
+	} else if got, want := haltLock.ID, int64(100); got != want {
+		t.Fatalf("ID=%d, want %d", got, want)
+	} else if got, want := haltLock.NodeID, uint64(2); got != want {
+		t.Fatalf("NodeID=%d, want %d", got, want)
+	} else if haltLock.AcquiredAt == nil || haltLock.Expires == nil {
+		t.Fatal("expected acquired & expiration times")
+	} else if got, want := haltLock.Expires.Sub(*haltLock.AcquiredAt), store.HaltLockTTL; got != want {
+		t.Fatalf("TTL=%s, want %s", got, want)
+	}
+
+	if s := store.Expvar().String(); !strings.Contains(s, `"haltLock":{"id":100,"nodeID":2,`) {
+		t.Fatalf("expected halt lock in expvar: %s", s)
+	} else if !strings.Contains(s, `"haltLocks":[{"name":"test.db","remote":false,"id":100,"nodeID":2,`) {
+		t.Fatalf("expected halt lock list in expvar: %s", s)
+	}
+
+	db.ReleaseHaltLock(context.Background(), 100)
+	if a := store.HaltLocks(); len(a) != 0 {
+		t.Fatalf("unexpected halt locks: %v", a)
+	}
+}
+
+func TestStore_Drain(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("RejectNewWrites", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Drain(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if !store.IsDraining() {
+			t.Fatal("expected store to be draining")
+		}
+
+		if ok, err := db.TryLocks(context.Background(), 1, []litefs.LockType{litefs.LockTypeReserved}); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			t.Fatal("expected RESERVED lock to be rejected")
+		}
+		if ok, err := db.TryLocks(context.Background(), 1, []litefs.LockType{litefs.LockTypeWrite}); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			t.Fatal("expected WRITE lock to be rejected")
+		}
+		if _, err := db.AcquireHaltLock(context.Background(), 2, 1); err != litefs.ErrStoreDraining {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := db.AcquireWriteLock(context.Background(), nil); err != litefs.ErrStoreDraining {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Reads continue to be served.
+		if !db.TryRLocks(context.Background(), 1, []litefs.LockType{litefs.LockTypeShared}) {
+			t.Fatal("expected SHARED lock")
+		}
+	})
+
+	t.Run("WaitInFlightWrite", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		db, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ok, err := db.TryLocks(context.Background(), 1, []litefs.LockType{litefs.LockTypeReserved}); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatal("expected RESERVED lock")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := store.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The in-flight transaction is allowed to finish.
+		if err := db.Unlock(context.Background(), 1, []litefs.LockType{litefs.LockTypeReserved}); err != nil {
+			t.Fatal(err)
+		} else if err := store.Drain(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("WaitReplica", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if _, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		sub := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 2})
+		defer func() { _ = sub.Close() }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := store.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Drain completes once the replica has received all transactions.
+		sub.SetPosMap(store.PosMap())
+		if err := store.Drain(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ReleaseLease", func(t *testing.T) {
+		store := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		if _, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		} else if !store.IsPrimary() {
+			t.Fatal("expected primary")
+		}
+
+		if err := store.Drain(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if store.IsPrimary() {
+			t.Fatal("expected lease to be released")
+		}
+
+		// A draining store does not become primary again.
+		time.Sleep(100 * time.Millisecond)
+		if store.IsPrimary() {
+			t.Fatal("expected store to remain a replica")
+		}
+	})
+
+	t.Run("WaitSyncReplicas", func(t *testing.T) {
+		store := newStore(t, newPrimaryStaticLeaser(), nil)
+		store.SyncReplicas = 1
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+		<-store.ReadyCh()
+
+		if _, err := store.CreateDBFromReader(context.Background(), "sqlite.db", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		sub0 := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 2})
+		defer func() { _ = sub0.Close() }()
+		sub1 := store.SubscribeWithOptions(litefs.SubscribeOptions{NodeID: 3})
+		defer func() { _ = sub1.Close() }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := store.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Only the quorum needs to acknowledge; the other replica can lag.
+		if err := store.Ack(2, store.PosMap()); err != nil {
+			t.Fatal(err)
+		} else if err := store.Drain(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestStore_MaxDatabases(t *testing.T) {
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		store := newStore(t, newPrimaryStaticLeaser(), nil)
+		store.MaxDatabases = 2
+		if err := store.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.CreateDBIfNotExists("a.db"); err != nil {
+			t.Fatal(err)
+		} else if _, err := store.CreateDBFromReader(context.Background(), "b.db", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := store.CreateDB("c.db"); err != litefs.ErrTooManyDatabases {
+			t.Fatalf("unexpected error: %v", err)
+		} else if _, err := store.CreateDBIfNotExists("c.db"); err != litefs.ErrTooManyDatabases {
+			t.Fatalf("unexpected error: %v", err)
+		} else if _, err := store.CreateDBFromReader(context.Background(), "c.db", bytes.NewReader(data)); err != litefs.ErrTooManyDatabases {
+			t.Fatalf("unexpected error: %v", err)
+		} else if _, err := store.CopyDB(context.Background(), "b.db", "c.db"); err != litefs.ErrTooManyDatabases {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Existing databases can still be fetched.
+		if db, err := store.CreateDBIfNotExists("a.db"); err != nil {
+			t.Fatal(err)
+		} else if db == nil {
+			t.Fatal("expected database")
+		}
+
+		// Dropping a database frees up space for another.
+		if err := store.DropDB(context.Background(), "a.db"); err != nil {
+			t.Fatal(err)
+		} else if _, err := store.CreateDBIfNotExists("c.db"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ReplicaSkipsNewDB", func(t *testing.T) {
+		primary := newOpenStore(t, newPrimaryStaticLeaser(), nil)
+		var snapshots [][]byte
+		for _, name := range []string{"a.db", "b.db"} {
+			if _, err := primary.CreateDBFromReader(context.Background(), name, bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if _, err := primary.Snapshot(context.Background(), name, &buf); err != nil {
+				t.Fatal(err)
+			}
+			snapshots = append(snapshots, buf.Bytes())
+		}
+
+		client := newStreamClient(t,
+			encodeLTXStreamFrame(t, "a.db", snapshots[0]),
+			encodeLTXStreamFrame(t, "b.db", snapshots[1]),
+			readyStreamFrame(t),
+		)
+		replica := newStore(t, litefs.NewStaticLeaser(false, "localhost", "http://localhost:20202"), client)
+		replica.MaxDatabases = 1
+		if err := replica.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for store ready")
+		case <-replica.ReadyCh():
+		}
+
+		if db := replica.DB("a.db"); db == nil {
+			t.Fatal("expected database")
+		} else if got, want := db.Pos(), primary.DB("a.db").Pos(); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+		if db := replica.DB("b.db"); db != nil {
+			t.Fatal("expected database to be skipped")
+		}
+	})
+}
+
+// newHandoffLeaser returns a mock leaser that always acquires a lease that
+// supports handoff.
+func newHandoffLeaser() *mock.Leaser {
+	lease := &mock.Lease{
+		IDFunc:        func() string { return "lease1" },
+		RenewedAtFunc: func() time.Time { return time.Now() },
+		TTLFunc:       func() time.Duration { return 10 * time.Second },
+		RenewFunc:     func(ctx context.Context) error { return nil },
+		CloseFunc:     func() error { return nil },
+	}
+	return &mock.Leaser{
+		CloseFunc:        func() error { return nil },
+		AdvertiseURLFunc: func() string { return "http://localhost:20202" },
+		AcquireFunc:      func(ctx context.Context) (litefs.Lease, error) { return lease, nil },
+		PrimaryInfoFunc: func(ctx context.Context) (litefs.PrimaryInfo, error) {
+			return litefs.PrimaryInfo{}, litefs.ErrNoPrimary
+		},
+	}
+}
+
+// newStreamClient returns a mock client that streams a concatenation of
+// frames to the replica and then blocks until the connection is closed.
+func newStreamClient(tb testing.TB, frames ...[]byte) *mock.Client {
+	return &mock.Client{
+		StreamFunc: func(ctx context.Context, rawurl string, nodeID uint64, posMap map[string]litefs.Pos, opts litefs.StreamOptions) (io.ReadCloser, error) {
+			pr, pw := io.Pipe()
+			go func() {
+				for _, frame := range frames {
+					if _, err := pw.Write(frame); err != nil {
+						return
+					}
+				}
+				<-ctx.Done()
+				_ = pw.CloseWithError(ctx.Err())
+			}()
+			return pr, nil
+		},
+		AckFunc: func(ctx context.Context, primaryURL string, nodeID uint64, posMap map[string]litefs.Pos, token string) error {
+			return nil
+		},
+	}
+}
+
+// encodeLTXStreamFrame returns an encoded LTX stream frame with a chunked LTX file.
+func encodeLTXStreamFrame(tb testing.TB, name string, data []byte) []byte {
+	tb.Helper()
+
+	var buf bytes.Buffer
+	if err := litefs.WriteStreamFrame(&buf, &litefs.LTXStreamFrame{Name: name}); err != nil {
+		tb.Fatal(err)
+	}
+
+	w := chunk.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		tb.Fatal(err)
+	} else if err := w.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readyStreamFrame returns an encoded ready stream frame.
+func readyStreamFrame(tb testing.TB) []byte {
+	tb.Helper()
+
+	var buf bytes.Buffer
+	if err := litefs.WriteStreamFrame(&buf, &litefs.ReadyStreamFrame{}); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newStore returns a new instance of a Store on a temporary directory.
+// This store will automatically close when the test ends.
+func newStore(tb testing.TB, leaser litefs.Leaser, client litefs.Client) *litefs.Store {
+	store := litefs.NewStore(tb.TempDir(), true)
+	store.Leaser = leaser
+	store.Client = client
+	tb.Cleanup(func() {
+		if err := store.Close(); err != nil {
+			tb.Fatalf("cannot close store: %s", err)
+		}
+	})
+	return store
+}
+
+// newOpenStore returns a new instance of an empty, opened store.
+func newOpenStore(tb testing.TB, leaser litefs.Leaser, client litefs.Client) *litefs.Store {
+	tb.Helper()
+	store := newStore(tb, leaser, client)
+	if err := store.Open(); err != nil {
+		tb.Fatal(err)
+	}
+
+	select {
+	case <-time.After(5 * time.Second):
+		tb.Fatal("timeout waiting for store ready")
+	case <-store.ReadyCh(): // wait for lease
+	}
+	return store
+}
+
+// newOpenStoreWithDB returns an open primary store with a database written by
+// n transactions. Each transaction imports the test fixture database, alternating
+// its last byte so that consecutive transactions have different checksums.
+func newOpenStoreWithDB(tb testing.TB, name string, n int) (*litefs.Store, *litefs.DB) {
+	tb.Helper()
+	data, err := os.ReadFile("testdata/store/open-and-write-snapshot/dbs/sqlite.db/database")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	store := newOpenStore(tb, newPrimaryStaticLeaser(), nil)
+	db, err := store.CreateDBFromReader(context.Background(), name, bytes.NewReader(data))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for i := 1; i < n; i++ {
+		data[len(data)-1] ^= 1
+		if err := db.Import(context.Background(), bytes.NewReader(data)); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return store, db
+}
+
+func newStoreFromFixture(tb testing.TB, leaser litefs.Leaser, client litefs.Client, path string) *litefs.Store {
+	tb.Helper()
+	store := newStore(tb, leaser, client)
+	testingutil.MustCopyDir(tb, path, store.Path())
 	return store
 }
+
+// gatherDBMetric returns the value of a counter or gauge metric for a database from
+// the default registry. Returns zero if the metric does not exist.
+func gatherDBMetric(tb testing.TB, metricName, dbName string) float64 {
+	tb.Helper()
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != metricName {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() != "db" || label.GetValue() != dbName {
+					continue
+				} else if m.GetGauge() != nil {
+					return m.GetGauge().GetValue()
+				}
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+// gatherMetric returns the value of an unlabeled counter or gauge metric.
+func gatherMetric(tb testing.TB, metricName string) float64 {
+	tb.Helper()
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != metricName || len(mf.GetMetric()) == 0 {
+			continue
+		} else if m := mf.GetMetric()[0]; m.GetGauge() != nil {
+			return m.GetGauge().GetValue()
+		} else {
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// memBackup is an in-memory implementation of litefs.Backup.
+type memBackup struct {
+	mu    sync.Mutex
+	files map[string]map[string][]byte // db name -> ltx filename -> data
+}
+
+func newMemBackup() *memBackup {
+	return &memBackup{files: make(map[string]map[string][]byte)}
+}
+
+// Len returns the total number of LTX files in the backup.
+func (b *memBackup) Len() (n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range b.files {
+		n += len(m)
+	}
+	return n
+}
+
+// Delete removes an LTX file from the backup.
+func (b *memBackup) Delete(dbName string, minTXID, maxTXID uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.files[dbName], ltx.FormatFilename(minTXID, maxTXID))
+}
+
+// Mock returns a mock backup that reads & writes to b.
+func (b *memBackup) Mock() *mock.Backup {
+	return &mock.Backup{
+		WriteLTXFunc: func(ctx context.Context, dbName string, hdr ltx.Header, r io.Reader) error {
+			buf, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if b.files[dbName] == nil {
+				b.files[dbName] = make(map[string][]byte)
+			}
+			b.files[dbName][ltx.FormatFilename(hdr.MinTXID, hdr.MaxTXID)] = buf
+			return nil
+		},
+		DBsFunc: func(ctx context.Context) ([]string, error) {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			var names []string
+			for name := range b.files {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return names, nil
+		},
+		LTXFilesFunc: func(ctx context.Context, dbName string) ([]litefs.LTXFileInfo, error) {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			var infos []litefs.LTXFileInfo
+			for filename, buf := range b.files[dbName] {
+				minTXID, maxTXID, _ := ltx.ParseFilename(filename)
+				infos = append(infos, litefs.LTXFileInfo{MinTXID: minTXID, MaxTXID: maxTXID, Size: int64(len(buf)), Snapshot: minTXID == 1})
+			}
+			sort.Slice(infos, func(i, j int) bool { return infos[i].MinTXID < infos[j].MinTXID })
+			return infos, nil
+		},
+		OpenLTXFunc: func(ctx context.Context, dbName string, minTXID, maxTXID uint64) (io.ReadCloser, error) {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			buf, ok := b.files[dbName][ltx.FormatFilename(minTXID, maxTXID)]
+			if !ok {
+				return nil, os.ErrNotExist
+			}
+			return io.NopCloser(bytes.NewReader(buf)), nil
+		},
+	}
+}